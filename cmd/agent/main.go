@@ -1,6 +1,10 @@
 package main
 
 import (
+	"errors"
+	"flag"
+	"net/http"
+	"os"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -11,25 +15,183 @@ import (
 
 func main() {
 	cfg, err := agent.LoadConfig()
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
 	if err != nil {
 		log.Fatalf("Could not load config: %s", err.Error())
 	}
 
-	collectTicker := time.NewTicker(time.Duration(cfg.PollInterval) * time.Second)
-	sendTicker := time.NewTicker(time.Duration(cfg.ReportInterval) * time.Second)
+	pipeline, err := agent.NewPipeline(cfg.Rules)
+	if err != nil {
+		log.Fatalf("Could not init rules: %s", err.Error())
+	}
+	if cfg.DryRun {
+		if err := pipeline.DryRun(os.Stdout, agent.CollectMetrics()); err != nil {
+			log.Fatalf("Could not print dry run: %s", err.Error())
+		}
+		return
+	}
+
+	switch cfg.Command {
+	case agent.CommandCheck:
+		if err := agent.Check(cfg.ServerAddress, cfg.Compression); err != nil {
+			log.Fatalf("Check failed: %s", err.Error())
+		}
+		log.Infof("Server %s accepts metrics", cfg.ServerAddress)
+		return
+	case agent.CommandPush:
+		push(cfg)
+		return
+	case agent.CommandCollect:
+		collect(cfg, pipeline)
+		return
+	}
+
+	sinks, err := agent.NewSinks(cfg.Sinks)
+	if err != nil {
+		log.Fatalf("Could not init sink: %s", err.Error())
+	}
+
+	registry := agent.NewRegistry()
+	if cfg.ListenAddress != "" {
+		go func() {
+			log.Infof("Serving metrics for scraping on %s", cfg.ListenAddress)
+			if err := http.ListenAndServe(cfg.ListenAddress, agent.NewScrapeHandler(registry)); err != nil {
+				log.Fatalf("Scrape endpoint error: %s", err.Error())
+			}
+		}()
+	}
+
+	var relay *agent.Relay
+	if cfg.RelayAddress != "" {
+		relay = agent.NewRelay(sinks, pipeline, cfg.RelayPrefix, cfg.RelayLabels)
+		go func() {
+			log.Infof("Relaying metrics received on %s", cfg.RelayAddress)
+			if err := http.ListenAndServe(cfg.RelayAddress, relay.Handler()); err != nil {
+				log.Fatalf("Relay endpoint error: %s", err.Error())
+			}
+		}()
+	}
+
+	if cfg.ProfileInterval > 0 {
+		profiler := agent.NewProfiler(
+			cfg.ServerAddress,
+			cfg.ProfileSource,
+			cfg.ProfileTargets,
+			time.Duration(cfg.ProfileDuration)*time.Second,
+		)
+		go func() {
+			scheduler := agent.NewScheduler(time.Duration(cfg.ProfileInterval)*time.Second, cfg.Align, 0)
+			for range scheduler.C {
+				if err := profiler.Collect(); err != nil {
+					log.Errorf("Could not upload profiles: %s", err.Error())
+				}
+			}
+		}()
+	}
+
+	collectScheduler := agent.NewScheduler(time.Duration(cfg.PollInterval)*time.Second, cfg.Align, 0)
+	sendScheduler := agent.NewScheduler(
+		time.Duration(cfg.ReportInterval)*time.Second,
+		cfg.Align,
+		time.Duration(cfg.ReportSplay)*time.Second,
+	)
 
-	var metrics []shared.Metric
+	// every sink keeps the aggregated metrics it failed to send until its next report
+	pending := make([][]shared.Metric, len(sinks))
 
 	for {
 		select {
-		case <-collectTicker.C:
-			metrics = append(metrics, agent.CollectMetrics()...)
-		case <-sendTicker.C:
-			log.Infof("Sending %d metrics", len(metrics))
-			metrics, err = agent.SendMetrics(metrics, cfg.ServerAddress)
-			if err != nil {
+		case tick := <-collectScheduler.C:
+			if tick.Missed > 0 {
+				log.Warnf("Missed %d collection ticks before %s", tick.Missed, tick.Scheduled.Format(time.RFC3339))
+			}
+			collected := pipeline.Apply(agent.CollectMetrics())
+			registry.Add(collected)
+			for i, sink := range sinks {
+				var dropped int
+				pending[i], dropped = agent.BufferMetrics(pending[i], collected, cfg.MaxPending)
+				if dropped > 0 {
+					log.Warnf("Dropped %d oldest unsent metrics of %s", dropped, sink.Name())
+				}
+			}
+		case tick := <-sendScheduler.C:
+			if tick.Missed > 0 {
+				log.Warnf("Missed %d report ticks before %s", tick.Missed, tick.Scheduled.Format(time.RFC3339))
+			}
+			for i, sink := range sinks {
+				log.Infof("Sending %d metrics to %s", len(pending[i]), sink.Name())
+				err := sink.Send(pending[i])
+				if err == nil {
+					pending[i] = nil
+					continue
+				}
+				log.Errorf("Could not send metrics to %s: %s", sink.Name(), err.Error())
+				// only the failed chunks are sent again, the rejected ones are dropped
+				var sendErr *agent.SendError
+				if errors.As(err, &sendErr) {
+					log.Warnf("Keeping %d of %d metrics for %s", len(sendErr.Unsent), len(pending[i]), sink.Name())
+					pending[i] = sendErr.Unsent
+				}
+			}
+			if relay != nil {
+				if err := relay.Flush(); err != nil {
+					log.Errorf("Could not forward relayed metrics: %s", err.Error())
+				}
+			}
+		}
+	}
+}
+
+// push sends the single metric from the command-line arguments to the sinks.
+func push(cfg agent.Config) {
+	metric, err := agent.ParseMetric(cfg.Args[0], cfg.Args[1], cfg.Args[2])
+	if err != nil {
+		log.Fatalf("Could not parse metric: %s", err.Error())
+	}
+	sinks, err := agent.NewSinks(cfg.Sinks)
+	if err != nil {
+		log.Fatalf("Could not init sink: %s", err.Error())
+	}
+	if err := agent.SendToSinks(sinks, []shared.Metric{metric}); err != nil {
+		log.Fatalf("Could not push metric: %s", err.Error())
+	}
+}
+
+// collect sends or prints the collected metrics every poll interval, or once.
+func collect(cfg agent.Config, pipeline *agent.Pipeline) {
+	var sinks []agent.Sink
+	if !cfg.Print {
+		var err error
+		if sinks, err = agent.NewSinks(cfg.Sinks); err != nil {
+			log.Fatalf("Could not init sink: %s", err.Error())
+		}
+	}
+
+	var scheduler *agent.Scheduler
+	if !cfg.Once {
+		scheduler = agent.NewScheduler(time.Duration(cfg.PollInterval)*time.Second, cfg.Align, 0)
+	}
+
+	for {
+		metrics := pipeline.Apply(agent.CollectMetrics())
+		if cfg.Print {
+			if err := agent.PrintMetrics(os.Stdout, metrics, cfg.Format); err != nil {
+				log.Fatalf("Could not print metrics: %s", err.Error())
+			}
+		} else if err := agent.SendToSinks(sinks, metrics); err != nil {
+			if cfg.Once {
 				log.Fatalf("Could not send metrics: %s", err.Error())
 			}
+			log.Errorf("Could not send metrics: %s", err.Error())
+		}
+
+		if cfg.Once {
+			return
+		}
+		if tick := <-scheduler.C; tick.Missed > 0 {
+			log.Warnf("Missed %d collection ticks before %s", tick.Missed, tick.Scheduled.Format(time.RFC3339))
 		}
 	}
 }
@@ -5,33 +5,70 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"sync"
 	"syscall"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
 	"github.com/gonozov0/go-musthave-devops/internal/server"
+	"github.com/gonozov0/go-musthave-devops/internal/server/alerting"
 	"github.com/gonozov0/go-musthave-devops/internal/server/application"
+	"github.com/gonozov0/go-musthave-devops/internal/server/discovery"
+	"github.com/gonozov0/go-musthave-devops/internal/server/graphite"
+	"github.com/gonozov0/go-musthave-devops/internal/server/influx"
+	"github.com/gonozov0/go-musthave-devops/internal/server/otlp"
+	"github.com/gonozov0/go-musthave-devops/internal/server/profiles"
+	"github.com/gonozov0/go-musthave-devops/internal/server/promql"
+	"github.com/gonozov0/go-musthave-devops/internal/server/remotewrite"
 	"github.com/gonozov0/go-musthave-devops/internal/server/repository"
 	inmemory "github.com/gonozov0/go-musthave-devops/internal/server/repository/in_memory"
 	postgres "github.com/gonozov0/go-musthave-devops/internal/server/repository/postgres"
+	"github.com/gonozov0/go-musthave-devops/internal/server/rollup"
+	"github.com/gonozov0/go-musthave-devops/internal/server/scrape"
+	"github.com/gonozov0/go-musthave-devops/internal/server/statsd"
+	"github.com/gonozov0/go-musthave-devops/internal/server/stream"
 )
 
+// shutdownTimeout limits how long the server waits for the active requests on shutdown.
+const shutdownTimeout = 10 * time.Second
+
 func main() {
 	cfg, err := server.LoadConfig()
 	if err != nil {
 		log.Fatalf("Could not load config: %s", err.Error())
 	}
 
-	var repo repository.Repository
+	var (
+		repo         repository.Repository
+		profileStore profiles.Store
+	)
+	retention := profiles.Retention{
+		MaxAge:   time.Duration(cfg.ProfileMaxAge) * time.Second,
+		MaxBytes: cfg.ProfileMaxBytes,
+	}
+	historyWindow := inmemory.WithHistoryWindow(time.Duration(cfg.HistoryWindow) * time.Second)
+	setResetInterval := time.Duration(cfg.SetResetInterval) * time.Second
+	// every successful update is published to the stream subscribers
+	streamHub := stream.NewHub(int(cfg.StreamBufferSize))
 	ctx, cancel := context.WithCancel(context.Background())
 	wg := &sync.WaitGroup{}
 
 	if cfg.DatabaseDSN != "" {
-		repo, err = postgres.NewPGRepository(cfg.DatabaseDSN)
+		repo, err = postgres.NewPGRepository(
+			cfg.DatabaseDSN,
+			postgres.WithHistoryRetention(time.Duration(cfg.HistoryRetention)*time.Second),
+			postgres.WithSetResetInterval(setResetInterval),
+			postgres.WithPublisher(streamHub),
+		)
 		if err != nil {
 			log.Fatalf("Could not init postgres repository: %s", err.Error())
 		}
+		profileStore, err = postgres.NewPGProfileStore(cfg.DatabaseDSN, retention)
+		if err != nil {
+			log.Fatalf("Could not init postgres profile store: %s", err.Error())
+		}
 	} else if cfg.FileStoragePath != "" {
 		wg.Add(1)
 		repo, err = inmemory.NewInMemoryRepositoryWithFileStorage(
@@ -40,24 +77,187 @@ func main() {
 			cfg.FileStoragePath,
 			cfg.StoreInterval,
 			cfg.RestoreFlag,
+			historyWindow,
+			inmemory.WithSetResetInterval(setResetInterval),
+			inmemory.WithPublisher(streamHub),
 		)
 		if err != nil {
 			log.Fatalf("Could not init in memory repository: %s", err.Error())
 		}
+		profileStore, err = profiles.NewFileStore(filepath.Join(filepath.Dir(cfg.FileStoragePath), "profiles"), retention)
+		if err != nil {
+			log.Fatalf("Could not init file profile store: %s", err.Error())
+		}
 	} else {
-		repo = inmemory.NewInMemoryRepository()
+		repo = inmemory.NewInMemoryRepository(
+			historyWindow,
+			inmemory.WithSetResetInterval(setResetInterval),
+			inmemory.WithPublisher(streamHub),
+		)
+	}
+
+	remoteWriteConverter, err := remotewrite.NewConverter(cfg.RemoteWriteCounters, cfg.RemoteWriteGauges)
+	if err != nil {
+		log.Fatalf("Could not init remote write: %s", err.Error())
+	}
+	influxConverter, err := influx.NewConverter(cfg.InfluxCounters)
+	if err != nil {
+		log.Fatalf("Could not init line protocol ingestion: %s", err.Error())
+	}
+	routerOpts := []application.Option{
+		application.WithTimestampWindow(
+			time.Duration(cfg.MaxSampleAge)*time.Second,
+			time.Duration(cfg.MaxSampleSkew)*time.Second,
+		),
+		application.WithRemoteWriteConverter(remoteWriteConverter),
+		application.WithOTLPConverter(otlp.NewConverter(cfg.OTLPResourceAttributes)),
+		application.WithInfluxConverter(influxConverter),
+		application.WithStreamHub(streamHub),
+	}
+	if profileStore != nil {
+		routerOpts = append(routerOpts, application.WithProfileStore(profileStore))
+	}
+	if history, ok := repo.(repository.HistoryRepository); ok {
+		rollupJob := rollup.NewJob(
+			history,
+			time.Duration(cfg.RollupInterval)*time.Second,
+			repository.RollupRetention{
+				time.Minute:    time.Duration(cfg.RollupRetention1m) * time.Second,
+				time.Hour:      time.Duration(cfg.RollupRetention1h) * time.Second,
+				24 * time.Hour: time.Duration(cfg.RollupRetention1d) * time.Second,
+			},
+			rollup.WithDelay(time.Duration(cfg.MaxSampleAge)*time.Second),
+		)
+		wg.Add(1)
+		go rollupJob.Run(ctx, wg)
+	}
+	if len(cfg.ScrapeTargets) > 0 || len(cfg.TargetFiles) > 0 {
+		scrapeManager := scrape.NewManager(
+			repo,
+			time.Duration(cfg.ScrapeInterval)*time.Second,
+			time.Duration(cfg.ScrapeTimeout)*time.Second,
+		)
+		discoverer := discovery.NewFileDiscoverer(
+			cfg.TargetFiles,
+			cfg.ScrapeTargets,
+			time.Duration(cfg.RefreshInterval)*time.Second,
+			scrapeManager,
+		)
+		wg.Add(2)
+		go scrapeManager.Run(ctx, wg)
+		go discoverer.Run(ctx, wg)
+		routerOpts = append(routerOpts, application.WithTargetsProvider(scrapeManager))
+	}
+
+	graphiteTemplates, err := graphite.ParseTemplates(cfg.GraphiteTemplates)
+	if err != nil {
+		log.Fatalf("Could not parse graphite templates: %s", err.Error())
+	}
+	graphiteServer := graphite.NewServer(
+		repo,
+		graphite.WithTCP(cfg.GraphiteTCPAddress),
+		graphite.WithUDP(cfg.GraphiteUDPAddress),
+		graphite.WithPickle(cfg.GraphitePickleAddress),
+		graphite.WithTemplates(graphiteTemplates),
+		graphite.WithBatching(time.Duration(cfg.GraphiteFlushInterval)*time.Second, int(cfg.GraphiteBatchSize)),
+		graphite.WithTimestampWindow(
+			time.Duration(cfg.MaxSampleAge)*time.Second,
+			time.Duration(cfg.MaxSampleSkew)*time.Second,
+		),
+	)
+	if graphiteServer.Enabled() {
+		if err := graphiteServer.Listen(); err != nil {
+			log.Fatalf("Could not start graphite listeners: %s", err.Error())
+		}
+		wg.Add(1)
+		go graphiteServer.Run(ctx, wg)
+	}
+
+	if cfg.StatsDAddress != "" {
+		statsdServer := statsd.NewServer(
+			repo,
+			cfg.StatsDAddress,
+			statsd.WithFlushInterval(time.Duration(cfg.StatsDFlushInterval)*time.Second),
+		)
+		if err := statsdServer.Listen(); err != nil {
+			log.Fatalf("Could not start statsd listener: %s", err.Error())
+		}
+		wg.Add(1)
+		go statsdServer.Run(ctx, wg)
+	}
+
+	// alerting runs only when something can fire or be notified
+	if len(cfg.AlertRuleFiles) > 0 || len(cfg.AlertWebhooks) > 0 || cfg.AlertStatePath != "" || cfg.AlertPingRule {
+		var webhookOpts []alerting.WebhookOption
+		if cfg.AlertWebhookTemplate != "" {
+			payload, err := alerting.ParsePayloadTemplate(cfg.AlertWebhookTemplate)
+			if err != nil {
+				log.Fatalf("Could not parse webhook template: %s", err.Error())
+			}
+			webhookOpts = append(webhookOpts, alerting.WithPayloadTemplate(payload))
+		}
+		webhookOpts = append(webhookOpts, alerting.WithRetries(int(cfg.AlertWebhookRetries), time.Second))
+		notifier := alerting.NewWebhookNotifier(cfg.AlertWebhooks, webhookOpts...)
+		ruleFiles, err := alerting.LoadRuleFiles(cfg.AlertRuleFiles)
+		if err != nil {
+			log.Fatalf("Could not load alerting rules: %s", err.Error())
+		}
+		inhibitRules, err := alerting.LoadInhibitRules(cfg.AlertInhibitRuleFiles)
+		if err != nil {
+			log.Fatalf("Could not load inhibit rules: %s", err.Error())
+		}
+		history, _ := repo.(repository.HistoryRepository)
+		managerOpts := []alerting.Option{
+			alerting.WithStatePath(cfg.AlertStatePath),
+			alerting.WithRuleFiles(ruleFiles),
+		}
+		if cfg.AlertPingRule {
+			managerOpts = append(managerOpts, alerting.WithPingRule(repo))
+		}
+		// the manager doesn't notify itself, the dispatcher groups and mutes its alerts
+		alertManager, err := alerting.NewManager(
+			promql.NewEngine(promql.NewRepositoryStorage(repo, history)),
+			nil,
+			time.Duration(cfg.AlertEvaluationInterval)*time.Second,
+			managerOpts...,
+		)
+		if err != nil {
+			log.Fatalf("Could not init alerting: %s", err.Error())
+		}
+		var dispatcherOpts []alerting.DispatcherOption
+		if silenceRepo, ok := repo.(repository.SilenceRepository); ok {
+			silences := alerting.NewSilences(silenceRepo)
+			dispatcherOpts = append(dispatcherOpts, alerting.WithSilences(silences))
+			routerOpts = append(routerOpts, application.WithSilences(silences))
+		}
+		dispatcher, err := alerting.NewDispatcher(alertManager, notifier, inhibitRules, alerting.GroupOptions{
+			By:             cfg.AlertGroupBy,
+			Wait:           time.Duration(cfg.AlertGroupWait) * time.Second,
+			Interval:       time.Duration(cfg.AlertGroupInterval) * time.Second,
+			RepeatInterval: time.Duration(cfg.AlertRepeatInterval) * time.Second,
+		}, dispatcherOpts...)
+		if err != nil {
+			log.Fatalf("Could not init alert dispatcher: %s", err.Error())
+		}
+		wg.Add(3)
+		go notifier.Run(ctx, wg)
+		go alertManager.Run(ctx, wg)
+		go dispatcher.Run(ctx, wg)
+		routerOpts = append(routerOpts, application.WithAlertManager(alertManager))
 	}
 
 	errChan := make(chan error, 1)
 	stopChan := make(chan os.Signal, 1)
 	signal.Notify(stopChan, syscall.SIGINT, syscall.SIGTERM)
 
-	router := application.NewRouter(repo)
+	router := application.NewRouter(repo, routerOpts...)
 
 	srv := &http.Server{
 		Addr:    cfg.ServerAddress,
 		Handler: router,
 	}
+	// Shutdown doesn't wait for the streams to end by themselves
+	srv.RegisterOnShutdown(streamHub.Close)
 
 	go func() {
 		log.Infof("Starting server on port %s", cfg.ServerAddress)
@@ -69,9 +269,11 @@ func main() {
 	select {
 	case <-stopChan:
 		log.Info("Received signal to stop. Shutting down...")
-		if err := srv.Shutdown(ctx); err != nil {
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		if err := srv.Shutdown(shutdownCtx); err != nil {
 			log.Errorf("Server shutdown failed:%+v", err)
 		}
+		shutdownCancel()
 		cancel()
 		wg.Wait()
 	case err := <-errChan:
@@ -0,0 +1,105 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/avast/retry-go"
+
+	"github.com/gonozov0/go-musthave-devops/internal/shared"
+)
+
+// ChunkResult is the outcome of sending a single chunk of a batch.
+type ChunkResult struct {
+	Start    int // index of the first metric of the chunk in the batch
+	End      int // index after the last metric of the chunk in the batch
+	Bytes    int // size of the chunk encoded as a JSON array
+	Attempts uint
+	Err      error
+	Dropped  bool // the error is unrecoverable, so sending the chunk again won't help
+}
+
+// SendError is returned by the sinks of NewSink when some chunks weren't sent.
+type SendError struct {
+	Unsent []shared.Metric // metrics of the failed chunks that may be sent again later
+	Chunks []ChunkResult   // results of all chunks, empty when the metrics couldn't be split
+	Err    error
+}
+
+// Error returns the joined errors of the failed chunks.
+func (e *SendError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the joined errors of the failed chunks.
+func (e *SendError) Unwrap() error {
+	return e.Err
+}
+
+// splitBatch splits the metrics into chunks of at most maxMetrics metrics whose JSON encoding
+// doesn't exceed maxBytes. A metric larger than maxBytes is sent in a chunk of its own.
+// Zero limits are ignored.
+func splitBatch(metrics []shared.Metric, maxMetrics, maxBytes int) ([]ChunkResult, error) {
+	var chunks []ChunkResult
+	chunk := ChunkResult{Bytes: 2} // the array brackets
+	for i, metric := range metrics {
+		encoded, err := json.Marshal(metric)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode metric %s: %w", metric.ID, err)
+		}
+		size := len(encoded)
+		if chunk.End > chunk.Start {
+			size++ // the separating comma
+		}
+
+		full := maxMetrics > 0 && chunk.End-chunk.Start >= maxMetrics
+		tooLarge := maxBytes > 0 && chunk.Bytes+size > maxBytes
+		if chunk.End > chunk.Start && (full || tooLarge) {
+			chunks = append(chunks, chunk)
+			chunk = ChunkResult{Start: i, End: i, Bytes: 2}
+			size = len(encoded)
+		}
+		chunk.End = i + 1
+		chunk.Bytes += size
+	}
+	if chunk.End > chunk.Start {
+		chunks = append(chunks, chunk)
+	}
+	return chunks, nil
+}
+
+// sendChunks splits the metrics and sends every chunk with its own retries.
+// It keeps going after a failed chunk and returns the results of all chunks
+// together with the joined errors of the failed ones.
+func sendChunks(
+	sink Sink,
+	metrics []shared.Metric,
+	maxMetrics, maxBytes int,
+	attempts uint,
+	opts ...retry.Option,
+) ([]ChunkResult, error) {
+	chunks, err := splitBatch(metrics, maxMetrics, maxBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	for i := range chunks {
+		chunk := &chunks[i]
+		batch := metrics[chunk.Start:chunk.End]
+		chunk.Err = retry.Do(
+			func() error {
+				chunk.Attempts++
+				err := sink.Send(batch)
+				chunk.Dropped = !retry.IsRecoverable(err)
+				return err
+			},
+			append([]retry.Option{retry.Attempts(attempts), retry.LastErrorOnly(true)}, opts...)...,
+		)
+		if chunk.Err != nil {
+			errs = append(errs, fmt.Errorf("failed to send batch %d-%d: %w", chunk.Start, chunk.End, chunk.Err))
+		}
+	}
+	return chunks, errors.Join(errs...)
+}
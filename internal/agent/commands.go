@@ -0,0 +1,123 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/gonozov0/go-musthave-devops/internal/shared"
+)
+
+// NewSinks creates the sinks described by the configs.
+func NewSinks(cfgs []SinkConfig) ([]Sink, error) {
+	sinks := make([]Sink, 0, len(cfgs))
+	for _, cfg := range cfgs {
+		sink, err := NewSink(cfg)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+	return sinks, nil
+}
+
+// SendToSinks sends the metrics to every sink and returns the joined errors of the failed ones.
+func SendToSinks(sinks []Sink, metrics []shared.Metric) error {
+	var errs []error
+	for _, sink := range sinks {
+		if err := sink.Send(metrics); err != nil {
+			errs = append(errs, fmt.Errorf("failed to send metrics to %s: %w", sink.Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ParseMetric builds a metric from the push subcommand arguments.
+func ParseMetric(mType, name, value string) (shared.Metric, error) {
+	if name == "" {
+		return shared.Metric{}, errors.New("metric name is required")
+	}
+	switch mType {
+	case shared.Gauge:
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return shared.Metric{}, fmt.Errorf("invalid gauge value %q: %w", value, err)
+		}
+		return shared.Metric{ID: name, MType: mType, Value: &parsed}, nil
+	case shared.Counter:
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return shared.Metric{}, fmt.Errorf("invalid counter value %q: %w", value, err)
+		}
+		return shared.Metric{ID: name, MType: mType, Delta: &parsed}, nil
+	default:
+		return shared.Metric{}, fmt.Errorf("unknown metric type %q", mType)
+	}
+}
+
+// PrintMetrics writes the metrics as a table or as a JSON array.
+func PrintMetrics(w io.Writer, metrics []shared.Metric, format string) error {
+	switch format {
+	case FormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(metrics)
+	case FormatTable:
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "NAME\tTYPE\tVALUE")
+		for _, metric := range metrics {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", metric.ID, metric.MType, formatMetricValue(metric))
+		}
+		return tw.Flush()
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+}
+
+// checkMetric is the metric looked up by Check.
+const checkMetric = "AgentCheck"
+
+// Check verifies that the server is reachable and its storage is available via GET /ping,
+// then looks up a probe metric via POST /value with the configured compression to make sure
+// the server reads the compressed requests of the agent. Nothing is stored on the server,
+// the probe usually doesn't exist, so 404 is an expected answer. The server has no authentication,
+// so a 401 or 403 can only come from a proxy in front of it.
+func Check(serverAddress, compression string) error {
+	client := &http.Client{}
+
+	r, err := client.Get(buildURL(serverAddress, "/ping"))
+	if err != nil {
+		return fmt.Errorf("server is unreachable: %w", err)
+	}
+	body, err := readResponseBody(r)
+	r.Body.Close()
+	if err != nil {
+		return fmt.Errorf("failed to read ping response: %w", err)
+	}
+	if r.StatusCode != http.StatusOK {
+		return fmt.Errorf("ping failed: %d, error: %s", r.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	sink, err := newServerSink(serverAddress, compression)
+	if err != nil {
+		return err
+	}
+	probe := shared.Metric{ID: checkMetric, MType: shared.Counter}
+	status, body, err := sink.postJSON(buildURL(serverAddress, "/value/"), probe, sink.currentEncoding())
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	switch status {
+	case http.StatusOK, http.StatusNotFound:
+		return nil
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return fmt.Errorf("request of the agent was refused: %d, error: %s", status, strings.TrimSpace(string(body)))
+	default:
+		return fmt.Errorf("server doesn't accept the requests: %d, error: %s", status, strings.TrimSpace(string(body)))
+	}
+}
@@ -0,0 +1,102 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gonozov0/go-musthave-devops/internal/server/application"
+	repository "github.com/gonozov0/go-musthave-devops/internal/server/repository/in_memory"
+	"github.com/gonozov0/go-musthave-devops/internal/shared"
+)
+
+func TestLoadConfigCommands(t *testing.T) {
+	cfg, err := loadConfig(nil)
+	require.NoError(t, err)
+	require.Equal(t, CommandRun, cfg.Command)
+
+	cfg, err = loadConfig([]string{"push", "-a", "example.com:8080", "counter", "deploys", "1"})
+	require.NoError(t, err)
+	require.Equal(t, CommandPush, cfg.Command)
+	require.Equal(t, []string{"counter", "deploys", "1"}, cfg.Args)
+	require.Equal(t, "example.com:8080", cfg.Sinks[0].Address)
+
+	cfg, err = loadConfig([]string{"collect", "--once", "--print", "--format", "json"})
+	require.NoError(t, err)
+	require.True(t, cfg.Once)
+	require.True(t, cfg.Print)
+	require.Equal(t, FormatJSON, cfg.Format)
+
+	_, err = loadConfig([]string{"push", "counter", "deploys"})
+	require.Error(t, err)
+	_, err = loadConfig([]string{"check", "extra"})
+	require.Error(t, err)
+	_, err = loadConfig([]string{"run", "--once"})
+	require.Error(t, err)
+	_, err = loadConfig([]string{"deploy"})
+	require.Error(t, err)
+}
+
+func TestParseMetric(t *testing.T) {
+	metric, err := ParseMetric(shared.Counter, "deploys", "1")
+	require.NoError(t, err)
+	require.Equal(t, int64(1), *metric.Delta)
+
+	metric, err = ParseMetric(shared.Gauge, "temperature", "36.6")
+	require.NoError(t, err)
+	require.Equal(t, 36.6, *metric.Value)
+
+	_, err = ParseMetric(shared.Counter, "deploys", "1.5")
+	require.Error(t, err)
+	_, err = ParseMetric("histogram", "latency", "1")
+	require.Error(t, err)
+}
+
+func TestPrintMetrics(t *testing.T) {
+	metrics := aggregateMetrics(testMetrics())
+
+	var table bytes.Buffer
+	require.NoError(t, PrintMetrics(&table, metrics, FormatTable))
+	require.Equal(t, "NAME       TYPE     VALUE\nAlloc      gauge    2.5\nPollCount  counter  5\n", table.String())
+
+	var buffer bytes.Buffer
+	require.NoError(t, PrintMetrics(&buffer, metrics, FormatJSON))
+	var printed []shared.Metric
+	require.NoError(t, json.Unmarshal(buffer.Bytes(), &printed))
+	require.Equal(t, metrics, printed)
+}
+
+func TestCheck(t *testing.T) {
+	repo := repository.NewInMemoryRepository()
+	server := httptest.NewServer(application.NewRouter(repo))
+	defer server.Close()
+	require.NoError(t, Check(server.URL, CompressionAuto))
+	_, err := repo.GetCounter(checkMetric)
+	require.Error(t, err, "the check must not store anything")
+
+	// the probe is a valid request, so a 400 means the server can't read the requests of the agent
+	rejected := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/ping" {
+			return
+		}
+		http.Error(w, "unsupported encoding", http.StatusBadRequest)
+	}))
+	defer rejected.Close()
+	require.ErrorContains(t, Check(rejected.URL, ""), "unsupported encoding")
+
+	forbidden := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/ping" {
+			return
+		}
+		http.Error(w, "invalid signature", http.StatusForbidden)
+	}))
+	defer forbidden.Close()
+	require.ErrorContains(t, Check(forbidden.URL, ""), "invalid signature")
+
+	server.Close()
+	require.Error(t, Check(server.URL, ""))
+}
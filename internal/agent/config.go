@@ -1,10 +1,29 @@
 package agent
 
 import (
+	"encoding/json"
 	"errors"
 	"flag"
+	"fmt"
 	"os"
 	"strconv"
+	"strings"
+
+	"github.com/gonozov0/go-musthave-devops/internal/shared"
+)
+
+// Subcommands of the agent binary.
+const (
+	CommandRun     = "run"     // collect and send metrics until stopped, the default
+	CommandPush    = "push"    // send a single metric: push <type> <name> <value>
+	CommandCollect = "collect" // collect metrics and send or print them
+	CommandCheck   = "check"   // check that the server is reachable and accepts metrics
+)
+
+// Output formats of the collect subcommand.
+const (
+	FormatTable = "table"
+	FormatJSON  = "json"
 )
 
 // Config is a struct that represents configuration
@@ -12,6 +31,31 @@ type Config struct {
 	PollInterval   int // in seconds
 	ReportInterval int // in seconds
 	ServerAddress  string
+	Align          bool   // align collection and reporting to multiples of the intervals
+	ReportSplay    int    // max random delay of reporting (in seconds)
+	BatchSize      int    // max metrics per request to the server, 0 disables the limit
+	BatchBytes     int    // max JSON size of a request to the server, 0 disables the limit
+	MaxPending     int    // max metrics kept for a sink that failed to send them, 0 disables the limit
+	Compression    string // encoding of requests to the server, see CompressionAuto
+	SinksFile      string // path to a JSON file with the list of sinks
+	Sinks          []SinkConfig
+	ListenAddress  string // address of the scrape endpoint, empty disables it
+	RelayAddress   string // address to receive metrics from other agents, empty disables relay mode
+	RelayPrefix    string
+	RelayLabels    map[string]string
+	RulesFile      string // path to a JSON file with the list of rules
+	Rules          []Rule
+	DryRun         bool     // print how the rules transform one collection cycle and exit
+	Command        string   // one of the Command constants
+	Args           []string // positional arguments of the subcommand
+	Once           bool     // collect: run a single collection cycle
+	Print          bool     // collect: print metrics instead of sending them
+	Format         string   // collect: output format of printed metrics
+
+	ProfileInterval int      // in seconds, 0 disables profiling
+	ProfileDuration int      // duration of CPU profiles (in seconds)
+	ProfileSource   string   // name of the agent process in the stored profiles
+	ProfileTargets  []string // base URLs of net/http/pprof endpoints to profile
 }
 
 // newConfig returns a new Config struct with default values
@@ -20,13 +64,34 @@ func newConfig() Config {
 		PollInterval:   2,
 		ReportInterval: 10,
 		ServerAddress:  "localhost:8080",
+		Compression:    shared.EncodingGzip,
+		MaxPending:     10000,
+		Command:        CommandRun,
+		Format:         FormatTable,
+
+		ProfileDuration: 10,
+		ProfileSource:   defaultProfileSource(),
 	}
 }
 
-// LoadConfig loads the configuration from envs and command-line flags
+// LoadConfig loads the configuration from envs and command-line arguments.
+// The first argument may be a subcommand, run is used otherwise.
 func LoadConfig() (Config, error) {
+	return loadConfig(os.Args[1:])
+}
+
+func loadConfig(args []string) (Config, error) {
 	config := newConfig()
 
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		config.Command, args = args[0], args[1:]
+	}
+	switch config.Command {
+	case CommandRun, CommandPush, CommandCollect, CommandCheck:
+	default:
+		return config, fmt.Errorf("unknown command %q", config.Command)
+	}
+
 	if envPollInterval, exists := os.LookupEnv("POLL_INTERVAL"); exists {
 		parsed, err := strconv.Atoi(envPollInterval)
 		if err == nil {
@@ -39,19 +104,231 @@ func LoadConfig() (Config, error) {
 			config.ReportInterval = parsed
 		}
 	}
+	if envAlign, exists := os.LookupEnv("ALIGN"); exists {
+		parsed, err := strconv.ParseBool(envAlign)
+		if err == nil {
+			config.Align = parsed
+		}
+	}
+	if envReportSplay, exists := os.LookupEnv("REPORT_SPLAY"); exists {
+		parsed, err := strconv.Atoi(envReportSplay)
+		if err == nil {
+			config.ReportSplay = parsed
+		}
+	}
 	if envAddress, exists := os.LookupEnv("ADDRESS"); exists {
 		config.ServerAddress = envAddress
 	}
+	if envBatchSize, exists := os.LookupEnv("BATCH_SIZE"); exists {
+		parsed, err := strconv.Atoi(envBatchSize)
+		if err == nil {
+			config.BatchSize = parsed
+		}
+	}
+	if envBatchBytes, exists := os.LookupEnv("BATCH_BYTES"); exists {
+		parsed, err := strconv.Atoi(envBatchBytes)
+		if err == nil {
+			config.BatchBytes = parsed
+		}
+	}
+	if envMaxPending, exists := os.LookupEnv("MAX_PENDING"); exists {
+		parsed, err := strconv.Atoi(envMaxPending)
+		if err == nil {
+			config.MaxPending = parsed
+		}
+	}
+	if envCompression, exists := os.LookupEnv("COMPRESSION"); exists {
+		config.Compression = envCompression
+	}
+	if envSinksFile, exists := os.LookupEnv("SINKS_FILE"); exists {
+		config.SinksFile = envSinksFile
+	}
+	if envListenAddress, exists := os.LookupEnv("LISTEN_ADDRESS"); exists {
+		config.ListenAddress = envListenAddress
+	}
+	if envRelayAddress, exists := os.LookupEnv("RELAY_ADDRESS"); exists {
+		config.RelayAddress = envRelayAddress
+	}
+	if envRelayPrefix, exists := os.LookupEnv("RELAY_PREFIX"); exists {
+		config.RelayPrefix = envRelayPrefix
+	}
+	if envProfileInterval, exists := os.LookupEnv("PROFILE_INTERVAL"); exists {
+		parsed, err := strconv.Atoi(envProfileInterval)
+		if err == nil {
+			config.ProfileInterval = parsed
+		}
+	}
+	if envProfileDuration, exists := os.LookupEnv("PROFILE_DURATION"); exists {
+		parsed, err := strconv.Atoi(envProfileDuration)
+		if err == nil {
+			config.ProfileDuration = parsed
+		}
+	}
+	if envProfileSource, exists := os.LookupEnv("PROFILE_SOURCE"); exists {
+		config.ProfileSource = envProfileSource
+	}
+	if envProfileTargets, exists := os.LookupEnv("PROFILE_TARGETS"); exists {
+		config.ProfileTargets = splitList(envProfileTargets)
+	}
+	if envRulesFile, exists := os.LookupEnv("RULES_FILE"); exists {
+		config.RulesFile = envRulesFile
+	}
+	if envRelayLabels, exists := os.LookupEnv("RELAY_LABELS"); exists {
+		labels, err := parseLabels(envRelayLabels)
+		if err != nil {
+			return config, fmt.Errorf("failed to parse RELAY_LABELS: %w", err)
+		}
+		config.RelayLabels = labels
+	}
+
+	flags := flag.NewFlagSet(config.Command, flag.ContinueOnError)
+	flags.StringVar(&config.ServerAddress, "a", config.ServerAddress, "HTTP server endpoint address")
+	flags.IntVar(&config.ReportInterval, "r", config.ReportInterval, "Frequency of sending metrics to the server (in seconds)")
+	flags.IntVar(&config.PollInterval, "p", config.PollInterval, "Frequency of polling metrics from the runtime package (in seconds)")
+	flags.BoolVar(&config.Align, "align", config.Align, "Align collection and reporting to wall-clock multiples of the intervals")
+	flags.IntVar(&config.ReportSplay, "splay", config.ReportSplay, "Max random delay of reporting (in seconds)")
+	flags.IntVar(&config.BatchSize, "batch-size", config.BatchSize, "Max number of metrics in a single request to the server")
+	flags.IntVar(&config.BatchBytes, "batch-bytes", config.BatchBytes, "Max size of JSON metrics in a single request to the server (in bytes)")
+	flags.IntVar(&config.MaxPending, "max-pending", config.MaxPending, "Max number of unsent metrics kept for every sink, the oldest are dropped")
+	flags.StringVar(&config.Compression, "compression", config.Compression, "Compression of requests to the server: zstd, br, gzip, deflate, none or auto")
+	flags.StringVar(&config.SinksFile, "sinks", config.SinksFile, "Path to a JSON file with the output sinks configuration")
+	flags.StringVar(&config.ListenAddress, "l", config.ListenAddress, "Address to serve collected metrics for scraping")
+	flags.StringVar(&config.RelayAddress, "relay", config.RelayAddress, "Address to receive metrics from other agents in relay mode")
+	flags.StringVar(&config.RelayPrefix, "relay-prefix", config.RelayPrefix, "Prefix added to the names of relayed metrics")
+	flags.Func("relay-labels", "Comma-separated key=value labels added to relayed metrics", func(value string) error {
+		labels, err := parseLabels(value)
+		if err != nil {
+			return err
+		}
+		config.RelayLabels = labels
+		return nil
+	})
+	flags.IntVar(&config.ProfileInterval, "profile-interval", config.ProfileInterval, "Frequency of uploading pprof profiles (in seconds), 0 disables profiling")
+	flags.IntVar(&config.ProfileDuration, "profile-duration", config.ProfileDuration, "Duration of CPU profiles (in seconds)")
+	flags.StringVar(&config.ProfileSource, "profile-source", config.ProfileSource, "Name of the agent process in the uploaded profiles")
+	flags.Func("profile-targets", "Comma-separated list of net/http/pprof base URLs to profile", func(value string) error {
+		config.ProfileTargets = splitList(value)
+		return nil
+	})
+	flags.StringVar(&config.RulesFile, "rules", config.RulesFile, "Path to a JSON file with the metric rules")
+	flags.BoolVar(&config.DryRun, "dry-run", config.DryRun, "Print how the rules transform one collection cycle and exit")
 
-	flag.StringVar(&config.ServerAddress, "a", config.ServerAddress, "HTTP server endpoint address")
-	flag.IntVar(&config.ReportInterval, "r", config.ReportInterval, "Frequency of sending metrics to the server (in seconds)")
-	flag.IntVar(&config.PollInterval, "p", config.PollInterval, "Frequency of polling metrics from the runtime package (in seconds)")
+	if config.Command == CommandCollect {
+		flags.BoolVar(&config.Once, "once", config.Once, "Run a single collection cycle and exit")
+		flags.BoolVar(&config.Print, "print", config.Print, "Print collected metrics instead of sending them")
+		flags.StringVar(&config.Format, "format", config.Format, "Format of printed metrics: table or json")
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return config, err
+	}
+	config.Args = flags.Args()
+
+	switch config.Command {
+	case CommandPush:
+		if len(config.Args) != 3 {
+			return config, errors.New("push expects <type> <name> <value> arguments")
+		}
+	default:
+		if len(config.Args) > 0 {
+			return config, errors.New("unexpected arguments provided")
+		}
+	}
+	if config.Format != FormatTable && config.Format != FormatJSON {
+		return config, fmt.Errorf("unknown format %q", config.Format)
+	}
+	if config.ProfileInterval > 0 && (config.ProfileDuration <= 0 || config.ProfileDuration >= config.ProfileInterval) {
+		return config, errors.New("profile duration must be positive and less than the profile interval")
+	}
 
-	flag.Parse()
+	defaultServerSink := SinkConfig{
+		Type:          SinkServer,
+		Address:       config.ServerAddress,
+		Compression:   config.Compression,
+		BatchSize:     config.BatchSize,
+		BatchBytes:    config.BatchBytes,
+		RetryAttempts: 3,
+		RetryDelay:    1,
+	}
+	sinks, err := loadSinks(config.SinksFile, defaultServerSink)
+	if err != nil {
+		return config, err
+	}
+	config.Sinks = sinks
 
-	if len(flag.Args()) > 0 {
-		return config, errors.New("unexpected arguments provided")
+	if config.RulesFile != "" {
+		if err := readJSONFile(config.RulesFile, &config.Rules); err != nil {
+			return config, fmt.Errorf("failed to load rules: %w", err)
+		}
 	}
 
 	return config, nil
 }
+
+// loadSinks reads the sinks configuration from the file.
+// Without a file the agent sends metrics only to the metrics server.
+// An empty list in the file is allowed for agents that are only scraped.
+func loadSinks(path string, defaultServerSink SinkConfig) ([]SinkConfig, error) {
+	if path == "" {
+		return []SinkConfig{defaultServerSink}, nil
+	}
+
+	var sinks []SinkConfig
+	if err := readJSONFile(path, &sinks); err != nil {
+		return nil, fmt.Errorf("failed to load sinks: %w", err)
+	}
+	for i := range sinks {
+		if sinks[i].Type == SinkServer && sinks[i].Address == "" {
+			sinks[i].Address = defaultServerSink.Address
+		}
+	}
+	return sinks, nil
+}
+
+// splitList splits a comma-separated list skipping empty items.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// defaultProfileSource names the agent process by the host name.
+func defaultProfileSource() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "agent"
+	}
+	return "agent@" + hostname
+}
+
+// parseLabels parses a comma-separated list of key=value pairs.
+func parseLabels(value string) (map[string]string, error) {
+	labels := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		key, val, ok := strings.Cut(pair, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid label %q", pair)
+		}
+		labels[key] = val
+	}
+	return labels, nil
+}
+
+// readJSONFile unmarshals the JSON file into v.
+func readJSONFile(path string, v interface{}) error {
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read file: %w", err)
+	}
+	if err := json.Unmarshal(bytes, v); err != nil {
+		return fmt.Errorf("failed to unmarshal file: %w", err)
+	}
+	return nil
+}
@@ -1,26 +1,16 @@
 package agent
 
 import (
-	"bytes"
-	"compress/gzip"
-	"encoding/json"
-	"errors"
 	"fmt"
-	"io"
-	"net"
-	"net/http"
 	"runtime"
-	"strings"
 	"time"
 
-	"github.com/avast/retry-go"
-
 	"github.com/gonozov0/go-musthave-devops/internal/shared"
 )
 
 var pollCount int64
 
-// CollectMetrics collects metrics from the runtime and returns them as a slice
+// CollectMetrics collects metrics from the runtime and returns them as a slice stamped with the collection time
 func CollectMetrics() []shared.Metric {
 	var metrics []shared.Metric
 	var memStats runtime.MemStats
@@ -60,6 +50,11 @@ func CollectMetrics() []shared.Metric {
 		newGaugeMetric("RandomValue", time.Now().UnixNano()),
 	)
 
+	timestamp := time.Now().UnixMilli()
+	for i := range metrics {
+		metrics[i].Timestamp = &timestamp
+	}
+
 	return metrics
 }
 
@@ -85,76 +80,3 @@ func newGaugeMetric(metricName string, metricValue interface{}) shared.Metric {
 func newCounterMetric(metricName string, metricValue int64) shared.Metric {
 	return shared.Metric{ID: metricName, MType: shared.Counter, Delta: &metricValue}
 }
-
-// SendMetrics sends metrics to the server and returns a new metrics slice
-func SendMetrics(metrics []shared.Metric, serverAddress string) ([]shared.Metric, error) {
-	var url string
-	if !strings.HasPrefix(serverAddress, "http") {
-		url += "http://"
-	}
-	url += fmt.Sprintf("%s/updates/", serverAddress)
-
-	var (
-		statusCode int
-		body       []byte
-		buffer     bytes.Buffer
-	)
-	client := &http.Client{}
-	writer := gzip.NewWriter(&buffer)
-	encoder := json.NewEncoder(writer)
-
-	if err := encoder.Encode(metrics); err != nil {
-		return nil, fmt.Errorf("failed to encode metrics to JSON: %v", err)
-	}
-	if err := writer.Close(); err != nil {
-		return nil, fmt.Errorf("failed to close gzip writer: %v", err)
-	}
-	data := buffer.Bytes()
-
-	err := retry.Do(
-		func() error {
-			req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(data))
-			if err != nil {
-				return retry.Unrecoverable(err)
-			}
-			req.Header.Set("Content-Type", "application/json")
-			req.Header.Set("Accept", "application/json")
-			req.Header.Set("Content-Encoding", "gzip")
-			req.Header.Set("Accept-Encoding", "gzip")
-
-			r, err := client.Do(req)
-			if err != nil {
-				var netErr net.Error
-				if (errors.As(err, &netErr) && netErr.Timeout()) ||
-					strings.Contains(err.Error(), "EOF") ||
-					strings.Contains(err.Error(), "connection reset by peer") {
-					return err // retry only network errors
-				}
-				return retry.Unrecoverable(err)
-			}
-			defer r.Body.Close()
-			statusCode = r.StatusCode
-			body, err = io.ReadAll(r.Body)
-			if err != nil {
-				return retry.Unrecoverable(err)
-			}
-			return nil
-		},
-		retry.Attempts(3),
-		retry.Delay(time.Second),
-		retry.DelayType(retry.BackOffDelay),
-	)
-	if err != nil {
-		return nil, fmt.Errorf("failed to send metrics: %v", err)
-	}
-
-	if statusCode != http.StatusOK {
-		return nil, fmt.Errorf(
-			"received non-OK response while sending metrics: %d, error: %s",
-			statusCode,
-			string(body),
-		)
-	}
-
-	return []shared.Metric{}, nil
-}
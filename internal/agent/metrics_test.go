@@ -1,9 +1,12 @@
 package agent
 
 import (
+	"math"
 	"net/http/httptest"
 	"testing"
 
+	"github.com/avast/retry-go"
+
 	"github.com/gonozov0/go-musthave-devops/internal/server/application"
 	repository "github.com/gonozov0/go-musthave-devops/internal/server/repository/in_memory"
 
@@ -19,6 +22,7 @@ func TestCollectMetrics(t *testing.T) {
 
 	for _, metric := range metrics {
 		require.NotEmpty(t, metric.ID)
+		require.NotNil(t, metric.Timestamp)
 		if metric.ID == "PollCount" {
 			require.Equal(t, "counter", metric.MType)
 			require.NotNil(t, metric.Delta)
@@ -29,22 +33,15 @@ func TestCollectMetrics(t *testing.T) {
 	}
 }
 
-func TestSendMetrics(t *testing.T) {
+func TestServerSinkEncodeError(t *testing.T) {
 	repo := repository.NewInMemoryRepository()
-	router := application.NewRouter(repo)
-	server := httptest.NewServer(router)
+	server := httptest.NewServer(application.NewRouter(repo))
 	defer server.Close()
 
-	testFloat := 42.0
-	testInt := int64(42)
-
-	metrics := []shared.Metric{
-		{ID: "TestMetric1", MType: shared.Gauge, Value: &testFloat},
-		{ID: "TestMetric2", MType: shared.Counter, Delta: &testInt},
-	}
-
-	newMetrics, err := SendMetrics(metrics, server.URL)
-
+	nan := math.NaN()
+	sink, err := newServerSink(server.URL, "")
 	require.NoError(t, err)
-	require.Empty(t, newMetrics)
+	err = sink.Send([]shared.Metric{{ID: "TestNaN", MType: shared.Gauge, Value: &nan}})
+	require.Error(t, err)
+	require.False(t, retry.IsRecoverable(err))
 }
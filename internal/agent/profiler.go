@@ -0,0 +1,173 @@
+package agent
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"runtime/pprof"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/avast/retry-go"
+)
+
+// Profile types collected by the Profiler.
+const (
+	ProfileCPU       = "cpu"
+	ProfileHeap      = "heap"
+	ProfileGoroutine = "goroutine"
+)
+
+// profileTypes are collected in this order, the CPU profile takes the whole profiling duration.
+var profileTypes = []string{ProfileCPU, ProfileHeap, ProfileGoroutine}
+
+// Profiler takes pprof profiles of the agent process and of net/http/pprof endpoints
+// and uploads them to the server.
+type Profiler struct {
+	uploadURL   string
+	source      string
+	targets     []string
+	cpuDuration time.Duration
+	client      *http.Client
+}
+
+// NewProfiler creates a new Profiler. The source names the agent process in the stored profiles,
+// the targets are base URLs of net/http/pprof endpoints, their profiles are named by the target host.
+func NewProfiler(serverAddress, source string, targets []string, cpuDuration time.Duration) *Profiler {
+	return &Profiler{
+		uploadURL:   buildURL(serverAddress, "/api/v1/profiles"),
+		source:      source,
+		targets:     targets,
+		cpuDuration: cpuDuration,
+		client:      &http.Client{},
+	}
+}
+
+// Collect takes the profiles of the agent and of every target concurrently and uploads them.
+// It returns the joined errors of the profiles that failed.
+func (p *Profiler) Collect() error {
+	var (
+		mu   sync.Mutex
+		errs []error
+		wg   sync.WaitGroup
+	)
+	addErr := func(err error) {
+		mu.Lock()
+		errs = append(errs, err)
+		mu.Unlock()
+	}
+
+	wg.Add(1 + len(p.targets))
+	go func() {
+		defer wg.Done()
+		for _, profileType := range profileTypes {
+			timestamp := time.Now()
+			data, err := p.profileSelf(profileType)
+			if err == nil {
+				err = p.upload(p.source, profileType, timestamp, data)
+			}
+			if err != nil {
+				addErr(fmt.Errorf("%s profile of %s: %w", profileType, p.source, err))
+			}
+		}
+	}()
+	for _, target := range p.targets {
+		go func(target string) {
+			defer wg.Done()
+			source := targetSource(target)
+			for _, profileType := range profileTypes {
+				timestamp := time.Now()
+				data, err := p.profileTarget(target, profileType)
+				if err == nil {
+					err = p.upload(source, profileType, timestamp, data)
+				}
+				if err != nil {
+					addErr(fmt.Errorf("%s profile of %s: %w", profileType, source, err))
+				}
+			}
+		}(target)
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// profileSelf takes the profile of the agent process.
+func (p *Profiler) profileSelf(profileType string) ([]byte, error) {
+	var buffer bytes.Buffer
+	if profileType == ProfileCPU {
+		if err := pprof.StartCPUProfile(&buffer); err != nil {
+			return nil, err
+		}
+		time.Sleep(p.cpuDuration)
+		pprof.StopCPUProfile()
+		return buffer.Bytes(), nil
+	}
+
+	profile := pprof.Lookup(profileType)
+	if profile == nil {
+		return nil, fmt.Errorf("unknown profile type %q", profileType)
+	}
+	if err := profile.WriteTo(&buffer, 0); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// profileTarget downloads the profile from the net/http/pprof endpoint.
+func (p *Profiler) profileTarget(target, profileType string) ([]byte, error) {
+	path := "/debug/pprof/" + profileType
+	if profileType == ProfileCPU {
+		path = fmt.Sprintf("/debug/pprof/profile?seconds=%d", max(1, int(p.cpuDuration.Seconds())))
+	}
+
+	r, err := p.client.Get(buildURL(target, path))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Body.Close()
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	if r.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("received non-OK response: %d, error: %s", r.StatusCode, string(body))
+	}
+	return body, nil
+}
+
+// upload sends the profile to the server retrying server and network errors.
+func (p *Profiler) upload(source, profileType string, timestamp time.Time, data []byte) error {
+	query := url.Values{}
+	query.Set("source", source)
+	query.Set("type", profileType)
+	query.Set("timestamp", strconv.FormatInt(timestamp.UnixMilli(), 10))
+
+	return retry.Do(
+		func() error {
+			req, err := http.NewRequest(http.MethodPost, p.uploadURL+"?"+query.Encode(), bytes.NewReader(data))
+			if err != nil {
+				return retry.Unrecoverable(err)
+			}
+			req.Header.Set("Content-Type", "application/octet-stream")
+			return doHTTPRequest(p.client, req)
+		},
+		retry.Attempts(3),
+		retry.Delay(time.Second),
+		retry.DelayType(retry.BackOffDelay),
+		retry.LastErrorOnly(true),
+	)
+}
+
+// targetSource names the profiles of the target by its host.
+func targetSource(target string) string {
+	parsed, err := url.Parse(buildURL(target, ""))
+	if err != nil || parsed.Host == "" {
+		return target
+	}
+	return parsed.Host
+}
@@ -0,0 +1,55 @@
+package agent
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gonozov0/go-musthave-devops/internal/server/application"
+	"github.com/gonozov0/go-musthave-devops/internal/server/profiles"
+	repository "github.com/gonozov0/go-musthave-devops/internal/server/repository/in_memory"
+)
+
+func TestProfiler(t *testing.T) {
+	store, err := profiles.NewFileStore(t.TempDir(), profiles.Retention{})
+	require.NoError(t, err)
+	server := httptest.NewServer(application.NewRouter(
+		repository.NewInMemoryRepository(),
+		application.WithProfileStore(store),
+	))
+	defer server.Close()
+
+	var paths []string
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.RequestURI())
+		_, _ = w.Write([]byte("profile"))
+	}))
+	defer target.Close()
+
+	profiler := NewProfiler(server.URL, "agent1", []string{target.URL}, 10*time.Millisecond)
+	require.NoError(t, profiler.Collect())
+
+	require.Equal(t, []string{
+		"/debug/pprof/profile?seconds=1",
+		"/debug/pprof/heap",
+		"/debug/pprof/goroutine",
+	}, paths)
+
+	stored, err := store.List(profiles.Filter{Source: "agent1"})
+	require.NoError(t, err)
+	types := make([]string, 0, len(stored))
+	for _, profile := range stored {
+		require.Positive(t, profile.Size)
+		types = append(types, profile.Type)
+	}
+	sort.Strings(types)
+	require.Equal(t, []string{ProfileCPU, ProfileGoroutine, ProfileHeap}, types)
+
+	stored, err = store.List(profiles.Filter{Source: targetSource(target.URL), Type: ProfileHeap})
+	require.NoError(t, err)
+	require.Len(t, stored, 1)
+}
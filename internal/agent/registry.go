@@ -0,0 +1,94 @@
+package agent
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/gonozov0/go-musthave-devops/internal/shared"
+)
+
+// Registry keeps the current aggregated state of the collected metrics:
+// the latest value of every gauge and the running total of every counter.
+type Registry struct {
+	mu       sync.RWMutex
+	gauges   map[string]float64
+	counters map[string]int64
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		gauges:   make(map[string]float64),
+		counters: make(map[string]int64),
+	}
+}
+
+// Add merges collected metrics into the registry.
+func (r *Registry) Add(metrics []shared.Metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, metric := range metrics {
+		switch {
+		case metric.MType == shared.Gauge && metric.Value != nil:
+			r.gauges[metric.ID] = *metric.Value
+		case metric.MType == shared.Counter && metric.Delta != nil:
+			r.counters[metric.ID] += *metric.Delta
+		}
+	}
+}
+
+// Metrics returns the current state sorted by name. Counters carry their totals in Delta.
+func (r *Registry) Metrics() []shared.Metric {
+	r.mu.RLock()
+	metrics := make([]shared.Metric, 0, len(r.gauges)+len(r.counters))
+	for name, value := range r.gauges {
+		value := value
+		metrics = append(metrics, shared.Metric{ID: name, MType: shared.Gauge, Value: &value})
+	}
+	for name, total := range r.counters {
+		total := total
+		metrics = append(metrics, shared.Metric{ID: name, MType: shared.Counter, Delta: &total})
+	}
+	r.mu.RUnlock()
+
+	sort.Slice(metrics, func(i, j int) bool {
+		if metrics[i].ID == metrics[j].ID {
+			return metrics[i].MType < metrics[j].MType
+		}
+		return metrics[i].ID < metrics[j].ID
+	})
+	return metrics
+}
+
+// NewScrapeHandler returns the handler serving the registry state on GET /metrics.
+// It responds with a JSON array of shared.Metric when the client accepts application/json
+// and with the Prometheus text exposition format otherwise.
+func NewScrapeHandler(registry *Registry) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		metrics := registry.Metrics()
+		if strings.Contains(r.Header.Get("Accept"), "application/json") {
+			w.Header().Set("Content-Type", "application/json")
+			if err := json.NewEncoder(w).Encode(metrics); err != nil {
+				log.Errorf("failed to encode metrics: %v", err)
+			}
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		if err := shared.WritePrometheusText(w, metrics); err != nil {
+			log.Errorf("failed to write metrics: %v", err)
+		}
+	})
+	return mux
+}
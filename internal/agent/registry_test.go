@@ -0,0 +1,52 @@
+package agent
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gonozov0/go-musthave-devops/internal/shared"
+)
+
+func TestRegistry(t *testing.T) {
+	registry := NewRegistry()
+	registry.Add(testMetrics())
+	registry.Add(testMetrics())
+
+	metrics := registry.Metrics()
+	require.Len(t, metrics, 2)
+	require.Equal(t, "Alloc", metrics[0].ID)
+	require.Equal(t, 2.5, *metrics[0].Value)
+	require.Equal(t, "PollCount", metrics[1].ID)
+	require.Equal(t, int64(10), *metrics[1].Delta)
+}
+
+func TestScrapeHandler(t *testing.T) {
+	registry := NewRegistry()
+	registry.Add(testMetrics())
+	handler := NewScrapeHandler(registry)
+
+	t.Run("json", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		req.Header.Set("Accept", "application/json")
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+
+		require.Equal(t, http.StatusOK, recorder.Code)
+		var metrics []shared.Metric
+		require.NoError(t, json.NewDecoder(recorder.Body).Decode(&metrics))
+		require.Equal(t, registry.Metrics(), metrics)
+	})
+
+	t.Run("prometheus", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+
+		require.Equal(t, http.StatusOK, recorder.Code)
+		require.Equal(t, "# TYPE Alloc gauge\nAlloc 2.5\n# TYPE PollCount counter\nPollCount 5\n", recorder.Body.String())
+	})
+}
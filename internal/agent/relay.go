@@ -0,0 +1,127 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/go-chi/chi/v5"
+	chiMiddleware "github.com/go-chi/chi/v5/middleware"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/gonozov0/go-musthave-devops/internal/ingest"
+	"github.com/gonozov0/go-musthave-devops/internal/shared"
+)
+
+// Relay receives metrics from leaf agents through the JSON update API of the metrics server,
+// applies the rules of the pipeline to them, pre-aggregates them and forwards compacted batches
+// to the sinks on Flush. Metrics a sink failed to send are spooled and merged into the next batch of that sink,
+// metrics the sink rejected are dropped.
+type Relay struct {
+	buffer   *relayBuffer
+	handler  http.Handler
+	pipeline *Pipeline
+	prefix   string
+	labels   map[string]string
+
+	mu     sync.Mutex
+	sinks  []Sink
+	spools [][]shared.Metric
+}
+
+// NewRelay creates a new Relay. The rules of the pipeline are applied to the received metrics,
+// the prefix and the labels are added to every forwarded metric.
+func NewRelay(sinks []Sink, pipeline *Pipeline, prefix string, labels map[string]string) *Relay {
+	r := &Relay{
+		buffer:   newRelayBuffer(),
+		pipeline: pipeline,
+		prefix:   prefix,
+		labels:   labels,
+		sinks:    sinks,
+		spools:   make([][]shared.Metric, len(sinks)),
+	}
+
+	router := chi.NewRouter()
+	router.Use(chiMiddleware.Recoverer)
+	router.Use(chiMiddleware.StripSlashes)
+	router.Use(ingest.CompressionMiddleware)
+	router.Get("/ping", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	router.Post("/update", r.update)
+	router.Post("/updates", r.updates)
+	r.handler = router
+
+	return r
+}
+
+// Handler returns the HTTP handler serving /ping, /update and /updates with JSON bodies like the metrics server.
+func (r *Relay) Handler() http.Handler {
+	return r.handler
+}
+
+// update buffers the metric of POST /update and echoes it, the relay doesn't know the stored values upstream.
+func (r *Relay) update(w http.ResponseWriter, req *http.Request) {
+	var metric shared.Metric
+	if err := json.NewDecoder(req.Body).Decode(&metric); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := ingest.Validate(metric); err != nil {
+		ingest.Error(w, err)
+		return
+	}
+	r.buffer.add(r.pipeline.Apply([]shared.Metric{metric}))
+	writeJSON(w, metric)
+}
+
+// updates buffers the metrics of POST /updates and echoes them.
+func (r *Relay) updates(w http.ResponseWriter, req *http.Request) {
+	metrics, err := ingest.DecodeBatch(req.Body)
+	if err != nil {
+		ingest.Error(w, err)
+		return
+	}
+	r.buffer.add(r.pipeline.Apply(metrics))
+	writeJSON(w, metrics)
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Errorf("failed to encode metrics: %v", err)
+	}
+}
+
+// Flush forwards the buffered metrics to every sink.
+func (r *Relay) Flush() error {
+	metrics := r.buffer.drain()
+	for i := range metrics {
+		metrics[i].ID = shared.WithLabels(r.prefix+metrics[i].ID, r.labels)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var errs []error
+	for i, sink := range r.sinks {
+		batch := aggregateMetrics(append(r.spools[i], metrics...))
+		if len(batch) == 0 {
+			continue
+		}
+		if err := sink.Send(batch); err != nil {
+			r.spools[i] = batch
+			var sendErr *SendError
+			if errors.As(err, &sendErr) {
+				r.spools[i] = sendErr.Unsent
+			}
+			errs = append(errs, fmt.Errorf("failed to forward %d metrics to %s: %w", len(batch), sink.Name(), err))
+			continue
+		}
+		r.spools[i] = nil
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,102 @@
+package agent
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gonozov0/go-musthave-devops/internal/shared"
+)
+
+// relayBuffer pre-aggregates metrics received by the relay until they are drained:
+// gauges keep the newest sample, counters accumulate deltas, histograms are merged
+// and sets keep the distinct members.
+type relayBuffer struct {
+	mu         sync.Mutex
+	gauges     map[string]gaugeSample
+	counters   map[string]int64
+	histograms map[string]shared.HistogramValue
+	sets       map[string]map[string]struct{}
+}
+
+type gaugeSample struct {
+	value     float64
+	timestamp time.Time
+}
+
+func newRelayBuffer() *relayBuffer {
+	return &relayBuffer{
+		gauges:     make(map[string]gaugeSample),
+		counters:   make(map[string]int64),
+		histograms: make(map[string]shared.HistogramValue),
+		sets:       make(map[string]map[string]struct{}),
+	}
+}
+
+// add buffers the metrics. Gauges without a timestamp are stamped with the current time,
+// a gauge sample older than the buffered one is ignored. Metrics without a value are skipped.
+func (b *relayBuffer) add(metrics []shared.Metric) {
+	now := time.Now()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, metric := range metrics {
+		switch {
+		case metric.MType == shared.Gauge && metric.Value != nil:
+			timestamp := now
+			if metric.Timestamp != nil {
+				timestamp = time.UnixMilli(*metric.Timestamp)
+			}
+			if stored, ok := b.gauges[metric.ID]; ok && stored.timestamp.After(timestamp) {
+				continue
+			}
+			b.gauges[metric.ID] = gaugeSample{value: *metric.Value, timestamp: timestamp}
+		case metric.MType == shared.Counter && metric.Delta != nil:
+			b.counters[metric.ID] += *metric.Delta
+		case metric.MType == shared.Histogram && metric.Histogram != nil:
+			b.histograms[metric.ID] = b.histograms[metric.ID].Merge(*metric.Histogram)
+		case metric.MType == shared.Set && len(metric.Members) > 0:
+			members, ok := b.sets[metric.ID]
+			if !ok {
+				members = make(map[string]struct{}, len(metric.Members))
+				b.sets[metric.ID] = members
+			}
+			for _, member := range metric.Members {
+				members[member] = struct{}{}
+			}
+		}
+	}
+}
+
+// drain returns the buffered metrics and empties the buffer.
+func (b *relayBuffer) drain() []shared.Metric {
+	b.mu.Lock()
+	gauges, counters, histograms, sets := b.gauges, b.counters, b.histograms, b.sets
+	b.gauges = make(map[string]gaugeSample)
+	b.counters = make(map[string]int64)
+	b.histograms = make(map[string]shared.HistogramValue)
+	b.sets = make(map[string]map[string]struct{})
+	b.mu.Unlock()
+
+	metrics := make([]shared.Metric, 0, len(gauges)+len(counters)+len(histograms)+len(sets))
+	for name, gauge := range gauges {
+		value, timestamp := gauge.value, gauge.timestamp.UnixMilli()
+		metrics = append(metrics, shared.Metric{ID: name, MType: shared.Gauge, Value: &value, Timestamp: &timestamp})
+	}
+	for name, delta := range counters {
+		delta := delta
+		metrics = append(metrics, shared.Metric{ID: name, MType: shared.Counter, Delta: &delta})
+	}
+	for name, histogram := range histograms {
+		histogram := histogram
+		metrics = append(metrics, shared.Metric{ID: name, MType: shared.Histogram, Histogram: &histogram})
+	}
+	for name, members := range sets {
+		metric := shared.Metric{ID: name, MType: shared.Set, Members: make([]string, 0, len(members))}
+		for member := range members {
+			metric.Members = append(metric.Members, member)
+		}
+		sort.Strings(metric.Members)
+		metrics = append(metrics, metric)
+	}
+	return metrics
+}
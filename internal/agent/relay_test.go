@@ -0,0 +1,114 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/avast/retry-go"
+	"github.com/stretchr/testify/require"
+
+	"github.com/gonozov0/go-musthave-devops/internal/server/application"
+	repository "github.com/gonozov0/go-musthave-devops/internal/server/repository/in_memory"
+	"github.com/gonozov0/go-musthave-devops/internal/shared"
+)
+
+func TestRelay(t *testing.T) {
+	upstreamRepo := repository.NewInMemoryRepository()
+	upstream := httptest.NewServer(application.NewRouter(upstreamRepo))
+	defer upstream.Close()
+
+	sink, err := NewSink(SinkConfig{Type: SinkServer, Address: upstream.URL})
+	require.NoError(t, err)
+	pipeline, err := NewPipeline(nil)
+	require.NoError(t, err)
+	relay := NewRelay([]Sink{sink}, pipeline, "rack1_", map[string]string{"relay": "rack1"})
+	relayServer := httptest.NewServer(relay.Handler())
+	defer relayServer.Close()
+
+	// two leaf agents push through the relay
+	leaf, err := NewSink(SinkConfig{Type: SinkServer, Address: relayServer.URL})
+	require.NoError(t, err)
+	require.NoError(t, leaf.Send(testMetrics()))
+	require.NoError(t, leaf.Send(testMetrics()))
+
+	require.NoError(t, relay.Flush())
+
+	gauge, err := upstreamRepo.GetGauge(`rack1_Alloc{relay="rack1"}`)
+	require.NoError(t, err)
+	require.Equal(t, 2.5, gauge)
+	counter, err := upstreamRepo.GetCounter(`rack1_PollCount{relay="rack1"}`)
+	require.NoError(t, err)
+	require.Equal(t, int64(10), counter)
+
+	// nothing is resent after a successful flush
+	require.NoError(t, relay.Flush())
+	counter, err = upstreamRepo.GetCounter(`rack1_PollCount{relay="rack1"}`)
+	require.NoError(t, err)
+	require.Equal(t, int64(10), counter)
+}
+
+func TestRelaySpool(t *testing.T) {
+	fake := &fakeSink{errs: []error{errors.New("upstream is down")}}
+	pipeline, err := NewPipeline(nil)
+	require.NoError(t, err)
+	relay := NewRelay([]Sink{fake}, pipeline, "", nil)
+
+	delta1, delta2 := int64(2), int64(3)
+	relay.buffer.add([]shared.Metric{{ID: "PollCount", MType: shared.Counter, Delta: &delta1}})
+	require.Error(t, relay.Flush())
+	require.Empty(t, fake.batches)
+
+	relay.buffer.add([]shared.Metric{{ID: "PollCount", MType: shared.Counter, Delta: &delta2}})
+	require.NoError(t, relay.Flush())
+
+	require.Len(t, fake.batches, 1)
+	require.Len(t, fake.batches[0], 1)
+	require.Equal(t, int64(5), *fake.batches[0][0].Delta)
+}
+
+func TestRelayRules(t *testing.T) {
+	fake := &fakeSink{}
+	pipeline, err := NewPipeline([]Rule{
+		{Action: RuleDrop, Match: "Alloc"},
+		{Action: RuleRename, Match: "Poll(.*)", Replacement: "Relayed${1}"},
+	})
+	require.NoError(t, err)
+	relay := NewRelay([]Sink{fake}, pipeline, "", nil)
+	relayServer := httptest.NewServer(relay.Handler())
+	defer relayServer.Close()
+
+	leaf, err := NewSink(SinkConfig{Type: SinkServer, Address: relayServer.URL})
+	require.NoError(t, err)
+	require.NoError(t, leaf.Send(testMetrics()))
+
+	delta := int64(5)
+	body, err := json.Marshal(shared.Metric{ID: "PollCount", MType: shared.Counter, Delta: &delta})
+	require.NoError(t, err)
+	r, err := http.Post(relayServer.URL+"/update/", "application/json", bytes.NewReader(body))
+	require.NoError(t, err)
+	_ = r.Body.Close()
+	require.Equal(t, http.StatusOK, r.StatusCode)
+
+	require.NoError(t, relay.Flush())
+	require.Len(t, fake.batches, 1)
+	require.Len(t, fake.batches[0], 1, "the dropped gauge must not be forwarded")
+	require.Equal(t, "RelayedCount", fake.batches[0][0].ID)
+	require.Equal(t, int64(10), *fake.batches[0][0].Delta)
+}
+
+func TestRelayRejected(t *testing.T) {
+	fake := &fakeSink{errs: []error{retry.Unrecoverable(errors.New("rejected"))}}
+	pipeline, err := NewPipeline(nil)
+	require.NoError(t, err)
+	relay := NewRelay([]Sink{&batchingSink{sink: fake, attempts: 3}}, pipeline, "", nil)
+
+	delta := int64(2)
+	relay.buffer.add([]shared.Metric{{ID: "PollCount", MType: shared.Counter, Delta: &delta}})
+	require.Error(t, relay.Flush())
+	require.NoError(t, relay.Flush())
+	require.Empty(t, fake.batches, "rejected metrics must not be spooled")
+}
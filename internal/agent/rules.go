@@ -0,0 +1,188 @@
+package agent
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/gonozov0/go-musthave-devops/internal/shared"
+)
+
+// Rule actions supported in Rule.
+const (
+	RuleKeep    = "keep"    // drop metrics that don't match
+	RuleDrop    = "drop"    // drop metrics that match
+	RuleRename  = "rename"  // replace the name using capture groups of the match, e.g. "${1}_bytes"
+	RulePrefix  = "prefix"  // add the prefix to the name
+	RuleConvert = "convert" // change the metric type, counter deltas are rounded
+	RuleScale   = "scale"   // multiply the value by the factor, counter deltas are rounded
+)
+
+// Rule is a struct that represents a single step of the metrics pipeline.
+// Match is a regular expression over the whole metric name, empty matches every metric.
+type Rule struct {
+	Action      string  `json:"action"`
+	Match       string  `json:"match"`
+	MatchType   string  `json:"match_type"` // optional metric type filter
+	Replacement string  `json:"replacement"`
+	Prefix      string  `json:"prefix"`
+	Type        string  `json:"type"`
+	Factor      float64 `json:"factor"`
+}
+
+// Pipeline applies the rules to every collected metric in order.
+type Pipeline struct {
+	rules []compiledRule
+}
+
+type compiledRule struct {
+	Rule
+	re *regexp.Regexp
+}
+
+// NewPipeline validates and compiles the rules.
+func NewPipeline(rules []Rule) (*Pipeline, error) {
+	compiled := make([]compiledRule, 0, len(rules))
+	for i, rule := range rules {
+		match := rule.Match
+		if match == "" {
+			match = ".*"
+		}
+		re, err := regexp.Compile("^(?:" + match + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: invalid match: %w", i, err)
+		}
+		if rule.MatchType != "" && rule.MatchType != shared.Gauge && rule.MatchType != shared.Counter {
+			return nil, fmt.Errorf("rule %d: unknown match_type %q", i, rule.MatchType)
+		}
+
+		switch rule.Action {
+		case RuleKeep, RuleDrop:
+		case RuleRename:
+			if rule.Replacement == "" {
+				return nil, fmt.Errorf("rule %d: replacement is required", i)
+			}
+		case RulePrefix:
+			if rule.Prefix == "" {
+				return nil, fmt.Errorf("rule %d: prefix is required", i)
+			}
+		case RuleConvert:
+			if rule.Type != shared.Gauge && rule.Type != shared.Counter {
+				return nil, fmt.Errorf("rule %d: unknown type %q", i, rule.Type)
+			}
+		case RuleScale:
+			if rule.Factor == 0 {
+				return nil, fmt.Errorf("rule %d: factor is required", i)
+			}
+		default:
+			return nil, fmt.Errorf("rule %d: unknown action %q", i, rule.Action)
+		}
+		compiled = append(compiled, compiledRule{Rule: rule, re: re})
+	}
+	return &Pipeline{rules: compiled}, nil
+}
+
+// Apply returns the transformed metrics without the dropped ones.
+func (p *Pipeline) Apply(metrics []shared.Metric) []shared.Metric {
+	result := make([]shared.Metric, 0, len(metrics))
+	for _, metric := range metrics {
+		if transformed, ok := p.applyOne(metric); ok {
+			result = append(result, transformed)
+		}
+	}
+	return result
+}
+
+// DryRun prints how every metric is transformed by the pipeline.
+func (p *Pipeline) DryRun(w io.Writer, metrics []shared.Metric) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tTYPE\tVALUE\t\tNAME\tTYPE\tVALUE")
+	for _, metric := range metrics {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t->\t", metric.ID, metric.MType, formatMetricValue(metric))
+		transformed, ok := p.applyOne(metric)
+		if !ok {
+			fmt.Fprintln(tw, "dropped\t\t")
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", transformed.ID, transformed.MType, formatMetricValue(transformed))
+	}
+	return tw.Flush()
+}
+
+func (p *Pipeline) applyOne(metric shared.Metric) (shared.Metric, bool) {
+	metric = copyMetric(metric)
+	for _, rule := range p.rules {
+		matched := rule.re.MatchString(metric.ID) && (rule.MatchType == "" || rule.MatchType == metric.MType)
+
+		switch rule.Action {
+		case RuleKeep:
+			if !matched {
+				return metric, false
+			}
+			continue
+		case RuleDrop:
+			if matched {
+				return metric, false
+			}
+			continue
+		}
+		if !matched {
+			continue
+		}
+
+		switch rule.Action {
+		case RuleRename:
+			metric.ID = rule.re.ReplaceAllString(metric.ID, rule.Replacement)
+		case RulePrefix:
+			metric.ID = rule.Prefix + metric.ID
+		case RuleConvert:
+			metric = convertMetric(metric, rule.Type)
+		case RuleScale:
+			if metric.Value != nil {
+				value := *metric.Value * rule.Factor
+				metric.Value = &value
+			}
+			if metric.Delta != nil {
+				delta := int64(math.Round(float64(*metric.Delta) * rule.Factor))
+				metric.Delta = &delta
+			}
+		}
+	}
+	return metric, true
+}
+
+func convertMetric(metric shared.Metric, mType string) shared.Metric {
+	if metric.MType == mType {
+		return metric
+	}
+	switch mType {
+	case shared.Gauge:
+		if metric.Delta != nil {
+			value := float64(*metric.Delta)
+			metric.Value = &value
+		}
+		metric.Delta = nil
+	case shared.Counter:
+		if metric.Value != nil {
+			delta := int64(math.Round(*metric.Value))
+			metric.Delta = &delta
+		}
+		metric.Value = nil
+	}
+	metric.MType = mType
+	return metric
+}
+
+func formatMetricValue(metric shared.Metric) string {
+	switch {
+	case metric.Value != nil:
+		return strconv.FormatFloat(*metric.Value, 'g', -1, 64)
+	case metric.Delta != nil:
+		return strconv.FormatInt(*metric.Delta, 10)
+	default:
+		return ""
+	}
+}
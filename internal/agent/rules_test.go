@@ -0,0 +1,80 @@
+package agent
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gonozov0/go-musthave-devops/internal/shared"
+)
+
+func TestPipeline(t *testing.T) {
+	pipeline, err := NewPipeline([]Rule{
+		{Action: RuleDrop, Match: "Random.*"},
+		{Action: RuleKeep, Match: "Heap.*|PollCount|RandomValue"},
+		{Action: RuleRename, Match: "Heap(.*)", Replacement: "heap_${1}_bytes"},
+		{Action: RuleScale, Match: "heap_.*_bytes", Factor: 1.0 / (1 << 20)},
+		{Action: RulePrefix, Match: "heap_.*", Prefix: "go_"},
+		{Action: RuleConvert, MatchType: shared.Counter, Type: shared.Gauge},
+	})
+	require.NoError(t, err)
+
+	heapAlloc, random := float64(3<<20), 0.5
+	pollCount := int64(7)
+	metrics := pipeline.Apply([]shared.Metric{
+		{ID: "HeapAlloc", MType: shared.Gauge, Value: &heapAlloc},
+		{ID: "RandomValue", MType: shared.Gauge, Value: &random},
+		{ID: "Alloc", MType: shared.Gauge, Value: &heapAlloc},
+		{ID: "PollCount", MType: shared.Counter, Delta: &pollCount},
+	})
+
+	require.Len(t, metrics, 2)
+	require.Equal(t, "go_heap_Alloc_bytes", metrics[0].ID)
+	require.Equal(t, 3.0, *metrics[0].Value)
+	require.Equal(t, float64(3<<20), heapAlloc, "input metrics must not be modified")
+	require.Equal(t, "PollCount", metrics[1].ID)
+	require.Equal(t, shared.Gauge, metrics[1].MType)
+	require.Nil(t, metrics[1].Delta)
+	require.Equal(t, 7.0, *metrics[1].Value)
+}
+
+func TestNewPipelineErrors(t *testing.T) {
+	testCases := []struct {
+		name string
+		rule Rule
+	}{
+		{"invalid regex", Rule{Action: RuleDrop, Match: "("}},
+		{"unknown action", Rule{Action: "explode"}},
+		{"rename without replacement", Rule{Action: RuleRename}},
+		{"prefix without prefix", Rule{Action: RulePrefix}},
+		{"convert to unknown type", Rule{Action: RuleConvert, Type: "histogram"}},
+		{"scale without factor", Rule{Action: RuleScale}},
+		{"unknown match type", Rule{Action: RuleDrop, MatchType: "histogram"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewPipeline([]Rule{tc.rule})
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestPipelineDryRun(t *testing.T) {
+	pipeline, err := NewPipeline([]Rule{
+		{Action: RuleDrop, Match: "RandomValue"},
+		{Action: RulePrefix, Prefix: "agent_"},
+	})
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	require.NoError(t, pipeline.DryRun(&out, testMetrics()[:2]))
+	require.Equal(
+		t,
+		"NAME       TYPE     VALUE      NAME             TYPE     VALUE\n"+
+			"Alloc      gauge    1.5    ->  agent_Alloc      gauge    1.5\n"+
+			"PollCount  counter  2      ->  agent_PollCount  counter  2\n",
+		out.String(),
+	)
+}
@@ -0,0 +1,102 @@
+package agent
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Tick is a single firing of the Scheduler.
+type Tick struct {
+	Scheduled time.Time // the interval boundary the tick belongs to, without the splay
+	Fired     time.Time
+	Missed    int // number of ticks skipped since the previous one
+}
+
+// Scheduler fires once per interval like time.Ticker, but it can align ticks to wall-clock
+// boundaries and delay every tick by a random splay so that many agents don't fire at once.
+// Ticks that couldn't fire in time, e.g. after a suspend, a long GC pause or a slow consumer,
+// are skipped and reported in Tick.Missed instead of being delivered in a burst.
+type Scheduler struct {
+	C <-chan Tick
+
+	ticks    chan Tick
+	interval time.Duration
+	align    bool
+	splay    time.Duration
+	stop     chan struct{}
+}
+
+// NewScheduler starts a scheduler firing every interval. With align the ticks are
+// aligned to multiples of the interval since the Unix epoch, e.g. to whole minutes.
+// Every tick is delayed by a random duration in [0, splay), the splay is capped by the interval.
+func NewScheduler(interval time.Duration, align bool, splay time.Duration) *Scheduler {
+	ticks := make(chan Tick, 1)
+	s := &Scheduler{
+		C:        ticks,
+		ticks:    ticks,
+		interval: interval,
+		align:    align,
+		splay:    min(splay, interval),
+		stop:     make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// Stop stops the scheduler. It doesn't close the channel.
+func (s *Scheduler) Stop() {
+	close(s.stop)
+}
+
+func (s *Scheduler) run() {
+	scheduled := firstTick(time.Now(), s.interval, s.align)
+	target := scheduled.Add(s.jitter())
+	dropped := 0
+	timer := time.NewTimer(time.Until(target))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case fired := <-timer.C:
+			missed := missedTicks(target, fired, s.interval)
+			tick := Tick{Scheduled: scheduled.Add(time.Duration(missed) * s.interval), Fired: fired, Missed: missed + dropped}
+			select {
+			case s.ticks <- tick:
+				dropped = 0
+			default:
+				dropped = tick.Missed + 1 // the consumer hasn't received the previous tick yet
+			}
+			scheduled = tick.Scheduled.Add(s.interval)
+			target = scheduled.Add(s.jitter())
+			timer.Reset(time.Until(target))
+		}
+	}
+}
+
+func (s *Scheduler) jitter() time.Duration {
+	if s.splay <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(s.splay)))
+}
+
+// firstTick returns the time of the first tick after now.
+func firstTick(now time.Time, interval time.Duration, align bool) time.Time {
+	if !align {
+		return now.Add(interval)
+	}
+	nanos := now.UnixNano()
+	return time.Unix(0, nanos-nanos%int64(interval)+int64(interval))
+}
+
+// missedTicks returns how many whole intervals passed between the target and the actual time of the tick.
+// Wall clock readings are compared since the monotonic clock doesn't advance while the host is suspended.
+func missedTicks(target, fired time.Time, interval time.Duration) int {
+	target, fired = target.Round(0), fired.Round(0)
+	if !fired.After(target) {
+		return 0
+	}
+	return int(fired.Sub(target) / interval)
+}
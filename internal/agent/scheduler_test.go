@@ -0,0 +1,40 @@
+package agent
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFirstTick(t *testing.T) {
+	now := time.Date(2024, 1, 1, 10, 0, 7, 500, time.UTC)
+
+	require.Equal(t, now.Add(10*time.Second), firstTick(now, 10*time.Second, false))
+	require.True(t, time.Date(2024, 1, 1, 10, 0, 10, 0, time.UTC).Equal(firstTick(now, 10*time.Second, true)))
+	require.True(t, time.Date(2024, 1, 1, 10, 1, 0, 0, time.UTC).Equal(firstTick(now, time.Minute, true)))
+}
+
+func TestMissedTicks(t *testing.T) {
+	target := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	require.Equal(t, 0, missedTicks(target, target.Add(-time.Millisecond), time.Second))
+	require.Equal(t, 0, missedTicks(target, target.Add(500*time.Millisecond), time.Second))
+	require.Equal(t, 3, missedTicks(target, target.Add(3500*time.Millisecond), time.Second))
+}
+
+func TestScheduler(t *testing.T) {
+	interval := 20 * time.Millisecond
+	scheduler := NewScheduler(interval, true, 5*time.Millisecond)
+	defer scheduler.Stop()
+
+	tick := <-scheduler.C
+	require.Zero(t, tick.Scheduled.UnixNano()%int64(interval))
+	require.False(t, tick.Fired.Before(tick.Scheduled))
+
+	// a slow consumer gets the skipped ticks reported instead of a burst
+	time.Sleep(5 * interval)
+	<-scheduler.C
+	tick = <-scheduler.C
+	require.Positive(t, tick.Missed)
+}
@@ -0,0 +1,214 @@
+package agent
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/avast/retry-go"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/gonozov0/go-musthave-devops/internal/shared"
+)
+
+// Sink types supported in SinkConfig.
+const (
+	SinkServer      = "server"
+	SinkStdout      = "stdout"
+	SinkFile        = "file"
+	SinkPushgateway = "pushgateway"
+	SinkInfluxDB    = "influxdb"
+)
+
+// Sink is an output destination for collected metrics.
+type Sink interface {
+	// Name returns the sink name used in logs.
+	Name() string
+	// Send delivers a single batch of metrics. Errors wrapped with retry.Unrecoverable are not retried.
+	Send(metrics []shared.Metric) error
+}
+
+// SinkConfig is a struct that represents configuration of a single sink.
+type SinkConfig struct {
+	Type          string `json:"type"`
+	Address       string `json:"address"`     // server, pushgateway or InfluxDB address
+	Compression   string `json:"compression"` // server request encoding, auto or none, gzip by default
+	Path          string `json:"path"`        // file path for the file sink
+	MaxSize       int64  `json:"max_size"`    // file size in bytes that triggers rotation, 0 disables rotation
+	MaxBackups    int    `json:"max_backups"` // number of rotated files to keep
+	Job           string `json:"job"`         // pushgateway job name
+	Database      string `json:"database"`    // InfluxDB database
+	Token         string `json:"token"`       // InfluxDB auth token
+	BatchSize     int    `json:"batch_size"`  // max metrics per Send call, 0 disables the limit
+	BatchBytes    int    `json:"batch_bytes"` // max size of a batch encoded as JSON, 0 disables the limit
+	RetryAttempts uint   `json:"retry_attempts"`
+	RetryDelay    int    `json:"retry_delay"` // in seconds
+}
+
+// NewSink creates the sink described by the config and wraps it with batching and retries.
+func NewSink(cfg SinkConfig) (Sink, error) {
+	var (
+		sink Sink
+		err  error
+	)
+
+	switch cfg.Type {
+	case SinkServer:
+		sink, err = newServerSink(cfg.Address, cfg.Compression)
+	case SinkStdout:
+		sink = newStdoutSink()
+	case SinkFile:
+		sink, err = newFileSink(cfg.Path, cfg.MaxSize, cfg.MaxBackups)
+	case SinkPushgateway:
+		sink, err = newPushgatewaySink(cfg.Address, cfg.Job)
+	case SinkInfluxDB:
+		sink, err = newInfluxDBSink(cfg.Address, cfg.Database, cfg.Token)
+	default:
+		return nil, fmt.Errorf("unknown sink type: %q", cfg.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s sink: %w", cfg.Type, err)
+	}
+
+	attempts := cfg.RetryAttempts
+	if attempts == 0 {
+		attempts = 1
+	}
+	return &batchingSink{
+		sink:       sink,
+		batchSize:  cfg.BatchSize,
+		batchBytes: cfg.BatchBytes,
+		attempts:   attempts,
+		delay:      time.Duration(cfg.RetryDelay) * time.Second,
+	}, nil
+}
+
+// batchingSink splits metrics into batches and retries every batch independently.
+type batchingSink struct {
+	sink       Sink
+	batchSize  int
+	batchBytes int
+	attempts   uint
+	delay      time.Duration
+}
+
+// Name returns the name of the wrapped sink.
+func (s *batchingSink) Name() string {
+	return s.sink.Name()
+}
+
+// Send sends metrics to the wrapped sink batch by batch.
+// A failed batch doesn't prevent the following ones from being sent, the errors are returned
+// as a *SendError with the metrics of the batches to send again.
+func (s *batchingSink) Send(metrics []shared.Metric) error {
+	chunks, err := sendChunks(
+		s.sink,
+		metrics,
+		s.batchSize,
+		s.batchBytes,
+		s.attempts,
+		retry.Delay(s.delay),
+		retry.DelayType(retry.BackOffDelay),
+	)
+	// metrics that can't be encoded have no chunks and aren't sent again
+	var unsent []shared.Metric
+	for _, chunk := range chunks {
+		switch {
+		case chunk.Err == nil:
+			log.Debugf(
+				"Sent batch %d-%d (%d bytes) to %s in %d attempts",
+				chunk.Start, chunk.End, chunk.Bytes, s.sink.Name(), chunk.Attempts,
+			)
+		case chunk.Dropped:
+			log.Warnf("Dropped batch %d-%d rejected by %s", chunk.Start, chunk.End, s.sink.Name())
+		default:
+			unsent = append(unsent, metrics[chunk.Start:chunk.End]...)
+		}
+	}
+	if err != nil {
+		return &SendError{Unsent: unsent, Chunks: chunks, Err: err}
+	}
+	return nil
+}
+
+// aggregateMetrics collapses metrics with the same name: the last gauge value wins,
+// counter deltas are summed and stamped with the latest timestamp, histograms are merged
+// and set members are joined.
+// The order of the first appearance is preserved.
+func aggregateMetrics(metrics []shared.Metric) []shared.Metric {
+	type key struct{ id, mType string }
+
+	index := make(map[key]int, len(metrics))
+	result := make([]shared.Metric, 0, len(metrics))
+	for _, metric := range metrics {
+		k := key{metric.ID, metric.MType}
+		i, ok := index[k]
+		if !ok {
+			index[k] = len(result)
+			result = append(result, copyMetric(metric))
+			continue
+		}
+		switch metric.MType {
+		case shared.Counter:
+			if metric.Delta != nil {
+				delta := *metric.Delta
+				if result[i].Delta != nil {
+					delta += *result[i].Delta
+				}
+				result[i].Delta = &delta
+			}
+			if metric.Timestamp != nil && (result[i].Timestamp == nil || *metric.Timestamp > *result[i].Timestamp) {
+				timestamp := *metric.Timestamp
+				result[i].Timestamp = &timestamp
+			}
+		case shared.Set:
+			result[i].Members = append(result[i].Members, metric.Members...)
+		case shared.Histogram:
+			if metric.Histogram != nil && result[i].Histogram != nil {
+				merged := result[i].Histogram.Merge(*metric.Histogram)
+				result[i].Histogram = &merged
+			} else if metric.Histogram != nil {
+				result[i] = copyMetric(metric)
+			}
+		default:
+			result[i] = copyMetric(metric)
+		}
+	}
+	return result
+}
+
+// copyMetric returns a metric that doesn't share value pointers with the original one.
+func copyMetric(metric shared.Metric) shared.Metric {
+	if metric.Value != nil {
+		value := *metric.Value
+		metric.Value = &value
+	}
+	if metric.Delta != nil {
+		delta := *metric.Delta
+		metric.Delta = &delta
+	}
+	if metric.Timestamp != nil {
+		timestamp := *metric.Timestamp
+		metric.Timestamp = &timestamp
+	}
+	if metric.Histogram != nil {
+		histogram := shared.HistogramValue{}.Merge(*metric.Histogram)
+		metric.Histogram = &histogram
+	}
+	if metric.Members != nil {
+		metric.Members = append([]string(nil), metric.Members...)
+	}
+	return metric
+}
+
+// BufferMetrics adds the metrics to the buffer of a sink and aggregates it, so the buffer
+// holds a single value of every metric however long the sink is unavailable.
+// When the buffer has more than limit metrics the oldest ones are dropped and their number
+// is returned. A limit of 0 disables the limit.
+func BufferMetrics(buffer, metrics []shared.Metric, limit int) ([]shared.Metric, int) {
+	buffer = aggregateMetrics(append(buffer, metrics...))
+	if limit <= 0 || len(buffer) <= limit {
+		return buffer, 0
+	}
+	dropped := len(buffer) - limit
+	return buffer[dropped:], dropped
+}
@@ -0,0 +1,121 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/gonozov0/go-musthave-devops/internal/shared"
+)
+
+// fileSink writes metrics as JSON lines to stdout or to a size-rotated file.
+type fileSink struct {
+	mu         sync.Mutex
+	name       string
+	path       string
+	maxSize    int64
+	maxBackups int
+	out        io.Writer
+	file       *os.File
+	size       int64
+}
+
+func newStdoutSink() *fileSink {
+	return &fileSink{name: SinkStdout, out: os.Stdout}
+}
+
+func newFileSink(path string, maxSize int64, maxBackups int) (*fileSink, error) {
+	if path == "" {
+		return nil, errors.New("path is required")
+	}
+	sink := &fileSink{name: SinkFile, path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := sink.open(); err != nil {
+		return nil, err
+	}
+	return sink, nil
+}
+
+// Name returns the sink name.
+func (s *fileSink) Name() string {
+	return s.name
+}
+
+// Send writes every metric as a separate JSON line.
+func (s *fileSink) Send(metrics []shared.Metric) error {
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	for _, metric := range metrics {
+		if err := encoder.Encode(metric); err != nil {
+			return fmt.Errorf("failed to encode metric to JSON: %w", err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file != nil && s.maxSize > 0 && s.size > 0 && s.size+int64(buffer.Len()) > s.maxSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := s.out.Write(buffer.Bytes())
+	s.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write metrics: %w", err)
+	}
+	return nil
+}
+
+func (s *fileSink) open() error {
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat file: %w", err)
+	}
+	s.file = file
+	s.out = file
+	s.size = info.Size()
+	return nil
+}
+
+// rotate shifts path.N-1 to path.N, ..., path to path.1 and opens a new file.
+// When the rotation fails the current file is reopened, so the next write retries it.
+func (s *fileSink) rotate() error {
+	err := s.file.Close()
+	if err != nil {
+		err = fmt.Errorf("failed to close file: %w", err)
+	} else {
+		err = s.shift()
+	}
+	if openErr := s.open(); openErr != nil {
+		return errors.Join(err, openErr)
+	}
+	return err
+}
+
+// shift renames the closed file and its backups.
+func (s *fileSink) shift() error {
+	if s.maxBackups > 0 {
+		for i := s.maxBackups - 1; i > 0; i-- {
+			src := fmt.Sprintf("%s.%d", s.path, i)
+			if err := os.Rename(src, fmt.Sprintf("%s.%d", s.path, i+1)); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to rotate file: %w", err)
+			}
+		}
+		if err := os.Rename(s.path, s.path+".1"); err != nil {
+			return fmt.Errorf("failed to rotate file: %w", err)
+		}
+	} else if err := os.Remove(s.path); err != nil {
+		return fmt.Errorf("failed to remove file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package agent
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/avast/retry-go"
+
+	"github.com/gonozov0/go-musthave-devops/internal/shared"
+)
+
+var influxMeasurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+
+// influxDBSink writes metrics to InfluxDB over HTTP using the line protocol.
+type influxDBSink struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+func newInfluxDBSink(address, database, token string) (*influxDBSink, error) {
+	if address == "" {
+		return nil, errors.New("address is required")
+	}
+	if database == "" {
+		return nil, errors.New("database is required")
+	}
+	return &influxDBSink{
+		url:    buildURL(address, "/write?precision=ms&db="+url.QueryEscape(database)),
+		token:  token,
+		client: &http.Client{},
+	}, nil
+}
+
+// Name returns the sink name.
+func (s *influxDBSink) Name() string {
+	return SinkInfluxDB
+}
+
+// Send writes the batch as one line per metric: `<name>,type=<type> value=<value> [<timestamp>]`.
+// Histograms are written with their count as the value and a sum field, sets are skipped.
+func (s *influxDBSink) Send(metrics []shared.Metric) error {
+	var buffer bytes.Buffer
+	for _, metric := range metrics {
+		if metric.MType == shared.Set {
+			// the set members are counted by the server only
+			continue
+		}
+		line, err := formatInfluxLine(metric)
+		if err != nil {
+			return retry.Unrecoverable(err)
+		}
+		buffer.WriteString(line)
+		buffer.WriteByte('\n')
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.url, &buffer)
+	if err != nil {
+		return retry.Unrecoverable(err)
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Token "+s.token)
+	}
+
+	return doHTTPRequest(s.client, req)
+}
+
+func formatInfluxLine(metric shared.Metric) (string, error) {
+	var value string
+	switch metric.MType {
+	case shared.Gauge:
+		if metric.Value == nil {
+			return "", fmt.Errorf("value is required for gauge metric %s", metric.ID)
+		}
+		value = strconv.FormatFloat(*metric.Value, 'g', -1, 64)
+	case shared.Counter:
+		if metric.Delta == nil {
+			return "", fmt.Errorf("delta is required for counter metric %s", metric.ID)
+		}
+		value = strconv.FormatInt(*metric.Delta, 10) + "i"
+	case shared.Histogram:
+		if metric.Histogram == nil {
+			return "", fmt.Errorf("histogram is required for histogram metric %s", metric.ID)
+		}
+		// the count is the value, so the histogram fields are written next to it
+		value = strconv.FormatUint(metric.Histogram.Count, 10) + "i,sum=" +
+			strconv.FormatFloat(metric.Histogram.Sum, 'g', -1, 64)
+	default:
+		return "", fmt.Errorf("unknown metric type %s", metric.MType)
+	}
+	line := fmt.Sprintf("%s,type=%s value=%s", influxMeasurementEscaper.Replace(metric.ID), metric.MType, value)
+	if metric.Timestamp != nil {
+		line += " " + strconv.FormatInt(*metric.Timestamp, 10)
+	}
+	return line, nil
+}
@@ -0,0 +1,117 @@
+package agent
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+
+	"github.com/avast/retry-go"
+
+	"github.com/gonozov0/go-musthave-devops/internal/shared"
+)
+
+// pushgatewaySink pushes metrics to the Prometheus pushgateway in the text exposition format.
+// Pushgateway expects cumulative counters and histograms, so the sink keeps running totals of the sent deltas.
+type pushgatewaySink struct {
+	mu         sync.Mutex
+	url        string
+	client     *http.Client
+	totals     map[string]int64
+	histograms map[string]shared.HistogramValue
+}
+
+func newPushgatewaySink(address, job string) (*pushgatewaySink, error) {
+	if address == "" {
+		return nil, errors.New("address is required")
+	}
+	if job == "" {
+		job = "agent"
+	}
+	return &pushgatewaySink{
+		url:        buildURL(address, "/metrics/job/"+url.PathEscape(job)),
+		client:     &http.Client{},
+		totals:     make(map[string]int64),
+		histograms: make(map[string]shared.HistogramValue),
+	}, nil
+}
+
+// Name returns the sink name.
+func (s *pushgatewaySink) Name() string {
+	return SinkPushgateway
+}
+
+// Send pushes the aggregated batch replacing the previous values of the same metrics.
+func (s *pushgatewaySink) Send(metrics []shared.Metric) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// the set members are counted by the server only, so they can't be pushed
+	metrics = slices.DeleteFunc(aggregateMetrics(metrics), func(metric shared.Metric) bool {
+		return metric.MType == shared.Set
+	})
+	newTotals := make(map[string]int64)
+	newHistograms := make(map[string]shared.HistogramValue)
+	for i, metric := range metrics {
+		switch {
+		case metric.MType == shared.Counter && metric.Delta != nil:
+			total := s.totals[metric.ID] + *metric.Delta
+			newTotals[metric.ID] = total
+			metrics[i].Delta = &total
+		case metric.MType == shared.Histogram && metric.Histogram != nil:
+			total := s.histograms[metric.ID].Merge(*metric.Histogram)
+			newHistograms[metric.ID] = total
+			metrics[i].Histogram = &total
+		}
+	}
+
+	var buffer bytes.Buffer
+	if err := shared.WritePrometheusText(&buffer, metrics); err != nil {
+		return retry.Unrecoverable(fmt.Errorf("failed to encode metrics: %w", err))
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.url, &buffer)
+	if err != nil {
+		return retry.Unrecoverable(err)
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+
+	if err := doHTTPRequest(s.client, req); err != nil {
+		return err
+	}
+
+	for name, total := range newTotals {
+		s.totals[name] = total
+	}
+	for name, total := range newHistograms {
+		s.histograms[name] = total
+	}
+	return nil
+}
+
+// doHTTPRequest sends the request and checks the response status.
+// Network errors and 5xx responses are returned as recoverable, everything else is not retried.
+func doHTTPRequest(client *http.Client, req *http.Request) error {
+	r, err := client.Do(req)
+	if err != nil {
+		if isNetworkError(err) {
+			return err
+		}
+		return retry.Unrecoverable(err)
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode >= http.StatusOK && r.StatusCode < http.StatusMultipleChoices {
+		return nil
+	}
+	body, _ := io.ReadAll(r.Body)
+	err = fmt.Errorf("received non-OK response: %d, error: %s", r.StatusCode, string(body))
+	if r.StatusCode >= http.StatusInternalServerError {
+		return err
+	}
+	return retry.Unrecoverable(err)
+}
@@ -0,0 +1,232 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/avast/retry-go"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/gonozov0/go-musthave-devops/internal/shared"
+)
+
+// Compression modes of the server sink in addition to the content encodings.
+const (
+	CompressionAuto = "auto" // use the best encoding the server advertises, gzip until it's known
+	CompressionNone = "none"
+)
+
+// acceptedResponseEncodings is the Accept-Encoding header of the requests to the server.
+var acceptedResponseEncodings = strings.Join(shared.SupportedEncodings, ", ")
+
+// serverSink sends metrics to the metrics server via POST /updates.
+// The request encoding is switched to one the server advertises in the Accept-Encoding
+// response header when the configured one is not supported.
+type serverSink struct {
+	url    string
+	client *http.Client
+	auto   bool
+
+	mu       sync.Mutex
+	encoding string
+}
+
+func newServerSink(serverAddress, compression string) (*serverSink, error) {
+	sink := &serverSink{
+		url:      buildURL(serverAddress, "/updates/"),
+		client:   &http.Client{},
+		encoding: compression,
+	}
+	switch compression {
+	case "":
+		sink.encoding = shared.EncodingGzip
+	case CompressionAuto:
+		sink.auto = true
+		sink.encoding = shared.EncodingGzip
+	case CompressionNone:
+		sink.encoding = shared.EncodingIdentity
+	default:
+		if !shared.IsSupportedEncoding(compression) {
+			return nil, fmt.Errorf("unknown compression %q", compression)
+		}
+	}
+	return sink, nil
+}
+
+// Name returns the sink name.
+func (s *serverSink) Name() string {
+	return SinkServer
+}
+
+// Send makes a single attempt to send compressed metrics to the server.
+// The body is streamed through JSON and compression encoders, so it is never held in memory as a whole.
+// Only network errors and unsupported encodings are returned as recoverable.
+func (s *serverSink) Send(metrics []shared.Metric) error {
+	encoding := s.currentEncoding()
+	status, body, err := s.post(metrics, encoding)
+	if err != nil {
+		var encodeErr *metricsEncodeError
+		if errors.As(err, &encodeErr) {
+			return retry.Unrecoverable(encodeErr)
+		}
+		if isNetworkError(err) {
+			return err // retry only network errors
+		}
+		return retry.Unrecoverable(err)
+	}
+
+	if status == http.StatusUnsupportedMediaType && s.currentEncoding() != encoding {
+		return fmt.Errorf("server doesn't support %s compression", encoding) // retry with the advertised one
+	}
+
+	if status != http.StatusOK {
+		return retry.Unrecoverable(fmt.Errorf(
+			"received non-OK response while sending metrics: %d, error: %s",
+			status,
+			string(body),
+		))
+	}
+	return nil
+}
+
+// post sends the metrics compressed with the encoding and returns the response status and body.
+func (s *serverSink) post(metrics []shared.Metric, encoding string) (int, []byte, error) {
+	return s.postJSON(s.url, metrics, encoding)
+}
+
+// postJSON sends the value as JSON compressed with the encoding to the URL
+// and returns the response status and body.
+func (s *serverSink) postJSON(url string, v any, encoding string) (int, []byte, error) {
+	reader, writer := io.Pipe()
+	encoded := make(chan error, 1)
+	go func() {
+		encoded <- encodeMetrics(writer, v, encoding)
+	}()
+	defer func() {
+		// unblocks the encoder if the request ended before the body was read
+		reader.Close()
+		<-encoded
+	}()
+
+	req, err := http.NewRequest(http.MethodPost, url, reader)
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	if encoding != shared.EncodingIdentity {
+		req.Header.Set("Content-Encoding", encoding)
+	}
+	req.Header.Set("Accept-Encoding", acceptedResponseEncodings)
+
+	r, err := s.client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer r.Body.Close()
+	s.learnEncodings(r.Header.Get("Accept-Encoding"))
+
+	body, err := readResponseBody(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	return r.StatusCode, body, nil
+}
+
+// metricsEncodeError is an error of encoding the request body.
+type metricsEncodeError struct {
+	err error
+}
+
+func (e *metricsEncodeError) Error() string {
+	return e.err.Error()
+}
+
+func (e *metricsEncodeError) Unwrap() error {
+	return e.err
+}
+
+func (s *serverSink) currentEncoding() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.encoding
+}
+
+// learnEncodings switches the request encoding according to the encodings advertised by the server.
+// Servers that don't advertise encodings are expected to support gzip only.
+func (s *serverSink) learnEncodings(acceptEncoding string) {
+	supported := shared.AcceptedEncodings(acceptEncoding)
+	if len(supported) == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.auto {
+		s.encoding = supported[0]
+		return
+	}
+	if s.encoding == shared.EncodingIdentity {
+		return
+	}
+	for _, encoding := range supported {
+		if encoding == s.encoding {
+			return
+		}
+	}
+	log.Warnf("Server doesn't support %s compression, switching to %s", s.encoding, supported[0])
+	s.encoding = supported[0]
+}
+
+// readResponseBody reads the response body decoding it with the response content encoding.
+func readResponseBody(r *http.Response) ([]byte, error) {
+	reader, err := shared.NewDecompressReader(r.Header.Get("Content-Encoding"), r.Body)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+// encodeMetrics writes the compressed JSON of the metrics to the pipe and closes it with the encoding error if any.
+func encodeMetrics(pipe *io.PipeWriter, metrics any, encoding string) error {
+	writer, err := shared.NewCompressWriter(encoding, pipe)
+	if err != nil {
+		err = &metricsEncodeError{err}
+		pipe.CloseWithError(err)
+		return err
+	}
+	err = json.NewEncoder(writer).Encode(metrics)
+	if err != nil {
+		err = &metricsEncodeError{fmt.Errorf("failed to encode metrics to JSON: %w", err)}
+	}
+	if closeErr := writer.Close(); err == nil && closeErr != nil {
+		err = &metricsEncodeError{fmt.Errorf("failed to close %s writer: %w", encoding, closeErr)}
+	}
+	pipe.CloseWithError(err)
+	return err
+}
+
+// isNetworkError reports whether the error is a transient network error worth retrying.
+func isNetworkError(err error) bool {
+	var netErr net.Error
+	return (errors.As(err, &netErr) && netErr.Timeout()) ||
+		strings.Contains(err.Error(), "EOF") ||
+		strings.Contains(err.Error(), "connection reset by peer")
+}
+
+// buildURL joins the address and the path adding the http scheme when it's missing.
+func buildURL(address, path string) string {
+	var url string
+	if !strings.HasPrefix(address, "http") {
+		url += "http://"
+	}
+	url += strings.TrimSuffix(address, "/") + path
+	return url
+}
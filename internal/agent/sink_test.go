@@ -0,0 +1,283 @@
+package agent
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/avast/retry-go"
+	"github.com/stretchr/testify/require"
+
+	"github.com/gonozov0/go-musthave-devops/internal/server/application"
+	repository "github.com/gonozov0/go-musthave-devops/internal/server/repository/in_memory"
+	"github.com/gonozov0/go-musthave-devops/internal/shared"
+)
+
+type fakeSink struct {
+	batches [][]shared.Metric
+	errs    []error
+}
+
+func (s *fakeSink) Name() string { return "fake" }
+
+func (s *fakeSink) Send(metrics []shared.Metric) error {
+	if len(s.errs) > 0 {
+		err := s.errs[0]
+		s.errs = s.errs[1:]
+		return err
+	}
+	s.batches = append(s.batches, metrics)
+	return nil
+}
+
+func testMetrics() []shared.Metric {
+	gauge1, gauge2 := 1.5, 2.5
+	delta1, delta2 := int64(2), int64(3)
+	return []shared.Metric{
+		{ID: "Alloc", MType: shared.Gauge, Value: &gauge1},
+		{ID: "PollCount", MType: shared.Counter, Delta: &delta1},
+		{ID: "Alloc", MType: shared.Gauge, Value: &gauge2},
+		{ID: "PollCount", MType: shared.Counter, Delta: &delta2},
+	}
+}
+
+func TestBatchingSink(t *testing.T) {
+	fake := &fakeSink{errs: []error{errors.New("temporary")}}
+	sink := &batchingSink{sink: fake, batchSize: 3, attempts: 2}
+
+	err := sink.Send(testMetrics())
+	require.NoError(t, err)
+	require.Len(t, fake.batches, 2)
+	require.Len(t, fake.batches[0], 3)
+	require.Len(t, fake.batches[1], 1)
+
+	fake = &fakeSink{errs: []error{retry.Unrecoverable(errors.New("fatal")), nil}}
+	sink = &batchingSink{sink: fake, attempts: 3}
+	err = sink.Send(testMetrics())
+	require.Error(t, err)
+	require.Empty(t, fake.batches)
+}
+
+func TestBatchingSinkInChunks(t *testing.T) {
+	metrics := make([]shared.Metric, 0, 10)
+	for i := 0; i < 10; i++ {
+		value := float64(i)
+		metrics = append(metrics, shared.Metric{ID: fmt.Sprintf("TestMetric%d", i), MType: shared.Gauge, Value: &value})
+	}
+
+	fake := &fakeSink{}
+	require.NoError(t, (&batchingSink{sink: fake, batchSize: 4, attempts: 1}).Send(metrics))
+	require.Len(t, fake.batches, 3)
+	require.Equal(t, metrics[8:], fake.batches[2])
+
+	fake = &fakeSink{}
+	require.NoError(t, (&batchingSink{sink: fake, batchBytes: 100, attempts: 1}).Send(metrics))
+	require.Greater(t, len(fake.batches), 1)
+	for _, batch := range fake.batches {
+		encoded, err := json.Marshal(batch)
+		require.NoError(t, err)
+		require.LessOrEqual(t, len(encoded), 100)
+	}
+}
+
+func TestBatchingSinkUnsent(t *testing.T) {
+	// the first chunk fails, the second is rejected and the third is sent
+	fake := &fakeSink{errs: []error{errors.New("temporary"), retry.Unrecoverable(errors.New("rejected"))}}
+	sink := &batchingSink{sink: fake, batchSize: 2, attempts: 1}
+	metrics := append(testMetrics(), testMetrics()[:2]...)
+
+	err := sink.Send(metrics)
+	var sendErr *SendError
+	require.ErrorAs(t, err, &sendErr)
+	require.Equal(t, metrics[:2], sendErr.Unsent)
+	require.Len(t, sendErr.Chunks, 3)
+	require.Error(t, sendErr.Chunks[0].Err)
+	require.False(t, sendErr.Chunks[0].Dropped)
+	require.Error(t, sendErr.Chunks[1].Err)
+	require.True(t, sendErr.Chunks[1].Dropped)
+	require.NoError(t, sendErr.Chunks[2].Err)
+	require.Equal(t, [][]shared.Metric{metrics[4:]}, fake.batches)
+}
+
+func TestAggregateMetrics(t *testing.T) {
+	metrics := aggregateMetrics(testMetrics())
+
+	require.Len(t, metrics, 2)
+	require.Equal(t, "Alloc", metrics[0].ID)
+	require.Equal(t, 2.5, *metrics[0].Value)
+	require.Equal(t, "PollCount", metrics[1].ID)
+	require.Equal(t, int64(5), *metrics[1].Delta)
+}
+
+func TestBufferMetrics(t *testing.T) {
+	var buffer []shared.Metric
+	for i := 0; i < 1000; i++ {
+		var dropped int
+		buffer, dropped = BufferMetrics(buffer, testMetrics(), 10)
+		require.Zero(t, dropped)
+	}
+	require.Len(t, buffer, 2, "the buffer must be aggregated")
+	require.Equal(t, int64(5000), *buffer[1].Delta)
+
+	value := 1.0
+	buffer, dropped := BufferMetrics(buffer, []shared.Metric{{ID: "HeapAlloc", MType: shared.Gauge, Value: &value}}, 2)
+	require.Equal(t, 1, dropped)
+	require.Equal(t, []string{"PollCount", "HeapAlloc"}, []string{buffer[0].ID, buffer[1].ID})
+}
+
+func TestServerSink(t *testing.T) {
+	repo := repository.NewInMemoryRepository()
+	server := httptest.NewServer(application.NewRouter(repo))
+	defer server.Close()
+
+	sink, err := NewSink(SinkConfig{Type: SinkServer, Address: server.URL, BatchSize: 1})
+	require.NoError(t, err)
+	require.NoError(t, sink.Send(testMetrics()))
+
+	gauge, err := repo.GetGauge("Alloc")
+	require.NoError(t, err)
+	require.Equal(t, 2.5, gauge)
+	counter, err := repo.GetCounter("PollCount")
+	require.NoError(t, err)
+	require.Equal(t, int64(5), counter)
+}
+
+func TestFileSinkRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.jsonl")
+
+	sink, err := NewSink(SinkConfig{Type: SinkFile, Path: path, MaxSize: 100, MaxBackups: 2, BatchSize: 1})
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, sink.Send(testMetrics()))
+	}
+
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	require.True(t, scanner.Scan())
+	var metric shared.Metric
+	require.NoError(t, json.Unmarshal(scanner.Bytes(), &metric))
+	require.NotEmpty(t, metric.ID)
+
+	require.FileExists(t, path+".1")
+	require.FileExists(t, path+".2")
+	require.NoFileExists(t, path+".3")
+}
+
+func TestFileSinkRotationFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.jsonl")
+	// a non-empty directory can't be replaced by the rotated file
+	require.NoError(t, os.MkdirAll(filepath.Join(path+".1", "blocker"), 0755))
+
+	sink, err := newFileSink(path, 100, 1)
+	require.NoError(t, err)
+	require.NoError(t, sink.Send(testMetrics()))
+	require.Error(t, sink.Send(testMetrics()))
+
+	// the file is still open and the next write rotates it
+	require.NoError(t, os.RemoveAll(path+".1"))
+	require.NoError(t, sink.Send(testMetrics()))
+	require.FileExists(t, path+".1")
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Positive(t, info.Size())
+}
+
+func TestPushgatewaySink(t *testing.T) {
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/metrics/job/test", r.URL.Path)
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		bodies = append(bodies, string(body))
+	}))
+	defer server.Close()
+
+	sink, err := NewSink(SinkConfig{Type: SinkPushgateway, Address: server.URL, Job: "test"})
+	require.NoError(t, err)
+	require.NoError(t, sink.Send(testMetrics()))
+	require.NoError(t, sink.Send(testMetrics()))
+
+	require.Equal(t, []string{
+		"# TYPE Alloc gauge\nAlloc 2.5\n# TYPE PollCount counter\nPollCount 5\n",
+		"# TYPE Alloc gauge\nAlloc 2.5\n# TYPE PollCount counter\nPollCount 10\n",
+	}, bodies)
+}
+
+func TestInfluxDBSink(t *testing.T) {
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/write", r.URL.Path)
+		require.Equal(t, "metrics", r.URL.Query().Get("db"))
+		require.Equal(t, "Token secret", r.Header.Get("Authorization"))
+		bytes, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		body = string(bytes)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	sink, err := NewSink(SinkConfig{Type: SinkInfluxDB, Address: server.URL, Database: "metrics", Token: "secret"})
+	require.NoError(t, err)
+	require.NoError(t, sink.Send(testMetrics()[:2]))
+
+	require.Equal(t, "Alloc,type=gauge value=1.5\nPollCount,type=counter value=2i\n", body)
+}
+
+func TestServerSinkCompression(t *testing.T) {
+	repo := repository.NewInMemoryRepository()
+	router := application.NewRouter(repo)
+	var encodings []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encodings = append(encodings, r.Header.Get("Content-Encoding"))
+		router.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	sink, err := newServerSink(server.URL, CompressionAuto)
+	require.NoError(t, err)
+	require.NoError(t, sink.Send(testMetrics()))
+	require.NoError(t, sink.Send(testMetrics()))
+	require.Equal(t, []string{shared.EncodingGzip, shared.EncodingZstd}, encodings)
+
+	encodings = nil
+	sink, err = newServerSink(server.URL, CompressionNone)
+	require.NoError(t, err)
+	require.NoError(t, sink.Send(testMetrics()))
+	require.Equal(t, []string{""}, encodings)
+
+	_, err = newServerSink(server.URL, "lzma")
+	require.Error(t, err)
+
+	counter, err := repo.GetCounter("PollCount")
+	require.NoError(t, err)
+	require.Equal(t, int64(15), counter)
+}
+
+func TestServerSinkCompressionFallback(t *testing.T) {
+	var encodings []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding := r.Header.Get("Content-Encoding")
+		encodings = append(encodings, encoding)
+		w.Header().Set("Accept-Encoding", "gzip, deflate")
+		if encoding != shared.EncodingGzip && encoding != shared.EncodingDeflate {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	sink, err := NewSink(SinkConfig{Type: SinkServer, Address: server.URL, Compression: shared.EncodingBrotli, RetryAttempts: 2})
+	require.NoError(t, err)
+	require.NoError(t, sink.Send(testMetrics()))
+	require.Equal(t, []string{shared.EncodingBrotli, shared.EncodingGzip}, encodings)
+}
@@ -0,0 +1,62 @@
+package ingest
+
+import (
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gonozov0/go-musthave-devops/internal/shared"
+)
+
+// acceptedRequestEncodings advertises the request encodings that can be decoded (RFC 7694).
+var acceptedRequestEncodings = strings.Join(shared.SupportedEncodings, ", ")
+
+// CompressionMiddleware decodes request bodies compressed with any supported encoding
+// and compresses responses with the encoding negotiated from Accept-Encoding.
+func CompressionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Accept-Encoding", acceptedRequestEncodings)
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		// Для входящего содержимого
+		contentEncoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+		if !shared.IsSupportedEncoding(contentEncoding) && contentEncoding != "" {
+			http.Error(w, "Unsupported content encoding", http.StatusUnsupportedMediaType)
+			return
+		}
+		reader, err := shared.NewDecompressReader(contentEncoding, r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer reader.Close()
+		r.Body = reader
+		r.Header.Del("Content-Encoding")
+
+		// Подготовка к сжатию исходящего содержимого
+		encoding := shared.NegotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding != shared.EncodingIdentity {
+			writer, err := shared.NewCompressWriter(encoding, w)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			defer writer.Close()
+
+			w.Header().Set("Content-Encoding", encoding)
+			w.Header().Del("Content-Length")
+			w = &wrapResponseWriter{Writer: writer, ResponseWriter: w}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+type wrapResponseWriter struct {
+	io.Writer
+	http.ResponseWriter
+}
+
+func (w *wrapResponseWriter) Write(data []byte) (int, error) {
+	return w.Writer.Write(data)
+}
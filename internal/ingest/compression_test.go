@@ -0,0 +1,142 @@
+package ingest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gonozov0/go-musthave-devops/internal/shared"
+	"github.com/stretchr/testify/require"
+)
+
+// echoHandler responds with the metric decoded from the request.
+var echoHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	var metric shared.Metric
+	if err := json.NewDecoder(r.Body).Decode(&metric); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(metric)
+})
+
+func TestGZipMiddleware(t *testing.T) {
+	router := CompressionMiddleware(echoHandler)
+
+	testFloat := 32.5
+	testMetric := shared.Metric{ID: "temperature", MType: shared.Gauge, Value: &testFloat}
+
+	body, err := json.Marshal(testMetric)
+	require.NoError(t, err, "Failed to marshal metric")
+
+	var b bytes.Buffer
+	gz := gzip.NewWriter(&b)
+	_, _ = gz.Write(body)
+	_ = gz.Close()
+
+	req, err := http.NewRequest(http.MethodPost, "/update/", &b)
+	require.NoError(t, err, "Failed to create request")
+	req.Header.Set("Content-Encoding", "gzip")
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	require.Equal(t, "gzip", rr.Header().Get("Content-Encoding"))
+
+	gr, err := gzip.NewReader(rr.Body)
+	require.NoError(t, err)
+	respBody, err := io.ReadAll(gr)
+	require.NoError(t, err)
+
+	resultMetric := shared.Metric{}
+	err = json.Unmarshal(respBody, &resultMetric)
+	require.NoError(t, err, "Failed to unmarshal metric")
+	require.Equal(t, testMetric.ID, resultMetric.ID, "Unexpected metric ID")
+	require.Equal(t, testMetric.MType, resultMetric.MType, "Unexpected metric type")
+	require.Equal(t, *testMetric.Value, *resultMetric.Value, "Unexpected metric value")
+}
+
+func TestCompressionNegotiation(t *testing.T) {
+	router := CompressionMiddleware(echoHandler)
+
+	testFloat := 32.5
+	testMetric := shared.Metric{ID: "temperature", MType: shared.Gauge, Value: &testFloat}
+	body, err := json.Marshal(testMetric)
+	require.NoError(t, err, "Failed to marshal metric")
+
+	testCases := []struct {
+		name             string
+		contentEncoding  string
+		acceptEncoding   string
+		expectedCode     int
+		expectedEncoding string
+	}{
+		{
+			name:             "TestZstdRequestBrotliResponse",
+			contentEncoding:  shared.EncodingZstd,
+			acceptEncoding:   "gzip;q=0.5, br",
+			expectedCode:     http.StatusOK,
+			expectedEncoding: shared.EncodingBrotli,
+		},
+		{
+			name:             "TestDeflateRequestIdentityResponse",
+			contentEncoding:  shared.EncodingDeflate,
+			acceptEncoding:   "identity",
+			expectedCode:     http.StatusOK,
+			expectedEncoding: "",
+		},
+		{
+			name:             "TestIdentityRequestZstdResponse",
+			contentEncoding:  "",
+			acceptEncoding:   "*",
+			expectedCode:     http.StatusOK,
+			expectedEncoding: shared.EncodingZstd,
+		},
+		{
+			name:            "TestUnsupportedRequestEncoding",
+			contentEncoding: "lz4",
+			expectedCode:    http.StatusUnsupportedMediaType,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var b bytes.Buffer
+			if tc.contentEncoding == "lz4" {
+				b.Write(body)
+			} else {
+				writer, err := shared.NewCompressWriter(tc.contentEncoding, &b)
+				require.NoError(t, err)
+				_, _ = writer.Write(body)
+				require.NoError(t, writer.Close())
+			}
+
+			req, err := http.NewRequest(http.MethodPost, "/update/", &b)
+			require.NoError(t, err, "Failed to create request")
+			req.Header.Set("Content-Encoding", tc.contentEncoding)
+			req.Header.Set("Accept-Encoding", tc.acceptEncoding)
+
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			require.Equal(t, tc.expectedCode, rr.Code)
+			require.Equal(t, "Accept-Encoding", rr.Header().Get("Vary"))
+			require.Equal(t, "zstd, br, gzip, deflate", rr.Header().Get("Accept-Encoding"))
+			if tc.expectedCode != http.StatusOK {
+				return
+			}
+			require.Equal(t, tc.expectedEncoding, rr.Header().Get("Content-Encoding"))
+
+			reader, err := shared.NewDecompressReader(rr.Header().Get("Content-Encoding"), rr.Body)
+			require.NoError(t, err)
+			resultMetric := shared.Metric{}
+			require.NoError(t, json.NewDecoder(reader).Decode(&resultMetric))
+			require.Equal(t, *testMetric.Value, *resultMetric.Value, "Unexpected metric value")
+		})
+	}
+}
@@ -0,0 +1,67 @@
+// Package ingest contains the parts of the metrics update API shared by the server and the relay of the agent.
+package ingest
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/gonozov0/go-musthave-devops/internal/shared"
+)
+
+// ErrUnknownType is returned for metrics of an unsupported type.
+var ErrUnknownType = errors.New("Unknown metric type")
+
+// DecodeBatch decodes the JSON array of metrics sent to /updates and validates every metric.
+func DecodeBatch(r io.Reader) ([]shared.Metric, error) {
+	var metrics []shared.Metric
+	if err := json.NewDecoder(r).Decode(&metrics); err != nil {
+		return nil, err
+	}
+	if len(metrics) == 0 {
+		return nil, errors.New("empty metrics")
+	}
+	for _, metric := range metrics {
+		if err := Validate(metric); err != nil {
+			return nil, err
+		}
+	}
+	return metrics, nil
+}
+
+// Validate checks that the metric has a known type and the value of that type.
+func Validate(metric shared.Metric) error {
+	switch metric.MType {
+	case shared.Gauge:
+		if metric.Value == nil {
+			return errors.New("value is required for gauge metric")
+		}
+	case shared.Counter:
+		if metric.Delta == nil {
+			return errors.New("delta is required for counter metric")
+		}
+	case shared.Histogram:
+		if metric.Histogram == nil {
+			return errors.New("histogram is required for histogram metric")
+		}
+		return metric.Histogram.Validate()
+	case shared.Set:
+		if len(metric.Members) == 0 {
+			return errors.New("members are required for set metric")
+		}
+	default:
+		return ErrUnknownType
+	}
+	return nil
+}
+
+// Error replies to the request with the error of DecodeBatch or Validate.
+func Error(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrUnknownType) {
+		// Must be 400, return 501 because of autotests.
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusBadRequest)
+}
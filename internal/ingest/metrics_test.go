@@ -0,0 +1,41 @@
+package ingest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeBatch(t *testing.T) {
+	testCases := []struct {
+		name         string
+		body         string
+		expectedCode int
+		expectedErr  string
+	}{
+		{"TestValid", `[{"id":"Alloc","type":"gauge","value":1.5},{"id":"PollCount","type":"counter","delta":2}]`, http.StatusOK, ""},
+		{"TestInvalidJSON", `[{`, http.StatusBadRequest, "unexpected EOF"},
+		{"TestEmpty", `[]`, http.StatusBadRequest, "empty metrics"},
+		{"TestNoValue", `[{"id":"Alloc","type":"gauge"}]`, http.StatusBadRequest, "value is required for gauge metric"},
+		{"TestUnknownType", `[{"id":"Alloc","type":"unknown","value":1.5}]`, http.StatusNotImplemented, "Unknown metric type"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			metrics, err := DecodeBatch(strings.NewReader(tc.body))
+			if tc.expectedErr == "" {
+				require.NoError(t, err)
+				require.Len(t, metrics, 2)
+				return
+			}
+			require.EqualError(t, err, tc.expectedErr)
+
+			rr := httptest.NewRecorder()
+			Error(rr, err)
+			require.Equal(t, tc.expectedCode, rr.Code)
+		})
+	}
+}
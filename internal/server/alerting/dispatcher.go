@@ -0,0 +1,309 @@
+package alerting
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gonozov0/go-musthave-devops/internal/server/promql"
+)
+
+// dispatchInterval is how often the Dispatcher checks the alerts.
+const dispatchInterval = time.Second
+
+// InhibitRule mutes the alerts matching the target matchers while an alert matching the source matchers
+// is firing and both alerts have the same values of the Equal labels. Matchers are written as in PromQL
+// selectors, for example:
+//
+//	[{"source_matchers": ["alertname=\"RepositoryDown\""], "target_matchers": ["alertname!=\"RepositoryDown\""]}]
+type InhibitRule struct {
+	SourceMatchers []string `json:"source_matchers" yaml:"source_matchers"`
+	TargetMatchers []string `json:"target_matchers" yaml:"target_matchers"`
+	Equal          []string `json:"equal,omitempty" yaml:"equal"`
+}
+
+// compiledInhibitRule is the inhibit rule with its matchers.
+type compiledInhibitRule struct {
+	source []*promql.Matcher
+	target []*promql.Matcher
+	equal  []string
+}
+
+func compileInhibitRule(rule InhibitRule) (compiledInhibitRule, error) {
+	if len(rule.SourceMatchers) == 0 || len(rule.TargetMatchers) == 0 {
+		return compiledInhibitRule{}, fmt.Errorf("inhibit rule requires source and target matchers")
+	}
+	compiled := compiledInhibitRule{equal: rule.Equal}
+	for _, text := range rule.SourceMatchers {
+		matcher, err := ParseMatcher(text)
+		if err != nil {
+			return compiledInhibitRule{}, err
+		}
+		compiled.source = append(compiled.source, matcher)
+	}
+	for _, text := range rule.TargetMatchers {
+		matcher, err := ParseMatcher(text)
+		if err != nil {
+			return compiledInhibitRule{}, err
+		}
+		compiled.target = append(compiled.target, matcher)
+	}
+	return compiled, nil
+}
+
+// LoadInhibitRules reads the inhibit rules of the JSON or YAML files matching the patterns.
+// Every file contains a list of inhibit rules.
+func LoadInhibitRules(patterns []string) ([]InhibitRule, error) {
+	var rules []InhibitRule
+	for _, pattern := range patterns {
+		files, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid inhibit rule files pattern %q: %w", pattern, err)
+		}
+		for _, file := range files {
+			var fileRules []InhibitRule
+			if err := readFile(file, &fileRules); err != nil {
+				return nil, fmt.Errorf("failed to read inhibit rule file %s: %w", file, err)
+			}
+			rules = append(rules, fileRules...)
+		}
+	}
+	return rules, nil
+}
+
+// AlertSource provides the current alerts, it's implemented by the Manager.
+type AlertSource interface {
+	Alerts() []Alert
+}
+
+// GroupOptions configures how the alerts are grouped into the notifications.
+type GroupOptions struct {
+	By             []string      // labels of the group, every alert has a separate group when empty
+	Wait           time.Duration // how long the first notification of a new group waits for more alerts
+	Interval       time.Duration // how long the notification of a changed group waits after the previous one
+	RepeatInterval time.Duration // how often a group of the same firing alerts is notified again
+}
+
+// group is a group of alerts with the same values of the group labels.
+type group struct {
+	labels    map[string]string
+	firstSeen time.Time
+	lastSent  time.Time        // zero until the first notification
+	sent      map[string]State // states of the alerts in the last notification by alertKey
+}
+
+// Dispatcher notifies about the firing and resolved alerts of the source grouped by the group labels.
+// Silenced and inhibited alerts aren't notified, the resolved alerts are notified only when
+// they were notified as firing.
+type Dispatcher struct {
+	source   AlertSource
+	notifier Notifier
+	silences *Silences
+	inhibit  []compiledInhibitRule
+	opts     GroupOptions
+
+	mu     sync.Mutex
+	groups map[string]*group // by alertKey of the group labels
+}
+
+// DispatcherOption configures the Dispatcher.
+type DispatcherOption func(*Dispatcher)
+
+// WithSilences mutes the alerts matching the active silences.
+func WithSilences(silences *Silences) DispatcherOption {
+	return func(d *Dispatcher) {
+		d.silences = silences
+	}
+}
+
+// NewDispatcher creates a new Dispatcher of the inhibit rules.
+func NewDispatcher(
+	source AlertSource,
+	notifier Notifier,
+	inhibit []InhibitRule,
+	opts GroupOptions,
+	dispatcherOpts ...DispatcherOption,
+) (*Dispatcher, error) {
+	d := &Dispatcher{
+		source:   source,
+		notifier: notifier,
+		opts:     opts,
+		groups:   make(map[string]*group),
+	}
+	for _, rule := range inhibit {
+		compiled, err := compileInhibitRule(rule)
+		if err != nil {
+			return nil, err
+		}
+		d.inhibit = append(d.inhibit, compiled)
+	}
+	for _, opt := range dispatcherOpts {
+		opt(d)
+	}
+	return d, nil
+}
+
+// Run flushes the groups every second until the context is canceled.
+func (d *Dispatcher) Run(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	ticker := time.NewTicker(dispatchInterval)
+	defer ticker.Stop()
+	for {
+		d.Flush(time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Flush notifies about the groups that are due at the time: a new group after the group wait,
+// a changed group after the group interval and an unchanged firing group after the repeat interval.
+func (d *Dispatcher) Flush(now time.Time) {
+	alerts := d.source.Alerts()
+	muted := d.muted(alerts, now)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	current := make(map[string][]Alert)
+	for _, alert := range alerts {
+		key := alertKey(alert.Rule, alert.Labels)
+		if alert.State == StatePending || muted[key] {
+			continue
+		}
+		labels := d.groupLabels(alert.Labels)
+		groupKey := alertKey("", labels)
+		g, ok := d.groups[groupKey]
+		if alert.State == StateResolved && (!ok || g.sent[key] != StateFiring) {
+			continue // wasn't notified as firing
+		}
+		if !ok {
+			g = &group{labels: labels, firstSeen: now, sent: make(map[string]State)}
+			d.groups[groupKey] = g
+		}
+		current[groupKey] = append(current[groupKey], alert)
+	}
+
+	for groupKey, g := range d.groups {
+		groupAlerts := current[groupKey]
+		if len(groupAlerts) == 0 {
+			delete(d.groups, groupKey)
+			continue
+		}
+		if !d.due(g, groupAlerts, now) {
+			continue
+		}
+
+		firing := false
+		g.sent = make(map[string]State, len(groupAlerts))
+		for _, alert := range groupAlerts {
+			if alert.State == StateFiring {
+				firing = true
+				g.sent[alertKey(alert.Rule, alert.Labels)] = StateFiring
+			}
+		}
+		g.lastSent = now
+
+		status := StateResolved
+		if firing {
+			status = StateFiring
+		}
+		sort.Slice(groupAlerts, func(i, j int) bool {
+			return alertKey(groupAlerts[i].Rule, groupAlerts[i].Labels) < alertKey(groupAlerts[j].Rule, groupAlerts[j].Labels)
+		})
+		if d.notifier != nil {
+			d.notifier.Notify(Notification{Status: status, GroupLabels: g.labels, Alerts: groupAlerts})
+		}
+		if !firing {
+			delete(d.groups, groupKey)
+		}
+	}
+}
+
+// due reports whether the group has to be notified at the time.
+func (d *Dispatcher) due(g *group, alerts []Alert, now time.Time) bool {
+	if g.lastSent.IsZero() {
+		return now.Sub(g.firstSeen) >= d.opts.Wait
+	}
+	changed := len(alerts) != len(g.sent)
+	for _, alert := range alerts {
+		if g.sent[alertKey(alert.Rule, alert.Labels)] != alert.State {
+			changed = true
+		}
+	}
+	if changed {
+		return now.Sub(g.lastSent) >= d.opts.Interval
+	}
+	return now.Sub(g.lastSent) >= d.opts.RepeatInterval
+}
+
+// groupLabels returns the group labels of the alert labels.
+func (d *Dispatcher) groupLabels(labels map[string]string) map[string]string {
+	if len(d.opts.By) == 0 {
+		return labels
+	}
+	grouped := make(map[string]string, len(d.opts.By))
+	for _, name := range d.opts.By {
+		if value, ok := labels[name]; ok {
+			grouped[name] = value
+		}
+	}
+	return grouped
+}
+
+// muted returns the keys of the firing alerts that are silenced or inhibited at the time.
+func (d *Dispatcher) muted(alerts []Alert, now time.Time) map[string]bool {
+	muted := make(map[string]bool)
+	var silences []compiledSilence
+	if d.silences != nil {
+		silences = d.silences.active(now)
+	}
+	for _, alert := range alerts {
+		if alert.State != StateFiring {
+			continue
+		}
+		key := alertKey(alert.Rule, alert.Labels)
+		for _, silence := range silences {
+			if matchesAll(silence.matchers, alert.Labels) {
+				muted[key] = true
+				break
+			}
+		}
+		if !muted[key] && d.inhibited(alert, key, alerts) {
+			muted[key] = true
+		}
+	}
+	return muted
+}
+
+// inhibited reports whether another firing alert inhibits the alert.
+func (d *Dispatcher) inhibited(alert Alert, key string, alerts []Alert) bool {
+	for _, rule := range d.inhibit {
+		if !matchesAll(rule.target, alert.Labels) {
+			continue
+		}
+		for _, source := range alerts {
+			if source.State != StateFiring || alertKey(source.Rule, source.Labels) == key ||
+				!matchesAll(rule.source, source.Labels) {
+				continue
+			}
+			equal := true
+			for _, name := range rule.equal {
+				if source.Labels[name] != alert.Labels[name] {
+					equal = false
+					break
+				}
+			}
+			if equal {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,113 @@
+package alerting
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gonozov0/go-musthave-devops/internal/server/repository"
+	inMemory "github.com/gonozov0/go-musthave-devops/internal/server/repository/in_memory"
+)
+
+type staticSource struct {
+	alerts []Alert
+}
+
+func (s *staticSource) Alerts() []Alert {
+	return s.alerts
+}
+
+func testAlert(rule, instance string, state State) Alert {
+	return Alert{Rule: rule, State: state, Labels: map[string]string{"alertname": rule, "instance": instance}}
+}
+
+func TestDispatcherGrouping(t *testing.T) {
+	source := &staticSource{}
+	notifier := &recordingNotifier{}
+	dispatcher, err := NewDispatcher(source, notifier, nil, GroupOptions{
+		By:             []string{"alertname"},
+		Wait:           30 * time.Second,
+		Interval:       5 * time.Minute,
+		RepeatInterval: time.Hour,
+	})
+	require.NoError(t, err)
+	now := time.Unix(1700000000, 0)
+
+	// pending alerts aren't notified, the first notification waits for more alerts
+	source.alerts = []Alert{testAlert("HighHeap", "a", StateFiring), testAlert("HighHeap", "b", StatePending)}
+	dispatcher.Flush(now)
+	source.alerts[1].State = StateFiring
+	dispatcher.Flush(now.Add(20 * time.Second))
+	require.Empty(t, notifier.notifications)
+	dispatcher.Flush(now.Add(30 * time.Second))
+	require.Len(t, notifier.notifications, 1)
+	require.Equal(t, StateFiring, notifier.notifications[0].Status)
+	require.Equal(t, map[string]string{"alertname": "HighHeap"}, notifier.notifications[0].GroupLabels)
+	require.Len(t, notifier.notifications[0].Alerts, 2)
+
+	// changes wait for the group interval
+	source.alerts[1].State = StateResolved
+	dispatcher.Flush(now.Add(time.Minute))
+	require.Len(t, notifier.notifications, 1)
+	dispatcher.Flush(now.Add(5*time.Minute + 30*time.Second))
+	require.Len(t, notifier.notifications, 2)
+	require.Equal(t, StateFiring, notifier.notifications[1].Status)
+	require.Equal(t, StateResolved, notifier.notifications[1].Alerts[1].State)
+
+	// the same firing alerts are repeated after the repeat interval
+	dispatcher.Flush(now.Add(time.Hour))
+	require.Len(t, notifier.notifications, 2)
+	dispatcher.Flush(now.Add(time.Hour + 5*time.Minute + 30*time.Second))
+	require.Len(t, notifier.notifications, 3)
+	require.Len(t, notifier.notifications[2].Alerts, 1)
+
+	// the resolved group is notified once
+	source.alerts[0].State = StateResolved
+	dispatcher.Flush(now.Add(2 * time.Hour))
+	dispatcher.Flush(now.Add(3 * time.Hour))
+	require.Len(t, notifier.notifications, 4)
+	require.Equal(t, StateResolved, notifier.notifications[3].Status)
+	require.Empty(t, dispatcher.groups)
+}
+
+func TestDispatcherSilencesAndInhibition(t *testing.T) {
+	source := &staticSource{}
+	notifier := &recordingNotifier{}
+	silences := NewSilences(inMemory.NewInMemoryRepository().(repository.SilenceRepository))
+	dispatcher, err := NewDispatcher(source, notifier, []InhibitRule{{
+		SourceMatchers: []string{`alertname="RepositoryDown"`},
+		TargetMatchers: []string{`alertname!="RepositoryDown"`},
+	}}, GroupOptions{RepeatInterval: time.Hour}, WithSilences(silences))
+	require.NoError(t, err)
+	now := time.Now()
+
+	_, err = silences.Create(repository.Silence{
+		Matchers: []repository.SilenceMatcher{{Name: "instance", Value: "a"}},
+		EndsAt:   now.Add(time.Hour),
+	}, now)
+	require.NoError(t, err)
+
+	source.alerts = []Alert{testAlert("HighHeap", "a", StateFiring), testAlert("HighHeap", "b", StateFiring)}
+	dispatcher.Flush(now)
+	require.Len(t, notifier.notifications, 1)
+	require.Equal(t, "b", notifier.notifications[0].Alerts[0].Labels["instance"])
+
+	// the firing RepositoryDown alert inhibits the others
+	source.alerts = append(source.alerts, testAlert(RepositoryDownRule, "", StatePending))
+	dispatcher.Flush(now.Add(time.Second))
+	require.Len(t, notifier.notifications, 1)
+	source.alerts[2].State = StateFiring
+	dispatcher.Flush(now.Add(2 * time.Second))
+	require.Len(t, notifier.notifications, 2)
+	require.Equal(t, RepositoryDownRule, notifier.notifications[1].Alerts[0].Rule)
+
+	// the silence ends
+	source.alerts = source.alerts[:1]
+	dispatcher.Flush(now.Add(2 * time.Hour))
+	require.Len(t, notifier.notifications, 3)
+	require.Equal(t, "a", notifier.notifications[2].Alerts[0].Labels["instance"])
+
+	_, err = NewDispatcher(source, notifier, []InhibitRule{{SourceMatchers: []string{`alertname="x"`}}}, GroupOptions{})
+	require.Error(t, err)
+}
@@ -0,0 +1,511 @@
+package alerting
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/gonozov0/go-musthave-devops/internal/server/promql"
+)
+
+const (
+	// resolvedRetention is how long the resolved alerts are listed.
+	resolvedRetention = 15 * time.Minute
+	// pingRuleHold is how long the pings fail before the RepositoryDown alert fires.
+	pingRuleHold = time.Minute
+)
+
+const (
+	// BuiltinSource is the source of the built-in rules.
+	BuiltinSource = "builtin"
+	// RepositoryDownRule is the built-in rule firing while the repository doesn't respond to pings.
+	RepositoryDownRule = "RepositoryDown"
+)
+
+var (
+	// ErrRuleNotFound is returned when the rule doesn't exist.
+	ErrRuleNotFound = errors.New("rule not found")
+	// ErrReadOnlyRule is returned when a rule of the rule files is changed via the API.
+	ErrReadOnlyRule = errors.New("rule is defined in a rule file")
+)
+
+// State is the state of an alert. Alerts of inactive rules aren't kept.
+type State string
+
+const (
+	// StatePending alerts are active for less than the For duration of their rule.
+	StatePending State = "pending"
+	// StateFiring alerts are active for at least the For duration of their rule.
+	StateFiring State = "firing"
+	// StateResolved alerts were firing and aren't active anymore.
+	StateResolved State = "resolved"
+)
+
+// SampleValue is the value of an alert encoded as a string like in the Prometheus API,
+// so it can be infinite or NaN.
+type SampleValue float64
+
+func (v SampleValue) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(strconv.FormatFloat(float64(v), 'g', -1, 64))), nil
+}
+
+func (v *SampleValue) UnmarshalJSON(data []byte) error {
+	value, err := strconv.Unquote(string(data))
+	if err != nil {
+		value = string(data)
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return fmt.Errorf("invalid sample value %s", data)
+	}
+	*v = SampleValue(parsed)
+	return nil
+}
+
+// Alert is a sample returned by the query of the rule. Its labels are the sample labels without
+// the metric name, the rule labels and the alertname label of the rule name.
+type Alert struct {
+	Rule        string            `json:"rule"`
+	State       State             `json:"state"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Value       SampleValue       `json:"value"`
+	ActiveAt    time.Time         `json:"activeAt"`
+	FiredAt     *time.Time        `json:"firedAt,omitempty"`
+	ResolvedAt  *time.Time        `json:"resolvedAt,omitempty"`
+}
+
+// Notification is sent when alerts start firing or get resolved. The status is firing when any alert
+// is firing. The Dispatcher sets the group labels shared by the alerts.
+type Notification struct {
+	Status      State             `json:"status"`
+	GroupLabels map[string]string `json:"groupLabels,omitempty"`
+	Alerts      []Alert           `json:"alerts"`
+}
+
+// Notifier delivers the notifications. Notify is called while evaluating the rules, so it must not block.
+type Notifier interface {
+	Notify(notification Notification)
+}
+
+// Pinger checks the connection to the repository like the /ping handler.
+type Pinger interface {
+	Ping() error
+}
+
+// Querier evaluates the rule queries.
+type Querier interface {
+	Instant(query string, t time.Time) (promql.Value, error)
+}
+
+// RuleStatus is a rule with the result of its last evaluation.
+type RuleStatus struct {
+	Rule
+	Source         string     `json:"source"`
+	Query          string     `json:"query"`
+	LastEvaluation *time.Time `json:"lastEvaluation,omitempty"`
+	LastError      string     `json:"lastError,omitempty"`
+}
+
+type ruleHealth struct {
+	lastEvaluation time.Time
+	lastError      error
+}
+
+// Manager evaluates the alerting rules on an interval and notifies about the firing and the resolved
+// alerts. The rules created via the API and the alerts are persisted to the state file.
+type Manager struct {
+	querier   Querier
+	notifier  Notifier
+	interval  time.Duration
+	statePath string
+	files     map[string][]Rule
+	pinger    Pinger
+
+	mu     sync.Mutex
+	rules  map[string]*compiledRule
+	health map[string]*ruleHealth
+	alerts map[string]*Alert // by alertKey
+}
+
+// Option configures the Manager.
+type Option func(*Manager)
+
+// WithStatePath persists the state to the file, empty disables the persistence.
+func WithStatePath(path string) Option {
+	return func(m *Manager) {
+		m.statePath = path
+	}
+}
+
+// WithRuleFiles adds the read-only rules of the files by file, see LoadRuleFiles.
+func WithRuleFiles(rules map[string][]Rule) Option {
+	return func(m *Manager) {
+		m.files = rules
+	}
+}
+
+// WithPingRule adds the built-in RepositoryDown rule, which fires when the pings fail for a minute.
+func WithPingRule(pinger Pinger) Option {
+	return func(m *Manager) {
+		m.pinger = pinger
+	}
+}
+
+// NewManager creates a new Manager with the rules of the files and the state restored from the state file.
+func NewManager(querier Querier, notifier Notifier, interval time.Duration, opts ...Option) (*Manager, error) {
+	m := &Manager{
+		querier:  querier,
+		notifier: notifier,
+		interval: interval,
+		rules:    make(map[string]*compiledRule),
+		health:   make(map[string]*ruleHealth),
+		alerts:   make(map[string]*Alert),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	files := make([]string, 0, len(m.files))
+	for file := range m.files {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		for _, rule := range m.files[file] {
+			compiled, err := compile(rule, file)
+			if err != nil {
+				return nil, fmt.Errorf("invalid rule in %s: %w", file, err)
+			}
+			if existing, ok := m.rules[rule.Name]; ok {
+				return nil, fmt.Errorf("rule %s is defined in %s and %s", rule.Name, existing.source, file)
+			}
+			m.rules[rule.Name] = compiled
+		}
+	}
+	if m.pinger != nil {
+		if existing, ok := m.rules[RepositoryDownRule]; ok {
+			return nil, fmt.Errorf("rule %s is built in and can't be defined in %s", RepositoryDownRule, existing.source)
+		}
+		m.rules[RepositoryDownRule] = pingRule(m.pinger)
+	}
+
+	if err := m.restore(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Run evaluates the rules immediately and then every interval until the context is canceled.
+func (m *Manager) Run(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		m.Evaluate(time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Evaluate evaluates every rule at the time and advances the states of the alerts:
+// new alerts are pending until they are active for the For duration of the rule and then fire,
+// firing alerts get resolved when they aren't active. Pending alerts that aren't active are dropped.
+// The alerts of the rules failing to evaluate keep their states.
+func (m *Manager) Evaluate(now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	active := make(map[string]bool)
+	failed := make(map[string]bool)
+	var firing, resolved []Alert
+	for _, name := range m.ruleNames() {
+		rule := m.rules[name]
+		vector, err := m.query(rule, now)
+		m.health[name] = &ruleHealth{lastEvaluation: now, lastError: err}
+		if err != nil {
+			log.Errorf("Could not evaluate alerting rule %s: %s", name, err.Error())
+			failed[name] = true
+			continue
+		}
+
+		for _, sample := range vector {
+			labels := alertLabels(rule, sample.Metric)
+			key := alertKey(name, labels)
+			active[key] = true
+
+			alert, ok := m.alerts[key]
+			if !ok || alert.State == StateResolved {
+				alert = &Alert{Rule: name, State: StatePending, Labels: labels, ActiveAt: now}
+				m.alerts[key] = alert
+			}
+			alert.Value = SampleValue(sample.V)
+			alert.Annotations = rule.expand(labels, sample.V)
+			if alert.State == StatePending && now.Sub(alert.ActiveAt) >= rule.hold {
+				firedAt := now
+				alert.State, alert.FiredAt = StateFiring, &firedAt
+				firing = append(firing, copyAlert(alert))
+			}
+		}
+	}
+
+	for key, alert := range m.alerts {
+		if active[key] || failed[alert.Rule] {
+			continue
+		}
+		switch alert.State {
+		case StatePending:
+			delete(m.alerts, key)
+		case StateFiring:
+			resolvedAt := now
+			alert.State, alert.ResolvedAt = StateResolved, &resolvedAt
+			resolved = append(resolved, copyAlert(alert))
+		case StateResolved:
+			if now.Sub(*alert.ResolvedAt) > resolvedRetention {
+				delete(m.alerts, key)
+			}
+		}
+	}
+
+	m.notify(StateFiring, firing)
+	m.notify(StateResolved, resolved)
+	m.save()
+}
+
+// query evaluates the query of the rule, which must return a vector.
+func (m *Manager) query(rule *compiledRule, now time.Time) (promql.Vector, error) {
+	if rule.eval != nil {
+		return rule.eval()
+	}
+	value, err := m.querier.Instant(rule.query, now)
+	if err != nil {
+		return nil, err
+	}
+	vector, ok := value.(promql.Vector)
+	if !ok {
+		return nil, fmt.Errorf("query returned %s instead of vector", value.Type())
+	}
+	return vector, nil
+}
+
+// Rules returns the rules sorted by name.
+func (m *Manager) Rules() []RuleStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rules := make([]RuleStatus, 0, len(m.rules))
+	for _, name := range m.ruleNames() {
+		rule := m.rules[name]
+		status := RuleStatus{Rule: rule.Rule, Source: rule.source, Query: rule.query}
+		if health, ok := m.health[name]; ok {
+			lastEvaluation := health.lastEvaluation
+			status.LastEvaluation = &lastEvaluation
+			if health.lastError != nil {
+				status.LastError = health.lastError.Error()
+			}
+		}
+		rules = append(rules, status)
+	}
+	return rules
+}
+
+// Alerts returns the pending, firing and recently resolved alerts sorted by rule and labels.
+func (m *Manager) Alerts() []Alert {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys := make([]string, 0, len(m.alerts))
+	for key := range m.alerts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	alerts := make([]Alert, 0, len(keys))
+	for _, key := range keys {
+		alerts = append(alerts, copyAlert(m.alerts[key]))
+	}
+	return alerts
+}
+
+// SetRule creates or replaces the rule. It returns whether the rule was created.
+func (m *Manager) SetRule(rule Rule) (bool, error) {
+	compiled, err := compile(rule, APISource)
+	if err != nil {
+		return false, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	existing, ok := m.rules[rule.Name]
+	if ok && existing.source != APISource {
+		return false, ErrReadOnlyRule
+	}
+	m.rules[rule.Name] = compiled
+	m.save()
+	return !ok, nil
+}
+
+// DeleteRule deletes the rule and its alerts, the firing alerts get resolved.
+func (m *Manager) DeleteRule(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rule, ok := m.rules[name]
+	if !ok {
+		return ErrRuleNotFound
+	}
+	if rule.source != APISource {
+		return ErrReadOnlyRule
+	}
+	delete(m.rules, name)
+	delete(m.health, name)
+
+	now := time.Now()
+	var resolved []Alert
+	for key, alert := range m.alerts {
+		if alert.Rule != name {
+			continue
+		}
+		if alert.State == StateFiring {
+			alert.State, alert.ResolvedAt = StateResolved, &now
+			resolved = append(resolved, copyAlert(alert))
+		}
+		delete(m.alerts, key)
+	}
+	m.notify(StateResolved, resolved)
+	m.save()
+	return nil
+}
+
+func (m *Manager) notify(status State, alerts []Alert) {
+	if len(alerts) == 0 || m.notifier == nil {
+		return
+	}
+	sort.Slice(alerts, func(i, j int) bool {
+		return alertKey(alerts[i].Rule, alerts[i].Labels) < alertKey(alerts[j].Rule, alerts[j].Labels)
+	})
+	m.notifier.Notify(Notification{Status: status, Alerts: alerts})
+}
+
+func (m *Manager) ruleNames() []string {
+	names := make([]string, 0, len(m.rules))
+	for name := range m.rules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// pingRule returns the built-in rule returning a sample while the pings fail.
+func pingRule(pinger Pinger) *compiledRule {
+	rule := Rule{
+		Name:        RepositoryDownRule,
+		For:         Duration(pingRuleHold),
+		Labels:      map[string]string{"severity": "critical"},
+		Annotations: map[string]string{"summary": "The metrics repository doesn't respond to pings"},
+	}
+	return &compiledRule{
+		Rule:        rule,
+		source:      BuiltinSource,
+		query:       "ping",
+		hold:        pingRuleHold,
+		annotations: map[string]*template.Template{"summary": template.Must(template.New("summary").Parse(rule.Annotations["summary"]))},
+		eval: func() (promql.Vector, error) {
+			if err := pinger.Ping(); err != nil {
+				return promql.Vector{{Metric: promql.Labels{}, Point: promql.Point{V: 1}}}, nil
+			}
+			return promql.Vector{}, nil
+		},
+	}
+}
+
+// expand expands the annotation templates of the rule, the failing ones are kept as is.
+func (r *compiledRule) expand(labels map[string]string, value float64) map[string]string {
+	if len(r.annotations) == 0 {
+		return nil
+	}
+	data := struct {
+		Labels map[string]string
+		Value  float64
+	}{Labels: labels, Value: value}
+
+	annotations := make(map[string]string, len(r.annotations))
+	for key, tmpl := range r.annotations {
+		var b strings.Builder
+		if err := tmpl.Execute(&b, data); err != nil {
+			log.Errorf("Could not expand annotation %s of alerting rule %s: %s", key, r.Name, err.Error())
+			annotations[key] = r.Annotations[key]
+			continue
+		}
+		annotations[key] = b.String()
+	}
+	return annotations
+}
+
+// alertLabels returns the labels of the alert of the sample.
+func alertLabels(rule *compiledRule, metric promql.Labels) map[string]string {
+	labels := make(map[string]string, len(metric)+len(rule.Labels)+1)
+	for key, value := range metric {
+		if key != "__name__" {
+			labels[key] = value
+		}
+	}
+	for key, value := range rule.Labels {
+		labels[key] = value
+	}
+	labels["alertname"] = rule.Name
+	return labels
+}
+
+// alertKey identifies the alert by its rule and labels.
+func alertKey(rule string, labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	b.WriteString(rule)
+	for _, key := range keys {
+		b.WriteByte(0xff)
+		b.WriteString(key)
+		b.WriteByte(0xff)
+		b.WriteString(labels[key])
+	}
+	return b.String()
+}
+
+// copyAlert returns a copy of the alert sharing nothing with it.
+func copyAlert(alert *Alert) Alert {
+	copied := *alert
+	copied.Labels = make(map[string]string, len(alert.Labels))
+	for key, value := range alert.Labels {
+		copied.Labels[key] = value
+	}
+	if alert.Annotations != nil {
+		copied.Annotations = make(map[string]string, len(alert.Annotations))
+		for key, value := range alert.Annotations {
+			copied.Annotations[key] = value
+		}
+	}
+	if alert.FiredAt != nil {
+		firedAt := *alert.FiredAt
+		copied.FiredAt = &firedAt
+	}
+	if alert.ResolvedAt != nil {
+		resolvedAt := *alert.ResolvedAt
+		copied.ResolvedAt = &resolvedAt
+	}
+	return copied
+}
@@ -0,0 +1,187 @@
+package alerting
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gonozov0/go-musthave-devops/internal/server/promql"
+)
+
+// fakeQuerier returns the vectors set by query.
+type fakeQuerier struct {
+	mu      sync.Mutex
+	results map[string]promql.Vector
+}
+
+func (q *fakeQuerier) set(query string, values ...float64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	vector := make(promql.Vector, 0, len(values))
+	for i, value := range values {
+		instance := string(rune('a' + i))
+		vector = append(vector, promql.Sample{
+			Metric: promql.Labels{"__name__": "HeapAlloc", "instance": instance},
+			Point:  promql.Point{V: value},
+		})
+	}
+	q.results[query] = vector
+}
+
+func (q *fakeQuerier) Instant(query string, _ time.Time) (promql.Value, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	vector, ok := q.results[query]
+	if !ok {
+		return nil, errors.New("query failed")
+	}
+	return vector, nil
+}
+
+type recordingNotifier struct {
+	notifications []Notification
+}
+
+func (n *recordingNotifier) Notify(notification Notification) {
+	n.notifications = append(n.notifications, notification)
+}
+
+func TestManagerStates(t *testing.T) {
+	querier := &fakeQuerier{results: make(map[string]promql.Vector)}
+	notifier := &recordingNotifier{}
+	statePath := filepath.Join(t.TempDir(), "alerts.json")
+	files := map[string][]Rule{
+		"rules.yml": {{
+			Name:        "HighHeap",
+			Condition:   "gauge HeapAlloc > 1e9 for 2m",
+			Labels:      map[string]string{"severity": "warning"},
+			Annotations: map[string]string{"summary": "Heap of {{ .Labels.instance }} is {{ .Value }}"},
+		}},
+	}
+	manager, err := NewManager(querier, notifier, time.Minute, WithStatePath(statePath), WithRuleFiles(files))
+	require.NoError(t, err)
+	const query = "HeapAlloc > 1e+09"
+	now := time.Unix(1700000000, 0)
+
+	querier.set(query, 2e9)
+	manager.Evaluate(now)
+	alerts := manager.Alerts()
+	require.Len(t, alerts, 1)
+	require.Equal(t, StatePending, alerts[0].State)
+	require.Equal(t, map[string]string{"alertname": "HighHeap", "instance": "a", "severity": "warning"}, alerts[0].Labels)
+	require.Equal(t, map[string]string{"summary": "Heap of a is 2e+09"}, alerts[0].Annotations)
+	require.Empty(t, notifier.notifications)
+
+	manager.Evaluate(now.Add(time.Minute))
+	require.Equal(t, StatePending, manager.Alerts()[0].State)
+
+	// the state survives restarts
+	manager, err = NewManager(querier, notifier, time.Minute, WithStatePath(statePath), WithRuleFiles(files))
+	require.NoError(t, err)
+	manager.Evaluate(now.Add(2 * time.Minute))
+	alerts = manager.Alerts()
+	require.Equal(t, StateFiring, alerts[0].State)
+	require.True(t, now.Equal(alerts[0].ActiveAt))
+	require.Len(t, notifier.notifications, 1)
+	require.Equal(t, StateFiring, notifier.notifications[0].Status)
+
+	// a failing query keeps the alerts
+	delete(querier.results, query)
+	manager.Evaluate(now.Add(3 * time.Minute))
+	require.Equal(t, StateFiring, manager.Alerts()[0].State)
+	require.NotEmpty(t, manager.Rules()[0].LastError)
+
+	querier.set(query)
+	manager.Evaluate(now.Add(4 * time.Minute))
+	alerts = manager.Alerts()
+	require.Equal(t, StateResolved, alerts[0].State)
+	require.Len(t, notifier.notifications, 2)
+	require.Equal(t, StateResolved, notifier.notifications[1].Status)
+
+	// resolved alerts fire again after the for duration and are eventually dropped
+	querier.set(query, 2e9)
+	manager.Evaluate(now.Add(5 * time.Minute))
+	require.Equal(t, StatePending, manager.Alerts()[0].State)
+	querier.set(query)
+	manager.Evaluate(now.Add(6 * time.Minute))
+	require.Empty(t, manager.Alerts())
+	require.Len(t, notifier.notifications, 2)
+}
+
+func TestManagerRulesAPI(t *testing.T) {
+	querier := &fakeQuerier{results: make(map[string]promql.Vector)}
+	notifier := &recordingNotifier{}
+	statePath := filepath.Join(t.TempDir(), "alerts.json")
+	manager, err := NewManager(querier, notifier, time.Minute, WithStatePath(statePath), WithRuleFiles(map[string][]Rule{
+		"rules.yml": {{Name: "FileRule", Expr: "up == 0"}},
+	}))
+	require.NoError(t, err)
+
+	_, err = manager.SetRule(Rule{Name: "FileRule", Expr: "up == 1"})
+	require.ErrorIs(t, err, ErrReadOnlyRule)
+	require.ErrorIs(t, manager.DeleteRule("FileRule"), ErrReadOnlyRule)
+	require.ErrorIs(t, manager.DeleteRule("Unknown"), ErrRuleNotFound)
+
+	created, err := manager.SetRule(Rule{Name: "ManyPolls", Condition: "counter PollCount > 100 over 5m"})
+	require.NoError(t, err)
+	require.True(t, created)
+	querier.set("increase(PollCount[300000ms]) > 100", 150)
+	manager.Evaluate(time.Now())
+	require.Len(t, notifier.notifications, 1)
+
+	// rules created via the API are persisted
+	restored, err := NewManager(querier, notifier, time.Minute, WithStatePath(statePath))
+	require.NoError(t, err)
+	rules := restored.Rules()
+	require.Len(t, rules, 1)
+	require.Equal(t, "ManyPolls", rules[0].Name)
+	require.Equal(t, APISource, rules[0].Source)
+	require.Len(t, restored.Alerts(), 1)
+
+	require.NoError(t, manager.DeleteRule("ManyPolls"))
+	require.Empty(t, manager.Alerts())
+	require.Len(t, notifier.notifications, 2)
+	require.Equal(t, StateResolved, notifier.notifications[1].Status)
+}
+
+type fakePinger struct {
+	err error
+}
+
+func (p *fakePinger) Ping() error {
+	return p.err
+}
+
+func TestManagerPingRule(t *testing.T) {
+	querier := &fakeQuerier{results: make(map[string]promql.Vector)}
+	notifier := &recordingNotifier{}
+	pinger := &fakePinger{}
+	manager, err := NewManager(querier, notifier, time.Minute, WithPingRule(pinger))
+	require.NoError(t, err)
+	now := time.Unix(1700000000, 0)
+
+	manager.Evaluate(now)
+	require.Empty(t, manager.Alerts())
+
+	pinger.err = errors.New("connection refused")
+	manager.Evaluate(now.Add(time.Minute))
+	manager.Evaluate(now.Add(2 * time.Minute))
+	alerts := manager.Alerts()
+	require.Len(t, alerts, 1)
+	require.Equal(t, StateFiring, alerts[0].State)
+	require.Equal(t, map[string]string{"alertname": RepositoryDownRule, "severity": "critical"}, alerts[0].Labels)
+	require.Len(t, notifier.notifications, 1)
+
+	_, err = manager.SetRule(Rule{Name: RepositoryDownRule, Expr: "up == 0"})
+	require.ErrorIs(t, err, ErrReadOnlyRule)
+	require.Equal(t, BuiltinSource, manager.Rules()[0].Source)
+
+	_, err = NewManager(querier, notifier, time.Minute, WithPingRule(pinger), WithRuleFiles(map[string][]Rule{
+		"rules.yml": {{Name: RepositoryDownRule, Expr: "up == 0"}},
+	}))
+	require.Error(t, err)
+}
@@ -0,0 +1,263 @@
+package alerting
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/gonozov0/go-musthave-devops/internal/server/promql"
+	"github.com/gonozov0/go-musthave-devops/internal/shared"
+)
+
+// APISource is the source of the rules created via the API.
+const APISource = "api"
+
+// Duration is a duration written as a PromQL duration like 2m or as a number of seconds.
+type Duration time.Duration
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var seconds float64
+	if err := json.Unmarshal(data, &seconds); err == nil {
+		*d = Duration(seconds * float64(time.Second))
+		return nil
+	}
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("invalid duration %s", data)
+	}
+	return d.parse(value)
+}
+
+func (d *Duration) UnmarshalYAML(node *yaml.Node) error {
+	if seconds, err := strconv.ParseFloat(node.Value, 64); err == nil {
+		*d = Duration(seconds * float64(time.Second))
+		return nil
+	}
+	return d.parse(node.Value)
+}
+
+func (d *Duration) parse(value string) error {
+	parsed, err := promql.ParseDuration(value)
+	if err != nil {
+		parsed, err = time.ParseDuration(value)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid duration %q", value)
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Rule is an alerting rule. Every sample of the vector returned by the query is an alert, which fires
+// when it's returned for the For duration. The query is either the Expr in PromQL or the Condition:
+//
+//	gauge <metric> <op> <threshold> [for <duration>]
+//	counter <metric> <op> <threshold> over <window> [for <duration>]
+//
+// where the counter condition compares the increase of the counter within the window.
+// Annotations are text/template templates of the .Labels and the .Value of the alert.
+type Rule struct {
+	Name        string            `json:"name" yaml:"name"`
+	Expr        string            `json:"expr,omitempty" yaml:"expr"`
+	Condition   string            `json:"condition,omitempty" yaml:"condition"`
+	For         Duration          `json:"for,omitempty" yaml:"for"`
+	Labels      map[string]string `json:"labels,omitempty" yaml:"labels"`
+	Annotations map[string]string `json:"annotations,omitempty" yaml:"annotations"`
+}
+
+// compiledRule is the validated rule with its query.
+type compiledRule struct {
+	Rule
+	source      string
+	query       string
+	hold        time.Duration
+	annotations map[string]*template.Template
+	eval        func() (promql.Vector, error) // evaluates the built-in rules instead of the query
+}
+
+// compile validates the rule and builds its query.
+func compile(rule Rule, source string) (*compiledRule, error) {
+	if rule.Name == "" {
+		return nil, errors.New("rule name is required")
+	}
+	compiled := &compiledRule{Rule: rule, source: source, query: rule.Expr, hold: time.Duration(rule.For)}
+	switch {
+	case rule.Expr != "" && rule.Condition != "":
+		return nil, fmt.Errorf("rule %s: expr and condition are mutually exclusive", rule.Name)
+	case rule.Condition != "":
+		query, hold, err := ParseCondition(rule.Condition)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: %w", rule.Name, err)
+		}
+		if hold != 0 && rule.For != 0 {
+			return nil, fmt.Errorf("rule %s: for is set both in the condition and the rule", rule.Name)
+		}
+		compiled.query = query
+		if hold != 0 {
+			compiled.hold = hold
+		}
+	case rule.Expr == "":
+		return nil, fmt.Errorf("rule %s: expr or condition is required", rule.Name)
+	}
+	if compiled.hold < 0 {
+		return nil, fmt.Errorf("rule %s: for must not be negative", rule.Name)
+	}
+	if _, err := promql.Parse(compiled.query); err != nil {
+		return nil, fmt.Errorf("rule %s: %w", rule.Name, err)
+	}
+
+	compiled.annotations = make(map[string]*template.Template, len(rule.Annotations))
+	for key, text := range rule.Annotations {
+		tmpl, err := template.New(key).Option("missingkey=zero").Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: invalid annotation %s: %w", rule.Name, key, err)
+		}
+		compiled.annotations[key] = tmpl
+	}
+	return compiled, nil
+}
+
+// ParseCondition translates the short rule condition into a PromQL query and returns it
+// with the for duration of the condition, zero when it's missing.
+func ParseCondition(condition string) (string, time.Duration, error) {
+	fields := strings.Fields(condition)
+	if len(fields) < 4 {
+		return "", 0, fmt.Errorf("invalid condition %q: expected <type> <metric> <op> <threshold>", condition)
+	}
+	metricType, metric, op, thresholdValue := fields[0], fields[1], fields[2], fields[3]
+	switch op {
+	case ">", "<", ">=", "<=", "==", "!=":
+	default:
+		return "", 0, fmt.Errorf("invalid condition %q: unknown operator %s", condition, op)
+	}
+	threshold, err := strconv.ParseFloat(thresholdValue, 64)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid condition %q: invalid threshold %s", condition, thresholdValue)
+	}
+
+	var window, hold time.Duration
+	for rest := fields[4:]; len(rest) > 0; rest = rest[2:] {
+		if len(rest) < 2 {
+			return "", 0, fmt.Errorf("invalid condition %q: missing duration after %s", condition, rest[0])
+		}
+		duration, err := promql.ParseDuration(rest[1])
+		if err != nil || duration <= 0 {
+			return "", 0, fmt.Errorf("invalid condition %q: invalid duration %s", condition, rest[1])
+		}
+		switch rest[0] {
+		case "over":
+			window = duration
+		case "for":
+			hold = duration
+		default:
+			return "", 0, fmt.Errorf("invalid condition %q: unexpected %s", condition, rest[0])
+		}
+	}
+
+	selector, err := selectorOf(metric)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid condition %q: %w", condition, err)
+	}
+	var query string
+	switch metricType {
+	case shared.Gauge:
+		if window != 0 {
+			return "", 0, fmt.Errorf("invalid condition %q: over is only supported by counters", condition)
+		}
+		query = selector
+	case shared.Counter:
+		if window == 0 {
+			return "", 0, fmt.Errorf("invalid condition %q: counter conditions require over <window>", condition)
+		}
+		query = fmt.Sprintf("increase(%s[%s])", selector, formatDuration(window))
+	default:
+		return "", 0, fmt.Errorf("invalid condition %q: unknown metric type %s", condition, metricType)
+	}
+	return fmt.Sprintf("%s %s %s", query, op, strconv.FormatFloat(threshold, 'g', -1, 64)), hold, nil
+}
+
+// selectorOf returns the PromQL selector of the metric ID named as in the Prometheus exposition.
+func selectorOf(metric string) (string, error) {
+	name, labels, err := shared.SplitLabels(metric)
+	if err != nil {
+		return "", err
+	}
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	matchers := make([]string, 0, len(keys))
+	for _, key := range keys {
+		matchers = append(matchers, fmt.Sprintf("%s=%s", shared.SanitizePrometheusLabelName(key), strconv.Quote(labels[key])))
+	}
+	selector := shared.SanitizePrometheusName(name)
+	if len(matchers) > 0 {
+		selector += "{" + strings.Join(matchers, ",") + "}"
+	}
+	return selector, nil
+}
+
+// formatDuration formats the duration in milliseconds, which every PromQL duration is a multiple of.
+func formatDuration(d time.Duration) string {
+	return strconv.FormatInt(d.Milliseconds(), 10) + "ms"
+}
+
+// LoadRuleFiles reads the rules of the JSON or YAML files matching the patterns by file.
+// Every file contains a list of rules.
+func LoadRuleFiles(patterns []string) (map[string][]Rule, error) {
+	rules := make(map[string][]Rule)
+	for _, pattern := range patterns {
+		files, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rule files pattern %q: %w", pattern, err)
+		}
+		for _, file := range files {
+			fileRules, err := readRuleFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read rule file %s: %w", file, err)
+			}
+			rules[file] = fileRules
+		}
+	}
+	return rules, nil
+}
+
+func readRuleFile(path string) ([]Rule, error) {
+	var rules []Rule
+	err := readFile(path, &rules)
+	return rules, err
+}
+
+// readFile decodes the JSON or YAML file into v.
+func readFile(path string, v interface{}) error {
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return json.Unmarshal(bytes, v)
+	case ".yml", ".yaml":
+		return yaml.Unmarshal(bytes, v)
+	default:
+		return fmt.Errorf("unsupported file extension %q", filepath.Ext(path))
+	}
+}
@@ -0,0 +1,81 @@
+package alerting
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCondition(t *testing.T) {
+	tests := []struct {
+		condition string
+		query     string
+		hold      time.Duration
+	}{
+		{condition: "gauge HeapAlloc > 1e9 for 2m", query: "HeapAlloc > 1e+09", hold: 2 * time.Minute},
+		{condition: "counter PollCount >= 100 over 5m", query: "increase(PollCount[300000ms]) >= 100"},
+		{
+			condition: `gauge cpu.usage{core="0"} < 0.5 for 30s`,
+			query:     `cpu_usage{core="0"} < 0.5`,
+			hold:      30 * time.Second,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.condition, func(t *testing.T) {
+			query, hold, err := ParseCondition(tt.condition)
+			require.NoError(t, err)
+			require.Equal(t, tt.query, query)
+			require.Equal(t, tt.hold, hold)
+		})
+	}
+
+	for _, condition := range []string{
+		"gauge HeapAlloc >",
+		"gauge HeapAlloc => 1",
+		"gauge HeapAlloc > high",
+		"gauge HeapAlloc > 1 over 5m",
+		"counter PollCount > 1",
+		"counter PollCount > 1 over",
+		"histogram Latency > 1 for 1m",
+		"gauge HeapAlloc > 1 until 1m",
+	} {
+		_, _, err := ParseCondition(condition)
+		require.Error(t, err, condition)
+	}
+}
+
+func TestLoadRuleFiles(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "heap.yml"), []byte(`
+- name: HighHeap
+  condition: gauge HeapAlloc > 1e9 for 2m
+  labels:
+    severity: warning
+- name: ManyPolls
+  expr: increase(PollCount[5m]) > 100
+  for: 60
+`), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "cpu.json"), []byte(`[{"name": "HighCPU", "expr": "cpu > 90", "for": "1m30s"}]`), 0644))
+
+	rules, err := LoadRuleFiles([]string{filepath.Join(dir, "*")})
+	require.NoError(t, err)
+	require.Equal(t, map[string][]Rule{
+		filepath.Join(dir, "heap.yml"): {
+			{Name: "HighHeap", Condition: "gauge HeapAlloc > 1e9 for 2m", Labels: map[string]string{"severity": "warning"}},
+			{Name: "ManyPolls", Expr: "increase(PollCount[5m]) > 100", For: Duration(time.Minute)},
+		},
+		filepath.Join(dir, "cpu.json"): {
+			{Name: "HighCPU", Expr: "cpu > 90", For: Duration(90 * time.Second)},
+		},
+	}, rules)
+
+	_, err = compile(Rule{Name: "Both", Expr: "cpu > 1", Condition: "gauge cpu > 1"}, APISource)
+	require.Error(t, err)
+	_, err = compile(Rule{Name: "Invalid", Expr: "cpu >"}, APISource)
+	require.Error(t, err)
+	_, err = compile(Rule{Name: "Twice", Condition: "gauge cpu > 1 for 1m", For: Duration(time.Minute)}, APISource)
+	require.Error(t, err)
+}
@@ -0,0 +1,194 @@
+package alerting
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/gonozov0/go-musthave-devops/internal/server/promql"
+	"github.com/gonozov0/go-musthave-devops/internal/server/repository"
+)
+
+// ErrInvalidSilence is returned when a silence fails the validation.
+var ErrInvalidSilence = errors.New("invalid silence")
+
+// Silences manages the alert silences kept in the repository.
+type Silences struct {
+	repo repository.SilenceRepository
+}
+
+// NewSilences creates a new Silences keeping the silences in the repository.
+func NewSilences(repo repository.SilenceRepository) *Silences {
+	return &Silences{repo: repo}
+}
+
+// Create validates the silence and saves it. A silence without the ID gets a new one and starts now
+// unless StartsAt is set, a silence with the ID replaces the existing silence.
+func (s *Silences) Create(silence repository.Silence, now time.Time) (repository.Silence, error) {
+	if silence.StartsAt.IsZero() {
+		silence.StartsAt = now
+	}
+	if _, err := compileSilence(silence); err != nil {
+		return repository.Silence{}, err
+	}
+	if !silence.EndsAt.After(silence.StartsAt) {
+		return repository.Silence{}, fmt.Errorf("%w: endsAt must be after startsAt", ErrInvalidSilence)
+	}
+	if !silence.EndsAt.After(now) {
+		return repository.Silence{}, fmt.Errorf("%w: endsAt must be in the future", ErrInvalidSilence)
+	}
+
+	if silence.ID == "" {
+		id, err := newSilenceID()
+		if err != nil {
+			return repository.Silence{}, err
+		}
+		silence.ID = id
+	} else if err := s.exists(silence.ID); err != nil {
+		return repository.Silence{}, err
+	}
+	if err := s.repo.SaveSilence(silence); err != nil {
+		return repository.Silence{}, err
+	}
+	return silence, nil
+}
+
+// List returns the silences that didn't end ordered by ID.
+func (s *Silences) List(now time.Time) ([]repository.Silence, error) {
+	silences, err := s.repo.GetSilences()
+	if err != nil {
+		return nil, err
+	}
+	active := make([]repository.Silence, 0, len(silences))
+	for _, silence := range silences {
+		if silence.EndsAt.After(now) {
+			active = append(active, silence)
+		}
+	}
+	return active, nil
+}
+
+// Delete deletes the silence, the alerts it muted get notified on the next flush.
+func (s *Silences) Delete(id string) error {
+	return s.repo.DeleteSilence(id)
+}
+
+// active returns the compiled silences muting alerts at the time and deletes the ended ones.
+// The invalid silences stored by older versions are skipped.
+func (s *Silences) active(now time.Time) []compiledSilence {
+	silences, err := s.repo.GetSilences()
+	if err != nil {
+		log.Errorf("Could not get alert silences: %s", err.Error())
+		return nil
+	}
+	active := make([]compiledSilence, 0, len(silences))
+	for _, silence := range silences {
+		if !silence.EndsAt.After(now) {
+			if err := s.repo.DeleteSilence(silence.ID); err != nil && !errors.Is(err, repository.ErrSilenceNotFound) {
+				log.Errorf("Could not delete ended silence %s: %s", silence.ID, err.Error())
+			}
+			continue
+		}
+		if silence.StartsAt.After(now) {
+			continue
+		}
+		compiled, err := compileSilence(silence)
+		if err != nil {
+			log.Warnf("Skipped silence %s: %s", silence.ID, err.Error())
+			continue
+		}
+		active = append(active, compiled)
+	}
+	return active
+}
+
+func (s *Silences) exists(id string) error {
+	silences, err := s.repo.GetSilences()
+	if err != nil {
+		return err
+	}
+	for _, silence := range silences {
+		if silence.ID == id {
+			return nil
+		}
+	}
+	return repository.ErrSilenceNotFound
+}
+
+// compiledSilence is the silence with its matchers.
+type compiledSilence struct {
+	repository.Silence
+	matchers []*promql.Matcher
+}
+
+func compileSilence(silence repository.Silence) (compiledSilence, error) {
+	if len(silence.Matchers) == 0 {
+		return compiledSilence{}, fmt.Errorf("%w: at least one matcher is required", ErrInvalidSilence)
+	}
+	matchers := make([]*promql.Matcher, 0, len(silence.Matchers))
+	for _, m := range silence.Matchers {
+		if !labelNameRegexp.MatchString(m.Name) {
+			return compiledSilence{}, fmt.Errorf("%w: invalid label name %q", ErrInvalidSilence, m.Name)
+		}
+		isEqual := m.IsEqual == nil || *m.IsEqual
+		var matchType promql.MatchType
+		switch {
+		case m.IsRegex && isEqual:
+			matchType = promql.MatchRegexp
+		case m.IsRegex:
+			matchType = promql.MatchNotRegexp
+		case isEqual:
+			matchType = promql.MatchEqual
+		default:
+			matchType = promql.MatchNotEqual
+		}
+		matcher, err := promql.NewMatcher(matchType, m.Name, m.Value)
+		if err != nil {
+			return compiledSilence{}, fmt.Errorf("%w: %s", ErrInvalidSilence, err.Error())
+		}
+		matchers = append(matchers, matcher)
+	}
+	return compiledSilence{Silence: silence, matchers: matchers}, nil
+}
+
+func newSilenceID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("failed to generate silence ID: %w", err)
+	}
+	return hex.EncodeToString(id), nil
+}
+
+var (
+	labelNameRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	matcherRegexp   = regexp.MustCompile(`^\s*([a-zA-Z_][a-zA-Z0-9_]*)\s*(=~|!~|!=|=)\s*("(?:[^"\\]|\\.)*")\s*$`)
+)
+
+// ParseMatcher parses a label matcher written as in PromQL selectors, for example severity=~"warning|info".
+func ParseMatcher(text string) (*promql.Matcher, error) {
+	parts := matcherRegexp.FindStringSubmatch(text)
+	if parts == nil {
+		return nil, fmt.Errorf("invalid matcher %q", text)
+	}
+	value, err := strconv.Unquote(parts[3])
+	if err != nil {
+		return nil, fmt.Errorf("invalid matcher %q: %w", text, err)
+	}
+	return promql.NewMatcher(promql.MatchType(parts[2]), parts[1], value)
+}
+
+// matchesAll reports whether the labels match all matchers.
+func matchesAll(matchers []*promql.Matcher, labels map[string]string) bool {
+	for _, m := range matchers {
+		if !m.Matches(labels[m.Name]) {
+			return false
+		}
+	}
+	return true
+}
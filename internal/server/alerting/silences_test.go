@@ -0,0 +1,76 @@
+package alerting
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gonozov0/go-musthave-devops/internal/server/promql"
+	"github.com/gonozov0/go-musthave-devops/internal/server/repository"
+	inMemory "github.com/gonozov0/go-musthave-devops/internal/server/repository/in_memory"
+)
+
+func TestParseMatcher(t *testing.T) {
+	matcher, err := ParseMatcher(` severity =~ "warning|info" `)
+	require.NoError(t, err)
+	require.Equal(t, promql.MatchRegexp, matcher.Type)
+	require.Equal(t, "severity", matcher.Name)
+	require.True(t, matcher.Matches("info"))
+	require.False(t, matcher.Matches("critical"))
+
+	matcher, err = ParseMatcher(`alertname!="Repository\"Down"`)
+	require.NoError(t, err)
+	require.Equal(t, `Repository"Down`, matcher.Value)
+
+	for _, text := range []string{`alertname`, `alertname="x`, `1name="x"`, `name=~"("`} {
+		_, err := ParseMatcher(text)
+		require.Error(t, err, text)
+	}
+}
+
+func TestSilences(t *testing.T) {
+	silences := NewSilences(inMemory.NewInMemoryRepository().(repository.SilenceRepository))
+	now := time.Unix(1700000000, 0)
+	notEqual := false
+
+	for _, invalid := range []repository.Silence{
+		{EndsAt: now.Add(time.Hour)},
+		{Matchers: []repository.SilenceMatcher{{Name: "alertname", Value: "x"}}},
+		{Matchers: []repository.SilenceMatcher{{Name: "alertname", Value: "x"}}, StartsAt: now.Add(-2 * time.Hour), EndsAt: now.Add(-time.Hour)},
+		{Matchers: []repository.SilenceMatcher{{Name: "alertname", Value: "(", IsRegex: true}}, EndsAt: now.Add(time.Hour)},
+		{Matchers: []repository.SilenceMatcher{{Name: "alert-name", Value: "x"}}, EndsAt: now.Add(time.Hour)},
+	} {
+		_, err := silences.Create(invalid, now)
+		require.ErrorIs(t, err, ErrInvalidSilence)
+	}
+	_, err := silences.Create(repository.Silence{
+		ID:       "unknown",
+		Matchers: []repository.SilenceMatcher{{Name: "alertname", Value: "x"}},
+		EndsAt:   now.Add(time.Hour),
+	}, now)
+	require.ErrorIs(t, err, repository.ErrSilenceNotFound)
+
+	created, err := silences.Create(repository.Silence{
+		Matchers: []repository.SilenceMatcher{
+			{Name: "alertname", Value: "High.*", IsRegex: true},
+			{Name: "severity", Value: "critical", IsEqual: &notEqual},
+		},
+		EndsAt: now.Add(time.Hour),
+	}, now)
+	require.NoError(t, err)
+	require.Len(t, created.ID, 32)
+	require.True(t, now.Equal(created.StartsAt))
+
+	active := silences.active(now)
+	require.Len(t, active, 1)
+	require.True(t, matchesAll(active[0].matchers, map[string]string{"alertname": "HighHeap", "severity": "warning"}))
+	require.False(t, matchesAll(active[0].matchers, map[string]string{"alertname": "HighHeap", "severity": "critical"}))
+
+	// ended silences are deleted
+	require.Empty(t, silences.active(now.Add(time.Hour)))
+	listed, err := silences.List(now)
+	require.NoError(t, err)
+	require.Empty(t, listed)
+	require.ErrorIs(t, silences.Delete(created.ID), repository.ErrSilenceNotFound)
+}
@@ -0,0 +1,90 @@
+package alerting
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// state is the content of the state file.
+type state struct {
+	Rules  []Rule  `json:"rules"` // created via the API
+	Alerts []Alert `json:"alerts"`
+}
+
+// restore loads the rules created via the API and the alerts of the known rules from the state file.
+// A rule conflicting with a rule file is dropped, the rule files win.
+func (m *Manager) restore() error {
+	if m.statePath == "" {
+		return nil
+	}
+	data, err := os.ReadFile(m.statePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read alerting state: %w", err)
+	}
+	var saved state
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return fmt.Errorf("failed to parse alerting state %s: %w", m.statePath, err)
+	}
+
+	for _, rule := range saved.Rules {
+		if existing, ok := m.rules[rule.Name]; ok {
+			log.Warnf("Dropped alerting rule %s created via the API, it's defined in %s", rule.Name, existing.source)
+			continue
+		}
+		compiled, err := compile(rule, APISource)
+		if err != nil {
+			log.Warnf("Dropped invalid alerting rule: %s", err.Error())
+			continue
+		}
+		m.rules[rule.Name] = compiled
+	}
+	for i := range saved.Alerts {
+		alert := saved.Alerts[i]
+		if _, ok := m.rules[alert.Rule]; !ok {
+			continue
+		}
+		m.alerts[alertKey(alert.Rule, alert.Labels)] = &alert
+	}
+	return nil
+}
+
+// save writes the state file replacing it atomically. It must be called with mu held.
+func (m *Manager) save() {
+	if m.statePath == "" {
+		return
+	}
+	saved := state{Rules: make([]Rule, 0), Alerts: make([]Alert, 0, len(m.alerts))}
+	for _, name := range m.ruleNames() {
+		if rule := m.rules[name]; rule.source == APISource {
+			saved.Rules = append(saved.Rules, rule.Rule)
+		}
+	}
+	for _, alert := range m.alerts {
+		saved.Alerts = append(saved.Alerts, *alert)
+	}
+	sort.Slice(saved.Alerts, func(i, j int) bool {
+		return alertKey(saved.Alerts[i].Rule, saved.Alerts[i].Labels) < alertKey(saved.Alerts[j].Rule, saved.Alerts[j].Labels)
+	})
+
+	data, err := json.Marshal(saved)
+	if err != nil {
+		log.Errorf("Could not encode alerting state: %s", err.Error())
+		return
+	}
+	tmpPath := m.statePath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		log.Errorf("Could not save alerting state: %s", err.Error())
+		return
+	}
+	if err := os.Rename(tmpPath, m.statePath); err != nil {
+		log.Errorf("Could not save alerting state: %s", err.Error())
+	}
+}
@@ -0,0 +1,184 @@
+package alerting
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"text/template"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultWebhookTimeout = 10 * time.Second
+	defaultWebhookBackoff = time.Second
+	// webhookQueueSize is the number of the notifications waiting for the delivery.
+	webhookQueueSize = 256
+)
+
+// templateFuncs are the functions of the payload templates in addition to the text/template ones.
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+// ParsePayloadTemplate parses the text/template file rendering the webhook payload of a Notification.
+// The json function encodes its argument as JSON.
+func ParsePayloadTemplate(path string) (*template.Template, error) {
+	return template.New(filepath.Base(path)).Funcs(templateFuncs).ParseFiles(path)
+}
+
+// WebhookNotifier posts the notifications to the webhooks in the background. The payload is
+// the Notification encoded as JSON unless a template is set. Failed requests are retried
+// with an exponential backoff, except for the ones rejected with a client error.
+type WebhookNotifier struct {
+	urls    []string
+	client  *http.Client
+	payload *template.Template
+	retries int
+	backoff time.Duration
+	queue   chan Notification
+}
+
+// WebhookOption configures the WebhookNotifier.
+type WebhookOption func(*WebhookNotifier)
+
+// WithPayloadTemplate renders the payloads with the template, see ParsePayloadTemplate.
+func WithPayloadTemplate(payload *template.Template) WebhookOption {
+	return func(n *WebhookNotifier) {
+		n.payload = payload
+	}
+}
+
+// WithRetries retries the failed requests up to retries times waiting the backoff doubled after every attempt.
+func WithRetries(retries int, backoff time.Duration) WebhookOption {
+	return func(n *WebhookNotifier) {
+		n.retries = retries
+		n.backoff = backoff
+	}
+}
+
+// WithHTTPClient sets the client of the requests.
+func WithHTTPClient(client *http.Client) WebhookOption {
+	return func(n *WebhookNotifier) {
+		n.client = client
+	}
+}
+
+// NewWebhookNotifier creates a new WebhookNotifier of the webhook URLs.
+func NewWebhookNotifier(urls []string, opts ...WebhookOption) *WebhookNotifier {
+	n := &WebhookNotifier{
+		urls:    urls,
+		client:  &http.Client{Timeout: defaultWebhookTimeout},
+		backoff: defaultWebhookBackoff,
+		queue:   make(chan Notification, webhookQueueSize),
+	}
+	for _, opt := range opts {
+		opt(n)
+	}
+	return n
+}
+
+// Notify queues the notification, it's dropped when the queue is full.
+func (n *WebhookNotifier) Notify(notification Notification) {
+	select {
+	case n.queue <- notification:
+	default:
+		log.Errorf("Dropped %s alerts notification, the webhook queue is full", notification.Status)
+	}
+}
+
+// Run sends the queued notifications until the context is canceled.
+func (n *WebhookNotifier) Run(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case notification := <-n.queue:
+			if err := n.Send(ctx, notification); err != nil {
+				log.Errorf("Could not send alerts notification: %s", err.Error())
+			}
+		}
+	}
+}
+
+// Send posts the notification to every webhook.
+func (n *WebhookNotifier) Send(ctx context.Context, notification Notification) error {
+	payload, err := n.render(notification)
+	if err != nil {
+		return fmt.Errorf("failed to render payload: %w", err)
+	}
+	var errs []error
+	for _, url := range n.urls {
+		if err := n.post(ctx, url, payload); err != nil {
+			errs = append(errs, fmt.Errorf("webhook %s: %w", url, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (n *WebhookNotifier) render(notification Notification) ([]byte, error) {
+	if n.payload == nil {
+		return json.Marshal(notification)
+	}
+	var b bytes.Buffer
+	if err := n.payload.Execute(&b, notification); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// post sends the payload retrying the failed attempts.
+func (n *WebhookNotifier) post(ctx context.Context, url string, payload []byte) error {
+	backoff := n.backoff
+	for attempt := 0; ; attempt++ {
+		retry, err := n.attempt(ctx, url, payload)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= n.retries {
+			return err
+		}
+		log.Warnf("Webhook %s failed, retrying in %s: %s", url, backoff, err.Error())
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// attempt sends the payload once and returns whether a failure is worth retrying.
+func (n *WebhookNotifier) attempt(ctx context.Context, url string, payload []byte) (bool, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return false, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	response, err := n.client.Do(request)
+	if err != nil {
+		return true, err
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, response.Body) //nolint:errcheck
+
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return false, nil
+	}
+	err = fmt.Errorf("unexpected status %s", response.Status)
+	clientError := response.StatusCode >= 400 && response.StatusCode < 500 &&
+		response.StatusCode != http.StatusRequestTimeout && response.StatusCode != http.StatusTooManyRequests
+	return !clientError, err
+}
@@ -0,0 +1,95 @@
+package alerting
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhookNotifier(t *testing.T) {
+	var mu sync.Mutex
+	var attempts int
+	var received Notification
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+		if attempts < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		require.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+	}))
+	defer receiver.Close()
+
+	notifier := NewWebhookNotifier([]string{receiver.URL}, WithRetries(3, time.Millisecond))
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go notifier.Run(ctx, wg)
+
+	notification := Notification{Status: StateFiring, Alerts: []Alert{{
+		Rule:     "HighHeap",
+		State:    StateFiring,
+		Labels:   map[string]string{"alertname": "HighHeap"},
+		Value:    2e9,
+		ActiveAt: time.Unix(1700000000, 0).UTC(),
+	}}}
+	notifier.Notify(notification)
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return attempts == 3
+	}, time.Second, time.Millisecond)
+	cancel()
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, notification, received)
+}
+
+func TestWebhookNotifierClientError(t *testing.T) {
+	var attempts int
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer receiver.Close()
+
+	notifier := NewWebhookNotifier([]string{receiver.URL}, WithRetries(3, time.Millisecond))
+	err := notifier.Send(context.Background(), Notification{Status: StateResolved})
+	require.ErrorContains(t, err, "400")
+	require.Equal(t, 1, attempts)
+}
+
+func TestWebhookPayloadTemplate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "slack.tmpl")
+	require.NoError(t, os.WriteFile(path, []byte(
+		`{"text": {{ printf "%s: %d alerts, first %s" .Status (len .Alerts) (index .Alerts 0).Rule | json }}}`,
+	), 0644))
+	payload, err := ParsePayloadTemplate(path)
+	require.NoError(t, err)
+
+	var body string
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		body = string(data)
+	}))
+	defer receiver.Close()
+
+	notifier := NewWebhookNotifier([]string{receiver.URL}, WithPayloadTemplate(payload))
+	err = notifier.Send(context.Background(), Notification{Status: StateFiring, Alerts: []Alert{{Rule: "HighHeap"}}})
+	require.NoError(t, err)
+	require.JSONEq(t, `{"text": "firing: 1 alerts, first HighHeap"}`, body)
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/gonozov0/go-musthave-devops/internal/server/alerting"
+)
+
+// GetRules returns the alerting rules with the results of their last evaluation.
+func (h *Handler) GetRules(w http.ResponseWriter, r *http.Request) {
+	if h.alerts == nil {
+		http.Error(w, "Alerting is not enabled", http.StatusNotImplemented)
+		return
+	}
+	writeJSON(w, http.StatusOK, h.alerts.Rules())
+}
+
+// SetRule creates or replaces the alerting rule of the body. Rules of the rule files can't be replaced.
+func (h *Handler) SetRule(w http.ResponseWriter, r *http.Request) {
+	if h.alerts == nil {
+		http.Error(w, "Alerting is not enabled", http.StatusNotImplemented)
+		return
+	}
+	var rule alerting.Rule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	created, err := h.alerts.SetRule(rule)
+	switch {
+	case errors.Is(err, alerting.ErrReadOnlyRule):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	writeJSON(w, status, rule)
+}
+
+// DeleteRule deletes the alerting rule, its firing alerts get resolved.
+func (h *Handler) DeleteRule(w http.ResponseWriter, r *http.Request) {
+	if h.alerts == nil {
+		http.Error(w, "Alerting is not enabled", http.StatusNotImplemented)
+		return
+	}
+	err := h.alerts.DeleteRule(chi.URLParam(r, "name"))
+	switch {
+	case errors.Is(err, alerting.ErrRuleNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, alerting.ErrReadOnlyRule):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// GetAlerts returns the pending, firing and recently resolved alerts.
+func (h *Handler) GetAlerts(w http.ResponseWriter, r *http.Request) {
+	if h.alerts == nil {
+		http.Error(w, "Alerting is not enabled", http.StatusNotImplemented)
+		return
+	}
+	writeJSON(w, http.StatusOK, h.alerts.Alerts())
+}
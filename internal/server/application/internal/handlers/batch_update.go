@@ -6,42 +6,45 @@ import (
 
 	log "github.com/sirupsen/logrus"
 
+	"github.com/gonozov0/go-musthave-devops/internal/ingest"
 	"github.com/gonozov0/go-musthave-devops/internal/server/repository"
 	"github.com/gonozov0/go-musthave-devops/internal/shared"
 )
 
 func (h *Handler) BatchUpdateMetrics(w http.ResponseWriter, r *http.Request) {
-	var metrics []shared.Metric
-	err := json.NewDecoder(r.Body).Decode(&metrics)
+	metrics, err := ingest.DecodeBatch(r.Body)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
-	if len(metrics) == 0 {
-		http.Error(w, "empty metrics", http.StatusBadRequest)
+		ingest.Error(w, err)
 		return
 	}
 
 	updateGauges := make([]repository.GaugeMetric, 0, len(metrics))
 	updateCounters := make([]repository.CounterMetric, 0, len(metrics))
+	updateHistograms := make([]repository.HistogramMetric, 0)
+	updateSets := make([]repository.SetMetric, 0)
+	timestamped := make(map[string]bool)
 	for _, metric := range metrics {
+		// a sample outside the timestamp window doesn't reject the rest of the batch
+		if err := h.checkTimestamp(metric); err != nil {
+			log.Warnf("skipped metric %s: %v", metric.ID, err)
+			continue
+		}
 		switch metric.MType {
 		case shared.Gauge:
-			if metric.Value == nil {
-				http.Error(w, "value is required for gauge metric", http.StatusBadRequest)
-				return
+			if metric.Timestamp != nil {
+				timestamped[metric.ID] = true
 			}
-			updateGauges = append(updateGauges, repository.GaugeMetric{Name: metric.ID, Value: *metric.Value})
+			updateGauges = append(updateGauges, repository.GaugeMetric{
+				Name:      metric.ID,
+				Value:     *metric.Value,
+				Timestamp: metricTime(metric),
+			})
 		case shared.Counter:
-			if metric.Delta == nil {
-				http.Error(w, "delta is required for counter metric", http.StatusBadRequest)
-				return
-			}
 			updateCounters = append(updateCounters, repository.CounterMetric{Name: metric.ID, Value: *metric.Delta})
-		default:
-			// Must be 400, return 501 because of autotests.
-			http.Error(w, "Unknown metric type", http.StatusNotImplemented)
-			return
+		case shared.Histogram:
+			updateHistograms = append(updateHistograms, repository.HistogramMetric{Name: metric.ID, Value: *metric.Histogram})
+		case shared.Set:
+			updateSets = append(updateSets, repository.SetMetric{Name: metric.ID, Members: metric.Members})
 		}
 	}
 
@@ -58,13 +61,45 @@ func (h *Handler) BatchUpdateMetrics(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	newHistograms, err := h.repo.UpdateHistograms(updateHistograms)
+	if err != nil {
+		log.Errorf("failed to update histograms: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	newSets, err := h.repo.UpdateSets(updateSets)
+	if err != nil {
+		log.Errorf("failed to update sets: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	var newMetrics []shared.Metric
 	for _, gauge := range newGauges {
-		newMetrics = append(newMetrics, shared.Metric{ID: gauge.Name, MType: shared.Gauge, Value: &gauge.Value})
+		gauge := gauge
+		metric := shared.Metric{ID: gauge.Name, MType: shared.Gauge, Value: &gauge.Value}
+		if timestamped[gauge.Name] {
+			metric.Timestamp = unixMilli(gauge.Timestamp)
+		}
+		newMetrics = append(newMetrics, metric)
 	}
 	for _, counter := range newCounters {
+		counter := counter
 		newMetrics = append(newMetrics, shared.Metric{ID: counter.Name, MType: shared.Counter, Delta: &counter.Value})
 	}
+	for _, histogram := range newHistograms {
+		histogram := histogram
+		newMetrics = append(newMetrics, shared.Metric{
+			ID:        histogram.Name,
+			MType:     shared.Histogram,
+			Histogram: &histogram.Value,
+		})
+	}
+	for _, set := range newSets {
+		set := set
+		newMetrics = append(newMetrics, shared.Metric{ID: set.Name, MType: shared.Set, Cardinality: &set.Cardinality})
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
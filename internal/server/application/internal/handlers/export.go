@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/gonozov0/go-musthave-devops/internal/shared"
+)
+
+// ExportMetrics writes all metrics for scraping by Prometheus in the text exposition or OpenMetrics format
+// negotiated by the Accept header.
+func (h *Handler) ExportMetrics(w http.ResponseWriter, r *http.Request) {
+	gauges, err := h.repo.GetAllGauges()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	counters, err := h.repo.GetAllCounters()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	histograms, err := h.repo.GetAllHistograms()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	sets, err := h.repo.GetAllSets()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	metrics := make([]shared.Metric, 0, len(gauges)+len(counters)+len(histograms)+len(sets))
+	for i := range gauges {
+		metrics = append(metrics, shared.Metric{ID: gauges[i].Name, MType: shared.Gauge, Value: &gauges[i].Value})
+	}
+	for i := range counters {
+		metrics = append(metrics, shared.Metric{ID: counters[i].Name, MType: shared.Counter, Delta: &counters[i].Value})
+	}
+	for i := range histograms {
+		metrics = append(metrics, shared.Metric{
+			ID:        histograms[i].Name,
+			MType:     shared.Histogram,
+			Histogram: &histograms[i].Value,
+		})
+	}
+	for i := range sets {
+		metrics = append(metrics, shared.Metric{ID: sets[i].Name, MType: shared.Set, Cardinality: &sets[i].Cardinality})
+	}
+
+	contentType := shared.NegotiateExposition(r.Header.Get("Accept"))
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	if err := shared.WriteExposition(w, contentType, metrics); err != nil {
+		log.Warnf("Could not export metrics: %s", err.Error())
+	}
+}
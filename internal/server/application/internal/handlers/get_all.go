@@ -16,6 +16,16 @@ func (h *Handler) GetAllMetrics(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	histogramMetrics, err := h.repo.GetAllHistograms()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	setMetrics, err := h.repo.GetAllSets()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
 	w.Header().Set("Content-Type", "text/html")
 	w.WriteHeader(http.StatusOK)
@@ -31,5 +41,17 @@ func (h *Handler) GetAllMetrics(w http.ResponseWriter, r *http.Request) {
 	for _, metric := range counterMetrics {
 		fmt.Fprintf(w, "<li>%s: %v</li>", metric.Name, metric.Value)
 	}
+	fmt.Fprint(w, "</ul>")
+
+	fmt.Fprint(w, "<h2>Histograms</h2><ul>")
+	for _, metric := range histogramMetrics {
+		fmt.Fprintf(w, "<li>%s: count %v, sum %v</li>", metric.Name, metric.Value.Count, metric.Value.Sum)
+	}
+	fmt.Fprint(w, "</ul>")
+
+	fmt.Fprint(w, "<h2>Sets</h2><ul>")
+	for _, metric := range setMetrics {
+		fmt.Fprintf(w, "<li>%s: %v</li>", metric.Name, metric.Cardinality)
+	}
 	fmt.Fprint(w, "</ul></body></html>")
 }
@@ -10,6 +10,9 @@ import (
 	"github.com/gonozov0/go-musthave-devops/internal/shared"
 )
 
+// GetMetricByBody is the HTTP handler for getting metrics by the JSON body.
+// Histograms are returned with the estimates of the quantile query parameters,
+// which may be repeated, or of defaultQuantiles.
 func (h *Handler) GetMetricByBody(w http.ResponseWriter, r *http.Request) {
 	var metric shared.Metric
 
@@ -32,6 +35,23 @@ func (h *Handler) GetMetricByBody(w http.ResponseWriter, r *http.Request) {
 	case shared.Counter:
 		counterValue, err = h.repo.GetCounter(metric.ID)
 		metric.Delta = &counterValue
+	case shared.Histogram:
+		quantiles, qErr := parseQuantiles(r)
+		if qErr != nil {
+			http.Error(w, qErr.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(quantiles) == 0 {
+			quantiles = defaultQuantiles
+		}
+		var histogram shared.HistogramValue
+		histogram, err = h.repo.GetHistogram(metric.ID)
+		metric.Histogram = &histogram
+		metric.Quantiles = histogramQuantiles(histogram, quantiles)
+	case shared.Set:
+		var cardinality uint64
+		cardinality, err = h.repo.GetSet(metric.ID)
+		metric.Cardinality = &cardinality
 	default:
 		// Must be 400, return 501 because of autotests.
 		http.Error(w, "Unknown metric type", http.StatusNotImplemented)
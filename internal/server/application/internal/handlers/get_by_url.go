@@ -32,7 +32,18 @@ func (h *Handler) getCounterValue(metricName string) (string, error) {
 	return valueStr, nil
 }
 
+// getHistogramQuantile returns the string estimate of the histogram quantile.
+func (h *Handler) getHistogramQuantile(metricName string, q float64) (string, error) {
+	histogram, err := h.repo.GetHistogram(metricName)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatFloat(histogram.Quantile(q), 'f', -1, 64), nil
+}
+
 // GetMetricByURL is the HTTP handler for getting metrics.
+// The value of a histogram is the estimate of the quantile query parameter, the median by default,
+// and the value of a set is the estimated number of distinct members.
 func (h *Handler) GetMetricByURL(w http.ResponseWriter, r *http.Request) {
 	metricType := chi.URLParam(r, "metricType")
 	metricName := chi.URLParam(r, "metricName")
@@ -45,6 +56,21 @@ func (h *Handler) GetMetricByURL(w http.ResponseWriter, r *http.Request) {
 		value, err = h.getGaugeValue(metricName)
 	case shared.Counter:
 		value, err = h.getCounterValue(metricName)
+	case shared.Histogram:
+		quantiles, qErr := parseQuantiles(r)
+		if qErr != nil || len(quantiles) > 1 {
+			http.Error(w, "Invalid quantile, must be a single value between 0 and 1", http.StatusBadRequest)
+			return
+		}
+		q := 0.5
+		if len(quantiles) == 1 {
+			q = quantiles[0]
+		}
+		value, err = h.getHistogramQuantile(metricName, q)
+	case shared.Set:
+		var cardinality uint64
+		cardinality, err = h.repo.GetSet(metricName)
+		value = strconv.FormatUint(cardinality, 10)
 	default:
 		// Must be 400, return 501 because of autotests.
 		http.Error(w, "Unknown metric type", http.StatusNotImplemented)
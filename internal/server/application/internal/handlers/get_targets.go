@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/gonozov0/go-musthave-devops/internal/server/scrape"
+)
+
+// GetTargets returns the discovered scrape targets with their last scrape status.
+func (h *Handler) GetTargets(w http.ResponseWriter, r *http.Request) {
+	targets := make([]scrape.TargetStatus, 0)
+	if h.targets != nil {
+		targets = h.targets.Targets()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err := json.NewEncoder(w).Encode(targets)
+	if err != nil {
+		log.Errorf("failed to encode targets: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
@@ -1,17 +1,147 @@
 package handlers
 
 import (
+	"time"
+
+	"github.com/gonozov0/go-musthave-devops/internal/server/alerting"
+	"github.com/gonozov0/go-musthave-devops/internal/server/influx"
+	"github.com/gonozov0/go-musthave-devops/internal/server/otlp"
+	"github.com/gonozov0/go-musthave-devops/internal/server/profiles"
+	"github.com/gonozov0/go-musthave-devops/internal/server/promql"
+	"github.com/gonozov0/go-musthave-devops/internal/server/remotewrite"
 	"github.com/gonozov0/go-musthave-devops/internal/server/repository"
+	"github.com/gonozov0/go-musthave-devops/internal/server/scrape"
+	"github.com/gonozov0/go-musthave-devops/internal/server/stream"
 )
 
+// TargetsProvider returns the current scrape targets with their last scrape status.
+type TargetsProvider interface {
+	Targets() []scrape.TargetStatus
+}
+
+// AlertManager manages the alerting rules and their alerts.
+type AlertManager interface {
+	Rules() []alerting.RuleStatus
+	Alerts() []alerting.Alert
+	SetRule(rule alerting.Rule) (bool, error)
+	DeleteRule(name string) error
+}
+
 // Handler is a struct that holds the repository to update metrics.
 type Handler struct {
-	repo repository.Repository
+	repo     repository.Repository
+	targets  TargetsProvider
+	profiles profiles.Store
+	alerts   AlertManager
+	silences *alerting.Silences
+	stream   *stream.Hub
+	history  repository.HistoryRepository // nil when the repository doesn't record history
+	// remoteWrite maps the remote write series to metrics
+	remoteWrite *remotewrite.Converter
+	// otlp maps the OTLP data points to metrics
+	otlp *otlp.Converter
+	// influx maps the line protocol fields to metrics
+	influx *influx.Converter
+	// accepted distance of metric timestamps from the server time, zero means unlimited
+	maxPast   time.Duration
+	maxFuture time.Duration
+	// series and query serve the PromQL API over the repository metrics
+	series promql.Storage
+	query  *promql.Engine
+}
+
+// Option configures optional dependencies of the Handler.
+type Option func(*Handler)
+
+// WithTargetsProvider sets the source of the scrape targets status.
+func WithTargetsProvider(targets TargetsProvider) Option {
+	return func(h *Handler) {
+		h.targets = targets
+	}
+}
+
+// WithProfileStore enables uploading and downloading pprof profiles.
+func WithProfileStore(store profiles.Store) Option {
+	return func(h *Handler) {
+		h.profiles = store
+	}
+}
+
+// WithAlertManager enables managing the alerting rules and listing the alerts.
+func WithAlertManager(alerts AlertManager) Option {
+	return func(h *Handler) {
+		h.alerts = alerts
+	}
+}
+
+// WithSilences enables managing the alert silences.
+func WithSilences(silences *alerting.Silences) Option {
+	return func(h *Handler) {
+		h.silences = silences
+	}
+}
+
+// WithStreamHub enables streaming the metric updates published to the hub.
+func WithStreamHub(hub *stream.Hub) Option {
+	return func(h *Handler) {
+		h.stream = hub
+	}
+}
+
+// WithTimestampWindow rejects metrics with timestamps older than maxPast or further than maxFuture
+// from the server time. Zero durations disable the corresponding check.
+func WithTimestampWindow(maxPast, maxFuture time.Duration) Option {
+	return func(h *Handler) {
+		h.maxPast = maxPast
+		h.maxFuture = maxFuture
+	}
+}
+
+// WithRemoteWriteConverter sets the converter of the remote write series, which overrides
+// the metric types by name. The default one only uses the request metadata and the naming.
+func WithRemoteWriteConverter(converter *remotewrite.Converter) Option {
+	return func(h *Handler) {
+		h.remoteWrite = converter
+	}
+}
+
+// WithOTLPConverter sets the converter of the OTLP data points, which selects the resource attributes
+// kept as labels. The default one keeps otlp.DefaultResourceAttributes.
+func WithOTLPConverter(converter *otlp.Converter) Option {
+	return func(h *Handler) {
+		h.otlp = converter
+	}
+}
+
+// WithInfluxConverter sets the converter of the line protocol points, which selects the counter fields.
+// The default one stores all fields as gauges.
+func WithInfluxConverter(converter *influx.Converter) Option {
+	return func(h *Handler) {
+		h.influx = converter
+	}
 }
 
 // NewHandler constructs a new MetricsHandler.
-func NewHandler(repo repository.Repository) *Handler {
-	return &Handler{
+func NewHandler(repo repository.Repository, opts ...Option) *Handler {
+	h := &Handler{
 		repo: repo,
 	}
+	if history, ok := repo.(repository.HistoryRepository); ok {
+		h.history = history
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	if h.remoteWrite == nil {
+		h.remoteWrite, _ = remotewrite.NewConverter(nil, nil)
+	}
+	if h.otlp == nil {
+		h.otlp = otlp.NewConverter(otlp.DefaultResourceAttributes)
+	}
+	if h.influx == nil {
+		h.influx, _ = influx.NewConverter(nil)
+	}
+	h.series = promql.NewRepositoryStorage(repo, h.history)
+	h.query = promql.NewEngine(h.series)
+	return h
 }
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gonozov0/go-musthave-devops/internal/shared"
+)
+
+// defaultQuantiles are the quantiles estimated by GetMetricByBody when none are requested.
+var defaultQuantiles = []float64{0.5, 0.9, 0.99}
+
+// parseBounds parses a comma-separated list of bucket bounds, nil for an empty string.
+func parseBounds(s string) ([]float64, error) {
+	if s == "" {
+		return nil, nil
+	}
+	parts := strings.Split(s, ",")
+	bounds := make([]float64, 0, len(parts))
+	for _, part := range parts {
+		bound, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bound %q", part)
+		}
+		bounds = append(bounds, bound)
+	}
+	hist := shared.HistogramValue{Bounds: bounds, Counts: make([]uint64, len(bounds)+1)}
+	if err := hist.Validate(); err != nil {
+		return nil, err
+	}
+	return bounds, nil
+}
+
+// parseQuantiles parses the quantile query parameters, which may be repeated.
+func parseQuantiles(r *http.Request) ([]float64, error) {
+	values := r.URL.Query()["quantile"]
+	quantiles := make([]float64, 0, len(values))
+	for _, value := range values {
+		q, err := strconv.ParseFloat(value, 64)
+		if err != nil || q < 0 || q > 1 {
+			return nil, fmt.Errorf("invalid quantile %q, must be between 0 and 1", value)
+		}
+		quantiles = append(quantiles, q)
+	}
+	return quantiles, nil
+}
+
+// histogramQuantiles estimates the quantiles of the histogram keyed by their string form.
+// An empty histogram has no estimates.
+func histogramQuantiles(histogram shared.HistogramValue, quantiles []float64) map[string]float64 {
+	if histogram.Count == 0 {
+		return nil
+	}
+	estimates := make(map[string]float64, len(quantiles))
+	for _, q := range quantiles {
+		estimates[strconv.FormatFloat(q, 'f', -1, 64)] = histogram.Quantile(q)
+	}
+	return estimates
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/gonozov0/go-musthave-devops/internal/shared"
+)
+
+const (
+	// defaultHistoryRange is queried when the from parameter is missing.
+	defaultHistoryRange = time.Hour
+	// maxHistoryBuckets limits the size of an aggregated response.
+	maxHistoryBuckets = 11000
+)
+
+// GetHistory returns the recorded updates of the metric between the from and to query parameters,
+// RFC 3339 or Unix milliseconds, which default to the last hour. When the step parameter is given,
+// as a duration or seconds, the updates are aggregated into buckets of the step using the coarsest
+// rolled up tier fitting it.
+func (h *Handler) GetHistory(w http.ResponseWriter, r *http.Request) {
+	if h.history == nil {
+		http.Error(w, "History is not supported by the repository", http.StatusNotImplemented)
+		return
+	}
+
+	metricType := chi.URLParam(r, "metricType")
+	metricName := chi.URLParam(r, "metricName")
+	if metricType != shared.Gauge && metricType != shared.Counter {
+		http.Error(w, "Unknown metric type", http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+	to := time.Now()
+	if value := query.Get("to"); value != "" {
+		parsed, err := parseTime(value)
+		if err != nil {
+			http.Error(w, "Invalid to", http.StatusBadRequest)
+			return
+		}
+		to = parsed
+	}
+	from := to.Add(-defaultHistoryRange)
+	if value := query.Get("from"); value != "" {
+		parsed, err := parseTime(value)
+		if err != nil {
+			http.Error(w, "Invalid from", http.StatusBadRequest)
+			return
+		}
+		from = parsed
+	}
+	if from.After(to) {
+		http.Error(w, "from must not be after to", http.StatusBadRequest)
+		return
+	}
+	var step time.Duration
+	if value := query.Get("step"); value != "" {
+		parsed, err := parseStep(value)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "Invalid step", http.StatusBadRequest)
+			return
+		}
+		if to.Sub(from)/parsed > maxHistoryBuckets {
+			http.Error(w, "Too many points for the step, increase it or narrow the range", http.StatusBadRequest)
+			return
+		}
+		step = parsed
+	}
+
+	if step == 0 {
+		points, err := h.history.GetHistory(metricType, metricName, from, to)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, points)
+		return
+	}
+	aggregates, err := h.history.GetHistoryAggregates(metricType, metricName, from, to, step)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, aggregates)
+}
+
+// parseStep parses a duration like 1m30s or a number of seconds.
+func parseStep(value string) (time.Duration, error) {
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+	return time.ParseDuration(value)
+}
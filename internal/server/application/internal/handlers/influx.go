@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/gonozov0/go-musthave-devops/internal/server/influx"
+)
+
+// maxInfluxSize limits the size of the decompressed line protocol request.
+const maxInfluxSize = 32 << 20
+
+// influxError is the error body of the InfluxDB 2.x API, the 1.x API only has the error field.
+type influxError struct {
+	Code    string `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// WriteInfluxV1 accepts line protocol on the InfluxDB 1.x /write API. The db query parameter is ignored.
+func (h *Handler) WriteInfluxV1(w http.ResponseWriter, r *http.Request) {
+	h.writeInflux(w, r, func(message string) influxError { return influxError{Error: message} })
+}
+
+// WriteInfluxV2 accepts line protocol on the InfluxDB 2.x /api/v2/write API. The org and bucket query
+// parameters and the token are ignored.
+func (h *Handler) WriteInfluxV2(w http.ResponseWriter, r *http.Request) {
+	h.writeInflux(w, r, func(message string) influxError { return influxError{Code: "invalid", Message: message} })
+}
+
+// writeInflux parses the whole request before writing it in one batch, so a request with an invalid
+// line isn't written at all and the response lists the line numbers of the errors.
+func (h *Handler) writeInflux(w http.ResponseWriter, r *http.Request, influxErr func(message string) influxError) {
+	precision, ok := influx.Precisions[r.URL.Query().Get("precision")]
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, influxErr("invalid precision"))
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxInfluxSize))
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		writeJSON(w, http.StatusRequestEntityTooLarge, influxErr("request is too large"))
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, influxErr(err.Error()))
+		return
+	}
+
+	points, errs := influx.Parse(body)
+	if len(errs) > 0 {
+		writeJSON(w, http.StatusBadRequest, influxErr(rejectionMessage(len(errs), "lines", errs)))
+		return
+	}
+	rejected, err := h.influx.Write(points, precision, h.checkTimestamp, h.updateMetrics)
+	if err != nil {
+		log.Errorf("failed to write line protocol points: %v", err)
+		writeJSON(w, http.StatusInternalServerError, influxErr(err.Error()))
+		return
+	}
+	if len(rejected) > 0 {
+		writeJSON(w, http.StatusBadRequest, influxErr(rejectionMessage(len(rejected), "lines", rejected)))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
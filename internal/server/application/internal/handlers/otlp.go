@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// maxOTLPSize limits the size of the decompressed OTLP request.
+	maxOTLPSize = 32 << 20
+
+	contentTypeProtobuf = "application/x-protobuf"
+	contentTypeJSON     = "application/json"
+)
+
+// ExportOTLPMetrics accepts the OTLP/HTTP ExportMetricsServiceRequest encoded as protobuf or JSON.
+// The response is encoded like the request. Rejected data points are reported in the partial success
+// of the 200 response, storage errors are 503 so the exporter retries the request.
+func (h *Handler) ExportOTLPMetrics(w http.ResponseWriter, r *http.Request) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || (mediaType != contentTypeProtobuf && mediaType != contentTypeJSON) {
+		http.Error(w, "Unsupported content type", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxOTLPSize))
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		writeOTLPStatus(w, mediaType, http.StatusRequestEntityTooLarge, codes.InvalidArgument, "request is too large")
+		return
+	}
+	if err != nil {
+		writeOTLPStatus(w, mediaType, http.StatusBadRequest, codes.InvalidArgument, err.Error())
+		return
+	}
+	req := &colmetricspb.ExportMetricsServiceRequest{}
+	if mediaType == contentTypeJSON {
+		err = protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(body, req)
+	} else {
+		err = proto.Unmarshal(body, req)
+	}
+	if err != nil {
+		writeOTLPStatus(w, mediaType, http.StatusBadRequest, codes.InvalidArgument, err.Error())
+		return
+	}
+
+	result, err := h.otlp.Write(req, h.checkTimestamp, h.updateMetrics)
+	if err != nil {
+		log.Errorf("failed to write OTLP metrics: %v", err)
+		writeOTLPStatus(w, mediaType, http.StatusServiceUnavailable, codes.Unavailable, err.Error())
+		return
+	}
+
+	resp := &colmetricspb.ExportMetricsServiceResponse{}
+	if result.RejectedDataPoints > 0 || result.ErrorMessage != "" {
+		resp.PartialSuccess = &colmetricspb.ExportMetricsPartialSuccess{
+			RejectedDataPoints: result.RejectedDataPoints,
+			ErrorMessage:       result.ErrorMessage,
+		}
+	}
+	writeOTLPMessage(w, mediaType, http.StatusOK, resp)
+}
+
+// writeOTLPStatus writes the error as the google.rpc.Status message required by OTLP/HTTP.
+func writeOTLPStatus(w http.ResponseWriter, mediaType string, httpStatus int, code codes.Code, message string) {
+	writeOTLPMessage(w, mediaType, httpStatus, &spb.Status{Code: int32(code), Message: message})
+}
+
+func writeOTLPMessage(w http.ResponseWriter, mediaType string, status int, message proto.Message) {
+	var (
+		data []byte
+		err  error
+	)
+	if mediaType == contentTypeJSON {
+		data, err = protojson.Marshal(message)
+	} else {
+		data, err = proto.Marshal(message)
+	}
+	if err != nil {
+		log.Errorf("failed to encode OTLP response: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", mediaType)
+	w.WriteHeader(status)
+	if _, err := w.Write(data); err != nil {
+		log.Errorf("failed to write OTLP response: %v", err)
+	}
+}
@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/gonozov0/go-musthave-devops/internal/server/profiles"
+)
+
+// maxProfileSize limits the size of an uploaded profile.
+const maxProfileSize = 32 << 20
+
+// UploadProfile stores the pprof profile from the request body.
+// The profile is described by the source, type and optional timestamp (Unix milliseconds) query parameters.
+func (h *Handler) UploadProfile(w http.ResponseWriter, r *http.Request) {
+	if h.profiles == nil {
+		http.Error(w, "Profiling is disabled", http.StatusNotImplemented)
+		return
+	}
+
+	query := r.URL.Query()
+	profile := profiles.Profile{
+		Source:    query.Get("source"),
+		Type:      query.Get("type"),
+		Timestamp: time.Now(),
+	}
+	if timestamp := query.Get("timestamp"); timestamp != "" {
+		parsed, err := parseTime(timestamp)
+		if err != nil {
+			http.Error(w, "Invalid timestamp", http.StatusBadRequest)
+			return
+		}
+		profile.Timestamp = parsed
+	}
+	if err := profiles.Validate(profile); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxProfileSize))
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		http.Error(w, "Profile is too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(data) == 0 {
+		http.Error(w, "empty profile", http.StatusBadRequest)
+		return
+	}
+
+	profile, err = h.profiles.Save(profile, data)
+	if err != nil {
+		log.Errorf("failed to save profile: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, profile)
+}
+
+// ListProfiles returns the stored profiles filtered by the source, type, from and to query parameters.
+// The time range bounds are RFC 3339 times or Unix milliseconds.
+func (h *Handler) ListProfiles(w http.ResponseWriter, r *http.Request) {
+	if h.profiles == nil {
+		writeJSON(w, http.StatusOK, []profiles.Profile{})
+		return
+	}
+
+	query := r.URL.Query()
+	filter := profiles.Filter{Source: query.Get("source"), Type: query.Get("type")}
+	for _, bound := range []struct {
+		name string
+		time *time.Time
+	}{{"from", &filter.From}, {"to", &filter.To}} {
+		value := query.Get(bound.name)
+		if value == "" {
+			continue
+		}
+		parsed, err := parseTime(value)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid %s", bound.name), http.StatusBadRequest)
+			return
+		}
+		*bound.time = parsed
+	}
+
+	list, err := h.profiles.List(filter)
+	if err != nil {
+		log.Errorf("failed to list profiles: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// DownloadProfile returns the raw profile, so it can be opened with `go tool pprof <url>`.
+func (h *Handler) DownloadProfile(w http.ResponseWriter, r *http.Request) {
+	if h.profiles == nil {
+		http.Error(w, "Profiling is disabled", http.StatusNotImplemented)
+		return
+	}
+
+	profile, data, err := h.profiles.Get(chi.URLParam(r, "id"))
+	if errors.Is(err, profiles.ErrProfileNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Errorf("failed to get profile: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set(
+		"Content-Disposition",
+		fmt.Sprintf("attachment; filename=%q", fmt.Sprintf("%s-%s.pprof", profile.Type, profile.ID)),
+	)
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(data); err != nil {
+		log.Errorf("failed to write profile: %v", err)
+	}
+}
+
+// parseTime parses an RFC 3339 time or Unix milliseconds.
+func parseTime(value string) (time.Time, error) {
+	if millis, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.UnixMilli(millis), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Errorf("failed to encode response: %v", err)
+	}
+}
@@ -0,0 +1,306 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/gonozov0/go-musthave-devops/internal/server/promql"
+)
+
+// Error types of the Prometheus HTTP API.
+const (
+	errorBadData   = "bad_data"
+	errorExecution = "execution"
+	errorInternal  = "internal"
+)
+
+type apiResponse struct {
+	Status    string      `json:"status"`
+	Data      interface{} `json:"data,omitempty"`
+	ErrorType string      `json:"errorType,omitempty"`
+	Error     string      `json:"error,omitempty"`
+}
+
+type queryData struct {
+	ResultType promql.ValueType `json:"resultType"`
+	Result     interface{}      `json:"result"`
+}
+
+// apiPoint is a point encoded as [<unix seconds>, "<value>"].
+type apiPoint promql.Point
+
+func (p apiPoint) MarshalJSON() ([]byte, error) {
+	value := strconv.FormatFloat(p.V, 'f', -1, 64)
+	switch {
+	case math.IsInf(p.V, 1):
+		value = "+Inf"
+	case math.IsInf(p.V, -1):
+		value = "-Inf"
+	case math.IsNaN(p.V):
+		value = "NaN"
+	}
+	return []byte(fmt.Sprintf(`[%s,"%s"]`, strconv.FormatFloat(float64(p.T)/1000, 'f', -1, 64), value)), nil
+}
+
+type apiSample struct {
+	Metric promql.Labels `json:"metric"`
+	Value  apiPoint      `json:"value"`
+}
+
+type apiSeries struct {
+	Metric promql.Labels `json:"metric"`
+	Values []apiPoint    `json:"values"`
+}
+
+// Query evaluates the PromQL query parameter at the time parameter, now by default,
+// and responds in the format of the Prometheus HTTP API.
+func (h *Handler) Query(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeAPIError(w, errorBadData, err)
+		return
+	}
+	t := time.Now()
+	if value := r.Form.Get("time"); value != "" {
+		parsed, err := parseAPITime(value)
+		if err != nil {
+			writeAPIError(w, errorBadData, fmt.Errorf("invalid parameter \"time\": %w", err))
+			return
+		}
+		t = parsed
+	}
+
+	value, err := h.query.Instant(r.Form.Get("query"), t)
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+
+	data := queryData{ResultType: value.Type()}
+	switch v := value.(type) {
+	case promql.Scalar:
+		data.Result = apiPoint(v)
+	case promql.Vector:
+		samples := make([]apiSample, 0, len(v))
+		for _, sample := range v {
+			samples = append(samples, apiSample{Metric: sample.Metric, Value: apiPoint(sample.Point)})
+		}
+		data.Result = samples
+	case promql.Matrix:
+		data.Result = matrixResult(v)
+	}
+	writeJSON(w, http.StatusOK, apiResponse{Status: "success", Data: data})
+}
+
+// QueryRange evaluates the PromQL query parameter at every step between the start and end parameters
+// and responds with the series of the results in the format of the Prometheus HTTP API.
+func (h *Handler) QueryRange(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeAPIError(w, errorBadData, err)
+		return
+	}
+	start, err := parseAPITime(r.Form.Get("start"))
+	if err != nil {
+		writeAPIError(w, errorBadData, fmt.Errorf("invalid parameter \"start\": %w", err))
+		return
+	}
+	end, err := parseAPITime(r.Form.Get("end"))
+	if err != nil {
+		writeAPIError(w, errorBadData, fmt.Errorf("invalid parameter \"end\": %w", err))
+		return
+	}
+	if end.Before(start) {
+		writeAPIError(w, errorBadData, errors.New("end timestamp must not be before start time"))
+		return
+	}
+	step, err := parseAPIDuration(r.Form.Get("step"))
+	if err != nil || step <= 0 {
+		writeAPIError(w, errorBadData, errors.New("invalid parameter \"step\": zero or negative query resolution step widths are not accepted"))
+		return
+	}
+	if end.Sub(start)/step > maxHistoryBuckets {
+		writeAPIError(w, errorBadData, fmt.Errorf(
+			"exceeded maximum resolution of %d points per timeseries, try decreasing the query resolution (?step=XX)",
+			maxHistoryBuckets,
+		))
+		return
+	}
+
+	matrix, err := h.query.Range(r.Form.Get("query"), start, end, step)
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, apiResponse{
+		Status: "success",
+		Data:   queryData{ResultType: promql.ValueTypeMatrix, Result: matrixResult(matrix)},
+	})
+}
+
+// Series returns the labels of the series matching any of the match[] selectors.
+func (h *Handler) Series(w http.ResponseWriter, r *http.Request) {
+	series, ok := h.matchSeries(w, r, true)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, apiResponse{Status: "success", Data: series})
+}
+
+// LabelNames returns the sorted label names of the series matching the optional match[] selectors.
+func (h *Handler) LabelNames(w http.ResponseWriter, r *http.Request) {
+	series, ok := h.matchSeries(w, r, false)
+	if !ok {
+		return
+	}
+	names := make(map[string]bool)
+	for _, labels := range series {
+		for name := range labels {
+			names[name] = true
+		}
+	}
+	writeJSON(w, http.StatusOK, apiResponse{Status: "success", Data: sortedKeys(names)})
+}
+
+// LabelValues returns the sorted values of the label of the series matching the optional match[] selectors.
+func (h *Handler) LabelValues(w http.ResponseWriter, r *http.Request) {
+	series, ok := h.matchSeries(w, r, false)
+	if !ok {
+		return
+	}
+	name := chi.URLParam(r, "name")
+	values := make(map[string]bool)
+	for _, labels := range series {
+		if value, ok := labels[name]; ok {
+			values[value] = true
+		}
+	}
+	writeJSON(w, http.StatusOK, apiResponse{Status: "success", Data: sortedKeys(values)})
+}
+
+// matchSeries returns the labels of the series matching any of the match[] selectors,
+// all series when there are none and they aren't required.
+func (h *Handler) matchSeries(w http.ResponseWriter, r *http.Request, required bool) ([]promql.Labels, bool) {
+	if err := r.ParseForm(); err != nil {
+		writeAPIError(w, errorBadData, err)
+		return nil, false
+	}
+	selectors := r.Form["match[]"]
+	if len(selectors) == 0 {
+		if required {
+			writeAPIError(w, errorBadData, errors.New("no match[] parameter provided"))
+			return nil, false
+		}
+		series, err := h.series.Series(nil)
+		if err != nil {
+			writeAPIError(w, errorInternal, err)
+			return nil, false
+		}
+		return series, true
+	}
+
+	unique := make(map[string]promql.Labels)
+	for _, selector := range selectors {
+		expr, err := promql.Parse(selector)
+		if err != nil {
+			writeAPIError(w, errorBadData, err)
+			return nil, false
+		}
+		vector, ok := expr.(*promql.VectorSelector)
+		if !ok {
+			writeAPIError(w, errorBadData, fmt.Errorf("invalid parameter \"match[]\": %s is not a series selector", selector))
+			return nil, false
+		}
+		series, err := h.series.Series(vector.Matchers)
+		if err != nil {
+			writeAPIError(w, errorInternal, err)
+			return nil, false
+		}
+		for _, labels := range series {
+			unique[fmt.Sprint(labels)] = labels
+		}
+	}
+	keys := make([]string, 0, len(unique))
+	for key := range unique {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	result := make([]promql.Labels, 0, len(keys))
+	for _, key := range keys {
+		result = append(result, unique[key])
+	}
+	return result, true
+}
+
+func matrixResult(matrix promql.Matrix) []apiSeries {
+	result := make([]apiSeries, 0, len(matrix))
+	for _, series := range matrix {
+		points := make([]apiPoint, 0, len(series.Points))
+		for _, point := range series.Points {
+			points = append(points, apiPoint(point))
+		}
+		result = append(result, apiSeries{Metric: series.Metric, Values: points})
+	}
+	return result
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// writeQueryError responds with the error type of the query error: invalid queries are bad data,
+// storage failures are internal errors and the rest are evaluation errors.
+func writeQueryError(w http.ResponseWriter, err error) {
+	var parseErr *promql.ParseError
+	var storageErr *promql.StorageError
+	switch {
+	case errors.As(err, &parseErr):
+		writeAPIError(w, errorBadData, err)
+	case errors.As(err, &storageErr):
+		writeAPIError(w, errorInternal, err)
+	default:
+		writeAPIError(w, errorExecution, err)
+	}
+}
+
+func writeAPIError(w http.ResponseWriter, errorType string, err error) {
+	status := http.StatusBadRequest
+	switch errorType {
+	case errorExecution:
+		status = http.StatusUnprocessableEntity
+	case errorInternal:
+		status = http.StatusInternalServerError
+	}
+	writeJSON(w, status, apiResponse{Status: "error", ErrorType: errorType, Error: err.Error()})
+}
+
+// parseAPITime parses an RFC 3339 time or a Unix time in seconds with a fraction.
+func parseAPITime(value string) (time.Time, error) {
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		whole, fraction := math.Modf(seconds)
+		return time.Unix(int64(whole), int64(math.Round(fraction*1000))*int64(time.Millisecond)), nil
+	}
+	parsed, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("cannot parse %q to a valid timestamp", value)
+	}
+	return parsed, nil
+}
+
+// parseAPIDuration parses a PromQL duration or a number of seconds.
+func parseAPIDuration(value string) (time.Duration, error) {
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+	return promql.ParseDuration(value)
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/gonozov0/go-musthave-devops/internal/server/remotewrite"
+	"github.com/gonozov0/go-musthave-devops/internal/server/repository"
+	"github.com/gonozov0/go-musthave-devops/internal/shared"
+)
+
+const (
+	// maxRemoteWriteSize limits the size of the compressed remote write request.
+	maxRemoteWriteSize = 32 << 20
+	// maxReportedRejections limits the number of rejected samples listed in the response.
+	maxReportedRejections = 10
+)
+
+// RemoteWrite accepts the snappy-compressed protobuf WriteRequest of the Prometheus remote write protocol 1.0.
+// The valid samples are written even when some are rejected, the response is 400 listing the first
+// rejections then, so the sender drops the request instead of retrying it. Storage errors are 500
+// and the sender retries the whole request.
+func (h *Handler) RemoteWrite(w http.ResponseWriter, r *http.Request) {
+	if encoding := r.Header.Get("Content-Encoding"); encoding != "" && !strings.EqualFold(encoding, "snappy") {
+		http.Error(w, "Unsupported content encoding", http.StatusUnsupportedMediaType)
+		return
+	}
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		mediaType, params, err := mime.ParseMediaType(contentType)
+		if err != nil || mediaType != "application/x-protobuf" ||
+			(params["proto"] != "" && params["proto"] != "prometheus.WriteRequest") {
+			http.Error(w, "Unsupported content type", http.StatusUnsupportedMediaType)
+			return
+		}
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRemoteWriteSize))
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		http.Error(w, "Request is too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req, err := remotewrite.DecodeWriteRequest(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rejected, err := h.remoteWrite.Write(req, h.checkTimestamp, h.updateMetrics)
+	if err != nil {
+		log.Errorf("failed to write remote write samples: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(rejected) > 0 {
+		http.Error(w, rejectionMessage(len(rejected), "samples", rejected), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// rejectionMessage reports the number of rejected items with the first errors.
+func rejectionMessage(count int, items string, errs []error) string {
+	reported := errs[:min(len(errs), maxReportedRejections)]
+	messages := make([]string, 0, len(reported))
+	for _, err := range reported {
+		messages = append(messages, err.Error())
+	}
+	return fmt.Sprintf("%d %s rejected: %s", count, items, strings.Join(messages, "; "))
+}
+
+// updateMetrics writes the validated metrics with the batch updates of the repository.
+func (h *Handler) updateMetrics(metrics []shared.Metric) error {
+	gauges := make([]repository.GaugeMetric, 0, len(metrics))
+	counters := make([]repository.CounterMetric, 0, len(metrics))
+	for _, metric := range metrics {
+		switch metric.MType {
+		case shared.Gauge:
+			gauges = append(gauges, repository.GaugeMetric{
+				Name:      metric.ID,
+				Value:     *metric.Value,
+				Timestamp: metricTime(metric),
+			})
+		case shared.Counter:
+			counters = append(counters, repository.CounterMetric{Name: metric.ID, Value: *metric.Delta})
+		}
+	}
+	if _, err := h.repo.UpdateGauges(gauges); err != nil {
+		return fmt.Errorf("failed to update gauges: %w", err)
+	}
+	if _, err := h.repo.UpdateCounters(counters); err != nil {
+		return fmt.Errorf("failed to update counters: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/gonozov0/go-musthave-devops/internal/server/alerting"
+	"github.com/gonozov0/go-musthave-devops/internal/server/repository"
+)
+
+// GetSilences returns the silences that didn't end.
+func (h *Handler) GetSilences(w http.ResponseWriter, r *http.Request) {
+	if h.silences == nil {
+		http.Error(w, "Alerting is not enabled", http.StatusNotImplemented)
+		return
+	}
+	silences, err := h.silences.List(time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, silences)
+}
+
+// CreateSilence creates the silence of the body or replaces the silence with its ID.
+func (h *Handler) CreateSilence(w http.ResponseWriter, r *http.Request) {
+	if h.silences == nil {
+		http.Error(w, "Alerting is not enabled", http.StatusNotImplemented)
+		return
+	}
+	var silence repository.Silence
+	if err := json.NewDecoder(r.Body).Decode(&silence); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	replaced := silence.ID != ""
+	silence, err := h.silences.Create(silence, time.Now())
+	switch {
+	case errors.Is(err, alerting.ErrInvalidSilence):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, repository.ErrSilenceNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	status := http.StatusCreated
+	if replaced {
+		status = http.StatusOK
+	}
+	writeJSON(w, status, silence)
+}
+
+// DeleteSilence deletes the silence, the alerts it muted get notified again.
+func (h *Handler) DeleteSilence(w http.ResponseWriter, r *http.Request) {
+	if h.silences == nil {
+		http.Error(w, "Alerting is not enabled", http.StatusNotImplemented)
+		return
+	}
+	err := h.silences.Delete(chi.URLParam(r, "id"))
+	switch {
+	case errors.Is(err, repository.ErrSilenceNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/websocket"
+
+	"github.com/gonozov0/go-musthave-devops/internal/server/stream"
+)
+
+const (
+	// streamWriteTimeout disconnects the clients that stop reading.
+	streamWriteTimeout = 10 * time.Second
+	// streamKeepAlive is how often an idle SSE stream sends a comment to keep the proxies from closing it.
+	streamKeepAlive = 15 * time.Second
+)
+
+// streamError is the last message of a stream closed by the server.
+type streamError struct {
+	Error string `json:"error"`
+}
+
+// Stream streams the metric updates as Server-Sent Events or over a WebSocket when the request upgrades.
+// The type query parameters (repeated or comma-separated) and the name regular expression filter the metrics.
+// A client falling too far behind is disconnected after an error message.
+func (h *Handler) Stream(w http.ResponseWriter, r *http.Request) {
+	if h.stream == nil {
+		http.Error(w, "Streaming is not enabled", http.StatusNotImplemented)
+		return
+	}
+	var types []string
+	for _, value := range r.URL.Query()["type"] {
+		for _, metricType := range strings.Split(value, ",") {
+			if metricType = strings.TrimSpace(metricType); metricType != "" {
+				types = append(types, metricType)
+			}
+		}
+	}
+	filter, err := stream.NewFilter(types, r.URL.Query().Get("name"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		// the server without a handshake function accepts any origin like the rest of the API
+		websocket.Server{Handler: func(conn *websocket.Conn) {
+			h.streamWebSocket(conn, filter)
+		}}.ServeHTTP(w, r)
+		return
+	}
+	h.streamEvents(w, r, filter)
+}
+
+// streamEvents writes the updates as the metric events of an SSE stream.
+func (h *Handler) streamEvents(w http.ResponseWriter, r *http.Request, filter stream.Filter) {
+	controller := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if err := controller.Flush(); err != nil {
+		log.Errorf("Could not start event stream: %s", err.Error())
+		return
+	}
+
+	subscription := h.stream.Subscribe(filter)
+	defer subscription.Close()
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+
+	write := func(format string, args ...interface{}) bool {
+		_ = controller.SetWriteDeadline(time.Now().Add(streamWriteTimeout)) // unsupported by some writers
+		if _, err := fmt.Fprintf(w, format, args...); err != nil {
+			return false
+		}
+		return controller.Flush() == nil
+	}
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-subscription.Done():
+			data, _ := json.Marshal(streamError{Error: subscription.Err().Error()})
+			write("event: error\ndata: %s\n\n", data)
+			return
+		case <-keepAlive.C:
+			if !write(": keep-alive\n\n") {
+				return
+			}
+		case metric := <-subscription.Updates():
+			data, err := json.Marshal(metric)
+			if err != nil {
+				log.Errorf("Could not encode streamed metric: %s", err.Error())
+				continue
+			}
+			if !write("event: metric\ndata: %s\n\n", data) {
+				return
+			}
+		}
+	}
+}
+
+// streamWebSocket sends the updates as JSON text messages until the client closes the connection.
+func (h *Handler) streamWebSocket(conn *websocket.Conn, filter stream.Filter) {
+	defer conn.Close()
+
+	subscription := h.stream.Subscribe(filter)
+	defer subscription.Close()
+
+	// the client doesn't send anything, reading detects the closed connection
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		_, _ = io.Copy(io.Discard, conn)
+	}()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case <-subscription.Done():
+			_ = conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+			_ = websocket.JSON.Send(conn, streamError{Error: subscription.Err().Error()})
+			return
+		case metric := <-subscription.Updates():
+			_ = conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+			if err := websocket.JSON.Send(conn, metric); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gonozov0/go-musthave-devops/internal/server/alerting"
+	"github.com/gonozov0/go-musthave-devops/internal/server/application"
+	"github.com/gonozov0/go-musthave-devops/internal/server/promql"
+	"github.com/gonozov0/go-musthave-devops/internal/server/repository"
+	inMemory "github.com/gonozov0/go-musthave-devops/internal/server/repository/in_memory"
+)
+
+type recordingNotifier struct {
+	notifications []alerting.Notification
+}
+
+func (n *recordingNotifier) Notify(notification alerting.Notification) {
+	n.notifications = append(n.notifications, notification)
+}
+
+func TestAlerts(t *testing.T) {
+	repo := inMemory.NewInMemoryRepository()
+	notifier := &recordingNotifier{}
+	engine := promql.NewEngine(promql.NewRepositoryStorage(repo, repo.(repository.HistoryRepository)))
+	manager, err := alerting.NewManager(engine, notifier, time.Minute, alerting.WithRuleFiles(map[string][]alerting.Rule{
+		"rules.yml": {{Name: "FileRule", Expr: "up == 0"}},
+	}))
+	require.NoError(t, err)
+	router := application.NewRouter(repo, application.WithAlertManager(manager))
+
+	serve := func(method, target string, body any) *httptest.ResponseRecorder {
+		var reader bytes.Buffer
+		if body != nil {
+			require.NoError(t, json.NewEncoder(&reader).Encode(body))
+		}
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(method, target, &reader))
+		return recorder
+	}
+
+	recorder := serve(http.MethodPost, "/api/v1/rules", alerting.Rule{Name: "HighHeap", Condition: "gauge HeapAlloc > 1e9"})
+	require.Equal(t, http.StatusCreated, recorder.Code, recorder.Body.String())
+	recorder = serve(http.MethodPost, "/api/v1/rules", alerting.Rule{Name: "HighHeap", Condition: "gauge HeapAlloc > 2e9"})
+	require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+	recorder = serve(http.MethodPost, "/api/v1/rules", alerting.Rule{Name: "Invalid", Condition: "gauge HeapAlloc"})
+	require.Equal(t, http.StatusBadRequest, recorder.Code)
+	recorder = serve(http.MethodPost, "/api/v1/rules", alerting.Rule{Name: "FileRule", Expr: "up == 1"})
+	require.Equal(t, http.StatusConflict, recorder.Code)
+
+	recorder = serve(http.MethodGet, "/api/v1/rules", nil)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	var rules []alerting.RuleStatus
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &rules))
+	require.Len(t, rules, 2)
+	require.Equal(t, "HeapAlloc > 2e+09", rules[1].Query)
+
+	_, err = repo.UpdateGauge("HeapAlloc", 3e9)
+	require.NoError(t, err)
+	manager.Evaluate(time.Now().Add(time.Second))
+
+	recorder = serve(http.MethodGet, "/api/v1/alerts", nil)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	var alerts []alerting.Alert
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &alerts))
+	require.Len(t, alerts, 1)
+	require.Equal(t, alerting.StateFiring, alerts[0].State)
+	require.Equal(t, alerting.SampleValue(3e9), alerts[0].Value)
+	require.Len(t, notifier.notifications, 1)
+
+	recorder = serve(http.MethodDelete, "/api/v1/rules/HighHeap", nil)
+	require.Equal(t, http.StatusNoContent, recorder.Code)
+	recorder = serve(http.MethodDelete, "/api/v1/rules/HighHeap", nil)
+	require.Equal(t, http.StatusNotFound, recorder.Code)
+	require.Len(t, notifier.notifications, 2)
+	require.Equal(t, alerting.StateResolved, notifier.notifications[1].Status)
+
+	router = application.NewRouter(repo)
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/alerts", nil))
+	require.Equal(t, http.StatusNotImplemented, recorder.Code)
+}
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gonozov0/go-musthave-devops/internal/server/application"
+	"github.com/gonozov0/go-musthave-devops/internal/server/remotewrite"
+	repository "github.com/gonozov0/go-musthave-devops/internal/server/repository/in_memory"
+	"github.com/gonozov0/go-musthave-devops/internal/shared"
+)
+
+func TestBatchUpdateCompression(t *testing.T) {
+	for _, encoding := range []string{shared.EncodingGzip, shared.EncodingZstd} {
+		t.Run(encoding, func(t *testing.T) {
+			repo := repository.NewInMemoryRepository()
+			router := application.NewRouter(repo)
+
+			value := 32.5
+			body, err := json.Marshal([]shared.Metric{{ID: "temperature", MType: shared.Gauge, Value: &value}})
+			require.NoError(t, err)
+			var compressed bytes.Buffer
+			writer, err := shared.NewCompressWriter(encoding, &compressed)
+			require.NoError(t, err)
+			_, _ = writer.Write(body)
+			require.NoError(t, writer.Close())
+
+			request := httptest.NewRequest(http.MethodPost, "/updates/", &compressed)
+			request.Header.Set("Content-Encoding", encoding)
+			request.Header.Set("Accept-Encoding", encoding)
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, request)
+
+			require.Equal(t, http.StatusOK, recorder.Code)
+			require.Equal(t, encoding, recorder.Header().Get("Content-Encoding"))
+			reader, err := shared.NewDecompressReader(encoding, recorder.Body)
+			require.NoError(t, err)
+			var metrics []shared.Metric
+			require.NoError(t, json.NewDecoder(reader).Decode(&metrics))
+			require.Len(t, metrics, 1)
+			require.Equal(t, value, *metrics[0].Value)
+
+			gauge, err := repo.GetGauge("temperature")
+			require.NoError(t, err)
+			require.Equal(t, value, gauge)
+		})
+	}
+}
+
+func TestRemoteWriteIsNotCompressed(t *testing.T) {
+	repo := repository.NewInMemoryRepository()
+	converter, err := remotewrite.NewConverter(nil, nil)
+	require.NoError(t, err)
+	router := application.NewRouter(repo, application.WithRemoteWriteConverter(converter))
+
+	body := remotewrite.EncodeWriteRequest(remotewrite.WriteRequest{Timeseries: []remotewrite.TimeSeries{{
+		Labels:  map[string]string{"__name__": "temperature"},
+		Samples: []remotewrite.Sample{{Value: 32.5, Timestamp: time.Now().UnixMilli()}},
+	}}})
+	// the snappy body is decoded by the handler only, the compression middleware would reject it
+	request := httptest.NewRequest(http.MethodPost, "/api/v1/write", bytes.NewReader(body))
+	request.Header.Set("Content-Type", "application/x-protobuf")
+	request.Header.Set("Content-Encoding", "snappy")
+	request.Header.Set("Accept-Encoding", shared.EncodingGzip)
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+
+	require.Equal(t, http.StatusNoContent, recorder.Code, recorder.Body.String())
+	require.Empty(t, recorder.Header().Get("Content-Encoding"))
+	require.Empty(t, recorder.Header().Get("Vary"))
+	gauge, err := repo.GetGauge("temperature")
+	require.NoError(t, err)
+	require.Equal(t, 32.5, gauge)
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gonozov0/go-musthave-devops/internal/server/application"
+	repository "github.com/gonozov0/go-musthave-devops/internal/server/repository/in_memory"
+	"github.com/gonozov0/go-musthave-devops/internal/shared"
+)
+
+func TestExportMetrics(t *testing.T) {
+	repo := repository.NewInMemoryRepository()
+	router := application.NewRouter(repo)
+	_, err := repo.UpdateGauge(`Alloc{host="a"}`, 2.5)
+	require.NoError(t, err)
+	_, err = repo.UpdateCounter("PollCount", 5)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name                string
+		accept              string
+		expectedContentType string
+		expectedBody        string
+	}{
+		{
+			name:                "TestPrometheusText",
+			expectedContentType: shared.ContentTypePrometheusText,
+			expectedBody:        "# TYPE Alloc gauge\nAlloc{host=\"a\"} 2.5\n# TYPE PollCount counter\nPollCount 5\n",
+		},
+		{
+			name:                "TestOpenMetrics",
+			accept:              "application/openmetrics-text;version=1.0.0,text/plain;version=0.0.4;q=0.5",
+			expectedContentType: shared.ContentTypeOpenMetrics,
+			expectedBody:        "# TYPE Alloc gauge\nAlloc{host=\"a\"} 2.5\n# TYPE PollCount counter\nPollCount_total 5\n# EOF\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/metrics/export", nil)
+			if tc.accept != "" {
+				req.Header.Set("Accept", tc.accept)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			require.Equal(t, http.StatusOK, w.Code)
+			require.Equal(t, tc.expectedContentType, w.Header().Get("Content-Type"))
+			require.Equal(t, tc.expectedBody, w.Body.String())
+		})
+	}
+}
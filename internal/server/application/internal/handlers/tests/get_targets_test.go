@@ -0,0 +1,48 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gonozov0/go-musthave-devops/internal/server/application"
+	repository "github.com/gonozov0/go-musthave-devops/internal/server/repository/in_memory"
+	"github.com/gonozov0/go-musthave-devops/internal/server/scrape"
+)
+
+type staticTargets []scrape.TargetStatus
+
+func (t staticTargets) Targets() []scrape.TargetStatus {
+	return t
+}
+
+func TestGetTargets(t *testing.T) {
+	repo := repository.NewInMemoryRepository()
+
+	t.Run("without scrape manager", func(t *testing.T) {
+		router := application.NewRouter(repo)
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/targets", nil))
+
+		require.Equal(t, http.StatusOK, recorder.Code)
+		require.JSONEq(t, "[]", recorder.Body.String())
+	})
+
+	t.Run("with scrape manager", func(t *testing.T) {
+		targets := staticTargets{{
+			Target: scrape.Target{URL: "http://agent/metrics", Labels: map[string]string{"zone": "dmz"}, Source: "static"},
+			Health: scrape.HealthDown,
+		}}
+		router := application.NewRouter(repo, application.WithTargetsProvider(targets))
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/targets", nil))
+
+		require.Equal(t, http.StatusOK, recorder.Code)
+		var actual []scrape.TargetStatus
+		require.NoError(t, json.NewDecoder(recorder.Body).Decode(&actual))
+		require.Equal(t, []scrape.TargetStatus(targets), actual)
+	})
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gonozov0/go-musthave-devops/internal/server/application"
+	repository "github.com/gonozov0/go-musthave-devops/internal/server/repository/in_memory"
+	"github.com/gonozov0/go-musthave-devops/internal/shared"
+)
+
+func TestHistogram(t *testing.T) {
+	repo := repository.NewInMemoryRepository()
+	router := application.NewRouter(repo)
+
+	serve := func(method, target string, body any) *httptest.ResponseRecorder {
+		var reader bytes.Buffer
+		if body != nil {
+			require.NoError(t, json.NewEncoder(&reader).Encode(body))
+		}
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(method, target, &reader))
+		return recorder
+	}
+
+	// observations by URL go to the buckets given by the first one
+	for _, value := range []string{"0.05", "0.2", "0.3", "2"} {
+		recorder := serve(http.MethodPost, "/update/histogram/latency/"+value+"?bounds=0.1,0.5,1", nil)
+		require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+	}
+	recorder := serve(http.MethodPost, "/update/histogram/latency/1?bounds=0.5,0.1", nil)
+	require.Equal(t, http.StatusBadRequest, recorder.Code)
+
+	// the JSON update is merged into the stored histogram
+	update := shared.HistogramValue{Bounds: []float64{0.1, 0.5, 1}, Counts: []uint64{1, 2, 1, 0}, Sum: 1.3, Count: 4}
+	recorder = serve(http.MethodPost, "/update/", shared.Metric{ID: "latency", MType: shared.Histogram, Histogram: &update})
+	require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+	var metric shared.Metric
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &metric))
+	require.Equal(t, []uint64{2, 4, 1, 1}, metric.Histogram.Counts)
+	require.Equal(t, uint64(8), metric.Histogram.Count)
+	require.InDelta(t, 3.85, metric.Histogram.Sum, 1e-9)
+
+	invalid := shared.HistogramValue{Bounds: []float64{0.1}, Counts: []uint64{1}, Count: 1}
+	recorder = serve(http.MethodPost, "/update/", shared.Metric{ID: "latency", MType: shared.Histogram, Histogram: &invalid})
+	require.Equal(t, http.StatusBadRequest, recorder.Code)
+
+	// the median is the 4th of 8 observations, the 2nd of 4 in the (0.1, 0.5] bucket
+	recorder = serve(http.MethodGet, "/value/histogram/latency", nil)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	median, err := strconv.ParseFloat(recorder.Body.String(), 64)
+	require.NoError(t, err)
+	require.InDelta(t, 0.3, median, 1e-9)
+	recorder = serve(http.MethodGet, "/value/histogram/latency?quantile=0.99", nil)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	require.Equal(t, "1", recorder.Body.String())
+	recorder = serve(http.MethodGet, "/value/histogram/latency?quantile=2", nil)
+	require.Equal(t, http.StatusBadRequest, recorder.Code)
+	recorder = serve(http.MethodGet, "/value/histogram/unknown", nil)
+	require.Equal(t, http.StatusNotFound, recorder.Code)
+
+	recorder = serve(http.MethodPost, "/value?quantile=0.25&quantile=0.5", shared.Metric{ID: "latency", MType: shared.Histogram})
+	require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+	metric = shared.Metric{}
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &metric))
+	require.Len(t, metric.Quantiles, 2)
+	require.InDelta(t, 0.1, metric.Quantiles["0.25"], 1e-9)
+	require.InDelta(t, 0.3, metric.Quantiles["0.5"], 1e-9)
+	require.Equal(t, uint64(8), metric.Histogram.Count)
+
+	// other bounds reset the histogram
+	recorder = serve(http.MethodPost, "/update/histogram/latency/3?bounds=1,5", nil)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	recorder = serve(http.MethodGet, "/metrics/export", nil)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	require.Equal(t, `# TYPE latency histogram
+latency_bucket{le="1"} 0
+latency_bucket{le="5"} 1
+latency_bucket{le="+Inf"} 1
+latency_sum 3
+latency_count 1
+`, recorder.Body.String())
+}
+
+func TestHistogramObservations(t *testing.T) {
+	repo := repository.NewInMemoryRepository()
+	router := application.NewRouter(repo)
+
+	// an infinite sum can't be saved as JSON
+	for _, value := range []string{"Inf", "+Inf", "-Inf", "NaN", "1e999"} {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/update/histogram/latency/"+value, nil))
+		require.Equal(t, http.StatusBadRequest, recorder.Code, value)
+	}
+	recorder := httptest.NewRecorder()
+	body := strings.NewReader(`{"id":"latency","type":"histogram","histogram":{"bounds":[1],"counts":[0,1],"sum":1e999,"count":1}}`)
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/update/", body))
+	require.Equal(t, http.StatusBadRequest, recorder.Code)
+	_, err := repo.GetHistogram("latency")
+	require.Error(t, err, "rejected observations must not create the histogram")
+
+	// the buckets are chosen under the repository lock, so concurrent observations aren't lost
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/update/histogram/latency/0?bounds=1,2", nil))
+	require.Equal(t, http.StatusOK, recorder.Code)
+	const observations = 100
+	var wg sync.WaitGroup
+	for i := 0; i < observations; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/update/histogram/latency/"+strconv.Itoa(i%3), nil))
+			require.Equal(t, http.StatusOK, recorder.Code)
+		}(i)
+	}
+	wg.Wait()
+	histogram, err := repo.GetHistogram("latency")
+	require.NoError(t, err)
+	require.Equal(t, []float64{1, 2}, histogram.Bounds)
+	require.Equal(t, uint64(observations+1), histogram.Count)
+	require.NoError(t, histogram.Validate())
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gonozov0/go-musthave-devops/internal/server/application"
+	"github.com/gonozov0/go-musthave-devops/internal/server/repository"
+	inmemory "github.com/gonozov0/go-musthave-devops/internal/server/repository/in_memory"
+)
+
+func TestGetHistory(t *testing.T) {
+	repo := inmemory.NewInMemoryRepository()
+	router := application.NewRouter(repo)
+
+	start := time.Now().Add(-10 * time.Minute).Truncate(time.Minute)
+	_, err := repo.UpdateGauges([]repository.GaugeMetric{
+		{Name: "Alloc", Value: 1, Timestamp: start},
+		{Name: "Alloc", Value: 3, Timestamp: start.Add(20 * time.Second)},
+		{Name: "Alloc", Value: 8, Timestamp: start.Add(time.Minute)},
+	})
+	require.NoError(t, err)
+	from, to := start.UnixMilli(), time.Now().UnixMilli()
+
+	testCases := []struct {
+		name         string
+		url          string
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			name:         "TestUnknownType",
+			url:          "/api/v1/history/histogram/Alloc",
+			expectedCode: http.StatusBadRequest,
+			expectedBody: "Unknown metric type\n",
+		},
+		{
+			name:         "TestInvalidStep",
+			url:          "/api/v1/history/gauge/Alloc?step=-1s",
+			expectedCode: http.StatusBadRequest,
+			expectedBody: "Invalid step\n",
+		},
+		{
+			name:         "TestTooManyBuckets",
+			url:          "/api/v1/history/gauge/Alloc?step=0.1",
+			expectedCode: http.StatusBadRequest,
+			expectedBody: "Too many points for the step, increase it or narrow the range\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tc.url, nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			require.Equal(t, tc.expectedCode, w.Code)
+			require.Equal(t, tc.expectedBody, w.Body.String())
+		})
+	}
+
+	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/v1/history/gauge/Alloc?from=%d&to=%d", from, to), nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	var points []repository.HistoryPoint
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &points))
+	require.Len(t, points, 3)
+	require.Equal(t, 3.0, points[1].Value)
+
+	req = httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/v1/history/gauge/Alloc?from=%d&to=%d&step=60", from, to), nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	var aggregates []repository.HistoryAggregate
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &aggregates))
+	require.Len(t, aggregates, 2)
+	require.True(t, start.Equal(aggregates[0].Timestamp))
+	require.Equal(t, repository.HistoryAggregate{
+		Timestamp: aggregates[0].Timestamp, Value: 2, Min: 1, Max: 3, Last: 3, Sum: 4, Count: 2,
+	}, aggregates[0])
+	require.Equal(t, 8.0, aggregates[1].Value)
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gonozov0/go-musthave-devops/internal/server/application"
+	repository "github.com/gonozov0/go-musthave-devops/internal/server/repository/in_memory"
+)
+
+func TestWriteInflux(t *testing.T) {
+	repo := repository.NewInMemoryRepository()
+	router := application.NewRouter(repo, application.WithTimestampWindow(time.Hour, time.Minute))
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+
+	testCases := []struct {
+		name         string
+		url          string
+		body         string
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			name:         "TestV1",
+			url:          "/write?db=telegraf&precision=s",
+			body:         "cpu,host=a usage_idle=90,usage_user=5i " + now + "\n",
+			expectedCode: http.StatusNoContent,
+		},
+		{
+			name:         "TestV2",
+			url:          "/api/v2/write?org=ops&bucket=telegraf",
+			body:         "mem,host=a free=1024i\n",
+			expectedCode: http.StatusNoContent,
+		},
+		{
+			name:         "TestParseErrors",
+			url:          "/api/v2/write",
+			body:         "mem,host=a free=1i\nmem free=oops\nmem\n",
+			expectedCode: http.StatusBadRequest,
+			expectedBody: `{"code":"invalid","message":"2 lines rejected: line 2: invalid value of field \"free\": ` +
+				`strconv.ParseFloat: parsing \"oops\": invalid syntax; line 3: missing fields"}`,
+		},
+		{
+			name:         "TestTimestampTooOld",
+			url:          "/write?precision=s",
+			body:         "mem free=1i 1\n",
+			expectedCode: http.StatusBadRequest,
+			expectedBody: `{"error":"1 lines rejected: line 1: metric timestamp is too old"}`,
+		},
+		{
+			name:         "TestInvalidPrecision",
+			url:          "/write?precision=d",
+			body:         "mem free=1i\n",
+			expectedCode: http.StatusBadRequest,
+			expectedBody: `{"error":"invalid precision"}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tc.url, strings.NewReader(tc.body))
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			require.Equal(t, tc.expectedCode, w.Code, w.Body.String())
+			if tc.expectedBody != "" {
+				require.JSONEq(t, tc.expectedBody, w.Body.String())
+			}
+		})
+	}
+
+	gauge, err := repo.GetGauge(`cpu_usage_idle{host="a"}`)
+	require.NoError(t, err)
+	require.Equal(t, 90.0, gauge)
+	gauge, err = repo.GetGauge(`mem_free{host="a"}`)
+	require.NoError(t, err)
+	require.Equal(t, 1024.0, gauge)
+	_, err = repo.GetGauge("mem_free")
+	require.Error(t, err)
+}
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/gonozov0/go-musthave-devops/internal/server/application"
+	repository "github.com/gonozov0/go-musthave-devops/internal/server/repository/in_memory"
+)
+
+func TestExportOTLPMetrics(t *testing.T) {
+	repo := repository.NewInMemoryRepository()
+	router := application.NewRouter(repo, application.WithTimestampWindow(time.Hour, time.Minute))
+	now := time.Now().UnixNano()
+
+	send := func(contentType string, body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/metrics", bytes.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// the JSON encoding uses integer enums and string int64 values
+	jsonBody := fmt.Sprintf(`{"resourceMetrics":[{
+		"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"checkout"}}]},
+		"scopeMetrics":[{"metrics":[
+			{"name":"requests","sum":{"aggregationTemporality":2,"isMonotonic":true,
+				"dataPoints":[{"startTimeUnixNano":"1","timeUnixNano":"%d","asInt":"5"}]}},
+			{"name":"temperature","gauge":{"dataPoints":[{"timeUnixNano":"%d","asDouble":21.5}]}}
+		]}]
+	}]}`, now, now-2*time.Hour.Nanoseconds())
+	w := send("application/json", []byte(jsonBody))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	require.JSONEq(t, `{"partialSuccess":{"rejectedDataPoints":"1","errorMessage":"temperature{service.name=\"checkout\"}: metric timestamp is too old"}}`, w.Body.String())
+
+	counter, err := repo.GetCounter(`requests{service.name="checkout"}`)
+	require.NoError(t, err)
+	require.Equal(t, int64(5), counter)
+
+	body, err := proto.Marshal(&colmetricspb.ExportMetricsServiceRequest{ResourceMetrics: []*metricspb.ResourceMetrics{{
+		ScopeMetrics: []*metricspb.ScopeMetrics{{Metrics: []*metricspb.Metric{{
+			Name: "temperature",
+			Data: &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{DataPoints: []*metricspb.NumberDataPoint{{
+				TimeUnixNano: uint64(now),
+				Value:        &metricspb.NumberDataPoint_AsDouble{AsDouble: 20},
+			}}}},
+		}}}},
+	}}})
+	require.NoError(t, err)
+	w = send("application/x-protobuf", body)
+	require.Equal(t, http.StatusOK, w.Code)
+	resp := &colmetricspb.ExportMetricsServiceResponse{}
+	require.NoError(t, proto.Unmarshal(w.Body.Bytes(), resp))
+	require.Nil(t, resp.GetPartialSuccess())
+
+	gauge, err := repo.GetGauge("temperature")
+	require.NoError(t, err)
+	require.Equal(t, 20.0, gauge)
+
+	require.Equal(t, http.StatusBadRequest, send("application/x-protobuf", []byte{0xff}).Code)
+	require.Equal(t, http.StatusUnsupportedMediaType, send("text/plain", body).Code)
+}
@@ -0,0 +1,72 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gonozov0/go-musthave-devops/internal/server/application"
+	"github.com/gonozov0/go-musthave-devops/internal/server/profiles"
+	repository "github.com/gonozov0/go-musthave-devops/internal/server/repository/in_memory"
+)
+
+func TestProfiles(t *testing.T) {
+	repo := repository.NewInMemoryRepository()
+
+	t.Run("without profile store", func(t *testing.T) {
+		router := application.NewRouter(repo)
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/api/v1/profiles?source=a&type=cpu", strings.NewReader("x")))
+		require.Equal(t, http.StatusNotImplemented, recorder.Code)
+	})
+
+	store, err := profiles.NewFileStore(t.TempDir(), profiles.Retention{})
+	require.NoError(t, err)
+	router := application.NewRouter(repo, application.WithProfileStore(store))
+
+	upload := func(query, body string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/api/v1/profiles?"+query, strings.NewReader(body)))
+		return recorder
+	}
+
+	recorder := upload("source=agent1&type=cpu&timestamp=1700000000000", "cpu profile")
+	require.Equal(t, http.StatusCreated, recorder.Code)
+	var cpu profiles.Profile
+	require.NoError(t, json.NewDecoder(recorder.Body).Decode(&cpu))
+	require.Equal(t, int64(1700000000000), cpu.Timestamp.UnixMilli())
+
+	require.Equal(t, http.StatusCreated, upload("source=agent1&type=heap", "heap profile").Code)
+	require.Equal(t, http.StatusBadRequest, upload("source=agent1&type=heap", "").Code)
+	require.Equal(t, http.StatusBadRequest, upload("type=heap", "heap profile").Code)
+	require.Equal(t, http.StatusBadRequest, upload("source=agent1&type=heap&timestamp=yesterday", "x").Code)
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(
+		http.MethodGet,
+		"/api/v1/profiles?source=agent1&type=cpu&from=2023-11-14T00:00:00Z&to=1700000000000",
+		nil,
+	))
+	require.Equal(t, http.StatusOK, recorder.Code)
+	var list []profiles.Profile
+	require.NoError(t, json.NewDecoder(recorder.Body).Decode(&list))
+	require.Len(t, list, 1)
+	require.Equal(t, cpu.ID, list[0].ID)
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/profiles/"+cpu.ID, nil))
+	require.Equal(t, http.StatusOK, recorder.Code)
+	require.Equal(t, "application/octet-stream", recorder.Header().Get("Content-Type"))
+	data, err := io.ReadAll(recorder.Body)
+	require.NoError(t, err)
+	require.Equal(t, "cpu profile", string(data))
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/profiles/1_cpu_bm9uZQ", nil))
+	require.Equal(t, http.StatusNotFound, recorder.Code)
+}
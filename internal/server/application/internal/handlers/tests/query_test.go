@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gonozov0/go-musthave-devops/internal/server/application"
+	repository "github.com/gonozov0/go-musthave-devops/internal/server/repository/in_memory"
+)
+
+type queryResponse struct {
+	Status    string `json:"status"`
+	ErrorType string `json:"errorType"`
+	Data      struct {
+		ResultType string          `json:"resultType"`
+		Result     json.RawMessage `json:"result"`
+	} `json:"data"`
+}
+
+type querySeries struct {
+	Metric map[string]string `json:"metric"`
+	Value  []interface{}     `json:"value"`
+	Values [][]interface{}   `json:"values"`
+}
+
+func decodeSeries(t *testing.T, response queryResponse) []querySeries {
+	var series []querySeries
+	require.NoError(t, json.Unmarshal(response.Data.Result, &series))
+	return series
+}
+
+func TestQuery(t *testing.T) {
+	repo := repository.NewInMemoryRepository()
+	router := application.NewRouter(repo)
+
+	_, err := repo.UpdateCounter(`http_requests{code="200"}`, 10)
+	require.NoError(t, err)
+	_, err = repo.UpdateCounter(`http_requests{code="500"}`, 2)
+	require.NoError(t, err)
+	_, err = repo.UpdateGauge("Alloc", 1024)
+	require.NoError(t, err)
+
+	serve := func(method, target string, form url.Values) (int, queryResponse) {
+		var request *http.Request
+		if method == http.MethodPost {
+			request = httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+			request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		} else {
+			request = httptest.NewRequest(method, target+"?"+form.Encode(), nil)
+		}
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+		var response queryResponse
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response), recorder.Body.String())
+		return recorder.Code, response
+	}
+
+	now := time.Now().Add(time.Second)
+	code, response := serve(http.MethodGet, "/api/v1/query", url.Values{
+		"query": {`sum(http_requests)`},
+		"time":  {fmt.Sprintf("%.3f", float64(now.UnixMilli())/1000)},
+	})
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "success", response.Status)
+	require.Equal(t, "vector", response.Data.ResultType)
+	series := decodeSeries(t, response)
+	require.Len(t, series, 1)
+	require.Equal(t, map[string]string{}, series[0].Metric)
+	require.Equal(t, []interface{}{float64(now.UnixMilli()) / 1000, "12"}, series[0].Value)
+
+	code, response = serve(http.MethodPost, "/api/v1/query", url.Values{"query": {`Alloc > 1000`}})
+	require.Equal(t, http.StatusOK, code)
+	series = decodeSeries(t, response)
+	require.Len(t, series, 1)
+	require.Equal(t, map[string]string{"__name__": "Alloc"}, series[0].Metric)
+	require.Equal(t, "1024", series[0].Value[1])
+
+	code, response = serve(http.MethodGet, "/api/v1/query", url.Values{"query": {"1 / 0"}})
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "scalar", response.Data.ResultType)
+	var scalar []interface{}
+	require.NoError(t, json.Unmarshal(response.Data.Result, &scalar))
+	require.Equal(t, "+Inf", scalar[1])
+
+	code, response = serve(http.MethodPost, "/api/v1/query_range", url.Values{
+		"query": {`increase(http_requests{code="500"}[1m])`},
+		"start": {now.Add(-time.Minute).Format(time.RFC3339Nano)},
+		"end":   {now.Format(time.RFC3339Nano)},
+		"step":  {"30s"},
+	})
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "matrix", response.Data.ResultType)
+	series = decodeSeries(t, response)
+	require.Len(t, series, 1)
+	require.Equal(t, map[string]string{"code": "500"}, series[0].Metric)
+	values := series[0].Values
+	require.Equal(t, "2", values[len(values)-1][1])
+
+	code, response = serve(http.MethodGet, "/api/v1/query", url.Values{"query": {"sum("}})
+	require.Equal(t, http.StatusBadRequest, code)
+	require.Equal(t, "error", response.Status)
+	require.Equal(t, "bad_data", response.ErrorType)
+
+	code, response = serve(http.MethodGet, "/api/v1/query_range", url.Values{
+		"query": {"Alloc"},
+		"start": {"0"},
+		"end":   {"86400"},
+		"step":  {"1"},
+	})
+	require.Equal(t, http.StatusBadRequest, code)
+	require.Equal(t, "bad_data", response.ErrorType)
+}
+
+func TestLabels(t *testing.T) {
+	repo := repository.NewInMemoryRepository()
+	router := application.NewRouter(repo)
+
+	_, err := repo.UpdateCounter(`http_requests{code="200"}`, 10)
+	require.NoError(t, err)
+	_, err = repo.UpdateGauge(`temperature{room="kitchen"}`, 21)
+	require.NoError(t, err)
+
+	serve := func(target string) (int, string) {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, target, nil))
+		return recorder.Code, recorder.Body.String()
+	}
+
+	code, body := serve("/api/v1/labels")
+	require.Equal(t, http.StatusOK, code)
+	require.JSONEq(t, `{"status":"success","data":["__name__","code","room"]}`, body)
+
+	code, body = serve("/api/v1/label/__name__/values")
+	require.Equal(t, http.StatusOK, code)
+	require.JSONEq(t, `{"status":"success","data":["http_requests","temperature"]}`, body)
+
+	code, body = serve("/api/v1/series?" + url.Values{"match[]": {`{room=~"kit.*"}`}}.Encode())
+	require.Equal(t, http.StatusOK, code)
+	require.JSONEq(t, `{"status":"success","data":[{"__name__":"temperature","room":"kitchen"}]}`, body)
+
+	code, _ = serve("/api/v1/series")
+	require.Equal(t, http.StatusBadRequest, code)
+}
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gonozov0/go-musthave-devops/internal/server/application"
+	"github.com/gonozov0/go-musthave-devops/internal/server/remotewrite"
+	repository "github.com/gonozov0/go-musthave-devops/internal/server/repository/in_memory"
+)
+
+func TestRemoteWrite(t *testing.T) {
+	repo := repository.NewInMemoryRepository()
+	converter, err := remotewrite.NewConverter(nil, []string{"build_info"})
+	require.NoError(t, err)
+	router := application.NewRouter(
+		repo,
+		application.WithTimestampWindow(time.Hour, time.Minute),
+		application.WithRemoteWriteConverter(converter),
+	)
+	now := time.Now().UnixMilli()
+
+	send := func(req remotewrite.WriteRequest, contentEncoding string) *httptest.ResponseRecorder {
+		httpReq := httptest.NewRequest(http.MethodPost, "/api/v1/write", bytes.NewReader(remotewrite.EncodeWriteRequest(req)))
+		httpReq.Header.Set("Content-Type", "application/x-protobuf")
+		httpReq.Header.Set("Content-Encoding", contentEncoding)
+		httpReq.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httpReq)
+		return w
+	}
+
+	w := send(remotewrite.WriteRequest{
+		Timeseries: []remotewrite.TimeSeries{
+			{
+				Labels:  map[string]string{"__name__": "http_requests_total", "code": "200"},
+				Samples: []remotewrite.Sample{{Value: 10, Timestamp: now - 1000}, {Value: 12, Timestamp: now}},
+			},
+			{
+				Labels:  map[string]string{"__name__": "build_info_total"},
+				Samples: []remotewrite.Sample{{Value: 1, Timestamp: now}},
+			},
+			{
+				Labels:  map[string]string{"__name__": "build_info", "version": "1.0"},
+				Samples: []remotewrite.Sample{{Value: 1, Timestamp: now}},
+			},
+		},
+		Metadata: []remotewrite.MetricMetadata{{Type: remotewrite.MetadataGauge, FamilyName: "build_info_total"}},
+	}, "snappy")
+	require.Equal(t, http.StatusNoContent, w.Code, w.Body.String())
+
+	counter, err := repo.GetCounter(`http_requests_total{code="200"}`)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), counter) // 10 is the baseline
+	gauge, err := repo.GetGauge("build_info_total")
+	require.NoError(t, err)
+	require.Equal(t, 1.0, gauge)
+	gauge, err = repo.GetGauge(`build_info{version="1.0"}`)
+	require.NoError(t, err)
+	require.Equal(t, 1.0, gauge)
+
+	// valid samples are stored even when the others are rejected
+	w = send(remotewrite.WriteRequest{Timeseries: []remotewrite.TimeSeries{
+		{
+			Labels:  map[string]string{"__name__": "http_requests_total", "code": "200"},
+			Samples: []remotewrite.Sample{{Value: 15, Timestamp: now}, {Value: 20, Timestamp: now - 2*time.Hour.Milliseconds()}},
+		},
+		{Labels: map[string]string{"job": "node"}, Samples: []remotewrite.Sample{{Value: 1, Timestamp: now}}},
+	}}, "snappy")
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.Contains(t, w.Body.String(), "2 samples rejected")
+	counter, err = repo.GetCounter(`http_requests_total{code="200"}`)
+	require.NoError(t, err)
+	require.Equal(t, int64(5), counter)
+
+	w = send(remotewrite.WriteRequest{}, "gzip")
+	require.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+
+	httpReq := httptest.NewRequest(http.MethodPost, "/api/v1/write", bytes.NewReader([]byte("garbage")))
+	httpReq.Header.Set("Content-Encoding", "snappy")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httpReq)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gonozov0/go-musthave-devops/internal/server/application"
+	repository "github.com/gonozov0/go-musthave-devops/internal/server/repository/in_memory"
+	"github.com/gonozov0/go-musthave-devops/internal/shared"
+)
+
+func TestSet(t *testing.T) {
+	repo := repository.NewInMemoryRepository()
+	router := application.NewRouter(repo)
+
+	serve := func(method, target string, body any) *httptest.ResponseRecorder {
+		var reader bytes.Buffer
+		if body != nil {
+			require.NoError(t, json.NewEncoder(&reader).Encode(body))
+		}
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(method, target, &reader))
+		return recorder
+	}
+
+	recorder := serve(http.MethodPost, "/update/set/unique_ips/10.0.0.1", nil)
+	require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+
+	recorder = serve(http.MethodPost, "/update/", shared.Metric{
+		ID:      "unique_ips",
+		MType:   shared.Set,
+		Members: []string{"10.0.0.1", "10.0.0.2"},
+	})
+	require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+	var metric shared.Metric
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &metric))
+	require.Equal(t, uint64(2), *metric.Cardinality)
+
+	recorder = serve(http.MethodPost, "/update/", shared.Metric{ID: "unique_ips", MType: shared.Set})
+	require.Equal(t, http.StatusBadRequest, recorder.Code)
+
+	recorder = serve(http.MethodPost, "/updates/", []shared.Metric{
+		{ID: "unique_users", MType: shared.Set, Members: []string{"alice"}},
+		{ID: "unique_ips", MType: shared.Set, Members: []string{"10.0.0.3"}},
+	})
+	require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+
+	recorder = serve(http.MethodGet, "/value/set/unique_ips", nil)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	require.Equal(t, "3", recorder.Body.String())
+	recorder = serve(http.MethodGet, "/value/set/unknown", nil)
+	require.Equal(t, http.StatusNotFound, recorder.Code)
+
+	recorder = serve(http.MethodPost, "/value", shared.Metric{ID: "unique_users", MType: shared.Set})
+	require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+	metric = shared.Metric{}
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &metric))
+	require.Equal(t, uint64(1), *metric.Cardinality)
+
+	recorder = serve(http.MethodGet, "/metrics/export", nil)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	require.Equal(t, "# TYPE unique_ips gauge\nunique_ips 3\n# TYPE unique_users gauge\nunique_users 1\n", recorder.Body.String())
+}
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gonozov0/go-musthave-devops/internal/server/alerting"
+	"github.com/gonozov0/go-musthave-devops/internal/server/application"
+	"github.com/gonozov0/go-musthave-devops/internal/server/repository"
+	inMemory "github.com/gonozov0/go-musthave-devops/internal/server/repository/in_memory"
+)
+
+func TestSilences(t *testing.T) {
+	repo := inMemory.NewInMemoryRepository()
+	silences := alerting.NewSilences(repo.(repository.SilenceRepository))
+	router := application.NewRouter(repo, application.WithSilences(silences))
+
+	serve := func(method, target string, body any) *httptest.ResponseRecorder {
+		var reader bytes.Buffer
+		if body != nil {
+			require.NoError(t, json.NewEncoder(&reader).Encode(body))
+		}
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(method, target, &reader))
+		return recorder
+	}
+
+	silence := repository.Silence{
+		Matchers: []repository.SilenceMatcher{{Name: "alertname", Value: "HighHeap"}},
+		EndsAt:   time.Now().Add(time.Hour),
+		Comment:  "deploy",
+	}
+	recorder := serve(http.MethodPost, "/api/v1/silences", silence)
+	require.Equal(t, http.StatusCreated, recorder.Code, recorder.Body.String())
+	var created repository.Silence
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &created))
+	require.NotEmpty(t, created.ID)
+	require.False(t, created.StartsAt.IsZero())
+
+	created.Comment = "long deploy"
+	recorder = serve(http.MethodPost, "/api/v1/silences", created)
+	require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+	recorder = serve(http.MethodPost, "/api/v1/silences", repository.Silence{EndsAt: time.Now().Add(time.Hour)})
+	require.Equal(t, http.StatusBadRequest, recorder.Code)
+	silence.ID = "unknown"
+	recorder = serve(http.MethodPost, "/api/v1/silences", silence)
+	require.Equal(t, http.StatusNotFound, recorder.Code)
+
+	recorder = serve(http.MethodGet, "/api/v1/silences", nil)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	var listed []repository.Silence
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &listed))
+	require.Len(t, listed, 1)
+	require.Equal(t, "long deploy", listed[0].Comment)
+
+	recorder = serve(http.MethodDelete, "/api/v1/silences/"+created.ID, nil)
+	require.Equal(t, http.StatusNoContent, recorder.Code)
+	recorder = serve(http.MethodDelete, "/api/v1/silences/"+created.ID, nil)
+	require.Equal(t, http.StatusNotFound, recorder.Code)
+
+	router = application.NewRouter(repo)
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/silences", nil))
+	require.Equal(t, http.StatusNotImplemented, recorder.Code)
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/websocket"
+
+	"github.com/gonozov0/go-musthave-devops/internal/server/application"
+	inMemory "github.com/gonozov0/go-musthave-devops/internal/server/repository/in_memory"
+	"github.com/gonozov0/go-musthave-devops/internal/server/stream"
+	"github.com/gonozov0/go-musthave-devops/internal/shared"
+)
+
+func TestStream(t *testing.T) {
+	hub := stream.NewHub(stream.DefaultBufferSize)
+	repo := inMemory.NewInMemoryRepository(inMemory.WithPublisher(hub))
+	server := httptest.NewServer(application.NewRouter(repo, application.WithStreamHub(hub)))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/api/v1/stream?type=gauge&name=Heap.*")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	conn, err := websocket.Dial(strings.Replace(server.URL, "http", "ws", 1)+"/api/v1/stream?type=counter", "", server.URL)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.Eventually(t, func() bool { return hub.Subscribers() == 2 }, time.Second, time.Millisecond)
+
+	_, err = repo.UpdateGauge("PollInterval", 2)
+	require.NoError(t, err)
+	_, err = repo.UpdateGauge("HeapAlloc", 42)
+	require.NoError(t, err)
+	_, err = repo.UpdateCounter("PollCount", 5)
+	require.NoError(t, err)
+
+	reader := bufio.NewReader(resp.Body)
+	line, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	require.Equal(t, "event: metric\n", line)
+	line, err = reader.ReadString('\n')
+	require.NoError(t, err)
+	var metric shared.Metric
+	require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &metric))
+	require.Equal(t, "HeapAlloc", metric.ID)
+	require.Equal(t, 42.0, *metric.Value)
+
+	metric = shared.Metric{}
+	require.NoError(t, websocket.JSON.Receive(conn, &metric))
+	require.Equal(t, shared.Counter, metric.MType)
+	require.Equal(t, int64(5), *metric.Delta)
+
+	badResp, err := http.Get(server.URL + "/api/v1/stream?type=summary")
+	require.NoError(t, err)
+	badResp.Body.Close()
+	require.Equal(t, http.StatusBadRequest, badResp.StatusCode)
+
+	recorder := httptest.NewRecorder()
+	application.NewRouter(repo).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/stream", nil))
+	require.Equal(t, http.StatusNotImplemented, recorder.Code)
+}
+
+func TestStreamShutdown(t *testing.T) {
+	hub := stream.NewHub(stream.DefaultBufferSize)
+	repo := inMemory.NewInMemoryRepository(inMemory.WithPublisher(hub))
+	server := httptest.NewUnstartedServer(application.NewRouter(repo, application.WithStreamHub(hub)))
+	server.Config.RegisterOnShutdown(hub.Close)
+	server.Start()
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/api/v1/stream")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Eventually(t, func() bool { return hub.Subscribers() == 1 }, time.Second, time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, server.Config.Shutdown(ctx))
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Contains(t, string(body), "event: error")
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gonozov0/go-musthave-devops/internal/server/application"
+	repository "github.com/gonozov0/go-musthave-devops/internal/server/repository/in_memory"
+	"github.com/gonozov0/go-musthave-devops/internal/shared"
+)
+
+func TestMetricTimestamps(t *testing.T) {
+	repo := repository.NewInMemoryRepository()
+	router := application.NewRouter(repo, application.WithTimestampWindow(time.Hour, time.Minute))
+
+	now := time.Now()
+	newer, older := now.UnixMilli(), now.Add(-time.Minute).UnixMilli()
+	tooOld, tooNew := now.Add(-2*time.Hour).UnixMilli(), now.Add(time.Hour).UnixMilli()
+	newValue, oldValue := 2.5, 1.5
+
+	testCases := []struct {
+		name          string
+		metric        shared.Metric
+		expectedCode  int
+		expectedErr   string
+		expectedValue float64
+	}{
+		{
+			name:          "TestNewSample",
+			metric:        shared.Metric{ID: "temperature", MType: shared.Gauge, Value: &newValue, Timestamp: &newer},
+			expectedCode:  http.StatusOK,
+			expectedValue: newValue,
+		},
+		{
+			name:          "TestStaleSample",
+			metric:        shared.Metric{ID: "temperature", MType: shared.Gauge, Value: &oldValue, Timestamp: &older},
+			expectedCode:  http.StatusOK,
+			expectedValue: newValue,
+		},
+		{
+			name:         "TestTooOld",
+			metric:       shared.Metric{ID: "temperature", MType: shared.Gauge, Value: &oldValue, Timestamp: &tooOld},
+			expectedCode: http.StatusBadRequest,
+			expectedErr:  "metric timestamp is too old\n",
+		},
+		{
+			name:         "TestInTheFuture",
+			metric:       shared.Metric{ID: "temperature", MType: shared.Gauge, Value: &oldValue, Timestamp: &tooNew},
+			expectedCode: http.StatusBadRequest,
+			expectedErr:  "metric timestamp is too far in the future\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			body, err := json.Marshal(tc.metric)
+			require.NoError(t, err)
+
+			request := httptest.NewRequest("POST", "/update/", bytes.NewReader(body))
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, request)
+
+			require.Equal(t, tc.expectedCode, recorder.Code)
+
+			if tc.expectedCode == http.StatusOK {
+				var metric shared.Metric
+				err = json.NewDecoder(recorder.Body).Decode(&metric)
+				require.NoError(t, err)
+				require.NotNil(t, metric.Value)
+				require.Equal(t, tc.expectedValue, *metric.Value)
+			} else {
+				require.Equal(t, tc.expectedErr, recorder.Body.String())
+			}
+		})
+	}
+}
+
+func TestBatchTimestamps(t *testing.T) {
+	repo := repository.NewInMemoryRepository()
+	router := application.NewRouter(repo, application.WithTimestampWindow(time.Hour, time.Minute))
+
+	tooOld := time.Now().Add(-2 * time.Hour).UnixMilli()
+	value, delta := 1.5, int64(3)
+	body, err := json.Marshal([]shared.Metric{
+		{ID: "temperature", MType: shared.Gauge, Value: &value, Timestamp: &tooOld},
+		{ID: "PollCount", MType: shared.Counter, Delta: &delta},
+	})
+	require.NoError(t, err)
+
+	request := httptest.NewRequest("POST", "/updates/", bytes.NewReader(body))
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	var metrics []shared.Metric
+	require.NoError(t, json.NewDecoder(recorder.Body).Decode(&metrics))
+	require.Len(t, metrics, 1, "the sample that is too old must be skipped")
+	require.Equal(t, "PollCount", metrics[0].ID)
+
+	_, err = repo.GetGauge("temperature")
+	require.Error(t, err)
+	counter, err := repo.GetCounter("PollCount")
+	require.NoError(t, err)
+	require.Equal(t, delta, counter)
+}
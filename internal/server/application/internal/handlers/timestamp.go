@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gonozov0/go-musthave-devops/internal/shared"
+)
+
+var (
+	errTimestampTooOld      = errors.New("metric timestamp is too old")
+	errTimestampInTheFuture = errors.New("metric timestamp is too far in the future")
+)
+
+// checkTimestamp validates the metric timestamp against the accepted window.
+func (h *Handler) checkTimestamp(metric shared.Metric) error {
+	if metric.Timestamp == nil {
+		return nil
+	}
+	now := time.Now()
+	timestamp := time.UnixMilli(*metric.Timestamp)
+	if h.maxPast > 0 && timestamp.Before(now.Add(-h.maxPast)) {
+		return errTimestampTooOld
+	}
+	if h.maxFuture > 0 && timestamp.After(now.Add(h.maxFuture)) {
+		return errTimestampInTheFuture
+	}
+	return nil
+}
+
+// metricTime returns the metric timestamp or zero time when the metric has no timestamp.
+func metricTime(metric shared.Metric) time.Time {
+	if metric.Timestamp == nil {
+		return time.Time{}
+	}
+	return time.UnixMilli(*metric.Timestamp)
+}
+
+// unixMilli returns the pointer to the time in Unix milliseconds.
+func unixMilli(t time.Time) *int64 {
+	timestamp := t.UnixMilli()
+	return &timestamp
+}
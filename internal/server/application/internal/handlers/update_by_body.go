@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"net/http"
 
+	"github.com/gonozov0/go-musthave-devops/internal/server/repository"
 	"github.com/gonozov0/go-musthave-devops/internal/shared"
 
 	log "github.com/sirupsen/logrus"
@@ -18,6 +19,11 @@ func (h *Handler) UpdateMetricByBody(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if err := h.checkTimestamp(metric); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	var (
 		newValue float64
 		newDelta int64
@@ -29,8 +35,19 @@ func (h *Handler) UpdateMetricByBody(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Invalid metric value for type Gauge", http.StatusBadRequest)
 			return
 		}
-		newValue, err = h.repo.UpdateGauge(metric.ID, *metric.Value)
-		metric.Value = &newValue
+		if metric.Timestamp == nil {
+			newValue, err = h.repo.UpdateGauge(metric.ID, *metric.Value)
+			metric.Value = &newValue
+			break
+		}
+		var newGauges []repository.GaugeMetric
+		newGauges, err = h.repo.UpdateGauges([]repository.GaugeMetric{
+			{Name: metric.ID, Value: *metric.Value, Timestamp: metricTime(metric)},
+		})
+		if err == nil && len(newGauges) > 0 {
+			metric.Value = &newGauges[0].Value
+			metric.Timestamp = unixMilli(newGauges[0].Timestamp)
+		}
 	case shared.Counter:
 		if metric.Delta == nil {
 			http.Error(w, "Invalid metric delta for type Counter", http.StatusBadRequest)
@@ -38,6 +55,32 @@ func (h *Handler) UpdateMetricByBody(w http.ResponseWriter, r *http.Request) {
 		}
 		newDelta, err = h.repo.UpdateCounter(metric.ID, *metric.Delta)
 		metric.Delta = &newDelta
+	case shared.Histogram:
+		if metric.Histogram == nil {
+			http.Error(w, "Invalid metric histogram for type Histogram", http.StatusBadRequest)
+			return
+		}
+		if err := metric.Histogram.Validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var newHistograms []repository.HistogramMetric
+		newHistograms, err = h.repo.UpdateHistograms([]repository.HistogramMetric{
+			{Name: metric.ID, Value: *metric.Histogram},
+		})
+		if err == nil && len(newHistograms) > 0 {
+			metric.Histogram = &newHistograms[0].Value
+		}
+	case shared.Set:
+		if len(metric.Members) == 0 {
+			http.Error(w, "Invalid metric members for type Set", http.StatusBadRequest)
+			return
+		}
+		var newSets []repository.SetMetric
+		newSets, err = h.repo.UpdateSets([]repository.SetMetric{{Name: metric.ID, Members: metric.Members}})
+		if err == nil && len(newSets) > 0 {
+			metric.Cardinality = &newSets[0].Cardinality
+		}
 	default:
 		// Must be 400, return 501 because of autotests.
 		http.Error(w, "Unknown metric type", http.StatusNotImplemented)
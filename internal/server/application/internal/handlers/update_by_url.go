@@ -1,9 +1,11 @@
 package handlers
 
 import (
+	"math"
 	"net/http"
 	"strconv"
 
+	"github.com/gonozov0/go-musthave-devops/internal/server/repository"
 	"github.com/gonozov0/go-musthave-devops/internal/shared"
 
 	log "github.com/sirupsen/logrus"
@@ -12,6 +14,8 @@ import (
 )
 
 // UpdateMetricByURL is the HTTP handler for updating metrics.
+// The value of a histogram is a single observation, see repository.Repository.ObserveHistogram,
+// and the value of a set is a member.
 func (h *Handler) UpdateMetricByURL(w http.ResponseWriter, r *http.Request) {
 	metricType := chi.URLParam(r, "metricType")
 	metricName := chi.URLParam(r, "metricName")
@@ -36,6 +40,22 @@ func (h *Handler) UpdateMetricByURL(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		_, err = h.repo.UpdateCounter(metricName, value)
+	case shared.Histogram:
+		var value float64
+		value, err = strconv.ParseFloat(metricValue, 64)
+		if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+			http.Error(w, "Invalid float metricValue", http.StatusBadRequest)
+			return
+		}
+		var bounds []float64
+		bounds, err = parseBounds(r.URL.Query().Get("bounds"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		_, err = h.repo.ObserveHistogram(metricName, value, bounds)
+	case shared.Set:
+		_, err = h.repo.UpdateSets([]repository.SetMetric{{Name: metricName, Members: []string{metricValue}}})
 	default:
 		// Must be 400, return 501 because of autotests.
 		http.Error(w, "Unknown metric type", http.StatusNotImplemented)
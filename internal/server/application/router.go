@@ -1,33 +1,136 @@
 package application
 
 import (
+	"time"
+
 	"github.com/go-chi/chi/v5"
 	chiMiddleware "github.com/go-chi/chi/v5/middleware"
+	"github.com/gonozov0/go-musthave-devops/internal/ingest"
+	"github.com/gonozov0/go-musthave-devops/internal/server/alerting"
+	"github.com/gonozov0/go-musthave-devops/internal/server/influx"
+	"github.com/gonozov0/go-musthave-devops/internal/server/otlp"
+	"github.com/gonozov0/go-musthave-devops/internal/server/profiles"
+	"github.com/gonozov0/go-musthave-devops/internal/server/remotewrite"
 	"github.com/gonozov0/go-musthave-devops/internal/server/repository"
+	"github.com/gonozov0/go-musthave-devops/internal/server/stream"
 
 	"github.com/gonozov0/go-musthave-devops/internal/server/application/internal/handlers"
-	"github.com/gonozov0/go-musthave-devops/internal/server/application/internal/middleware"
 )
 
-func NewRouter(repo repository.Repository) *chi.Mux {
-	handler := handlers.NewHandler(repo)
+// Option configures optional dependencies of the router.
+type Option = handlers.Option
+
+// TargetsProvider returns the current scrape targets with their last scrape status.
+type TargetsProvider = handlers.TargetsProvider
+
+// WithTargetsProvider exposes the scrape targets status on /api/v1/targets.
+func WithTargetsProvider(targets TargetsProvider) Option {
+	return handlers.WithTargetsProvider(targets)
+}
+
+// WithProfileStore exposes profile uploads and downloads on /api/v1/profiles.
+func WithProfileStore(store profiles.Store) Option {
+	return handlers.WithProfileStore(store)
+}
+
+// AlertManager manages the alerting rules and their alerts.
+type AlertManager = handlers.AlertManager
+
+// WithAlertManager exposes the alerting rules on /api/v1/rules and the alerts on /api/v1/alerts.
+func WithAlertManager(alerts AlertManager) Option {
+	return handlers.WithAlertManager(alerts)
+}
+
+// WithSilences exposes the alert silences on /api/v1/silences.
+func WithSilences(silences *alerting.Silences) Option {
+	return handlers.WithSilences(silences)
+}
+
+// WithStreamHub exposes the metric updates published to the hub on /api/v1/stream.
+func WithStreamHub(hub *stream.Hub) Option {
+	return handlers.WithStreamHub(hub)
+}
+
+// WithTimestampWindow rejects metrics with timestamps older than maxPast or further than maxFuture
+// from the server time. Zero durations disable the corresponding check.
+func WithTimestampWindow(maxPast, maxFuture time.Duration) Option {
+	return handlers.WithTimestampWindow(maxPast, maxFuture)
+}
+
+// WithRemoteWriteConverter sets the converter of the series received on /api/v1/write.
+func WithRemoteWriteConverter(converter *remotewrite.Converter) Option {
+	return handlers.WithRemoteWriteConverter(converter)
+}
+
+// WithOTLPConverter sets the converter of the data points received on /v1/metrics.
+func WithOTLPConverter(converter *otlp.Converter) Option {
+	return handlers.WithOTLPConverter(converter)
+}
+
+// WithInfluxConverter sets the converter of the line protocol received on /write and /api/v2/write.
+func WithInfluxConverter(converter *influx.Converter) Option {
+	return handlers.WithInfluxConverter(converter)
+}
+
+func NewRouter(repo repository.Repository, opts ...Option) *chi.Mux {
+	handler := handlers.NewHandler(repo, opts...)
 	router := chi.NewRouter()
 
 	router.Use(chiMiddleware.Logger)
 	router.Use(chiMiddleware.Recoverer)
 	router.Use(chiMiddleware.StripSlashes)
-	router.Use(middleware.GzipMiddleware)
 
-	router.Get("/ping", handler.Ping)
+	// the remote write body is a snappy block declared by Content-Encoding, which the handler decodes itself
+	router.Post("/api/v1/write", handler.RemoteWrite)
+	// the stream is flushed event by event and upgrades to WebSocket, so it isn't compressed
+	router.Get("/api/v1/stream", handler.Stream)
+
+	router.Group(func(router chi.Router) {
+		router.Use(ingest.CompressionMiddleware)
+
+		router.Get("/ping", handler.Ping)
+
+		router.Get("/", handler.GetAllMetrics)
+		router.Get("/metrics/export", handler.ExportMetrics)
+
+		router.Get("/value/{metricType}/{metricName}", handler.GetMetricByURL)
+		router.Post("/value", handler.GetMetricByBody)
+
+		router.Post("/update/{metricType}/{metricName}/{metricValue}", handler.UpdateMetricByURL)
+		router.Post("/update", handler.UpdateMetricByBody)
+		router.Post("/updates", handler.BatchUpdateMetrics)
+
+		router.Get("/api/v1/targets", handler.GetTargets)
+
+		router.Post("/v1/metrics", handler.ExportOTLPMetrics)
+		router.Post("/write", handler.WriteInfluxV1)
+		router.Post("/api/v2/write", handler.WriteInfluxV2)
+
+		router.Get("/api/v1/history/{metricType}/{metricName}", handler.GetHistory)
 
-	router.Get("/", handler.GetAllMetrics)
+		// the PromQL API accepts both methods like Prometheus, Grafana posts the queries as forms
+		router.Get("/api/v1/query", handler.Query)
+		router.Post("/api/v1/query", handler.Query)
+		router.Get("/api/v1/query_range", handler.QueryRange)
+		router.Post("/api/v1/query_range", handler.QueryRange)
+		router.Get("/api/v1/series", handler.Series)
+		router.Post("/api/v1/series", handler.Series)
+		router.Get("/api/v1/labels", handler.LabelNames)
+		router.Post("/api/v1/labels", handler.LabelNames)
+		router.Get("/api/v1/label/{name}/values", handler.LabelValues)
 
-	router.Get("/value/{metricType}/{metricName}", handler.GetMetricByURL)
-	router.Post("/value", handler.GetMetricByBody)
+		router.Get("/api/v1/rules", handler.GetRules)
+		router.Post("/api/v1/rules", handler.SetRule)
+		router.Delete("/api/v1/rules/{name}", handler.DeleteRule)
+		router.Get("/api/v1/alerts", handler.GetAlerts)
+		router.Get("/api/v1/silences", handler.GetSilences)
+		router.Post("/api/v1/silences", handler.CreateSilence)
+		router.Delete("/api/v1/silences/{id}", handler.DeleteSilence)
 
-	router.Post("/update/{metricType}/{metricName}/{metricValue}", handler.UpdateMetricByURL)
-	router.Post("/update", handler.UpdateMetricByBody)
-	router.Post("/updates", handler.BatchUpdateMetrics)
+		router.Post("/api/v1/profiles", handler.UploadProfile)
+		router.Get("/api/v1/profiles", handler.ListProfiles)
+		router.Get("/api/v1/profiles/{id}", handler.DownloadProfile)
+	})
 
 	return router
 }
@@ -0,0 +1,213 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+
+	"github.com/gonozov0/go-musthave-devops/internal/server/scrape"
+)
+
+// StaticSource is the source of targets listed in the server config.
+const StaticSource = "static"
+
+// TargetGroup is a struct that represents a group of targets sharing the same labels.
+// Target files contain a list of groups in JSON or YAML:
+//
+//	[{"targets": ["10.0.0.1:8081", "http://10.0.0.2:8081/metrics"], "labels": {"zone": "dmz"}}]
+type TargetGroup struct {
+	Targets []string          `json:"targets" yaml:"targets"`
+	Labels  map[string]string `json:"labels" yaml:"labels"`
+}
+
+// TargetsUpdater receives the full list of discovered targets every time it changes.
+type TargetsUpdater interface {
+	SetTargets(targets []scrape.Target)
+}
+
+// FileDiscoverer polls target files matching the patterns and updates the scrape targets on changes.
+type FileDiscoverer struct {
+	patterns []string
+	static   []scrape.Target
+	interval time.Duration
+	updater  TargetsUpdater
+
+	groups  map[string][]TargetGroup // last successfully parsed groups by file
+	targets []scrape.Target
+}
+
+// NewFileDiscoverer creates a new FileDiscoverer. Static targets are always kept in the target list.
+func NewFileDiscoverer(
+	patterns []string,
+	static []string,
+	interval time.Duration,
+	updater TargetsUpdater,
+) *FileDiscoverer {
+	staticTargets := make([]scrape.Target, 0, len(static))
+	for _, address := range static {
+		staticTargets = append(staticTargets, scrape.Target{URL: targetURL(address), Source: StaticSource})
+	}
+	return &FileDiscoverer{
+		patterns: patterns,
+		static:   staticTargets,
+		interval: interval,
+		updater:  updater,
+		groups:   make(map[string][]TargetGroup),
+	}
+}
+
+// Run refreshes the targets immediately and then every interval until the context is canceled.
+func (d *FileDiscoverer) Run(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+	for {
+		d.Refresh()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Refresh re-reads the target files and passes the targets to the updater if they changed.
+// A file that fails to parse keeps its previous targets, a file that disappeared loses them.
+func (d *FileDiscoverer) Refresh() {
+	files := make(map[string]struct{})
+	for _, pattern := range d.patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			log.Errorf("invalid target files pattern %q: %v", pattern, err)
+			continue
+		}
+		for _, match := range matches {
+			files[match] = struct{}{}
+		}
+	}
+
+	for file := range d.groups {
+		if _, ok := files[file]; !ok {
+			delete(d.groups, file)
+		}
+	}
+	for file := range files {
+		groups, err := readTargetFile(file)
+		if err != nil {
+			log.Errorf("failed to read target file %s: %v", file, err)
+			continue
+		}
+		d.groups[file] = groups
+	}
+
+	targets := d.buildTargets()
+	if reflect.DeepEqual(d.targets, targets) {
+		return
+	}
+	added, removed := diffTargets(d.targets, targets)
+	for _, target := range added {
+		log.Infof("Discovered scrape target %s from %s", target.URL, target.Source)
+	}
+	for _, target := range removed {
+		log.Infof("Removed scrape target %s from %s", target.URL, target.Source)
+	}
+
+	d.targets = targets
+	d.updater.SetTargets(targets)
+}
+
+// buildTargets merges static and file targets sorted by URL. The first occurrence of a URL wins.
+func (d *FileDiscoverer) buildTargets() []scrape.Target {
+	seen := make(map[string]struct{})
+	targets := make([]scrape.Target, 0, len(d.static))
+	add := func(target scrape.Target) {
+		if _, ok := seen[target.URL]; ok {
+			return
+		}
+		seen[target.URL] = struct{}{}
+		targets = append(targets, target)
+	}
+
+	for _, target := range d.static {
+		add(target)
+	}
+
+	files := make([]string, 0, len(d.groups))
+	for file := range d.groups {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		for _, group := range d.groups[file] {
+			for _, address := range group.Targets {
+				add(scrape.Target{URL: targetURL(address), Labels: group.Labels, Source: file})
+			}
+		}
+	}
+
+	sort.Slice(targets, func(i, j int) bool { return targets[i].URL < targets[j].URL })
+	return targets
+}
+
+// diffTargets returns targets that appear only in the new list and only in the old list.
+func diffTargets(old, new []scrape.Target) ([]scrape.Target, []scrape.Target) {
+	oldURLs := make(map[string]struct{}, len(old))
+	for _, target := range old {
+		oldURLs[target.URL] = struct{}{}
+	}
+	newURLs := make(map[string]struct{}, len(new))
+	var added []scrape.Target
+	for _, target := range new {
+		newURLs[target.URL] = struct{}{}
+		if _, ok := oldURLs[target.URL]; !ok {
+			added = append(added, target)
+		}
+	}
+	var removed []scrape.Target
+	for _, target := range old {
+		if _, ok := newURLs[target.URL]; !ok {
+			removed = append(removed, target)
+		}
+	}
+	return added, removed
+}
+
+func readTargetFile(path string) ([]TargetGroup, error) {
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var groups []TargetGroup
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.Unmarshal(bytes, &groups)
+	case ".yml", ".yaml":
+		err = yaml.Unmarshal(bytes, &groups)
+	default:
+		return nil, fmt.Errorf("unsupported file extension %q", filepath.Ext(path))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal target groups: %w", err)
+	}
+	return groups, nil
+}
+
+// targetURL turns a host:port address into the agent scrape URL. Full URLs are returned as is.
+func targetURL(address string) string {
+	if strings.HasPrefix(address, "http://") || strings.HasPrefix(address, "https://") {
+		return address
+	}
+	return "http://" + address + "/metrics"
+}
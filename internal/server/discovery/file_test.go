@@ -0,0 +1,73 @@
+package discovery
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gonozov0/go-musthave-devops/internal/server/scrape"
+)
+
+type fakeUpdater struct {
+	calls   int
+	targets []scrape.Target
+}
+
+func (u *fakeUpdater) SetTargets(targets []scrape.Target) {
+	u.calls++
+	u.targets = targets
+}
+
+func TestFileDiscoverer(t *testing.T) {
+	dir := t.TempDir()
+	jsonFile := filepath.Join(dir, "agents.json")
+	yamlFile := filepath.Join(dir, "agents.yaml")
+
+	require.NoError(t, os.WriteFile(
+		jsonFile,
+		[]byte(`[{"targets": ["10.0.0.1:8081"], "labels": {"zone": "dmz"}}]`),
+		0644,
+	))
+
+	updater := &fakeUpdater{}
+	discoverer := NewFileDiscoverer(
+		[]string{filepath.Join(dir, "*.json"), filepath.Join(dir, "*.yaml")},
+		[]string{"http://static:8081/metrics"},
+		time.Second,
+		updater,
+	)
+
+	discoverer.Refresh()
+	require.Equal(t, 1, updater.calls)
+	require.Equal(t, []scrape.Target{
+		{URL: "http://10.0.0.1:8081/metrics", Labels: map[string]string{"zone": "dmz"}, Source: jsonFile},
+		{URL: "http://static:8081/metrics", Source: StaticSource},
+	}, updater.targets)
+
+	discoverer.Refresh()
+	require.Equal(t, 1, updater.calls, "unchanged targets must not be updated")
+
+	require.NoError(t, os.WriteFile(
+		yamlFile,
+		[]byte("- targets: [\"http://10.0.0.2:9000/metrics\"]\n  labels:\n    zone: core\n"),
+		0644,
+	))
+	discoverer.Refresh()
+	require.Equal(t, 2, updater.calls)
+	require.Len(t, updater.targets, 3)
+
+	require.NoError(t, os.WriteFile(yamlFile, []byte("not: [valid"), 0644))
+	discoverer.Refresh()
+	require.Equal(t, 2, updater.calls, "broken file must keep its previous targets")
+
+	require.NoError(t, os.Remove(jsonFile))
+	discoverer.Refresh()
+	require.Equal(t, 3, updater.calls)
+	require.Equal(t, []scrape.Target{
+		{URL: "http://10.0.0.2:9000/metrics", Labels: map[string]string{"zone": "core"}, Source: yamlFile},
+		{URL: "http://static:8081/metrics", Source: StaticSource},
+	}, updater.targets)
+}
@@ -0,0 +1,67 @@
+package graphite
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gonozov0/go-musthave-devops/internal/shared"
+)
+
+func TestParsePlaintext(t *testing.T) {
+	sample, err := ParsePlaintext("servers.web1.cpu 12.5 1700000000")
+	require.NoError(t, err)
+	require.Equal(t, Sample{Path: "servers.web1.cpu", Value: 12.5, Timestamp: 1700000000}, sample)
+
+	sample, err = ParsePlaintext("stats.hits;env=prod;dc=eu 3 -1")
+	require.NoError(t, err)
+	require.Equal(t, Sample{Path: "stats.hits", Tags: map[string]string{"env": "prod", "dc": "eu"}, Value: 3}, sample)
+
+	for _, line := range []string{"only.path", "a.b nan 1", "a.b 1 now", "a.b;env 1", "a b c d"} {
+		_, err := ParsePlaintext(line)
+		require.Error(t, err, line)
+	}
+}
+
+func TestParsePickle(t *testing.T) {
+	// pickle.dumps of the same list with the protocols 0, 2 and 4
+	payloads := []string{
+		"286c70300a2856736572766572732e776562312e6370750a70310a2849313730303030303030300a4631322e350a7470320a7470330a61285673746174732e636f756e746572732e686974733b656e763d70726f640a70340a2846313730303030303030302e300a49330a7470350a7470360a6128566269670a70370a28492d310a4c313039393531313632373737364c0a7470380a7470390a612e",
+		"80025d7100285810000000736572766572732e776562312e63707571014a00f15365474029000000000000867102867103581c00000073746174732e636f756e746572732e686974733b656e763d70726f6471044741d954fc400000004b03867105867106580300000062696771074affffffff8a06000000000001867108867109652e",
+		"8004956f000000000000005d94288c10736572766572732e776562312e637075944a00f15365474029000000000000869486948c1c73746174732e636f756e746572732e686974733b656e763d70726f64944741d954fc400000004b03869486948c03626967944affffffff8a0600000000000186948694652e",
+	}
+	expected := []Sample{
+		{Path: "servers.web1.cpu", Value: 12.5, Timestamp: 1700000000},
+		{Path: "stats.counters.hits", Tags: map[string]string{"env": "prod"}, Value: 3, Timestamp: 1700000000},
+		{Path: "big", Value: 1 << 40},
+	}
+	for _, payload := range payloads {
+		data, err := hex.DecodeString(payload)
+		require.NoError(t, err)
+		samples, err := ParsePickle(data)
+		require.NoError(t, err)
+		require.Equal(t, expected, samples)
+	}
+
+	// GLOBAL would import a callable in Python
+	_, err := ParsePickle([]byte("cos\nsystem\n."))
+	require.Error(t, err)
+	_, err = ParsePickle([]byte("(l"))
+	require.Error(t, err)
+}
+
+func TestTemplates(t *testing.T) {
+	templates, err := ParseTemplates([]string{"stats.counters=counter", "stats.*.gauge_*=gauge", "stats=counter"})
+	require.NoError(t, err)
+
+	require.Equal(t, shared.Counter, metricType(templates, "stats.counters.hits.count"))
+	require.Equal(t, shared.Gauge, metricType(templates, "stats.timers.gauge_mean"))
+	require.Equal(t, shared.Counter, metricType(templates, "stats.timers.count"))
+	require.Equal(t, shared.Gauge, metricType(templates, "servers.web1.cpu"))
+
+	_, err = ParseTemplates([]string{"stats=histogram"})
+	require.Error(t, err)
+	_, err = ParseTemplates([]string{"stats.[=counter"})
+	require.Error(t, err)
+}
@@ -0,0 +1,368 @@
+package graphite
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// pickleMark separates the items of the MARK opcode on the stack.
+type pickleMark struct{}
+
+// ParsePickle decodes the payload of the carbon pickle protocol, a list of (path, (timestamp, value)) tuples.
+// Only the opcodes of plain data are supported, so a payload can't run code like Python's unpickler.
+func ParsePickle(data []byte) ([]Sample, error) {
+	value, err := unpickle(data)
+	if err != nil {
+		return nil, err
+	}
+	items, ok := value.([]interface{})
+	if !ok {
+		return nil, errors.New("pickle payload isn't a list")
+	}
+
+	samples := make([]Sample, 0, len(items))
+	for _, item := range items {
+		tuple, ok := item.([]interface{})
+		if !ok || len(tuple) != 2 {
+			return nil, errors.New("expected (path, (timestamp, value)) tuple")
+		}
+		point, ok := tuple[1].([]interface{})
+		if !ok || len(point) != 2 {
+			return nil, errors.New("expected (timestamp, value) tuple")
+		}
+		metricPath, ok := tuple[0].(string)
+		if !ok {
+			return nil, errors.New("path isn't a string")
+		}
+		sample, err := parsePath(metricPath)
+		if err != nil {
+			return nil, err
+		}
+		timestamp, err := pickleNumber(point[0])
+		if err != nil || math.Abs(timestamp) > math.MaxInt64/1e3 {
+			return nil, fmt.Errorf("invalid timestamp of %s", metricPath)
+		}
+		if timestamp > 0 {
+			sample.Timestamp = int64(timestamp)
+		}
+		if sample.Value, err = pickleNumber(point[1]); err != nil || math.IsNaN(sample.Value) || math.IsInf(sample.Value, 0) {
+			return nil, fmt.Errorf("invalid value of %s", metricPath)
+		}
+		samples = append(samples, sample)
+	}
+	return samples, nil
+}
+
+func pickleNumber(value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case int64:
+		return float64(v), nil
+	case float64:
+		return v, nil
+	case string:
+		return strconv.ParseFloat(v, 64)
+	}
+	return 0, fmt.Errorf("unexpected %T", value)
+}
+
+// unpickle runs the pickle machine of the protocols 0 to 5 for lists, tuples, strings and numbers.
+func unpickle(data []byte) (interface{}, error) {
+	r := bytes.NewReader(data)
+	var (
+		stack []interface{}
+		memo  = make(map[int]interface{})
+	)
+	pop := func() (interface{}, error) {
+		if len(stack) == 0 {
+			return nil, errors.New("pickle stack underflow")
+		}
+		value := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		return value, nil
+	}
+	popMark := func() ([]interface{}, error) {
+		for i := len(stack) - 1; i >= 0; i-- {
+			if _, ok := stack[i].(pickleMark); ok {
+				items := append([]interface{}{}, stack[i+1:]...)
+				stack = stack[:i]
+				return items, nil
+			}
+		}
+		return nil, errors.New("pickle mark not found")
+	}
+	top := func() (interface{}, error) {
+		if len(stack) == 0 {
+			return nil, errors.New("pickle stack underflow")
+		}
+		return stack[len(stack)-1], nil
+	}
+	appendItems := func(items ...interface{}) error {
+		list, err := pop()
+		if err != nil {
+			return err
+		}
+		values, ok := list.([]interface{})
+		if !ok {
+			return errors.New("pickle append to a non-list")
+		}
+		stack = append(stack, append(values, items...))
+		return nil
+	}
+
+	for {
+		opcode, err := r.ReadByte()
+		if err != nil {
+			return nil, errors.New("pickle has no STOP opcode")
+		}
+		switch opcode {
+		case '.': // STOP
+			return pop()
+		case 0x80: // PROTO
+			if _, err := r.ReadByte(); err != nil {
+				return nil, err
+			}
+		case 0x95: // FRAME
+			if _, err := readN(r, 8); err != nil {
+				return nil, err
+			}
+		case '(': // MARK
+			stack = append(stack, pickleMark{})
+		case ']', ')': // EMPTY_LIST, EMPTY_TUPLE
+			stack = append(stack, []interface{}{})
+		case 'l', 't': // LIST, TUPLE
+			items, err := popMark()
+			if err != nil {
+				return nil, err
+			}
+			stack = append(stack, items)
+		case 0x85, 0x86, 0x87: // TUPLE1, TUPLE2, TUPLE3
+			n := int(opcode - 0x84)
+			if len(stack) < n {
+				return nil, errors.New("pickle stack underflow")
+			}
+			items := append([]interface{}{}, stack[len(stack)-n:]...)
+			stack = append(stack[:len(stack)-n], items)
+		case 'a': // APPEND
+			item, err := pop()
+			if err != nil {
+				return nil, err
+			}
+			if err := appendItems(item); err != nil {
+				return nil, err
+			}
+		case 'e': // APPENDS
+			items, err := popMark()
+			if err != nil {
+				return nil, err
+			}
+			if err := appendItems(items...); err != nil {
+				return nil, err
+			}
+		case 'N': // NONE
+			stack = append(stack, nil)
+		case 0x88, 0x89: // NEWTRUE, NEWFALSE
+			stack = append(stack, opcode == 0x88)
+		case 'I', 'L': // INT, LONG
+			line, err := readLine(r)
+			if err != nil {
+				return nil, err
+			}
+			value, err := strconv.ParseInt(strings.TrimSuffix(line, "L"), 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pickle integer %q", line)
+			}
+			stack = append(stack, value)
+		case 'J': // BININT
+			b, err := readN(r, 4)
+			if err != nil {
+				return nil, err
+			}
+			stack = append(stack, int64(int32(binary.LittleEndian.Uint32(b))))
+		case 'K': // BININT1
+			b, err := r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			stack = append(stack, int64(b))
+		case 'M': // BININT2
+			b, err := readN(r, 2)
+			if err != nil {
+				return nil, err
+			}
+			stack = append(stack, int64(binary.LittleEndian.Uint16(b)))
+		case 0x8a: // LONG1
+			n, err := r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			b, err := readN(r, int(n))
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeLong(b)
+			if err != nil {
+				return nil, err
+			}
+			stack = append(stack, value)
+		case 'F': // FLOAT
+			line, err := readLine(r)
+			if err != nil {
+				return nil, err
+			}
+			value, err := strconv.ParseFloat(line, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pickle float %q", line)
+			}
+			stack = append(stack, value)
+		case 'G': // BINFLOAT
+			b, err := readN(r, 8)
+			if err != nil {
+				return nil, err
+			}
+			stack = append(stack, math.Float64frombits(binary.BigEndian.Uint64(b)))
+		case 'S', 'V': // STRING, UNICODE
+			line, err := readLine(r)
+			if err != nil {
+				return nil, err
+			}
+			if opcode == 'S' {
+				line = unquotePythonString(line)
+			}
+			stack = append(stack, line)
+		case 'T', 'X', 'B': // BINSTRING, BINUNICODE, BINBYTES
+			b, err := readN(r, 4)
+			if err != nil {
+				return nil, err
+			}
+			value, err := readN(r, int(binary.LittleEndian.Uint32(b)))
+			if err != nil {
+				return nil, err
+			}
+			stack = append(stack, string(value))
+		case 'U', 0x8c, 'C': // SHORT_BINSTRING, SHORT_BINUNICODE, SHORT_BINBYTES
+			n, err := r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			value, err := readN(r, int(n))
+			if err != nil {
+				return nil, err
+			}
+			stack = append(stack, string(value))
+		case 0x8d: // BINUNICODE8
+			b, err := readN(r, 8)
+			if err != nil {
+				return nil, err
+			}
+			value, err := readN(r, int(min(binary.LittleEndian.Uint64(b), uint64(r.Len()+1))))
+			if err != nil {
+				return nil, err
+			}
+			stack = append(stack, string(value))
+		case 'p', 'q', 'r', 0x94: // PUT, BINPUT, LONG_BINPUT, MEMOIZE
+			index, err := readMemoIndex(r, opcode, len(memo))
+			if err != nil {
+				return nil, err
+			}
+			value, err := top()
+			if err != nil {
+				return nil, err
+			}
+			memo[index] = value
+		case 'g', 'h', 'j': // GET, BINGET, LONG_BINGET
+			index, err := readMemoIndex(r, opcode, 0)
+			if err != nil {
+				return nil, err
+			}
+			value, ok := memo[index]
+			if !ok {
+				return nil, fmt.Errorf("pickle memo %d not found", index)
+			}
+			stack = append(stack, value)
+		default:
+			return nil, fmt.Errorf("unsupported pickle opcode 0x%02x", opcode)
+		}
+	}
+}
+
+// readMemoIndex reads the memo index argument of the opcode, MEMOIZE uses the next index.
+func readMemoIndex(r *bytes.Reader, opcode byte, next int) (int, error) {
+	switch opcode {
+	case 'p', 'g':
+		line, err := readLine(r)
+		if err != nil {
+			return 0, err
+		}
+		return strconv.Atoi(line)
+	case 'q', 'h':
+		b, err := r.ReadByte()
+		return int(b), err
+	case 'r', 'j':
+		b, err := readN(r, 4)
+		if err != nil {
+			return 0, err
+		}
+		return int(binary.LittleEndian.Uint32(b)), nil
+	}
+	return next, nil
+}
+
+func readN(r *bytes.Reader, n int) ([]byte, error) {
+	if n > r.Len() {
+		return nil, errors.New("truncated pickle")
+	}
+	b := make([]byte, n)
+	_, err := r.Read(b)
+	return b, err
+}
+
+func readLine(r *bytes.Reader) (string, error) {
+	var b strings.Builder
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return "", errors.New("truncated pickle")
+		}
+		if c == '\n' {
+			return b.String(), nil
+		}
+		b.WriteByte(c)
+	}
+}
+
+// decodeLong decodes the little-endian two's complement integer of LONG1.
+func decodeLong(b []byte) (int64, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	reversed := make([]byte, len(b))
+	for i, c := range b {
+		reversed[len(b)-1-i] = c
+	}
+	value := new(big.Int).SetBytes(reversed)
+	if b[len(b)-1]&0x80 != 0 {
+		value.Sub(value, new(big.Int).Lsh(big.NewInt(1), uint(len(b)*8)))
+	}
+	if !value.IsInt64() {
+		return 0, errors.New("pickle integer overflows int64")
+	}
+	return value.Int64(), nil
+}
+
+// unquotePythonString unquotes the repr of the STRING opcode, falling back to the raw value.
+func unquotePythonString(s string) string {
+	if len(s) >= 2 && (s[0] == '\'' || s[0] == '"') && s[len(s)-1] == s[0] {
+		inner := s[1 : len(s)-1]
+		if unquoted, err := strconv.Unquote(`"` + strings.ReplaceAll(inner, `"`, `\"`) + `"`); err == nil {
+			return unquoted
+		}
+		return inner
+	}
+	return s
+}
@@ -0,0 +1,66 @@
+// Package graphite receives metrics in the Graphite plaintext and pickle protocols.
+package graphite
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Sample is a Graphite data point. Tags of the tagged series format (path;tag=value) become labels.
+type Sample struct {
+	Path      string
+	Tags      map[string]string
+	Value     float64
+	Timestamp int64 // in Unix seconds, zero when the sample has no timestamp
+}
+
+// ParsePlaintext parses the "path value [timestamp]" line. A negative timestamp means no timestamp like in carbon.
+func ParsePlaintext(line string) (Sample, error) {
+	parts := strings.Fields(line)
+	if len(parts) < 2 || len(parts) > 3 {
+		return Sample{}, fmt.Errorf("expected \"path value [timestamp]\", got %q", line)
+	}
+
+	sample, err := parsePath(parts[0])
+	if err != nil {
+		return Sample{}, err
+	}
+	sample.Value, err = strconv.ParseFloat(parts[1], 64)
+	if err != nil || math.IsNaN(sample.Value) || math.IsInf(sample.Value, 0) {
+		return Sample{}, fmt.Errorf("invalid value %q", parts[1])
+	}
+	if len(parts) == 3 {
+		timestamp, err := strconv.ParseFloat(parts[2], 64)
+		if err != nil || math.IsNaN(timestamp) || math.Abs(timestamp) > math.MaxInt64/1e3 {
+			return Sample{}, fmt.Errorf("invalid timestamp %q", parts[2])
+		}
+		if timestamp > 0 {
+			sample.Timestamp = int64(timestamp)
+		}
+	}
+	return sample, nil
+}
+
+// parsePath splits the tags of the tagged series format off the path.
+func parsePath(value string) (Sample, error) {
+	path, tags, tagged := strings.Cut(value, ";")
+	if path == "" {
+		return Sample{}, errors.New("empty path")
+	}
+	sample := Sample{Path: path}
+	if !tagged {
+		return sample, nil
+	}
+	sample.Tags = make(map[string]string)
+	for _, tag := range strings.Split(tags, ";") {
+		key, tagValue, ok := strings.Cut(tag, "=")
+		if !ok || key == "" || tagValue == "" {
+			return Sample{}, fmt.Errorf("invalid tag %q", tag)
+		}
+		sample.Tags[key] = tagValue
+	}
+	return sample, nil
+}
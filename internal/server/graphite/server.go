@@ -0,0 +1,370 @@
+package graphite
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/gonozov0/go-musthave-devops/internal/server/repository"
+	"github.com/gonozov0/go-musthave-devops/internal/shared"
+)
+
+const (
+	// DefaultFlushInterval is the longest time a received sample waits for the batch write.
+	DefaultFlushInterval = time.Second
+	// DefaultBatchSize is the number of samples written at once without waiting for the flush interval.
+	DefaultBatchSize = 1000
+
+	// maxPickleSize limits the size of a pickle message like carbon does.
+	maxPickleSize = 1 << 20
+	// maxUDPPacketSize is the largest UDP payload.
+	maxUDPPacketSize = 65535
+)
+
+// Server receives Graphite samples on the enabled listeners and writes them to the repository
+// in batches. Gauges are written with the sample values and timestamps. Counters are written with
+// the sample values rounded to integers as the increments, like the per-interval counts of statsd.
+type Server struct {
+	repo          repository.Repository
+	templates     []Template
+	tcpAddr       string
+	udpAddr       string
+	pickleAddr    string
+	flushInterval time.Duration
+	batchSize     int
+	// accepted distance of sample timestamps from the server time, zero means unlimited
+	maxPast   time.Duration
+	maxFuture time.Duration
+
+	tcpListener    net.Listener
+	udpConn        net.PacketConn
+	pickleListener net.Listener
+
+	connsMu sync.Mutex
+	conns   map[net.Conn]struct{}
+	closed  bool
+	connsWg sync.WaitGroup
+
+	batchMu  sync.Mutex
+	gauges   []repository.GaugeMetric
+	counters []repository.CounterMetric
+	flushMu  sync.Mutex
+}
+
+// Option configures the Server.
+type Option func(*Server)
+
+// WithTCP enables the plaintext protocol on the TCP address.
+func WithTCP(addr string) Option {
+	return func(s *Server) {
+		s.tcpAddr = addr
+	}
+}
+
+// WithUDP enables the plaintext protocol on the UDP address.
+func WithUDP(addr string) Option {
+	return func(s *Server) {
+		s.udpAddr = addr
+	}
+}
+
+// WithPickle enables the pickle protocol on the TCP address.
+func WithPickle(addr string) Option {
+	return func(s *Server) {
+		s.pickleAddr = addr
+	}
+}
+
+// WithTemplates sets the templates deciding the metric types.
+func WithTemplates(templates []Template) Option {
+	return func(s *Server) {
+		s.templates = templates
+	}
+}
+
+// WithBatching sets the flush interval and the batch size of the repository writes.
+func WithBatching(flushInterval time.Duration, batchSize int) Option {
+	return func(s *Server) {
+		s.flushInterval = flushInterval
+		s.batchSize = batchSize
+	}
+}
+
+// WithTimestampWindow drops samples with timestamps older than maxPast or further than maxFuture
+// from the server time. Zero durations disable the corresponding check.
+func WithTimestampWindow(maxPast, maxFuture time.Duration) Option {
+	return func(s *Server) {
+		s.maxPast = maxPast
+		s.maxFuture = maxFuture
+	}
+}
+
+// NewServer creates a new Server. Nothing is received until Listen and Run are called.
+func NewServer(repo repository.Repository, opts ...Option) *Server {
+	s := &Server{
+		repo:          repo,
+		flushInterval: DefaultFlushInterval,
+		batchSize:     DefaultBatchSize,
+		conns:         make(map[net.Conn]struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Enabled reports whether any listener is configured.
+func (s *Server) Enabled() bool {
+	return s.tcpAddr != "" || s.udpAddr != "" || s.pickleAddr != ""
+}
+
+// Listen opens the configured listeners, so the address errors are reported before Run.
+func (s *Server) Listen() error {
+	var err error
+	if s.tcpAddr != "" {
+		if s.tcpListener, err = net.Listen("tcp", s.tcpAddr); err != nil {
+			s.close()
+			return err
+		}
+	}
+	if s.udpAddr != "" {
+		if s.udpConn, err = net.ListenPacket("udp", s.udpAddr); err != nil {
+			s.close()
+			return err
+		}
+	}
+	if s.pickleAddr != "" {
+		if s.pickleListener, err = net.Listen("tcp", s.pickleAddr); err != nil {
+			s.close()
+			return err
+		}
+	}
+	return nil
+}
+
+// Addrs returns the addresses of the opened TCP, UDP and pickle listeners, nil for disabled ones.
+func (s *Server) Addrs() (tcp, udp, pickle net.Addr) {
+	if s.tcpListener != nil {
+		tcp = s.tcpListener.Addr()
+	}
+	if s.udpConn != nil {
+		udp = s.udpConn.LocalAddr()
+	}
+	if s.pickleListener != nil {
+		pickle = s.pickleListener.Addr()
+	}
+	return tcp, udp, pickle
+}
+
+// Run receives samples until the context is canceled. Then it closes the listeners and the connections
+// and writes the last batch.
+func (s *Server) Run(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	if s.tcpListener != nil {
+		s.connsWg.Add(1)
+		go s.accept(s.tcpListener, s.servePlaintext)
+	}
+	if s.pickleListener != nil {
+		s.connsWg.Add(1)
+		go s.accept(s.pickleListener, s.servePickle)
+	}
+	if s.udpConn != nil {
+		s.connsWg.Add(1)
+		go s.serveUDP()
+	}
+
+	ticker := time.NewTicker(s.flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			s.close()
+			s.connsWg.Wait()
+			s.flush()
+			return
+		case <-ticker.C:
+			s.flush()
+		}
+	}
+}
+
+// close closes the listeners and the open connections.
+func (s *Server) close() {
+	for _, listener := range []net.Listener{s.tcpListener, s.pickleListener} {
+		if listener != nil {
+			listener.Close()
+		}
+	}
+	if s.udpConn != nil {
+		s.udpConn.Close()
+	}
+	s.connsMu.Lock()
+	s.closed = true
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.connsMu.Unlock()
+}
+
+func (s *Server) accept(listener net.Listener, serve func(net.Conn)) {
+	defer s.connsWg.Done()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Errorf("graphite listener %s stopped: %v", listener.Addr(), err)
+			}
+			return
+		}
+		s.connsMu.Lock()
+		if s.closed {
+			s.connsMu.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = struct{}{}
+		s.connsWg.Add(1)
+		s.connsMu.Unlock()
+		go func() {
+			defer s.connsWg.Done()
+			defer func() {
+				s.connsMu.Lock()
+				delete(s.conns, conn)
+				s.connsMu.Unlock()
+				conn.Close()
+			}()
+			serve(conn)
+		}()
+	}
+}
+
+func (s *Server) servePlaintext(conn net.Conn) {
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		s.addLine(scanner.Text(), conn.RemoteAddr())
+	}
+	if err := scanner.Err(); err != nil && !errors.Is(err, net.ErrClosed) {
+		log.Warnf("graphite connection from %s failed: %v", conn.RemoteAddr(), err)
+	}
+}
+
+func (s *Server) servePickle(conn net.Conn) {
+	reader := bufio.NewReader(conn)
+	header := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				log.Warnf("graphite pickle connection from %s failed: %v", conn.RemoteAddr(), err)
+			}
+			return
+		}
+		size := binary.BigEndian.Uint32(header)
+		if size > maxPickleSize {
+			log.Warnf("graphite pickle message of %d bytes from %s is too large", size, conn.RemoteAddr())
+			return
+		}
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(reader, payload); err != nil {
+			log.Warnf("graphite pickle connection from %s failed: %v", conn.RemoteAddr(), err)
+			return
+		}
+		samples, err := ParsePickle(payload)
+		if err != nil {
+			// the framing is intact, so the connection can go on with the next message
+			log.Warnf("invalid graphite pickle message from %s: %v", conn.RemoteAddr(), err)
+			continue
+		}
+		for _, sample := range samples {
+			s.add(sample)
+		}
+	}
+}
+
+func (s *Server) serveUDP() {
+	defer s.connsWg.Done()
+	buf := make([]byte, maxUDPPacketSize)
+	for {
+		n, addr, err := s.udpConn.ReadFrom(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Errorf("graphite UDP listener stopped: %v", err)
+			}
+			return
+		}
+		for _, line := range strings.Split(string(buf[:n]), "\n") {
+			s.addLine(line, addr)
+		}
+	}
+}
+
+func (s *Server) addLine(line string, from net.Addr) {
+	if line = strings.TrimSpace(line); line == "" {
+		return
+	}
+	sample, err := ParsePlaintext(line)
+	if err != nil {
+		log.Warnf("invalid graphite line from %s: %v", from, err)
+		return
+	}
+	s.add(sample)
+}
+
+// add queues the sample for the next batch and writes the batch when it's full.
+func (s *Server) add(sample Sample) {
+	now := time.Now()
+	timestamp := now
+	if sample.Timestamp != 0 {
+		timestamp = time.Unix(sample.Timestamp, 0)
+		if (s.maxPast > 0 && timestamp.Before(now.Add(-s.maxPast))) ||
+			(s.maxFuture > 0 && timestamp.After(now.Add(s.maxFuture))) {
+			log.Debugf("graphite sample %s at %d is out of the accepted time window", sample.Path, sample.Timestamp)
+			return
+		}
+	}
+	name := shared.WithLabels(sample.Path, sample.Tags)
+
+	s.batchMu.Lock()
+	if metricType(s.templates, sample.Path) == shared.Counter {
+		s.counters = append(s.counters, repository.CounterMetric{Name: name, Value: int64(math.Round(sample.Value))})
+	} else {
+		s.gauges = append(s.gauges, repository.GaugeMetric{Name: name, Value: sample.Value, Timestamp: timestamp})
+	}
+	full := len(s.gauges)+len(s.counters) >= s.batchSize
+	s.batchMu.Unlock()
+
+	if full {
+		s.flush()
+	}
+}
+
+// flush writes the queued samples. Graphite has no acknowledgements, so failed batches are logged and dropped.
+func (s *Server) flush() {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
+	s.batchMu.Lock()
+	gauges, counters := s.gauges, s.counters
+	s.gauges, s.counters = nil, nil
+	s.batchMu.Unlock()
+
+	if len(gauges) > 0 {
+		if _, err := s.repo.UpdateGauges(gauges); err != nil {
+			log.Errorf("failed to write %d graphite gauges: %v", len(gauges), err)
+		}
+	}
+	if len(counters) > 0 {
+		if _, err := s.repo.UpdateCounters(counters); err != nil {
+			log.Errorf("failed to write %d graphite counters: %v", len(counters), err)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package graphite
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	repository "github.com/gonozov0/go-musthave-devops/internal/server/repository/in_memory"
+)
+
+func TestServer(t *testing.T) {
+	repo := repository.NewInMemoryRepository()
+	templates, err := ParseTemplates([]string{"stats.counters=counter"})
+	require.NoError(t, err)
+	server := NewServer(
+		repo,
+		WithTCP("127.0.0.1:0"),
+		WithUDP("127.0.0.1:0"),
+		WithPickle("127.0.0.1:0"),
+		WithTemplates(templates),
+		WithBatching(time.Hour, 1000),
+		WithTimestampWindow(time.Hour, time.Minute),
+	)
+	require.NoError(t, server.Listen())
+	tcpAddr, udpAddr, pickleAddr := server.Addrs()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go server.Run(ctx, wg)
+
+	now := time.Now().Unix()
+	tcpConn, err := net.Dial("tcp", tcpAddr.String())
+	require.NoError(t, err)
+	_, err = fmt.Fprintf(tcpConn, "servers.web1.cpu 12.5 %d\nstats.counters.hits 3\nstats.counters.hits 2\nold.metric 1 1\n", now)
+	require.NoError(t, err)
+	require.NoError(t, tcpConn.Close())
+
+	udpConn, err := net.Dial("udp", udpAddr.String())
+	require.NoError(t, err)
+	_, err = fmt.Fprintf(udpConn, "servers.web2.cpu;dc=eu 7 %d\n", now)
+	require.NoError(t, err)
+	require.NoError(t, udpConn.Close())
+
+	// pickle.dumps([("stats.counters.pickled", (-1, 4))], protocol=2)
+	payload, err := hex.DecodeString("80025d7100581600000073746174732e636f756e746572732e7069636b6c656471014affffffff4b04867102867103612e")
+	require.NoError(t, err)
+	pickleConn, err := net.Dial("tcp", pickleAddr.String())
+	require.NoError(t, err)
+	require.NoError(t, binary.Write(pickleConn, binary.BigEndian, uint32(len(payload))))
+	_, err = pickleConn.Write(payload)
+	require.NoError(t, err)
+
+	// the samples are only written by the final flush on shutdown while the pickle connection is still open
+	require.Eventually(t, func() bool {
+		server.batchMu.Lock()
+		defer server.batchMu.Unlock()
+		return len(server.gauges)+len(server.counters) == 5
+	}, time.Second, 10*time.Millisecond)
+	cancel()
+	wg.Wait()
+	pickleConn.Close()
+
+	gauge, err := repo.GetGauge("servers.web1.cpu")
+	require.NoError(t, err)
+	require.Equal(t, 12.5, gauge)
+	gauge, err = repo.GetGauge(`servers.web2.cpu{dc="eu"}`)
+	require.NoError(t, err)
+	require.Equal(t, 7.0, gauge)
+	counter, err := repo.GetCounter("stats.counters.hits")
+	require.NoError(t, err)
+	require.Equal(t, int64(5), counter)
+	counter, err = repo.GetCounter("stats.counters.pickled")
+	require.NoError(t, err)
+	require.Equal(t, int64(4), counter)
+	_, err = repo.GetGauge("old.metric")
+	require.Error(t, err)
+}
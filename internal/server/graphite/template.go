@@ -0,0 +1,59 @@
+package graphite
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/gonozov0/go-musthave-devops/internal/shared"
+)
+
+// Template assigns the metric type to the paths matching the pattern.
+type Template struct {
+	pattern []string
+	mType   string
+}
+
+// ParseTemplates parses the "pattern=type" templates, the type is gauge or counter. The pattern nodes
+// separated by dots are matched against the leading nodes of the path with path.Match, so
+// "stats.counters" and "stats.counters.*" match every path under stats.counters.
+func ParseTemplates(specs []string) ([]Template, error) {
+	templates := make([]Template, 0, len(specs))
+	for _, spec := range specs {
+		pattern, mType, ok := strings.Cut(spec, "=")
+		if !ok || pattern == "" || (mType != shared.Gauge && mType != shared.Counter) {
+			return nil, fmt.Errorf("invalid template %q, expected pattern=gauge or pattern=counter", spec)
+		}
+		nodes := strings.Split(pattern, ".")
+		for _, node := range nodes {
+			if _, err := path.Match(node, ""); err != nil {
+				return nil, fmt.Errorf("invalid template pattern %q: %w", pattern, err)
+			}
+		}
+		templates = append(templates, Template{pattern: nodes, mType: mType})
+	}
+	return templates, nil
+}
+
+// metricType returns the type of the first template matching the path, gauge when none matches.
+func metricType(templates []Template, metricPath string) string {
+	nodes := strings.Split(metricPath, ".")
+	for _, template := range templates {
+		if matchNodes(template.pattern, nodes) {
+			return template.mType
+		}
+	}
+	return shared.Gauge
+}
+
+func matchNodes(pattern, nodes []string) bool {
+	if len(pattern) > len(nodes) {
+		return false
+	}
+	for i, node := range pattern {
+		if ok, _ := path.Match(node, nodes[i]); !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,40 @@
+package hll
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEstimate(t *testing.T) {
+	for _, n := range []int{0, 1, 100, 10000, 200000} {
+		t.Run(strconv.Itoa(n), func(t *testing.T) {
+			s := New()
+			for i := 0; i < n; i++ {
+				s.Insert("user-" + strconv.Itoa(i))
+				s.Insert("user-" + strconv.Itoa(i)) // duplicates don't count
+			}
+			require.InEpsilon(t, float64(n)+1, float64(s.Estimate())+1, 0.03)
+		})
+	}
+}
+
+func TestMergeAndMarshal(t *testing.T) {
+	a, b := New(), New()
+	for i := 0; i < 1000; i++ {
+		a.Insert("10.0.0." + strconv.Itoa(i))
+		b.Insert("10.0.0." + strconv.Itoa(i+500))
+	}
+
+	data, err := a.MarshalBinary()
+	require.NoError(t, err)
+	restored := New()
+	require.NoError(t, restored.UnmarshalBinary(data))
+	require.Equal(t, a.Estimate(), restored.Estimate())
+
+	restored.Merge(b)
+	require.InEpsilon(t, 1500, float64(restored.Estimate()), 0.03)
+
+	require.ErrorIs(t, restored.UnmarshalBinary(data[:10]), ErrInvalidSketch)
+}
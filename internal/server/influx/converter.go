@@ -0,0 +1,109 @@
+package influx
+
+import (
+	"fmt"
+	"math"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/gonozov0/go-musthave-devops/internal/shared"
+)
+
+// Converter maps every numeric or boolean field to the measurement_field metric labeled with the tags.
+// Booleans are 1 or 0 and string fields are skipped. The fields matching the counter patterns are
+// cumulative counters, like the interface byte counts of Telegraf, so the converter remembers their
+// last values and writes the increments. The other fields are gauges.
+type Converter struct {
+	counterPatterns []string
+
+	mu       sync.Mutex
+	counters map[string]float64 // last cumulative value by metric ID
+}
+
+// NewConverter creates the converter. The counter patterns match measurement_field names (path.Match syntax).
+func NewConverter(counterPatterns []string) (*Converter, error) {
+	for _, pattern := range counterPatterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid metric name pattern %q: %w", pattern, err)
+		}
+	}
+	return &Converter{counterPatterns: counterPatterns, counters: make(map[string]float64)}, nil
+}
+
+// Write converts the points and passes the metrics to update in one batch. The points are written
+// all or nothing: when validate rejects any of them, nothing is written and the *ParseError of every
+// rejected line is returned. The error is the one of update.
+func (c *Converter) Write(
+	points []Point,
+	precision time.Duration,
+	validate func(shared.Metric) error,
+	update func([]shared.Metric) error,
+) (rejected []error, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var metrics []shared.Metric
+	counters := make(map[string]float64)
+	for _, point := range points {
+		var timestamp *int64
+		if point.Timestamp != nil {
+			if *point.Timestamp > math.MaxInt64/int64(precision) || *point.Timestamp < math.MinInt64/int64(precision) {
+				rejected = append(rejected, &ParseError{Line: point.Line, Err: fmt.Errorf("timestamp %d is out of range", *point.Timestamp)})
+				continue
+			}
+			milli := time.Unix(0, *point.Timestamp*int64(precision)).UnixMilli()
+			timestamp = &milli
+		}
+		if err := validate(shared.Metric{ID: point.Measurement, Timestamp: timestamp}); err != nil {
+			rejected = append(rejected, &ParseError{Line: point.Line, Err: err})
+			continue
+		}
+
+		for _, field := range point.Fields {
+			if field.Type == FieldString {
+				continue
+			}
+			name := point.Measurement + "_" + field.Key
+			id := shared.WithLabels(name, point.Tags)
+			value := field.Value
+			if field.Type == FieldBoolean || !c.isCounter(name) {
+				metrics = append(metrics, shared.Metric{ID: id, MType: shared.Gauge, Value: &value, Timestamp: timestamp})
+				continue
+			}
+
+			last, ok := counters[id]
+			if !ok {
+				last, ok = c.counters[id]
+			}
+			// the first value of the series and the one after a reset are the increments themselves
+			delta := math.Round(value)
+			if ok && value >= last {
+				delta = math.Round(value) - math.Round(last)
+			}
+			counters[id] = value
+			increment := int64(delta)
+			metrics = append(metrics, shared.Metric{ID: id, MType: shared.Counter, Delta: &increment})
+		}
+	}
+
+	if len(rejected) > 0 || len(metrics) == 0 {
+		return rejected, nil
+	}
+	if err := update(metrics); err != nil {
+		return nil, err
+	}
+	for id, value := range counters {
+		c.counters[id] = value
+	}
+	return nil, nil
+}
+
+func (c *Converter) isCounter(name string) bool {
+	for _, pattern := range c.counterPatterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,56 @@
+package influx
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gonozov0/go-musthave-devops/internal/shared"
+)
+
+func TestConverterWrite(t *testing.T) {
+	converter, err := NewConverter([]string{"net_bytes_*"})
+	require.NoError(t, err)
+	validate := func(metric shared.Metric) error {
+		if metric.Timestamp != nil && *metric.Timestamp < 0 {
+			return errors.New("too old")
+		}
+		return nil
+	}
+	var written []shared.Metric
+	update := func(metrics []shared.Metric) error {
+		written = metrics
+		return nil
+	}
+
+	points, errs := Parse([]byte("net,iface=eth0 bytes_recv=100i,up=true,name=\"eth0\" 1700000000\n"))
+	require.Empty(t, errs)
+	rejected, err := converter.Write(points, time.Second, validate, update)
+	require.NoError(t, err)
+	require.Empty(t, rejected)
+
+	recv, up := int64(100), 1.0
+	timestamp := int64(1700000000000)
+	require.Equal(t, []shared.Metric{
+		{ID: `net_bytes_recv{iface="eth0"}`, MType: shared.Counter, Delta: &recv},
+		{ID: `net_up{iface="eth0"}`, MType: shared.Gauge, Value: &up, Timestamp: &timestamp},
+	}, written)
+
+	points, errs = Parse([]byte("net,iface=eth0 bytes_recv=150i\n"))
+	require.Empty(t, errs)
+	_, err = converter.Write(points, time.Nanosecond, validate, update)
+	require.NoError(t, err)
+	require.Equal(t, int64(50), *written[0].Delta)
+
+	// a rejected line rejects the whole request
+	written = nil
+	points, errs = Parse([]byte("net,iface=eth0 bytes_recv=200i\nnet,iface=eth1 bytes_recv=5i -1\n"))
+	require.Empty(t, errs)
+	rejected, err = converter.Write(points, time.Second, validate, update)
+	require.NoError(t, err)
+	require.Len(t, rejected, 1)
+	require.EqualError(t, rejected[0], "line 2: too old")
+	require.Nil(t, written)
+}
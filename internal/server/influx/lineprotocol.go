@@ -0,0 +1,202 @@
+// Package influx parses the InfluxDB line protocol and converts the points to the metrics of the server.
+package influx
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FieldType is the type of the field value.
+type FieldType int
+
+// Field types of the line protocol.
+const (
+	FieldFloat FieldType = iota
+	FieldInteger
+	FieldUnsigned
+	FieldBoolean
+	FieldString
+)
+
+// Field is a field of the point, Value holds numeric and boolean (1 or 0) values.
+type Field struct {
+	Key   string
+	Type  FieldType
+	Value float64
+	Text  string // the value of a string field
+}
+
+// Point is a parsed line.
+type Point struct {
+	Line        int
+	Measurement string
+	Tags        map[string]string
+	Fields      []Field
+	Timestamp   *int64 // in the precision of the request, nil when the line has no timestamp
+}
+
+// ParseError is the error of the line.
+type ParseError struct {
+	Line int
+	Err  error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// Precisions are the timestamp precisions of the write APIs by the precision query parameter.
+var Precisions = map[string]time.Duration{
+	"":   time.Nanosecond,
+	"n":  time.Nanosecond,
+	"ns": time.Nanosecond,
+	"u":  time.Microsecond,
+	"us": time.Microsecond,
+	"ms": time.Millisecond,
+	"s":  time.Second,
+	"m":  time.Minute,
+	"h":  time.Hour,
+}
+
+// Parse parses the lines skipping empty ones and comments. It returns the *ParseError of every invalid line.
+func Parse(data []byte) ([]Point, []error) {
+	var (
+		points []Point
+		errs   []error
+	)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64<<10), len(data)+1)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		point, err := parseLine(line)
+		if err != nil {
+			errs = append(errs, &ParseError{Line: lineNumber, Err: err})
+			continue
+		}
+		point.Line = lineNumber
+		points = append(points, point)
+	}
+	if err := scanner.Err(); err != nil {
+		errs = append(errs, err)
+	}
+	return points, errs
+}
+
+func parseLine(line string) (Point, error) {
+	seriesKey, rest := cutUnescaped(line, ' ', false)
+	fieldSet, timestamp := cutUnescaped(strings.TrimLeft(rest, " "), ' ', true)
+	timestamp = strings.TrimSpace(timestamp)
+	if fieldSet == "" {
+		return Point{}, errors.New("missing fields")
+	}
+
+	measurement, tagSet := cutUnescaped(seriesKey, ',', false)
+	point := Point{Measurement: unescape(measurement), Tags: make(map[string]string)}
+	if point.Measurement == "" {
+		return Point{}, errors.New("missing measurement")
+	}
+	for tagSet != "" {
+		var tag string
+		tag, tagSet = cutUnescaped(tagSet, ',', false)
+		key, value := cutUnescaped(tag, '=', false)
+		if key == "" || value == "" {
+			return Point{}, fmt.Errorf("invalid tag %q", tag)
+		}
+		point.Tags[unescape(key)] = unescape(value)
+	}
+
+	for fieldSet != "" {
+		var field string
+		field, fieldSet = cutUnescaped(fieldSet, ',', true)
+		key, value := cutUnescaped(field, '=', false)
+		if key == "" || value == "" {
+			return Point{}, fmt.Errorf("invalid field %q", field)
+		}
+		parsed, err := parseFieldValue(value)
+		if err != nil {
+			return Point{}, fmt.Errorf("invalid value of field %q: %w", unescape(key), err)
+		}
+		parsed.Key = unescape(key)
+		point.Fields = append(point.Fields, parsed)
+	}
+
+	if timestamp != "" {
+		ts, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			return Point{}, fmt.Errorf("invalid timestamp %q", timestamp)
+		}
+		point.Timestamp = &ts
+	}
+	return point, nil
+}
+
+func parseFieldValue(value string) (Field, error) {
+	switch {
+	case strings.HasPrefix(value, `"`):
+		body := value[1:]
+		if !strings.HasSuffix(body, `"`) || escaped(body, len(body)-1) {
+			return Field{}, errors.New("unterminated string")
+		}
+		text := strings.NewReplacer(`\"`, `"`, `\\`, `\`).Replace(body[:len(body)-1])
+		return Field{Type: FieldString, Text: text}, nil
+	case strings.HasSuffix(value, "i"):
+		v, err := strconv.ParseInt(strings.TrimSuffix(value, "i"), 10, 64)
+		return Field{Type: FieldInteger, Value: float64(v)}, err
+	case strings.HasSuffix(value, "u"):
+		v, err := strconv.ParseUint(strings.TrimSuffix(value, "u"), 10, 64)
+		return Field{Type: FieldUnsigned, Value: float64(v)}, err
+	}
+	switch value {
+	case "t", "T", "true", "True", "TRUE":
+		return Field{Type: FieldBoolean, Value: 1}, nil
+	case "f", "F", "false", "False", "FALSE":
+		return Field{Type: FieldBoolean, Value: 0}, nil
+	}
+	v, err := strconv.ParseFloat(value, 64)
+	if err == nil && (math.IsNaN(v) || math.IsInf(v, 0)) {
+		err = errors.New("value isn't finite")
+	}
+	return Field{Type: FieldFloat, Value: v}, err
+}
+
+// cutUnescaped cuts s around the first sep that isn't escaped with a backslash
+// and, when quoted is set, isn't inside a double-quoted string.
+func cutUnescaped(s string, sep byte, quoted bool) (string, string) {
+	inString := false
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\':
+			i++
+		case quoted && s[i] == '"':
+			inString = !inString
+		case s[i] == sep && !inString:
+			return s[:i], s[i+1:]
+		}
+	}
+	return s, ""
+}
+
+// escaped reports whether the character at i is preceded by an odd number of backslashes.
+func escaped(s string, i int) bool {
+	backslashes := 0
+	for j := i - 1; j >= 0 && s[j] == '\\'; j-- {
+		backslashes++
+	}
+	return backslashes%2 == 1
+}
+
+// unescape removes the backslashes escaping the special characters of names, tags and field keys.
+var unescape = strings.NewReplacer(`\,`, ",", `\=`, "=", `\ `, " ", `\\`, `\`).Replace
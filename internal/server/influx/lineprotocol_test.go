@@ -0,0 +1,55 @@
+package influx
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	data := []byte(`# comment
+cpu,host=server\ 1,region=eu usage_idle=92.5,usage_user=3i 1700000000000000000
+
+disk\,io,path=/var reads=10u,ok=t,state="running \"fine\", really"
+mem free=1e3
+bad_line
+cpu usage=abc
+cpu,host value="open
+`)
+	points, errs := Parse(data)
+
+	require.Equal(t, []Point{
+		{
+			Line:        2,
+			Measurement: "cpu",
+			Tags:        map[string]string{"host": "server 1", "region": "eu"},
+			Fields: []Field{
+				{Key: "usage_idle", Type: FieldFloat, Value: 92.5},
+				{Key: "usage_user", Type: FieldInteger, Value: 3},
+			},
+			Timestamp: func() *int64 { ts := int64(1700000000000000000); return &ts }(),
+		},
+		{
+			Line:        4,
+			Measurement: "disk,io",
+			Tags:        map[string]string{"path": "/var"},
+			Fields: []Field{
+				{Key: "reads", Type: FieldUnsigned, Value: 10},
+				{Key: "ok", Type: FieldBoolean, Value: 1},
+				{Key: "state", Type: FieldString, Text: `running "fine", really`},
+			},
+		},
+		{Line: 5, Measurement: "mem", Tags: map[string]string{}, Fields: []Field{{Key: "free", Type: FieldFloat, Value: 1000}}},
+	}, points)
+
+	require.Len(t, errs, 3)
+	var lines []int
+	for _, err := range errs {
+		var parseErr *ParseError
+		require.True(t, errors.As(err, &parseErr))
+		lines = append(lines, parseErr.Line)
+	}
+	require.Equal(t, []int{6, 7, 8}, lines)
+	require.EqualError(t, errs[0], "line 6: missing fields")
+}
@@ -0,0 +1,360 @@
+// Package otlp converts OTLP metrics to the metrics of the server.
+//
+// The data points are mapped as follows:
+//   - Gauge points become gauges.
+//   - Monotonic Sum points become counters. Delta points are written as is, cumulative points are
+//     converted to the increments since the previous point of the series. The first point of
+//     a series and the point after a reset are the increments themselves.
+//   - Non-monotonic Sum points become gauges when they're cumulative and counters with signed
+//     increments when they're delta.
+//   - Histogram points become the <name>_count and <name>_bucket{le="..."} counters with the cumulative
+//     bucket counts like in Prometheus, following the temporality of the histogram, and the <name>_sum
+//     gauge holding the sum of the point.
+//   - Exponential histogram and summary points are rejected.
+//
+// Values of counters are rounded to integers. The selected resource attributes and the data point
+// attributes become labels of the metric IDs, the data point attributes win on conflicts.
+package otlp
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+
+	"github.com/gonozov0/go-musthave-devops/internal/shared"
+)
+
+// maxErrorMessages limits the number of errors listed in the partial success message.
+const maxErrorMessages = 10
+
+// DefaultResourceAttributes are the resource attributes identifying the source of the metrics.
+// The others, like process.pid, would create a new series on every restart.
+var DefaultResourceAttributes = []string{"service.name", "service.namespace", "service.instance.id", "host.name"}
+
+var (
+	errNoValue     = errors.New("data point has no value")
+	errNoTimestamp = errors.New("data point has no timestamp")
+
+	errUnspecifiedTemporality = errors.New("aggregation temporality is unspecified")
+)
+
+// cumulativePoint is the last point of a cumulative counter series.
+type cumulativePoint struct {
+	start uint64
+	value float64
+}
+
+// Converter maps OTLP data points to gauges and counters. It remembers the last point of every
+// cumulative counter series to write the increments.
+type Converter struct {
+	resourceAttributes map[string]struct{} // nil keeps all of them
+
+	mu         sync.Mutex
+	cumulative map[string]cumulativePoint // by metric ID
+}
+
+// NewConverter creates the converter keeping the listed resource attributes as labels, "*" keeps all of them.
+func NewConverter(resourceAttributes []string) *Converter {
+	c := &Converter{cumulative: make(map[string]cumulativePoint)}
+	c.resourceAttributes = make(map[string]struct{}, len(resourceAttributes))
+	for _, attribute := range resourceAttributes {
+		if attribute == "*" {
+			c.resourceAttributes = nil
+			break
+		}
+		c.resourceAttributes[attribute] = struct{}{}
+	}
+	return c
+}
+
+// Result is the outcome of the conversion in terms of the OTLP partial success.
+type Result struct {
+	RejectedDataPoints int64
+	ErrorMessage       string
+}
+
+// Write converts the data points of the request and passes the valid ones to update. Points rejected
+// by validate or by the conversion are counted in the result, the error is the one of update.
+// The cumulative state only advances when update succeeds, so a retried request isn't counted twice.
+func (c *Converter) Write(
+	req *colmetricspb.ExportMetricsServiceRequest,
+	validate func(shared.Metric) error,
+	update func([]shared.Metric) error,
+) (Result, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	b := &batch{converter: c, validate: validate, cumulative: make(map[string]cumulativePoint)}
+	for _, resourceMetrics := range req.GetResourceMetrics() {
+		resourceLabels := make(map[string]string)
+		for _, attribute := range resourceMetrics.GetResource().GetAttributes() {
+			if _, ok := c.resourceAttributes[attribute.GetKey()]; ok || c.resourceAttributes == nil {
+				resourceLabels[attribute.GetKey()] = anyValueString(attribute.GetValue())
+			}
+		}
+		for _, scopeMetrics := range resourceMetrics.GetScopeMetrics() {
+			for _, metric := range scopeMetrics.GetMetrics() {
+				b.addMetric(metric, resourceLabels)
+			}
+		}
+	}
+
+	if len(b.metrics) > 0 {
+		if err := update(b.metrics); err != nil {
+			return Result{}, err
+		}
+	}
+	for id, point := range b.cumulative {
+		c.cumulative[id] = point
+	}
+	return Result{RejectedDataPoints: b.rejected, ErrorMessage: strings.Join(b.errors, "; ")}, nil
+}
+
+// batch collects the metrics of a request.
+type batch struct {
+	converter  *Converter
+	validate   func(shared.Metric) error
+	metrics    []shared.Metric
+	cumulative map[string]cumulativePoint
+	rejected   int64
+	errors     []string
+}
+
+func (b *batch) reject(name string, count int, err error) {
+	b.rejected += int64(count)
+	if len(b.errors) < maxErrorMessages {
+		b.errors = append(b.errors, fmt.Sprintf("%s: %v", name, err))
+	}
+}
+
+func (b *batch) addMetric(metric *metricspb.Metric, resourceLabels map[string]string) {
+	name := metric.GetName()
+	switch data := metric.GetData().(type) {
+	case *metricspb.Metric_Gauge:
+		for _, point := range data.Gauge.GetDataPoints() {
+			b.addNumber(name, point, resourceLabels, func(id string, value float64, timestamp int64) error {
+				return b.addGauge(id, value, timestamp)
+			})
+		}
+	case *metricspb.Metric_Sum:
+		temporality := data.Sum.GetAggregationTemporality()
+		for _, point := range data.Sum.GetDataPoints() {
+			start := point.GetStartTimeUnixNano()
+			b.addNumber(name, point, resourceLabels, func(id string, value float64, timestamp int64) error {
+				if !data.Sum.GetIsMonotonic() && temporality == metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE {
+					return b.addGauge(id, value, timestamp)
+				}
+				return b.addCounter(id, temporality, start, value, timestamp)
+			})
+		}
+	case *metricspb.Metric_Histogram:
+		for _, point := range data.Histogram.GetDataPoints() {
+			b.addHistogram(name, data.Histogram.GetAggregationTemporality(), point, resourceLabels)
+		}
+	case *metricspb.Metric_ExponentialHistogram:
+		b.reject(name, len(data.ExponentialHistogram.GetDataPoints()), errors.New("exponential histograms aren't supported"))
+	case *metricspb.Metric_Summary:
+		b.reject(name, len(data.Summary.GetDataPoints()), errors.New("summaries aren't supported"))
+	default:
+		b.reject(name, 0, errors.New("metric has no data"))
+	}
+}
+
+func (b *batch) addNumber(
+	name string,
+	point *metricspb.NumberDataPoint,
+	resourceLabels map[string]string,
+	add func(id string, value float64, timestamp int64) error,
+) {
+	if noRecordedValue(point.GetFlags()) {
+		return
+	}
+	var value float64
+	switch v := point.GetValue().(type) {
+	case *metricspb.NumberDataPoint_AsDouble:
+		value = v.AsDouble
+	case *metricspb.NumberDataPoint_AsInt:
+		value = float64(v.AsInt)
+	default:
+		b.reject(name, 1, errNoValue)
+		return
+	}
+	id := shared.WithLabels(name, labels(resourceLabels, point.GetAttributes()))
+	if err := add(id, value, unixMilli(point.GetTimeUnixNano())); err != nil {
+		b.reject(id, 1, err)
+	}
+}
+
+func (b *batch) addHistogram(
+	name string,
+	temporality metricspb.AggregationTemporality,
+	point *metricspb.HistogramDataPoint,
+	resourceLabels map[string]string,
+) {
+	if noRecordedValue(point.GetFlags()) {
+		return
+	}
+	pointLabels := labels(resourceLabels, point.GetAttributes())
+	start, timestamp := point.GetStartTimeUnixNano(), unixMilli(point.GetTimeUnixNano())
+
+	if err := b.check(shared.Counter, timestamp); err != nil {
+		b.reject(name, 1, err)
+		return
+	}
+	if sum := point.GetSum(); math.IsNaN(sum) || math.IsInf(sum, 0) {
+		b.reject(name, 1, fmt.Errorf("sum %v isn't finite", sum))
+		return
+	}
+	if temporality == metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_UNSPECIFIED {
+		b.reject(name, 1, errUnspecifiedTemporality)
+		return
+	}
+	bounds, counts := point.GetExplicitBounds(), point.GetBucketCounts()
+	if len(counts) > 0 && len(counts) != len(bounds)+1 {
+		b.reject(name, 1, fmt.Errorf("%d bucket counts don't match %d bounds", len(counts), len(bounds)))
+		return
+	}
+
+	// the point is validated above, so adding its series can't fail
+	_ = b.addCounter(shared.WithLabels(name+"_count", pointLabels), temporality, start, float64(point.GetCount()), timestamp)
+	if point.Sum != nil {
+		_ = b.addGauge(shared.WithLabels(name+"_sum", pointLabels), point.GetSum(), timestamp)
+	}
+	var cumulativeCount uint64
+	for i, count := range counts {
+		cumulativeCount += count
+		le := "+Inf"
+		if i < len(bounds) {
+			le = strconv.FormatFloat(bounds[i], 'g', -1, 64)
+		}
+		bucketLabels := make(map[string]string, len(pointLabels)+1)
+		for key, value := range pointLabels {
+			bucketLabels[key] = value
+		}
+		bucketLabels["le"] = le
+		_ = b.addCounter(shared.WithLabels(name+"_bucket", bucketLabels), temporality, start, float64(cumulativeCount), timestamp)
+	}
+}
+
+// check validates the timestamp of the data point.
+func (b *batch) check(mType string, timestamp int64) error {
+	if timestamp == 0 {
+		return errNoTimestamp
+	}
+	return b.validate(shared.Metric{MType: mType, Timestamp: &timestamp})
+}
+
+func (b *batch) addGauge(id string, value float64, timestamp int64) error {
+	if err := b.check(shared.Gauge, timestamp); err != nil {
+		return err
+	}
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return fmt.Errorf("value %v isn't finite", value)
+	}
+	b.metrics = append(b.metrics, shared.Metric{ID: id, MType: shared.Gauge, Value: &value, Timestamp: &timestamp})
+	return nil
+}
+
+func (b *batch) addCounter(
+	id string,
+	temporality metricspb.AggregationTemporality,
+	start uint64,
+	value float64,
+	timestamp int64,
+) error {
+	if err := b.check(shared.Counter, timestamp); err != nil {
+		return err
+	}
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return fmt.Errorf("value %v isn't finite", value)
+	}
+
+	delta := math.Round(value)
+	switch temporality {
+	case metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA:
+	case metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE:
+		last, ok := b.cumulative[id]
+		if !ok {
+			last, ok = b.converter.cumulative[id]
+		}
+		restarted := start != 0 && last.start != 0 && start != last.start
+		if ok && !restarted && value >= last.value {
+			delta = math.Round(value) - math.Round(last.value)
+		}
+		b.cumulative[id] = cumulativePoint{start: start, value: value}
+	default:
+		return errUnspecifiedTemporality
+	}
+	increment := int64(delta)
+	b.metrics = append(b.metrics, shared.Metric{ID: id, MType: shared.Counter, Delta: &increment})
+	return nil
+}
+
+// labels merges the resource labels with the data point attributes.
+func labels(resourceLabels map[string]string, attributes []*commonpb.KeyValue) map[string]string {
+	merged := make(map[string]string, len(resourceLabels)+len(attributes))
+	for key, value := range resourceLabels {
+		merged[key] = value
+	}
+	for _, attribute := range attributes {
+		merged[attribute.GetKey()] = anyValueString(attribute.GetValue())
+	}
+	return merged
+}
+
+// anyValueString renders the attribute value as a label value, arrays and maps are rendered as JSON.
+func anyValueString(value *commonpb.AnyValue) string {
+	if s, ok := value.GetValue().(*commonpb.AnyValue_StringValue); ok {
+		return s.StringValue
+	}
+	data, err := json.Marshal(anyValueJSON(value))
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+func anyValueJSON(value *commonpb.AnyValue) interface{} {
+	switch v := value.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		return v.StringValue
+	case *commonpb.AnyValue_BoolValue:
+		return v.BoolValue
+	case *commonpb.AnyValue_IntValue:
+		return v.IntValue
+	case *commonpb.AnyValue_DoubleValue:
+		return v.DoubleValue
+	case *commonpb.AnyValue_BytesValue:
+		return base64.StdEncoding.EncodeToString(v.BytesValue)
+	case *commonpb.AnyValue_ArrayValue:
+		values := make([]interface{}, 0, len(v.ArrayValue.GetValues()))
+		for _, item := range v.ArrayValue.GetValues() {
+			values = append(values, anyValueJSON(item))
+		}
+		return values
+	case *commonpb.AnyValue_KvlistValue:
+		values := make(map[string]interface{}, len(v.KvlistValue.GetValues()))
+		for _, item := range v.KvlistValue.GetValues() {
+			values[item.GetKey()] = anyValueJSON(item.GetValue())
+		}
+		return values
+	}
+	return nil
+}
+
+func noRecordedValue(flags uint32) bool {
+	return flags&uint32(metricspb.DataPointFlags_DATA_POINT_FLAGS_NO_RECORDED_VALUE_MASK) != 0
+}
+
+func unixMilli(nanos uint64) int64 {
+	return int64(nanos / 1e6)
+}
@@ -0,0 +1,140 @@
+package otlp
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+
+	"github.com/gonozov0/go-musthave-devops/internal/shared"
+)
+
+const (
+	cumulative = metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE
+	delta      = metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA
+)
+
+func stringAttribute(key, value string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{Key: key, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}}}
+}
+
+func request(metrics ...*metricspb.Metric) *colmetricspb.ExportMetricsServiceRequest {
+	return &colmetricspb.ExportMetricsServiceRequest{ResourceMetrics: []*metricspb.ResourceMetrics{{
+		Resource: &resourcepb.Resource{Attributes: []*commonpb.KeyValue{
+			stringAttribute("service.name", "checkout"),
+			stringAttribute("process.pid", "42"),
+		}},
+		ScopeMetrics: []*metricspb.ScopeMetrics{{Metrics: metrics}},
+	}}}
+}
+
+func sum(name string, temporality metricspb.AggregationTemporality, monotonic bool, start, ts uint64, value int64) *metricspb.Metric {
+	return &metricspb.Metric{Name: name, Data: &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+		AggregationTemporality: temporality,
+		IsMonotonic:            monotonic,
+		DataPoints: []*metricspb.NumberDataPoint{{
+			StartTimeUnixNano: start,
+			TimeUnixNano:      ts,
+			Value:             &metricspb.NumberDataPoint_AsInt{AsInt: value},
+		}},
+	}}}
+}
+
+func collect(metrics []shared.Metric) map[string]float64 {
+	values := make(map[string]float64)
+	for _, metric := range metrics {
+		if metric.MType == shared.Counter {
+			values["counter "+metric.ID] += float64(*metric.Delta)
+		} else {
+			values["gauge "+metric.ID] = *metric.Value
+		}
+	}
+	return values
+}
+
+func TestConverterWrite(t *testing.T) {
+	converter := NewConverter(DefaultResourceAttributes)
+	now := uint64(time.Now().UnixNano())
+	validate := func(shared.Metric) error { return nil }
+	var written []shared.Metric
+	update := func(metrics []shared.Metric) error {
+		written = metrics
+		return nil
+	}
+
+	sumValue := 1.5
+	result, err := converter.Write(request(
+		&metricspb.Metric{Name: "temperature", Data: &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{
+			DataPoints: []*metricspb.NumberDataPoint{{
+				TimeUnixNano: now,
+				Attributes:   []*commonpb.KeyValue{stringAttribute("room", "kitchen")},
+				Value:        &metricspb.NumberDataPoint_AsDouble{AsDouble: 21.5},
+			}},
+		}}},
+		sum("requests", cumulative, true, 1, now, 10),
+		sum("bytes", delta, true, 1, now, 300),
+		sum("queue_size", cumulative, false, 1, now, 7),
+		&metricspb.Metric{Name: "latency", Data: &metricspb.Metric_Histogram{Histogram: &metricspb.Histogram{
+			AggregationTemporality: delta,
+			DataPoints: []*metricspb.HistogramDataPoint{{
+				TimeUnixNano:   now,
+				Count:          3,
+				Sum:            &sumValue,
+				ExplicitBounds: []float64{0.5, 1},
+				BucketCounts:   []uint64{1, 1, 1},
+			}},
+		}}},
+		&metricspb.Metric{Name: "sizes", Data: &metricspb.Metric_Summary{Summary: &metricspb.Summary{
+			DataPoints: []*metricspb.SummaryDataPoint{{TimeUnixNano: now}},
+		}}},
+		sum("no_time", delta, true, 0, 0, 1),
+	), validate, update)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), result.RejectedDataPoints)
+	require.Contains(t, result.ErrorMessage, "summaries aren't supported")
+	require.Contains(t, result.ErrorMessage, "data point has no timestamp")
+	require.Equal(t, map[string]float64{
+		`gauge temperature{room="kitchen",service.name="checkout"}`: 21.5,
+		`counter requests{service.name="checkout"}`:                 10,
+		`counter bytes{service.name="checkout"}`:                    300,
+		`gauge queue_size{service.name="checkout"}`:                 7,
+		`counter latency_count{service.name="checkout"}`:            3,
+		`gauge latency_sum{service.name="checkout"}`:                1.5,
+		`counter latency_bucket{le="0.5",service.name="checkout"}`:  1,
+		`counter latency_bucket{le="1",service.name="checkout"}`:    2,
+		`counter latency_bucket{le="+Inf",service.name="checkout"}`: 3,
+	}, collect(written))
+
+	// cumulative points are converted to increments, a new start time resets the series
+	_, err = converter.Write(request(sum("requests", cumulative, true, 1, now+1, 25)), validate, update)
+	require.NoError(t, err)
+	require.Equal(t, map[string]float64{`counter requests{service.name="checkout"}`: 15}, collect(written))
+
+	_, err = converter.Write(
+		request(sum("requests", cumulative, true, 2, now+2, 30)),
+		validate,
+		func([]shared.Metric) error { return errors.New("storage is down") },
+	)
+	require.Error(t, err)
+	_, err = converter.Write(request(sum("requests", cumulative, true, 2, now+2, 30)), validate, update)
+	require.NoError(t, err)
+	require.Equal(t, map[string]float64{`counter requests{service.name="checkout"}`: 30}, collect(written))
+}
+
+func TestResourceAttributes(t *testing.T) {
+	var written []shared.Metric
+	update := func(metrics []shared.Metric) error {
+		written = metrics
+		return nil
+	}
+	now := uint64(time.Now().UnixNano())
+
+	_, err := NewConverter([]string{"*"}).Write(request(sum("bytes", delta, true, 1, now, 1)), func(shared.Metric) error { return nil }, update)
+	require.NoError(t, err)
+	require.Equal(t, map[string]float64{`counter bytes{process.pid="42",service.name="checkout"}`: 1}, collect(written))
+}
@@ -0,0 +1,178 @@
+package profiles
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const fileExtension = ".pprof"
+
+// fileStore keeps every profile in a separate file of the directory.
+// The profile metadata is encoded in the file name: <unix nanos>_<type>_<base64 source>.pprof,
+// the file name without the extension is the profile ID.
+type fileStore struct {
+	mu        sync.Mutex
+	dir       string
+	retention Retention
+}
+
+// NewFileStore creates a store keeping profiles in the directory.
+func NewFileStore(dir string, retention Retention) (Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create profiles directory: %w", err)
+	}
+	return &fileStore{dir: dir, retention: retention}, nil
+}
+
+// Save writes the profile to a new file and deletes the files exceeding the retention limits.
+func (s *fileStore) Save(profile Profile, data []byte) (Profile, error) {
+	if err := Validate(profile); err != nil {
+		return Profile{}, err
+	}
+	profile.ID = fmt.Sprintf(
+		"%d_%s_%s",
+		profile.Timestamp.UnixNano(),
+		profile.Type,
+		base64.RawURLEncoding.EncodeToString([]byte(profile.Source)),
+	)
+	profile.Size = int64(len(data))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path := filepath.Join(s.dir, profile.ID+fileExtension)
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return Profile{}, fmt.Errorf("failed to write profile: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return Profile{}, fmt.Errorf("failed to write profile: %w", err)
+	}
+
+	if err := s.applyRetention(); err != nil {
+		return Profile{}, err
+	}
+	return profile, nil
+}
+
+// List returns the matching profiles from the newest to the oldest.
+func (s *fileStore) List(filter Filter) ([]Profile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	all, err := s.list()
+	if err != nil {
+		return nil, err
+	}
+	profiles := make([]Profile, 0, len(all))
+	for _, profile := range all {
+		if filter.Match(profile) {
+			profiles = append(profiles, profile)
+		}
+	}
+	return profiles, nil
+}
+
+// Get reads the profile file.
+func (s *fileStore) Get(id string) (Profile, []byte, error) {
+	profile, err := parseProfileID(id)
+	if err != nil {
+		return Profile{}, nil, ErrProfileNotFound
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := os.ReadFile(filepath.Join(s.dir, id+fileExtension))
+	if errors.Is(err, os.ErrNotExist) {
+		return Profile{}, nil, ErrProfileNotFound
+	}
+	if err != nil {
+		return Profile{}, nil, fmt.Errorf("failed to read profile: %w", err)
+	}
+	profile.Size = int64(len(data))
+	return profile, data, nil
+}
+
+// list returns all stored profiles from the newest to the oldest. It must be called with mu held.
+func (s *fileStore) list() ([]Profile, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read profiles directory: %w", err)
+	}
+
+	profiles := make([]Profile, 0, len(entries))
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), fileExtension)
+		if !ok || entry.IsDir() {
+			continue
+		}
+		profile, err := parseProfileID(id)
+		if err != nil {
+			continue // not a profile file
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue // deleted concurrently
+		}
+		profile.Size = info.Size()
+		profiles = append(profiles, profile)
+	}
+	sort.Slice(profiles, func(i, j int) bool {
+		return profiles[i].Timestamp.After(profiles[j].Timestamp)
+	})
+	return profiles, nil
+}
+
+// applyRetention deletes the profiles older than MaxAge and the oldest profiles exceeding MaxBytes.
+// It must be called with mu held.
+func (s *fileStore) applyRetention() error {
+	if s.retention.MaxAge <= 0 && s.retention.MaxBytes <= 0 {
+		return nil
+	}
+	profiles, err := s.list()
+	if err != nil {
+		return err
+	}
+
+	minTime := time.Now().Add(-s.retention.MaxAge)
+	var total int64
+	for _, profile := range profiles {
+		total += profile.Size
+		expired := s.retention.MaxAge > 0 && profile.Timestamp.Before(minTime)
+		tooLarge := s.retention.MaxBytes > 0 && total > s.retention.MaxBytes
+		if !expired && !tooLarge {
+			continue
+		}
+		err := os.Remove(filepath.Join(s.dir, profile.ID+fileExtension))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to delete profile: %w", err)
+		}
+	}
+	return nil
+}
+
+// parseProfileID restores the profile metadata from its ID.
+func parseProfileID(id string) (Profile, error) {
+	parts := strings.SplitN(id, "_", 3)
+	if len(parts) != 3 || !typeRegexp.MatchString(parts[1]) {
+		return Profile{}, fmt.Errorf("invalid profile ID %q", id)
+	}
+	nanos, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return Profile{}, fmt.Errorf("invalid profile ID %q", id)
+	}
+	source, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Profile{}, fmt.Errorf("invalid profile ID %q", id)
+	}
+	return Profile{ID: id, Source: string(source), Type: parts[1], Timestamp: time.Unix(0, nanos)}, nil
+}
@@ -0,0 +1,70 @@
+package profiles
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileStore(t *testing.T) {
+	store, err := NewFileStore(t.TempDir(), Retention{})
+	require.NoError(t, err)
+
+	now := time.Now()
+	cpu, err := store.Save(Profile{Source: "host_1:8080", Type: "cpu", Timestamp: now.Add(-time.Minute)}, []byte("cpu"))
+	require.NoError(t, err)
+	heap, err := store.Save(Profile{Source: "host_1:8080", Type: "heap", Timestamp: now}, []byte("heap"))
+	require.NoError(t, err)
+	_, err = store.Save(Profile{Source: "host2", Type: "heap", Timestamp: now}, []byte("heap"))
+	require.NoError(t, err)
+
+	_, err = store.Save(Profile{Source: "host2", Type: "../heap", Timestamp: now}, []byte("heap"))
+	require.Error(t, err)
+
+	list, err := store.List(Filter{Source: "host_1:8080"})
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	require.Equal(t, heap.ID, list[0].ID)
+	require.Equal(t, cpu.ID, list[1].ID)
+	require.Equal(t, "host_1:8080", list[1].Source)
+	require.Equal(t, "cpu", list[1].Type)
+	require.Equal(t, int64(3), list[1].Size)
+	require.True(t, cpu.Timestamp.Equal(list[1].Timestamp))
+
+	list, err = store.List(Filter{Type: "heap", From: now.Add(-time.Second)})
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+
+	list, err = store.List(Filter{To: now.Add(-time.Second)})
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+
+	profile, data, err := store.Get(cpu.ID)
+	require.NoError(t, err)
+	require.Equal(t, []byte("cpu"), data)
+	require.Equal(t, cpu.Source, profile.Source)
+
+	_, _, err = store.Get("1_cpu_../../etc")
+	require.ErrorIs(t, err, ErrProfileNotFound)
+	_, _, err = store.Get("1_cpu_aG9zdA")
+	require.ErrorIs(t, err, ErrProfileNotFound)
+}
+
+func TestFileStoreRetention(t *testing.T) {
+	store, err := NewFileStore(t.TempDir(), Retention{MaxAge: time.Hour, MaxBytes: 10})
+	require.NoError(t, err)
+
+	now := time.Now()
+	_, err = store.Save(Profile{Source: "host", Type: "cpu", Timestamp: now.Add(-2 * time.Hour)}, []byte("old"))
+	require.NoError(t, err)
+	_, err = store.Save(Profile{Source: "host", Type: "cpu", Timestamp: now.Add(-time.Minute)}, []byte("12345"))
+	require.NoError(t, err)
+	latest, err := store.Save(Profile{Source: "host", Type: "cpu", Timestamp: now}, []byte("123456"))
+	require.NoError(t, err)
+
+	list, err := store.List(Filter{})
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	require.Equal(t, latest.ID, list[0].ID)
+}
@@ -0,0 +1,74 @@
+package profiles
+
+import (
+	"errors"
+	"regexp"
+	"time"
+)
+
+// ErrProfileNotFound is returned when there is no profile with the requested ID.
+var ErrProfileNotFound = errors.New("profile not found")
+
+// maxSourceLength keeps file names of the file store within the file system limits.
+const maxSourceLength = 128
+
+var typeRegexp = regexp.MustCompile(`^[a-z][a-z0-9]*$`)
+
+// Profile describes a stored pprof profile.
+type Profile struct {
+	ID        string    `json:"id"`
+	Source    string    `json:"source"` // the process the profile was taken from
+	Type      string    `json:"type"`   // cpu, heap, goroutine, etc.
+	Timestamp time.Time `json:"timestamp"`
+	Size      int64     `json:"size"`
+}
+
+// Filter selects profiles in List. Empty fields match everything, the time range is inclusive.
+type Filter struct {
+	Source string
+	Type   string
+	From   time.Time
+	To     time.Time
+}
+
+// Match reports whether the profile passes the filter.
+func (f Filter) Match(profile Profile) bool {
+	return (f.Source == "" || f.Source == profile.Source) &&
+		(f.Type == "" || f.Type == profile.Type) &&
+		(f.From.IsZero() || !profile.Timestamp.Before(f.From)) &&
+		(f.To.IsZero() || !profile.Timestamp.After(f.To))
+}
+
+// Retention limits the stored profiles. Zero values disable the corresponding limit.
+type Retention struct {
+	MaxAge   time.Duration
+	MaxBytes int64 // total size of the stored profiles, the oldest ones are deleted first
+}
+
+// Store persists profiles.
+type Store interface {
+	// Save stores the profile data and applies the retention limits.
+	// ID and Size of the profile are set by the store.
+	Save(profile Profile, data []byte) (Profile, error)
+	// List returns the profiles matching the filter ordered from the newest to the oldest.
+	List(filter Filter) ([]Profile, error)
+	// Get returns the profile and its data.
+	Get(id string) (Profile, []byte, error)
+}
+
+// Validate checks the fields of the profile to be saved.
+func Validate(profile Profile) error {
+	if profile.Source == "" {
+		return errors.New("profile source is required")
+	}
+	if len(profile.Source) > maxSourceLength {
+		return errors.New("profile source is too long")
+	}
+	if !typeRegexp.MatchString(profile.Type) {
+		return errors.New("profile type must consist of lowercase letters and digits")
+	}
+	if profile.Timestamp.IsZero() {
+		return errors.New("profile timestamp is required")
+	}
+	return nil
+}
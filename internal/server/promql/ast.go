@@ -0,0 +1,118 @@
+package promql
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// Expr is a node of the parsed query.
+type Expr interface {
+	expr()
+}
+
+// NumberLiteral is a scalar constant.
+type NumberLiteral struct {
+	Value float64
+}
+
+// MatchType is the operator of a label matcher.
+type MatchType string
+
+// Label matcher operators.
+const (
+	MatchEqual     MatchType = "="
+	MatchNotEqual  MatchType = "!="
+	MatchRegexp    MatchType = "=~"
+	MatchNotRegexp MatchType = "!~"
+)
+
+// Matcher matches the value of a label. A missing label has the empty value.
+type Matcher struct {
+	Type  MatchType
+	Name  string
+	Value string
+	re    *regexp.Regexp
+}
+
+// NewMatcher creates a new Matcher, the regular expressions are anchored.
+func NewMatcher(matchType MatchType, name, value string) (*Matcher, error) {
+	m := &Matcher{Type: matchType, Name: name, Value: value}
+	if matchType == MatchRegexp || matchType == MatchNotRegexp {
+		re, err := regexp.Compile("^(?:" + value + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression %q: %w", value, err)
+		}
+		m.re = re
+	}
+	return m, nil
+}
+
+// Matches reports whether the label value matches.
+func (m *Matcher) Matches(value string) bool {
+	switch m.Type {
+	case MatchEqual:
+		return value == m.Value
+	case MatchNotEqual:
+		return value != m.Value
+	case MatchRegexp:
+		return m.re.MatchString(value)
+	default:
+		return !m.re.MatchString(value)
+	}
+}
+
+// VectorSelector selects the series matching all matchers, the metric name is the __name__ matcher.
+type VectorSelector struct {
+	Matchers []*Matcher
+}
+
+// MatrixSelector selects the samples of the series within the range before the evaluation time.
+type MatrixSelector struct {
+	Vector *VectorSelector
+	Range  time.Duration
+}
+
+// Call is a function call.
+type Call struct {
+	Func string
+	Args []Expr
+}
+
+// AggregateExpr aggregates the samples of the vector into groups by the grouping labels,
+// or by all labels except them when Without is set.
+type AggregateExpr struct {
+	Op       string
+	Grouping []string
+	Without  bool
+	Expr     Expr
+}
+
+// VectorMatching selects the labels matching the samples of two vectors, all labels except
+// the metric name by default.
+type VectorMatching struct {
+	On     bool // only the labels are matched, otherwise they're ignored
+	Labels []string
+}
+
+// BinaryExpr is an arithmetic or comparison operation.
+type BinaryExpr struct {
+	Op         string
+	LHS, RHS   Expr
+	ReturnBool bool // comparisons return 0 or 1 instead of filtering
+	Matching   *VectorMatching
+}
+
+// UnaryExpr is a negation.
+type UnaryExpr struct {
+	Op   string
+	Expr Expr
+}
+
+func (*NumberLiteral) expr()  {}
+func (*VectorSelector) expr() {}
+func (*MatrixSelector) expr() {}
+func (*Call) expr()           {}
+func (*AggregateExpr) expr()  {}
+func (*BinaryExpr) expr()     {}
+func (*UnaryExpr) expr()      {}
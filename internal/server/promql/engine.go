@@ -0,0 +1,472 @@
+package promql
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// LookbackDelta is how old the latest sample of a series can be to be selected by an instant selector.
+const LookbackDelta = 5 * time.Minute
+
+// Storage provides the series to the engine.
+type Storage interface {
+	// Series returns the labels of the series matching all matchers.
+	Series(matchers []*Matcher) ([]Labels, error)
+	// Select returns the series matching all matchers with their points within [from, to] ordered by time.
+	Select(matchers []*Matcher, from, to time.Time) ([]Series, error)
+}
+
+// StorageError is a failure of the storage, as opposed to an invalid query.
+type StorageError struct {
+	Err error
+}
+
+func (e *StorageError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *StorageError) Unwrap() error {
+	return e.Err
+}
+
+// Engine evaluates the queries against the storage.
+type Engine struct {
+	storage Storage
+}
+
+func NewEngine(storage Storage) *Engine {
+	return &Engine{storage: storage}
+}
+
+// Instant evaluates the query at the time.
+func (e *Engine) Instant(query string, t time.Time) (Value, error) {
+	expr, err := Parse(query)
+	if err != nil {
+		return nil, err
+	}
+	ev, err := e.load(expr, t, t)
+	if err != nil {
+		return nil, err
+	}
+	value, err := ev.eval(expr, t.UnixMilli())
+	if err != nil {
+		return nil, err
+	}
+	switch v := value.(type) {
+	case Vector:
+		sortVector(v)
+	case Matrix:
+		sortMatrix(v)
+	}
+	return value, nil
+}
+
+// Range evaluates the query at every step from start to end and returns the series of the results.
+func (e *Engine) Range(query string, start, end time.Time, step time.Duration) (Matrix, error) {
+	if step <= 0 {
+		return nil, errors.New("zero or negative query resolution step widths are not accepted")
+	}
+	if end.Before(start) {
+		return nil, errors.New("end timestamp must not be before start time")
+	}
+	expr, err := Parse(query)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := expr.(*MatrixSelector); ok {
+		return nil, &ParseError{Err: "invalid expression type \"range vector\" for range query, must be scalar or instant vector"}
+	}
+	ev, err := e.load(expr, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	series := make(map[string]*Series)
+	for t := start; !t.After(end); t = t.Add(step) {
+		ts := t.UnixMilli()
+		value, err := ev.eval(expr, ts)
+		if err != nil {
+			return nil, err
+		}
+		var vector Vector
+		switch v := value.(type) {
+		case Scalar:
+			vector = Vector{{Metric: Labels{}, Point: Point{T: ts, V: v.V}}}
+		case Vector:
+			vector = v
+		}
+		for _, sample := range vector {
+			key := sample.Metric.key()
+			s, ok := series[key]
+			if !ok {
+				s = &Series{Metric: sample.Metric}
+				series[key] = s
+			}
+			s.Points = append(s.Points, Point{T: ts, V: sample.V})
+		}
+	}
+
+	matrix := make(Matrix, 0, len(series))
+	for _, s := range series {
+		matrix = append(matrix, *s)
+	}
+	sortMatrix(matrix)
+	return matrix, nil
+}
+
+// evaluator evaluates the expression on the series loaded for the selectors.
+type evaluator struct {
+	series map[*VectorSelector][]Series
+}
+
+// load selects the series of every selector of the expression needed to evaluate it from start to end.
+// The range functions also need the latest point before their range.
+func (e *Engine) load(expr Expr, start, end time.Time) (*evaluator, error) {
+	ev := &evaluator{series: make(map[*VectorSelector][]Series)}
+	var walk func(expr Expr) error
+	selectSeries := func(selector *VectorSelector, lookback time.Duration) error {
+		series, err := e.storage.Select(selector.Matchers, start.Add(-lookback), end)
+		if err != nil {
+			return &StorageError{Err: err}
+		}
+		ev.series[selector] = series
+		return nil
+	}
+	walk = func(expr Expr) error {
+		switch ex := expr.(type) {
+		case *VectorSelector:
+			return selectSeries(ex, LookbackDelta)
+		case *MatrixSelector:
+			return selectSeries(ex.Vector, ex.Range+LookbackDelta)
+		case *Call:
+			for _, arg := range ex.Args {
+				if err := walk(arg); err != nil {
+					return err
+				}
+			}
+		case *AggregateExpr:
+			return walk(ex.Expr)
+		case *UnaryExpr:
+			return walk(ex.Expr)
+		case *BinaryExpr:
+			if err := walk(ex.LHS); err != nil {
+				return err
+			}
+			return walk(ex.RHS)
+		}
+		return nil
+	}
+	if err := walk(expr); err != nil {
+		return nil, err
+	}
+	return ev, nil
+}
+
+func (ev *evaluator) eval(expr Expr, t int64) (Value, error) {
+	switch ex := expr.(type) {
+	case *NumberLiteral:
+		return Scalar{T: t, V: ex.Value}, nil
+	case *VectorSelector:
+		return ev.selectVector(ex, t), nil
+	case *MatrixSelector:
+		return ev.selectMatrix(ex, t), nil
+	case *Call:
+		return ev.call(ex, t), nil
+	case *AggregateExpr:
+		value, err := ev.eval(ex.Expr, t)
+		if err != nil {
+			return nil, err
+		}
+		return aggregate(ex, value.(Vector), t), nil
+	case *UnaryExpr:
+		value, err := ev.eval(ex.Expr, t)
+		if err != nil {
+			return nil, err
+		}
+		if scalar, ok := value.(Scalar); ok {
+			return Scalar{T: t, V: -scalar.V}, nil
+		}
+		vector := value.(Vector)
+		result := make(Vector, 0, len(vector))
+		for _, sample := range vector {
+			result = append(result, Sample{Metric: sample.Metric.without(nameLabel), Point: Point{T: t, V: -sample.V}})
+		}
+		return result, nil
+	case *BinaryExpr:
+		lhs, err := ev.eval(ex.LHS, t)
+		if err != nil {
+			return nil, err
+		}
+		rhs, err := ev.eval(ex.RHS, t)
+		if err != nil {
+			return nil, err
+		}
+		return binary(ex, lhs, rhs, t)
+	default:
+		return nil, fmt.Errorf("unsupported expression %T", expr)
+	}
+}
+
+// selectVector returns the latest point of every series at the time within the lookback delta.
+func (ev *evaluator) selectVector(selector *VectorSelector, t int64) Vector {
+	vector := make(Vector, 0)
+	for _, series := range ev.series[selector] {
+		i := sort.Search(len(series.Points), func(i int) bool { return series.Points[i].T > t })
+		if i == 0 || t-series.Points[i-1].T > LookbackDelta.Milliseconds() {
+			continue
+		}
+		vector = append(vector, Sample{Metric: series.Metric, Point: Point{T: t, V: series.Points[i-1].V}})
+	}
+	return vector
+}
+
+// selectMatrix returns the points of every series within the range before the time.
+func (ev *evaluator) selectMatrix(selector *MatrixSelector, t int64) Matrix {
+	matrix := make(Matrix, 0)
+	for _, series := range ev.series[selector.Vector] {
+		points := window(series.Points, t-selector.Range.Milliseconds(), t)
+		if len(points) > 0 {
+			matrix = append(matrix, Series{Metric: series.Metric, Points: points})
+		}
+	}
+	return matrix
+}
+
+// window returns the points within (from, to].
+func window(points []Point, from, to int64) []Point {
+	start := sort.Search(len(points), func(i int) bool { return points[i].T > from })
+	end := sort.Search(len(points), func(i int) bool { return points[i].T > to })
+	return points[start:end]
+}
+
+// call evaluates the range function. The stored values hold until the next point, so the latest
+// point before the range is included and the first change within the range counts.
+func (ev *evaluator) call(call *Call, t int64) Vector {
+	selector := call.Args[0].(*MatrixSelector)
+	from := t - selector.Range.Milliseconds()
+	vector := make(Vector, 0)
+	for _, series := range ev.series[selector.Vector] {
+		start := sort.Search(len(series.Points), func(i int) bool { return series.Points[i].T > from })
+		end := sort.Search(len(series.Points), func(i int) bool { return series.Points[i].T > t })
+		if start > 0 {
+			start--
+		}
+		points := series.Points[start:end]
+		if len(points) < 2 {
+			continue
+		}
+
+		var value float64
+		switch call.Func {
+		case "delta":
+			value = points[len(points)-1].V - points[0].V
+		default:
+			// counter resets start from zero
+			for i := 1; i < len(points); i++ {
+				if points[i].V < points[i-1].V {
+					value += points[i].V
+				} else {
+					value += points[i].V - points[i-1].V
+				}
+			}
+			if call.Func == "rate" {
+				value /= selector.Range.Seconds()
+			}
+		}
+		vector = append(vector, Sample{Metric: series.Metric.without(nameLabel), Point: Point{T: t, V: value}})
+	}
+	return vector
+}
+
+type group struct {
+	labels Labels
+	value  float64
+	count  int
+}
+
+// aggregate aggregates the samples of the vector by the grouping labels.
+func aggregate(expr *AggregateExpr, vector Vector, t int64) Vector {
+	groups := make(map[string]*group)
+	var order []string
+	for _, sample := range vector {
+		var labels Labels
+		if expr.Without {
+			labels = sample.Metric.without(append([]string{nameLabel}, expr.Grouping...)...)
+		} else {
+			labels = sample.Metric.only(expr.Grouping...)
+		}
+		key := labels.key()
+		g, ok := groups[key]
+		if !ok {
+			groups[key] = &group{labels: labels, value: sample.V, count: 1}
+			order = append(order, key)
+			continue
+		}
+		g.count++
+		switch expr.Op {
+		case "sum", "avg":
+			g.value += sample.V
+		case "min":
+			if sample.V < g.value || math.IsNaN(g.value) {
+				g.value = sample.V
+			}
+		case "max":
+			if sample.V > g.value || math.IsNaN(g.value) {
+				g.value = sample.V
+			}
+		}
+	}
+
+	result := make(Vector, 0, len(groups))
+	for _, key := range order {
+		g := groups[key]
+		value := g.value
+		switch expr.Op {
+		case "avg":
+			value /= float64(g.count)
+		case "count":
+			value = float64(g.count)
+		}
+		result = append(result, Sample{Metric: g.labels, Point: Point{T: t, V: value}})
+	}
+	return result
+}
+
+// binary evaluates the binary operation on scalars and vectors.
+func binary(expr *BinaryExpr, lhs, rhs Value, t int64) (Value, error) {
+	lhsScalar, lhsIsScalar := lhs.(Scalar)
+	rhsScalar, rhsIsScalar := rhs.(Scalar)
+	switch {
+	case lhsIsScalar && rhsIsScalar:
+		value, keep := apply(expr.Op, lhsScalar.V, rhsScalar.V)
+		if comparisons[expr.Op] {
+			value = boolValue(keep)
+		}
+		return Scalar{T: t, V: value}, nil
+	case rhsIsScalar:
+		return vectorScalar(expr, lhs.(Vector), rhsScalar.V, false, t), nil
+	case lhsIsScalar:
+		return vectorScalar(expr, rhs.(Vector), lhsScalar.V, true, t), nil
+	}
+	return vectorVector(expr, lhs.(Vector), rhs.(Vector), t)
+}
+
+// vectorScalar applies the operation to every sample of the vector and the scalar.
+// A filtering comparison keeps the sample value even when the scalar is the left operand.
+func vectorScalar(expr *BinaryExpr, vector Vector, scalar float64, swap bool, t int64) Vector {
+	result := make(Vector, 0, len(vector))
+	for _, sample := range vector {
+		lhs, rhs := sample.V, scalar
+		if swap {
+			lhs, rhs = rhs, lhs
+		}
+		value, keep := apply(expr.Op, lhs, rhs)
+		if comparisons[expr.Op] && !expr.ReturnBool {
+			if keep {
+				result = append(result, Sample{Metric: sample.Metric, Point: Point{T: t, V: sample.V}})
+			}
+			continue
+		}
+		if comparisons[expr.Op] {
+			value = boolValue(keep)
+		}
+		result = append(result, Sample{Metric: sample.Metric.without(nameLabel), Point: Point{T: t, V: value}})
+	}
+	return result
+}
+
+// vectorVector applies the operation to the samples of the vectors with the same matching labels.
+func vectorVector(expr *BinaryExpr, lhs, rhs Vector, t int64) (Vector, error) {
+	signature := func(labels Labels) string {
+		if expr.Matching != nil && expr.Matching.On {
+			return labels.only(expr.Matching.Labels...).key()
+		}
+		ignored := []string{nameLabel}
+		if expr.Matching != nil {
+			ignored = append(ignored, expr.Matching.Labels...)
+		}
+		return labels.without(ignored...).key()
+	}
+
+	rhsBySignature := make(map[string]Sample, len(rhs))
+	for _, sample := range rhs {
+		key := signature(sample.Metric)
+		if _, ok := rhsBySignature[key]; ok {
+			return nil, errors.New("found duplicate series for the match group on the right hand-side of the operation, many-to-many matching not allowed")
+		}
+		rhsBySignature[key] = sample
+	}
+
+	matched := make(map[string]bool, len(lhs))
+	result := make(Vector, 0, len(lhs))
+	for _, sample := range lhs {
+		key := signature(sample.Metric)
+		other, ok := rhsBySignature[key]
+		if !ok {
+			continue
+		}
+		if matched[key] {
+			return nil, errors.New("found duplicate series for the match group on the left hand-side of the operation, many-to-one matching not allowed")
+		}
+		matched[key] = true
+
+		value, keep := apply(expr.Op, sample.V, other.V)
+		if comparisons[expr.Op] && !expr.ReturnBool {
+			if keep {
+				result = append(result, Sample{Metric: sample.Metric, Point: Point{T: t, V: sample.V}})
+			}
+			continue
+		}
+		if comparisons[expr.Op] {
+			value = boolValue(keep)
+		}
+		labels := sample.Metric.without(nameLabel)
+		if expr.Matching != nil && expr.Matching.On {
+			labels = sample.Metric.only(expr.Matching.Labels...)
+		} else if expr.Matching != nil {
+			labels = labels.without(expr.Matching.Labels...)
+		}
+		result = append(result, Sample{Metric: labels, Point: Point{T: t, V: value}})
+	}
+	return result, nil
+}
+
+// apply applies the operator. It returns the result of arithmetic operators and whether the comparison holds.
+func apply(op string, lhs, rhs float64) (float64, bool) {
+	switch op {
+	case "+":
+		return lhs + rhs, true
+	case "-":
+		return lhs - rhs, true
+	case "*":
+		return lhs * rhs, true
+	case "/":
+		return lhs / rhs, true
+	case "%":
+		return math.Mod(lhs, rhs), true
+	case "^":
+		return math.Pow(lhs, rhs), true
+	case "==":
+		return lhs, lhs == rhs
+	case "!=":
+		return lhs, lhs != rhs
+	case ">":
+		return lhs, lhs > rhs
+	case "<":
+		return lhs, lhs < rhs
+	case ">=":
+		return lhs, lhs >= rhs
+	default: // <=
+		return lhs, lhs <= rhs
+	}
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,208 @@
+package promql
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gonozov0/go-musthave-devops/internal/server/repository"
+	inMemory "github.com/gonozov0/go-musthave-devops/internal/server/repository/in_memory"
+	"github.com/gonozov0/go-musthave-devops/internal/shared"
+)
+
+type staticStorage []Series
+
+func (s staticStorage) Series(matchers []*Matcher) ([]Labels, error) {
+	var result []Labels
+	for _, series := range s {
+		if series.Metric.matches(matchers) {
+			result = append(result, series.Metric)
+		}
+	}
+	return result, nil
+}
+
+func (s staticStorage) Select(matchers []*Matcher, from, to time.Time) ([]Series, error) {
+	var result []Series
+	for _, series := range s {
+		if series.Metric.matches(matchers) {
+			result = append(result, Series{Metric: series.Metric, Points: window(series.Points, from.UnixMilli()-1, to.UnixMilli())})
+		}
+	}
+	return result, nil
+}
+
+var testStart = time.Unix(1700000000, 0)
+
+func points(values ...float64) []Point {
+	result := make([]Point, 0, len(values))
+	for i, value := range values {
+		result = append(result, Point{T: testStart.Add(time.Duration(i) * time.Minute).UnixMilli(), V: value})
+	}
+	return result
+}
+
+func newTestEngine() *Engine {
+	return NewEngine(staticStorage{
+		{Metric: Labels{nameLabel: "requests_total", "job": "api", "code": "200"}, Points: points(0, 60, 120, 10, 70)},
+		{Metric: Labels{nameLabel: "requests_total", "job": "api", "code": "500"}, Points: points(0, 6, 12, 18, 24)},
+		{Metric: Labels{nameLabel: "requests_total", "job": "web", "code": "200"}, Points: points(0, 30, 60, 90, 120)},
+		{Metric: Labels{nameLabel: "temperature", "job": "api"}, Points: points(20, 22, 21, 25, 23)},
+	})
+}
+
+func TestInstantQuery(t *testing.T) {
+	engine := newTestEngine()
+	end := testStart.Add(4 * time.Minute)
+
+	tests := []struct {
+		query    string
+		expected Vector
+	}{
+		{
+			query: `requests_total{code="500"}`,
+			expected: Vector{
+				{Metric: Labels{nameLabel: "requests_total", "job": "api", "code": "500"}, Point: Point{T: end.UnixMilli(), V: 24}},
+			},
+		},
+		{
+			// the counter reset after 120 counts the 10 and 60 increments
+			query:    `increase(requests_total{job="api", code="200"}[3m])`,
+			expected: Vector{{Metric: Labels{"job": "api", "code": "200"}, Point: Point{T: end.UnixMilli(), V: 130}}},
+		},
+		{
+			query: `sum by (job) (rate(requests_total[2m]))`,
+			expected: Vector{
+				{Metric: Labels{"job": "api"}, Point: Point{T: end.UnixMilli(), V: 82.0 / 120}},
+				{Metric: Labels{"job": "web"}, Point: Point{T: end.UnixMilli(), V: 0.5}},
+			},
+		},
+		{
+			query:    `delta(temperature[4m])`,
+			expected: Vector{{Metric: Labels{"job": "api"}, Point: Point{T: end.UnixMilli(), V: 3}}},
+		},
+		{
+			query: `requests_total / on (job, code) requests_total{job="api"} * 100`,
+			expected: Vector{
+				{Metric: Labels{"job": "api", "code": "200"}, Point: Point{T: end.UnixMilli(), V: 100}},
+				{Metric: Labels{"job": "api", "code": "500"}, Point: Point{T: end.UnixMilli(), V: 100}},
+			},
+		},
+		{
+			query: `requests_total > 50`,
+			expected: Vector{
+				{Metric: Labels{nameLabel: "requests_total", "job": "api", "code": "200"}, Point: Point{T: end.UnixMilli(), V: 70}},
+				{Metric: Labels{nameLabel: "requests_total", "job": "web", "code": "200"}, Point: Point{T: end.UnixMilli(), V: 120}},
+			},
+		},
+		{
+			query: `max without (code) (requests_total) - ignoring (code) temperature`,
+			expected: Vector{
+				{Metric: Labels{"job": "api"}, Point: Point{T: end.UnixMilli(), V: 47}},
+			},
+		},
+		{
+			query: `count(temperature == bool 23) + avg(requests_total{job="api"})`,
+			expected: Vector{
+				{Metric: Labels{}, Point: Point{T: end.UnixMilli(), V: 48}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			value, err := engine.Instant(tt.query, end)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, value)
+		})
+	}
+
+	value, err := engine.Instant("2 ^ 3 % 5 > bool 2", end)
+	require.NoError(t, err)
+	require.Equal(t, Scalar{T: end.UnixMilli(), V: 1}, value)
+
+	// the latest sample is too old
+	value, err = engine.Instant("temperature", end.Add(LookbackDelta+time.Second))
+	require.NoError(t, err)
+	require.Empty(t, value)
+
+	_, err = engine.Instant("requests_total + temperature", end)
+	require.NoError(t, err)
+	_, err = engine.Instant("requests_total + ignoring (code) temperature", end)
+	require.ErrorContains(t, err, "many-to-one")
+}
+
+func TestRangeQuery(t *testing.T) {
+	engine := newTestEngine()
+
+	matrix, err := engine.Range(`temperature * 2`, testStart, testStart.Add(4*time.Minute), 2*time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, Matrix{{
+		Metric: Labels{"job": "api"},
+		Points: []Point{
+			{T: testStart.UnixMilli(), V: 40},
+			{T: testStart.Add(2 * time.Minute).UnixMilli(), V: 42},
+			{T: testStart.Add(4 * time.Minute).UnixMilli(), V: 46},
+		},
+	}}, matrix)
+
+	matrix, err = engine.Range(`1`, testStart, testStart.Add(time.Minute), time.Minute)
+	require.NoError(t, err)
+	require.Len(t, matrix, 1)
+	require.Len(t, matrix[0].Points, 2)
+
+	_, err = engine.Range(`temperature[5m]`, testStart, testStart.Add(time.Minute), time.Minute)
+	var parseErr *ParseError
+	require.ErrorAs(t, err, &parseErr)
+}
+
+func TestRepositoryStorage(t *testing.T) {
+	repo := inMemory.NewInMemoryRepository()
+	_, err := repo.UpdateCounter(`jobs_total{queue="email"}`, 5)
+	require.NoError(t, err)
+	_, err = repo.UpdateCounter(`jobs_total{queue="email"}`, 3)
+	require.NoError(t, err)
+	_, err = repo.UpdateGauge("queue.length", 7)
+	require.NoError(t, err)
+
+	now := time.Now().Add(time.Second)
+	storage := &repositoryStorage{repo: repo, history: repo.(repository.HistoryRepository), now: func() time.Time { return now }}
+
+	series, err := storage.Select([]*Matcher{{Type: MatchEqual, Name: nameLabel, Value: "jobs_total"}}, now.Add(-time.Hour), now)
+	require.NoError(t, err)
+	require.Len(t, series, 1)
+	require.Equal(t, Labels{nameLabel: "jobs_total", "queue": "email"}, series[0].Metric)
+	last := series[0].Points[len(series[0].Points)-1]
+	require.Equal(t, Point{T: now.UnixMilli(), V: 8}, last)
+
+	engine := NewEngine(storage)
+	value, err := engine.Instant(`increase(jobs_total[1m]) + on () queue_length`, now)
+	require.NoError(t, err)
+	require.Equal(t, Vector{{Metric: Labels{}, Point: Point{T: now.UnixMilli(), V: 15}}}, value)
+
+	value, err = engine.Instant(`queue_length`, now.Add(-2*time.Hour))
+	require.NoError(t, err)
+	require.Empty(t, value)
+}
+
+func TestRepositoryStorageRollups(t *testing.T) {
+	repo := inMemory.NewInMemoryRepository(inMemory.WithHistoryWindow(time.Minute))
+	_, err := repo.UpdateCounter("jobs_total", 5)
+	require.NoError(t, err)
+	_, err = repo.UpdateCounter("jobs_total", 3)
+	require.NoError(t, err)
+
+	// the raw history is expired after it's rolled up
+	now := time.Now().Add(3 * time.Minute)
+	history := repo.(repository.HistoryRepository)
+	require.NoError(t, history.RollupHistory(now, 0, nil))
+	raw, err := history.GetHistory(shared.Counter, "jobs_total", now.Add(-time.Hour), now)
+	require.NoError(t, err)
+	require.Empty(t, raw)
+
+	storage := &repositoryStorage{repo: repo, history: history, now: func() time.Time { return now }}
+	value, err := NewEngine(storage).Instant(`increase(jobs_total[1h])`, now)
+	require.NoError(t, err)
+	require.Len(t, value, 1)
+	require.InDelta(t, 8, value.(Vector)[0].V, 0.01)
+}
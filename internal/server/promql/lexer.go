@@ -0,0 +1,176 @@
+package promql
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdentifier
+	tokenNumber
+	tokenString
+	tokenDuration // the content of [...]
+	tokenOperator
+	tokenLeftParen
+	tokenRightParen
+	tokenLeftBrace
+	tokenRightBrace
+	tokenComma
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+	pos   int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokenEOF:
+		return "end of input"
+	case tokenString:
+		return fmt.Sprintf("string %q", t.value)
+	case tokenDuration:
+		return fmt.Sprintf("[%s]", t.value)
+	default:
+		return fmt.Sprintf("%q", t.value)
+	}
+}
+
+// operators are matched longest first.
+var operators = []string{"==", "!=", ">=", "<=", "=~", "!~", "+", "-", "*", "/", "%", "^", ">", "<", "="}
+
+// lex splits the query into tokens.
+func lex(query string) ([]token, error) {
+	var tokens []token
+	for pos := 0; pos < len(query); {
+		r, size := utf8.DecodeRuneInString(query[pos:])
+		switch {
+		case unicode.IsSpace(r):
+			pos += size
+		case r == '#': // comment up to the end of the line
+			end := strings.IndexByte(query[pos:], '\n')
+			if end < 0 {
+				return tokens, nil
+			}
+			pos += end
+		case r == '(':
+			tokens = append(tokens, token{tokenLeftParen, "(", pos})
+			pos++
+		case r == ')':
+			tokens = append(tokens, token{tokenRightParen, ")", pos})
+			pos++
+		case r == '{':
+			tokens = append(tokens, token{tokenLeftBrace, "{", pos})
+			pos++
+		case r == '}':
+			tokens = append(tokens, token{tokenRightBrace, "}", pos})
+			pos++
+		case r == ',':
+			tokens = append(tokens, token{tokenComma, ",", pos})
+			pos++
+		case r == '[':
+			end := strings.IndexByte(query[pos:], ']')
+			if end < 0 {
+				return nil, &ParseError{Pos: pos, Err: "unclosed ["}
+			}
+			tokens = append(tokens, token{tokenDuration, strings.TrimSpace(query[pos+1 : pos+end]), pos})
+			pos += end + 1
+		case r == '"' || r == '\'' || r == '`':
+			value, end, err := lexString(query, pos)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{tokenString, value, pos})
+			pos = end
+		case r >= '0' && r <= '9' || r == '.' && pos+1 < len(query) && query[pos+1] >= '0' && query[pos+1] <= '9':
+			end := pos
+			for end < len(query) && isNumberChar(query, end) {
+				end++
+			}
+			tokens = append(tokens, token{tokenNumber, query[pos:end], pos})
+			pos = end
+		case isIdentifierStart(r):
+			end := pos + size
+			for end < len(query) {
+				r, size := utf8.DecodeRuneInString(query[end:])
+				if !isIdentifierStart(r) && !(r >= '0' && r <= '9') {
+					break
+				}
+				end += size
+			}
+			tokens = append(tokens, token{tokenIdentifier, query[pos:end], pos})
+			pos = end
+		default:
+			matched := false
+			for _, op := range operators {
+				if strings.HasPrefix(query[pos:], op) {
+					tokens = append(tokens, token{tokenOperator, op, pos})
+					pos += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, &ParseError{Pos: pos, Err: fmt.Sprintf("unexpected character %q", r)}
+			}
+		}
+	}
+	return tokens, nil
+}
+
+// lexString reads the quoted string starting at pos and returns its value and the end position.
+// Backquoted strings are raw, the others support the Go escape sequences.
+func lexString(query string, pos int) (string, int, error) {
+	quote := query[pos]
+	var b strings.Builder
+	for i := pos + 1; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == quote:
+			return b.String(), i + 1, nil
+		case c == '\\' && quote != '`':
+			if i+1 >= len(query) {
+				break
+			}
+			i++
+			switch query[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'r':
+				b.WriteByte('\r')
+			default: // \\, \", \' and the escaped regexp characters are kept as is
+				if query[i] != '\\' && query[i] != '"' && query[i] != '\'' {
+					b.WriteByte('\\')
+				}
+				b.WriteByte(query[i])
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", 0, &ParseError{Pos: pos, Err: "unterminated string"}
+}
+
+func isIdentifierStart(r rune) bool {
+	return r == '_' || r == ':' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z'
+}
+
+// isNumberChar reports whether the character continues a number like 1.5e-3.
+func isNumberChar(query string, i int) bool {
+	c := query[i]
+	switch {
+	case c >= '0' && c <= '9', c == '.', c == 'e', c == 'E':
+		return true
+	case c == '+' || c == '-':
+		return query[i-1] == 'e' || query[i-1] == 'E'
+	}
+	return false
+}
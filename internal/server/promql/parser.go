@@ -0,0 +1,414 @@
+package promql
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseError is an error in the query syntax.
+type ParseError struct {
+	Pos int
+	Err string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("parse error at char %d: %s", e.Pos+1, e.Err)
+}
+
+// precedence of the binary operators, ^ is right-associative.
+var precedence = map[string]int{
+	"==": 1, "!=": 1, ">": 1, "<": 1, ">=": 1, "<=": 1,
+	"+": 2, "-": 2,
+	"*": 3, "/": 3, "%": 3,
+	"^": 4,
+}
+
+var comparisons = map[string]bool{"==": true, "!=": true, ">": true, "<": true, ">=": true, "<=": true}
+
+var aggregations = map[string]bool{"sum": true, "avg": true, "min": true, "max": true, "count": true}
+
+// rangeFunctions take a range vector and return an instant vector.
+var rangeFunctions = map[string]bool{"rate": true, "increase": true, "delta": true}
+
+type parser struct {
+	tokens []token
+	pos    int
+	end    int // the query length for the errors at the end
+}
+
+// Parse parses the query of the supported PromQL subset:
+//   - instant and range vector selectors with label matchers;
+//   - rate, increase and delta of range vectors;
+//   - sum, avg, min, max and count aggregations with by or without;
+//   - arithmetic and comparison operators with bool, on and ignoring.
+func Parse(query string) (Expr, error) {
+	tokens, err := lex(query)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens, end: len(query)}
+	expr, err := p.parseExpr(0)
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, p.errorf(t, "unexpected %s", t)
+	}
+	return expr, nil
+}
+
+func (p *parser) peek() token {
+	if p.pos >= len(p.tokens) {
+		return token{kind: tokenEOF, pos: p.end}
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.peek()
+	if p.pos < len(p.tokens) {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) expect(kind tokenKind, what string) (token, error) {
+	t := p.next()
+	if t.kind != kind {
+		return t, p.errorf(t, "expected %s, got %s", what, t)
+	}
+	return t, nil
+}
+
+func (p *parser) errorf(t token, format string, args ...interface{}) error {
+	return &ParseError{Pos: t.pos, Err: fmt.Sprintf(format, args...)}
+}
+
+// parseExpr parses the binary operations of at least the precedence by precedence climbing.
+func (p *parser) parseExpr(minPrecedence int) (Expr, error) {
+	lhs, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		prec, ok := precedence[t.value]
+		if t.kind != tokenOperator || !ok || prec < minPrecedence {
+			return lhs, nil
+		}
+		p.next()
+
+		binary := &BinaryExpr{Op: t.value, LHS: lhs}
+		if modifier := p.peek(); modifier.kind == tokenIdentifier && modifier.value == "bool" {
+			if !comparisons[t.value] {
+				return nil, p.errorf(modifier, "bool modifier can only be used on comparison operators")
+			}
+			p.next()
+			binary.ReturnBool = true
+		}
+		if modifier := p.peek(); modifier.kind == tokenIdentifier && (modifier.value == "on" || modifier.value == "ignoring") {
+			p.next()
+			labels, err := p.parseLabelList()
+			if err != nil {
+				return nil, err
+			}
+			binary.Matching = &VectorMatching{On: modifier.value == "on", Labels: labels}
+		}
+
+		nextPrecedence := prec + 1
+		if t.value == "^" {
+			nextPrecedence = prec
+		}
+		if binary.RHS, err = p.parseExpr(nextPrecedence); err != nil {
+			return nil, err
+		}
+		if err := p.checkBinary(t, binary); err != nil {
+			return nil, err
+		}
+		lhs = binary
+	}
+}
+
+// checkBinary checks the operand types, which are known from the syntax.
+func (p *parser) checkBinary(t token, binary *BinaryExpr) error {
+	_, lhsRange := binary.LHS.(*MatrixSelector)
+	_, rhsRange := binary.RHS.(*MatrixSelector)
+	if lhsRange || rhsRange {
+		return p.errorf(t, "binary expression must contain only scalar and instant vector types")
+	}
+	lhsScalar, rhsScalar := isScalar(binary.LHS), isScalar(binary.RHS)
+	if comparisons[binary.Op] && lhsScalar && rhsScalar && !binary.ReturnBool {
+		return p.errorf(t, "comparisons between scalars must use bool modifier")
+	}
+	if binary.Matching != nil && (lhsScalar || rhsScalar) {
+		return p.errorf(t, "vector matching only allowed between instant vectors")
+	}
+	return nil
+}
+
+func (p *parser) parseUnary() (Expr, error) {
+	if t := p.peek(); t.kind == tokenOperator && (t.value == "-" || t.value == "+") {
+		p.next()
+		// the unary operators bind weaker than ^, so -2^2 is -4
+		expr, err := p.parseExpr(precedence["^"])
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := expr.(*MatrixSelector); ok {
+			return nil, p.errorf(t, "unary expression only allowed on expressions of type scalar or instant vector")
+		}
+		if t.value == "+" {
+			return expr, nil
+		}
+		if number, ok := expr.(*NumberLiteral); ok {
+			return &NumberLiteral{Value: -number.Value}, nil
+		}
+		return &UnaryExpr{Op: "-", Expr: expr}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (Expr, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenNumber:
+		value, err := strconv.ParseFloat(t.value, 64)
+		if err != nil {
+			return nil, p.errorf(t, "invalid number %s", t.value)
+		}
+		return &NumberLiteral{Value: value}, nil
+	case tokenLeftParen:
+		expr, err := p.parseExpr(0)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokenRightParen, "\")\""); err != nil {
+			return nil, err
+		}
+		return expr, nil
+	case tokenLeftBrace:
+		p.pos--
+		return p.parseSelector("")
+	case tokenIdentifier:
+		switch next := p.peek(); {
+		case aggregations[t.value] && (next.kind == tokenLeftParen ||
+			next.kind == tokenIdentifier && (next.value == "by" || next.value == "without")):
+			return p.parseAggregation(t)
+		case next.kind == tokenLeftParen:
+			return p.parseCall(t)
+		}
+		switch strings.ToLower(t.value) {
+		case "inf":
+			return &NumberLiteral{Value: math.Inf(1)}, nil
+		case "nan":
+			return &NumberLiteral{Value: math.NaN()}, nil
+		}
+		return p.parseSelector(t.value)
+	default:
+		return nil, p.errorf(t, "unexpected %s", t)
+	}
+}
+
+// parseSelector parses the optional label matchers and range of the selector of the metric name.
+func (p *parser) parseSelector(name string) (Expr, error) {
+	selector := &VectorSelector{}
+	if name != "" {
+		matcher, _ := NewMatcher(MatchEqual, nameLabel, name)
+		selector.Matchers = append(selector.Matchers, matcher)
+	}
+	if p.peek().kind == tokenLeftBrace {
+		brace := p.next()
+		for p.peek().kind != tokenRightBrace {
+			label, err := p.expect(tokenIdentifier, "label name")
+			if err != nil {
+				return nil, err
+			}
+			op := p.next()
+			if op.kind != tokenOperator || !isMatchOperator(op.value) {
+				return nil, p.errorf(op, "expected label matching operator, got %s", op)
+			}
+			value, err := p.expect(tokenString, "label value")
+			if err != nil {
+				return nil, err
+			}
+			matcher, err := NewMatcher(MatchType(op.value), label.value, value.value)
+			if err != nil {
+				return nil, p.errorf(value, "%s", err.Error())
+			}
+			selector.Matchers = append(selector.Matchers, matcher)
+			if p.peek().kind != tokenComma {
+				break
+			}
+			p.next()
+		}
+		if _, err := p.expect(tokenRightBrace, "\"}\""); err != nil {
+			return nil, err
+		}
+		if !matchesNonEmpty(selector.Matchers) {
+			return nil, p.errorf(brace, "vector selector must contain at least one non-empty matcher")
+		}
+	}
+
+	if t := p.peek(); t.kind == tokenDuration {
+		p.next()
+		duration, err := ParseDuration(t.value)
+		if err != nil || duration <= 0 {
+			return nil, p.errorf(t, "invalid range %s", t)
+		}
+		return &MatrixSelector{Vector: selector, Range: duration}, nil
+	}
+	return selector, nil
+}
+
+func (p *parser) parseCall(name token) (Expr, error) {
+	if !rangeFunctions[name.value] {
+		return nil, p.errorf(name, "unknown function %q", name.value)
+	}
+	p.next() // (
+	arg, err := p.parseExpr(0)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(tokenRightParen, "\")\""); err != nil {
+		return nil, err
+	}
+	if _, ok := arg.(*MatrixSelector); !ok {
+		return nil, p.errorf(name, "%s expects a range vector argument", name.value)
+	}
+	return &Call{Func: name.value, Args: []Expr{arg}}, nil
+}
+
+// parseAggregation parses the aggregation with the grouping before or after the argument.
+func (p *parser) parseAggregation(op token) (Expr, error) {
+	aggregate := &AggregateExpr{Op: op.value}
+	parseGrouping := func() error {
+		if t := p.peek(); t.kind == tokenIdentifier && (t.value == "by" || t.value == "without") {
+			p.next()
+			labels, err := p.parseLabelList()
+			if err != nil {
+				return err
+			}
+			aggregate.Grouping, aggregate.Without = labels, t.value == "without"
+		}
+		return nil
+	}
+	if err := parseGrouping(); err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(tokenLeftParen, "\"(\""); err != nil {
+		return nil, err
+	}
+	expr, err := p.parseExpr(0)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(tokenRightParen, "\")\""); err != nil {
+		return nil, err
+	}
+	if aggregate.Grouping == nil {
+		if err := parseGrouping(); err != nil {
+			return nil, err
+		}
+	}
+	if _, ok := expr.(*MatrixSelector); ok || isScalar(expr) {
+		return nil, p.errorf(op, "%s expects an instant vector argument", op.value)
+	}
+	aggregate.Expr = expr
+	return aggregate, nil
+}
+
+func (p *parser) parseLabelList() ([]string, error) {
+	if _, err := p.expect(tokenLeftParen, "\"(\""); err != nil {
+		return nil, err
+	}
+	labels := make([]string, 0)
+	for p.peek().kind != tokenRightParen {
+		label, err := p.expect(tokenIdentifier, "label name")
+		if err != nil {
+			return nil, err
+		}
+		labels = append(labels, label.value)
+		if p.peek().kind != tokenComma {
+			break
+		}
+		p.next()
+	}
+	if _, err := p.expect(tokenRightParen, "\")\""); err != nil {
+		return nil, err
+	}
+	return labels, nil
+}
+
+func isMatchOperator(op string) bool {
+	switch MatchType(op) {
+	case MatchEqual, MatchNotEqual, MatchRegexp, MatchNotRegexp:
+		return true
+	}
+	return false
+}
+
+// matchesNonEmpty reports whether a matcher rejects the empty value, otherwise the selector matches everything.
+func matchesNonEmpty(matchers []*Matcher) bool {
+	for _, m := range matchers {
+		if !m.Matches("") {
+			return true
+		}
+	}
+	return false
+}
+
+// isScalar reports whether the expression evaluates to a scalar.
+func isScalar(expr Expr) bool {
+	switch e := expr.(type) {
+	case *NumberLiteral:
+		return true
+	case *UnaryExpr:
+		return isScalar(e.Expr)
+	case *BinaryExpr:
+		return isScalar(e.LHS) && isScalar(e.RHS)
+	}
+	return false
+}
+
+// durationUnits are the units of the PromQL durations.
+var durationUnits = map[string]time.Duration{
+	"ms": time.Millisecond,
+	"s":  time.Second,
+	"m":  time.Minute,
+	"h":  time.Hour,
+	"d":  24 * time.Hour,
+	"w":  7 * 24 * time.Hour,
+	"y":  365 * 24 * time.Hour,
+}
+
+// ParseDuration parses a PromQL duration like 5m or 1h30m, which supports the d, w and y units.
+func ParseDuration(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, fmt.Errorf("empty duration")
+	}
+	var total time.Duration
+	for rest := s; rest != ""; {
+		i := 0
+		for i < len(rest) && rest[i] >= '0' && rest[i] <= '9' {
+			i++
+		}
+		j := i
+		for j < len(rest) && (rest[j] < '0' || rest[j] > '9') {
+			j++
+		}
+		unit, ok := durationUnits[rest[i:j]]
+		if i == 0 || !ok {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		n, err := strconv.ParseInt(rest[:i], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		total += time.Duration(n) * unit
+		rest = rest[j:]
+	}
+	return total, nil
+}
@@ -0,0 +1,86 @@
+package promql
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	expr, err := Parse(`sum by (job) (rate(http_requests_total{job=~"api.*", code!="500"}[5m])) * 2 > bool 1`)
+	require.NoError(t, err)
+
+	comparison := expr.(*BinaryExpr)
+	require.Equal(t, ">", comparison.Op)
+	require.True(t, comparison.ReturnBool)
+	require.Equal(t, &NumberLiteral{Value: 1}, comparison.RHS)
+
+	product := comparison.LHS.(*BinaryExpr)
+	require.Equal(t, "*", product.Op)
+	aggregate := product.LHS.(*AggregateExpr)
+	require.Equal(t, "sum", aggregate.Op)
+	require.Equal(t, []string{"job"}, aggregate.Grouping)
+
+	call := aggregate.Expr.(*Call)
+	require.Equal(t, "rate", call.Func)
+	selector := call.Args[0].(*MatrixSelector)
+	require.Equal(t, 5*time.Minute, selector.Range)
+	require.Len(t, selector.Vector.Matchers, 3)
+	require.Equal(t, MatchRegexp, selector.Vector.Matchers[1].Type)
+	require.True(t, selector.Vector.Matchers[1].Matches("api-v2"))
+	require.False(t, selector.Vector.Matchers[1].Matches("web-api"))
+}
+
+func TestParsePrecedence(t *testing.T) {
+	expr, err := Parse("1 + 2 * 3 ^ 2 ^ 2")
+	require.NoError(t, err)
+	sum := expr.(*BinaryExpr)
+	require.Equal(t, "+", sum.Op)
+	product := sum.RHS.(*BinaryExpr)
+	require.Equal(t, "*", product.Op)
+	power := product.RHS.(*BinaryExpr)
+	require.Equal(t, &NumberLiteral{Value: 3}, power.LHS)
+	require.Equal(t, "^", power.RHS.(*BinaryExpr).Op)
+
+	expr, err = Parse("-2 ^ 2")
+	require.NoError(t, err)
+	require.Equal(t, &UnaryExpr{Op: "-", Expr: &BinaryExpr{Op: "^", LHS: &NumberLiteral{Value: 2}, RHS: &NumberLiteral{Value: 2}}}, expr)
+
+	expr, err = Parse("max(cpu) without (core)")
+	require.NoError(t, err)
+	require.True(t, expr.(*AggregateExpr).Without)
+	require.Equal(t, []string{"core"}, expr.(*AggregateExpr).Grouping)
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, query := range []string{
+		"",
+		"rate(cpu)",
+		"sum(cpu[5m])",
+		"1 > 2",
+		"cpu + ",
+		`{job=""}`,
+		`cpu{job~"api"}`,
+		"cpu[5x]",
+		"unknown(cpu[5m])",
+		"cpu[5m] + 1",
+		`cpu{job=~"("}`,
+		"1 + on (job) 2",
+	} {
+		t.Run(query, func(t *testing.T) {
+			_, err := Parse(query)
+			var parseErr *ParseError
+			require.ErrorAs(t, err, &parseErr)
+		})
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	duration, err := ParseDuration("1d2h30m")
+	require.NoError(t, err)
+	require.Equal(t, 26*time.Hour+30*time.Minute, duration)
+
+	_, err = ParseDuration("5")
+	require.Error(t, err)
+}
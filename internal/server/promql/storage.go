@@ -0,0 +1,268 @@
+package promql
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gonozov0/go-musthave-devops/internal/server/repository"
+	"github.com/gonozov0/go-musthave-devops/internal/shared"
+)
+
+// repositoryStorage exposes the metrics of the repository as series named as in the Prometheus exposition.
+// Gauges and counters have the points of their history, counters as running totals, the current values
+// are points at the end of the range. Histograms are expanded into the _bucket, _sum and _count series
+// and sets are gauges of their cardinality, they only have the current values.
+type repositoryStorage struct {
+	repo    repository.Repository
+	history repository.HistoryRepository
+	now     func() time.Time
+}
+
+// NewRepositoryStorage returns the storage of the repository metrics. The history is optional,
+// without it only the current values are available.
+func NewRepositoryStorage(repo repository.Repository, history repository.HistoryRepository) Storage {
+	return &repositoryStorage{repo: repo, history: history, now: time.Now}
+}
+
+// storedSeries is a series of the repository with its current value.
+type storedSeries struct {
+	labels     Labels
+	metricType string // gauge and counter have a history
+	id         string
+	value      float64
+	updated    time.Time
+}
+
+func (s *repositoryStorage) Series(matchers []*Matcher) ([]Labels, error) {
+	stored, err := s.list(matchers)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]Labels, 0, len(stored))
+	for _, series := range stored {
+		result = append(result, series.labels)
+	}
+	return result, nil
+}
+
+func (s *repositoryStorage) Select(matchers []*Matcher, from, to time.Time) ([]Series, error) {
+	stored, err := s.list(matchers)
+	if err != nil {
+		return nil, err
+	}
+	now := s.now()
+	current := now
+	if to.Before(now) {
+		current = to
+	}
+
+	var gauges, counters []string
+	for _, series := range stored {
+		switch series.metricType {
+		case shared.Gauge:
+			gauges = append(gauges, series.id)
+		case shared.Counter:
+			counters = append(counters, series.id)
+		}
+	}
+	gaugeHistory, err := s.readHistory(shared.Gauge, gauges, from, to)
+	if err != nil {
+		return nil, err
+	}
+	// the counter totals are rebuilt back from the current value, so the increments are read up to now
+	var counterHistory map[string][]repository.HistoryPoint
+	if !now.Before(from) {
+		counterHistory, err = s.readHistory(shared.Counter, counters, from, now)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	result := make([]Series, 0, len(stored))
+	for _, series := range stored {
+		var points []Point
+		switch series.metricType {
+		case shared.Gauge:
+			points = s.gaugePoints(series, gaugeHistory[series.id], from, current)
+		case shared.Counter:
+			points = s.counterPoints(series, counterHistory[series.id], from, to, current, now)
+		default:
+			points = currentPoint(series.value, from, to, current, now)
+		}
+		if len(points) > 0 {
+			result = append(result, Series{Metric: series.labels, Points: points})
+		}
+	}
+	return result, nil
+}
+
+// readHistory returns the history of the metrics of the type. The repositories reading the history
+// of many metrics at once are read in a query per tier including the rolled up history older than
+// the raw one, the others are read metric by metric.
+func (s *repositoryStorage) readHistory(
+	metricType string,
+	names []string,
+	from, to time.Time,
+) (map[string][]repository.HistoryPoint, error) {
+	if s.history == nil || len(names) == 0 {
+		return nil, nil
+	}
+	if reader, ok := s.history.(repository.SeriesHistoryReader); ok {
+		return repository.QuerySeriesHistory(reader, metricType, names, from, to)
+	}
+	history := make(map[string][]repository.HistoryPoint, len(names))
+	for _, name := range names {
+		points, err := s.history.GetHistory(metricType, name, from, to)
+		if err != nil {
+			return nil, err
+		}
+		history[name] = points
+	}
+	return history, nil
+}
+
+// gaugePoints returns the recorded samples and the current value, which holds since its update.
+func (s *repositoryStorage) gaugePoints(
+	series storedSeries,
+	history []repository.HistoryPoint,
+	from, current time.Time,
+) []Point {
+	var points []Point
+	if s.history != nil {
+		for _, point := range history {
+			points = appendPoint(points, Point{T: point.Timestamp.UnixMilli(), V: point.Value})
+		}
+	} else if !series.updated.Before(from) && series.updated.Before(current) {
+		points = appendPoint(points, Point{T: series.updated.UnixMilli(), V: series.value})
+	}
+	if !current.Before(from) && !series.updated.After(current) {
+		points = appendPoint(points, Point{T: current.UnixMilli(), V: series.value})
+	}
+	return points
+}
+
+// counterPoints rebuilds the running totals from the increments recorded up to now. The total before
+// the range is a point at its start, so the increments of the counters created within it count.
+func (s *repositoryStorage) counterPoints(
+	series storedSeries,
+	deltas []repository.HistoryPoint,
+	from, to, current, now time.Time,
+) []Point {
+	if s.history == nil || now.Before(from) {
+		return currentPoint(series.value, from, to, current, now)
+	}
+	total := series.value
+	for _, delta := range deltas {
+		total -= delta.Value
+	}
+
+	points := []Point{{T: from.UnixMilli(), V: total}}
+	for _, delta := range deltas {
+		if delta.Timestamp.After(to) {
+			break
+		}
+		total += delta.Value
+		points = appendPoint(points, Point{T: delta.Timestamp.UnixMilli(), V: total})
+	}
+	if !current.Before(from) {
+		points = appendPoint(points, Point{T: current.UnixMilli(), V: total})
+	}
+	return points
+}
+
+// currentPoint returns the current value without a history as a point at the end of the range,
+// unless the range ends before the lookback delta.
+func currentPoint(value float64, from, to, current, now time.Time) []Point {
+	if now.Sub(to) > LookbackDelta || current.Before(from) {
+		return nil
+	}
+	return []Point{{T: current.UnixMilli(), V: value}}
+}
+
+// appendPoint appends the point replacing the last one at the same millisecond.
+func appendPoint(points []Point, point Point) []Point {
+	if n := len(points); n > 0 && points[n-1].T == point.T {
+		points[n-1] = point
+		return points
+	}
+	return append(points, point)
+}
+
+// list returns the series of the repository matching all matchers.
+func (s *repositoryStorage) list(matchers []*Matcher) ([]storedSeries, error) {
+	var result []storedSeries
+	add := func(series storedSeries) {
+		if series.labels.matches(matchers) {
+			result = append(result, series)
+		}
+	}
+
+	gauges, err := s.repo.GetAllGauges()
+	if err != nil {
+		return nil, err
+	}
+	for _, gauge := range gauges {
+		add(storedSeries{
+			labels:     seriesLabels(gauge.Name, ""),
+			metricType: shared.Gauge,
+			id:         gauge.Name,
+			value:      gauge.Value,
+			updated:    gauge.Timestamp,
+		})
+	}
+
+	counters, err := s.repo.GetAllCounters()
+	if err != nil {
+		return nil, err
+	}
+	for _, counter := range counters {
+		add(storedSeries{
+			labels:     seriesLabels(counter.Name, ""),
+			metricType: shared.Counter,
+			id:         counter.Name,
+			value:      float64(counter.Value),
+		})
+	}
+
+	histograms, err := s.repo.GetAllHistograms()
+	if err != nil {
+		return nil, err
+	}
+	for _, histogram := range histograms {
+		var cumulative uint64
+		for i, count := range histogram.Value.Counts {
+			cumulative += count
+			labels := seriesLabels(histogram.Name, "_bucket")
+			labels["le"] = "+Inf"
+			if i < len(histogram.Value.Bounds) {
+				labels["le"] = strconv.FormatFloat(histogram.Value.Bounds[i], 'g', -1, 64)
+			}
+			add(storedSeries{labels: labels, value: float64(cumulative)})
+		}
+		add(storedSeries{labels: seriesLabels(histogram.Name, "_sum"), value: histogram.Value.Sum})
+		add(storedSeries{labels: seriesLabels(histogram.Name, "_count"), value: float64(histogram.Value.Count)})
+	}
+
+	sets, err := s.repo.GetAllSets()
+	if err != nil {
+		return nil, err
+	}
+	for _, set := range sets {
+		add(storedSeries{labels: seriesLabels(set.Name, ""), value: float64(set.Cardinality)})
+	}
+	return result, nil
+}
+
+// seriesLabels returns the labels of the metric ID with the name label of the name with the suffix.
+func seriesLabels(id, suffix string) Labels {
+	name, labels, err := shared.SplitLabels(id)
+	if err != nil {
+		name, labels = id, nil
+	}
+	result := make(Labels, len(labels)+1)
+	for key, value := range labels {
+		result[shared.SanitizePrometheusLabelName(key)] = value
+	}
+	result[nameLabel] = shared.SanitizePrometheusName(name) + suffix
+	return result
+}
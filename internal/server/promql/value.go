@@ -0,0 +1,116 @@
+package promql
+
+import (
+	"sort"
+	"strings"
+)
+
+// nameLabel is the label of the metric name.
+const nameLabel = "__name__"
+
+// ValueType is the type of the query result as named in the Prometheus API.
+type ValueType string
+
+// Types of the query results.
+const (
+	ValueTypeScalar ValueType = "scalar"
+	ValueTypeVector ValueType = "vector"
+	ValueTypeMatrix ValueType = "matrix"
+)
+
+// Value is the result of an expression: Scalar, Vector or Matrix.
+type Value interface {
+	Type() ValueType
+}
+
+// Labels are the labels of a series including the metric name.
+type Labels map[string]string
+
+// Point is a value at the Unix time in milliseconds.
+type Point struct {
+	T int64
+	V float64
+}
+
+// Scalar is a single value.
+type Scalar Point
+
+// Sample is a value of a series at the evaluation time.
+type Sample struct {
+	Metric Labels
+	Point
+}
+
+// Vector is a set of samples of different series at the same time.
+type Vector []Sample
+
+// Series is a series with its points ordered by time.
+type Series struct {
+	Metric Labels
+	Points []Point
+}
+
+// Matrix is a set of series.
+type Matrix []Series
+
+func (Scalar) Type() ValueType { return ValueTypeScalar }
+func (Vector) Type() ValueType { return ValueTypeVector }
+func (Matrix) Type() ValueType { return ValueTypeMatrix }
+
+// key returns a string identifying the label set.
+func (l Labels) key() string {
+	names := make([]string, 0, len(l))
+	for name := range l {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(name)
+		b.WriteByte(0xff)
+		b.WriteString(l[name])
+		b.WriteByte(0xff)
+	}
+	return b.String()
+}
+
+// without returns a copy of the labels without the names.
+func (l Labels) without(names ...string) Labels {
+	result := make(Labels, len(l))
+	for name, value := range l {
+		result[name] = value
+	}
+	for _, name := range names {
+		delete(result, name)
+	}
+	return result
+}
+
+// only returns a copy of the labels with only the names.
+func (l Labels) only(names ...string) Labels {
+	result := make(Labels, len(names))
+	for _, name := range names {
+		if value, ok := l[name]; ok {
+			result[name] = value
+		}
+	}
+	return result
+}
+
+// matches reports whether the labels match all matchers.
+func (l Labels) matches(matchers []*Matcher) bool {
+	for _, m := range matchers {
+		if !m.Matches(l[m.Name]) {
+			return false
+		}
+	}
+	return true
+}
+
+func sortVector(vector Vector) {
+	sort.Slice(vector, func(i, j int) bool { return vector[i].Metric.key() < vector[j].Metric.key() })
+}
+
+func sortMatrix(matrix Matrix) {
+	sort.Slice(matrix, func(i, j int) bool { return matrix[i].Metric.key() < matrix[j].Metric.key() })
+}
@@ -0,0 +1,208 @@
+package remotewrite
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gonozov0/go-musthave-devops/internal/shared"
+)
+
+const (
+	// staleNaN is the value Prometheus writes to mark the end of a series.
+	staleNaN = 0x7ff0000000000002
+	// counterTTL is how long the last value of a counter series that isn't written anymore is kept.
+	counterTTL = time.Hour
+)
+
+// counterSuffixes are the name suffixes of the cumulative series when the type isn't known otherwise.
+var counterSuffixes = []string{"_total", "_count", "_sum", "_bucket"}
+
+var errMissingName = errors.New("series has no __name__ label")
+
+// Converter maps remote write series to gauges and counters. Prometheus sends cumulative counter
+// values, so the converter remembers the last value of every counter series and writes the increments.
+// The first sample of a series is only the baseline: its total was counted before the series was seen,
+// for example before a server restart. Series not written for counterTTL are forgotten.
+type Converter struct {
+	counterPatterns []string
+	gaugePatterns   []string
+	now             func() time.Time
+
+	mu          sync.Mutex
+	counters    map[string]counterState // by metric ID
+	lastEvicted time.Time
+}
+
+// counterState is the last cumulative value of a counter series.
+type counterState struct {
+	value float64
+	seen  time.Time
+}
+
+// NewConverter creates the converter. The metric names matching counterPatterns or gaugePatterns
+// (path.Match syntax) get the corresponding type regardless of the request metadata and the naming.
+func NewConverter(counterPatterns, gaugePatterns []string) (*Converter, error) {
+	for _, pattern := range append(append([]string(nil), counterPatterns...), gaugePatterns...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid metric name pattern %q: %w", pattern, err)
+		}
+	}
+	return &Converter{
+		counterPatterns: counterPatterns,
+		gaugePatterns:   gaugePatterns,
+		now:             time.Now,
+		counters:        make(map[string]counterState),
+	}, nil
+}
+
+// Write converts the samples of the request and passes the valid ones to update. Samples rejected
+// by validate or by the conversion are returned as rejected, the error is the one of update.
+// The counter state only advances when update succeeds, so a retried request isn't counted twice.
+// Staleness markers and the first samples of the counter series are skipped.
+func (c *Converter) Write(
+	req WriteRequest,
+	validate func(shared.Metric) error,
+	update func([]shared.Metric) error,
+) (rejected []error, err error) {
+	metadata := make(map[string]int, len(req.Metadata))
+	for _, m := range req.Metadata {
+		metadata[m.FamilyName] = m.Type
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var metrics []shared.Metric
+	counters := make(map[string]float64)
+	for _, series := range req.Timeseries {
+		name := series.Labels["__name__"]
+		if name == "" {
+			rejected = append(rejected, errMissingName)
+			continue
+		}
+		labels := make(map[string]string, len(series.Labels)-1)
+		for key, value := range series.Labels {
+			if key != "__name__" {
+				labels[key] = value
+			}
+		}
+		id := shared.WithLabels(name, labels)
+		mType := c.metricType(name, metadata)
+
+		for _, sample := range series.Samples {
+			if math.Float64bits(sample.Value) == staleNaN {
+				continue
+			}
+			timestamp := sample.Timestamp
+			metric := shared.Metric{ID: id, MType: mType, Timestamp: &timestamp}
+			if err := validate(metric); err != nil {
+				rejected = append(rejected, fmt.Errorf("%s at %d: %w", id, sample.Timestamp, err))
+				continue
+			}
+			if math.IsNaN(sample.Value) || math.IsInf(sample.Value, 0) {
+				rejected = append(rejected, fmt.Errorf("%s at %d: value %v isn't finite", id, sample.Timestamp, sample.Value))
+				continue
+			}
+
+			if mType == shared.Gauge {
+				value := sample.Value
+				metric.Value = &value
+				metrics = append(metrics, metric)
+				continue
+			}
+			last, ok := counters[id]
+			if !ok {
+				var state counterState
+				state, ok = c.counters[id]
+				last = state.value
+			}
+			counters[id] = sample.Value
+			if !ok {
+				continue // the baseline
+			}
+			// the sample after a reset is the increment itself
+			delta := math.Round(sample.Value)
+			if sample.Value >= last {
+				delta = math.Round(sample.Value) - math.Round(last)
+			}
+			increment := int64(delta)
+			metric.Delta = &increment
+			metric.Timestamp = nil
+			metrics = append(metrics, metric)
+		}
+	}
+
+	if len(metrics) > 0 {
+		if err := update(metrics); err != nil {
+			return rejected, err
+		}
+	}
+	now := c.now()
+	for id, value := range counters {
+		c.counters[id] = counterState{value: value, seen: now}
+	}
+	c.evict(now)
+	return rejected, nil
+}
+
+// evict forgets the counter series not written for counterTTL, checking at most once per minute.
+// It must be called with mu held.
+func (c *Converter) evict(now time.Time) {
+	if now.Sub(c.lastEvicted) < time.Minute {
+		return
+	}
+	c.lastEvicted = now
+	for id, state := range c.counters {
+		if now.Sub(state.seen) > counterTTL {
+			delete(c.counters, id)
+		}
+	}
+}
+
+// metricType resolves the type of the metric by the configured patterns, the request metadata
+// of its family and finally by the name suffix.
+func (c *Converter) metricType(name string, metadata map[string]int) string {
+	for _, pattern := range c.counterPatterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return shared.Counter
+		}
+	}
+	for _, pattern := range c.gaugePatterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return shared.Gauge
+		}
+	}
+
+	if metricType, ok := metadata[name]; ok {
+		switch metricType {
+		case MetadataCounter:
+			return shared.Counter
+		case MetadataGauge, MetadataSummary:
+			// the quantiles of a summary are gauges
+			return shared.Gauge
+		}
+	}
+	for _, suffix := range counterSuffixes {
+		family, ok := strings.CutSuffix(name, suffix)
+		if !ok {
+			continue
+		}
+		if metricType, ok := metadata[family]; ok {
+			switch metricType {
+			case MetadataGauge:
+				return shared.Gauge
+			case MetadataGaugeHistogram:
+				if suffix == "_bucket" || suffix == "_count" {
+					return shared.Gauge
+				}
+			}
+		}
+		return shared.Counter
+	}
+	return shared.Gauge
+}
@@ -0,0 +1,240 @@
+package remotewrite
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/klauspost/compress/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// maxDecodedSize limits the size of the decompressed request.
+const maxDecodedSize = 64 << 20
+
+// Metric types of MetricMetadata.
+const (
+	MetadataUnknown        = 0
+	MetadataCounter        = 1
+	MetadataGauge          = 2
+	MetadataHistogram      = 3
+	MetadataGaugeHistogram = 4
+	MetadataSummary        = 5
+)
+
+// WriteRequest is the prometheus.WriteRequest message of the remote write protocol 1.0.
+// Only the fields used by the server are decoded.
+type WriteRequest struct {
+	Timeseries []TimeSeries
+	Metadata   []MetricMetadata
+}
+
+// TimeSeries is a series identified by the labels, the metric name is the __name__ label.
+type TimeSeries struct {
+	Labels  map[string]string
+	Samples []Sample
+}
+
+// Sample is a value with the timestamp in Unix milliseconds.
+type Sample struct {
+	Value     float64
+	Timestamp int64
+}
+
+// MetricMetadata describes the type of the metric family.
+type MetricMetadata struct {
+	Type       int
+	FamilyName string
+}
+
+// DecodeWriteRequest decompresses the snappy block and decodes the protobuf WriteRequest.
+func DecodeWriteRequest(body []byte) (WriteRequest, error) {
+	size, err := snappy.DecodedLen(body)
+	if err != nil {
+		return WriteRequest{}, fmt.Errorf("invalid snappy block: %w", err)
+	}
+	if size > maxDecodedSize {
+		return WriteRequest{}, errors.New("decompressed request is too large")
+	}
+	data, err := snappy.Decode(nil, body)
+	if err != nil {
+		return WriteRequest{}, fmt.Errorf("invalid snappy block: %w", err)
+	}
+
+	var req WriteRequest
+	err = consumeFields(data, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			value, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return n, nil
+			}
+			series, err := decodeTimeSeries(value)
+			if err != nil {
+				return 0, err
+			}
+			req.Timeseries = append(req.Timeseries, series)
+			return n, nil
+		case num == 3 && typ == protowire.BytesType:
+			value, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return n, nil
+			}
+			metadata, err := decodeMetadata(value)
+			if err != nil {
+				return 0, err
+			}
+			req.Metadata = append(req.Metadata, metadata)
+			return n, nil
+		}
+		return protowire.ConsumeFieldValue(num, typ, b), nil
+	})
+	if err != nil {
+		return WriteRequest{}, fmt.Errorf("invalid write request: %w", err)
+	}
+	return req, nil
+}
+
+// EncodeWriteRequest encodes the WriteRequest into the snappy-compressed protobuf message.
+func EncodeWriteRequest(req WriteRequest) []byte {
+	var data []byte
+	for _, series := range req.Timeseries {
+		var message []byte
+		for name, value := range series.Labels {
+			var label []byte
+			label = protowire.AppendTag(label, 1, protowire.BytesType)
+			label = protowire.AppendString(label, name)
+			label = protowire.AppendTag(label, 2, protowire.BytesType)
+			label = protowire.AppendString(label, value)
+			message = protowire.AppendTag(message, 1, protowire.BytesType)
+			message = protowire.AppendBytes(message, label)
+		}
+		for _, sample := range series.Samples {
+			var encoded []byte
+			encoded = protowire.AppendTag(encoded, 1, protowire.Fixed64Type)
+			encoded = protowire.AppendFixed64(encoded, math.Float64bits(sample.Value))
+			encoded = protowire.AppendTag(encoded, 2, protowire.VarintType)
+			encoded = protowire.AppendVarint(encoded, uint64(sample.Timestamp))
+			message = protowire.AppendTag(message, 2, protowire.BytesType)
+			message = protowire.AppendBytes(message, encoded)
+		}
+		data = protowire.AppendTag(data, 1, protowire.BytesType)
+		data = protowire.AppendBytes(data, message)
+	}
+	for _, metadata := range req.Metadata {
+		var message []byte
+		message = protowire.AppendTag(message, 1, protowire.VarintType)
+		message = protowire.AppendVarint(message, uint64(metadata.Type))
+		message = protowire.AppendTag(message, 2, protowire.BytesType)
+		message = protowire.AppendString(message, metadata.FamilyName)
+		data = protowire.AppendTag(data, 3, protowire.BytesType)
+		data = protowire.AppendBytes(data, message)
+	}
+	return snappy.Encode(nil, data)
+}
+
+func decodeTimeSeries(data []byte) (TimeSeries, error) {
+	series := TimeSeries{Labels: make(map[string]string)}
+	err := consumeFields(data, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			value, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return n, nil
+			}
+			name, labelValue, err := decodeLabel(value)
+			if err != nil {
+				return 0, err
+			}
+			series.Labels[name] = labelValue
+			return n, nil
+		case num == 2 && typ == protowire.BytesType:
+			value, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return n, nil
+			}
+			sample, err := decodeSample(value)
+			if err != nil {
+				return 0, err
+			}
+			series.Samples = append(series.Samples, sample)
+			return n, nil
+		}
+		return protowire.ConsumeFieldValue(num, typ, b), nil
+	})
+	return series, err
+}
+
+func decodeLabel(data []byte) (string, string, error) {
+	var name, value string
+	err := consumeFields(data, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		if typ == protowire.BytesType && (num == 1 || num == 2) {
+			s, n := protowire.ConsumeString(b)
+			if num == 1 {
+				name = s
+			} else {
+				value = s
+			}
+			return n, nil
+		}
+		return protowire.ConsumeFieldValue(num, typ, b), nil
+	})
+	return name, value, err
+}
+
+func decodeSample(data []byte) (Sample, error) {
+	var sample Sample
+	err := consumeFields(data, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch {
+		case num == 1 && typ == protowire.Fixed64Type:
+			bits, n := protowire.ConsumeFixed64(b)
+			sample.Value = math.Float64frombits(bits)
+			return n, nil
+		case num == 2 && typ == protowire.VarintType:
+			timestamp, n := protowire.ConsumeVarint(b)
+			sample.Timestamp = int64(timestamp)
+			return n, nil
+		}
+		return protowire.ConsumeFieldValue(num, typ, b), nil
+	})
+	return sample, err
+}
+
+func decodeMetadata(data []byte) (MetricMetadata, error) {
+	var metadata MetricMetadata
+	err := consumeFields(data, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch {
+		case num == 1 && typ == protowire.VarintType:
+			metricType, n := protowire.ConsumeVarint(b)
+			metadata.Type = int(metricType)
+			return n, nil
+		case num == 2 && typ == protowire.BytesType:
+			name, n := protowire.ConsumeString(b)
+			metadata.FamilyName = name
+			return n, nil
+		}
+		return protowire.ConsumeFieldValue(num, typ, b), nil
+	})
+	return metadata, err
+}
+
+// consumeFields calls consume for every field of the message. It returns the number of bytes
+// of the field value consumed or a negative protowire error code.
+func consumeFields(data []byte, consume func(num protowire.Number, typ protowire.Type, b []byte) (int, error)) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+		n, err := consume(num, typ, data)
+		if err != nil {
+			return err
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package remotewrite
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gonozov0/go-musthave-devops/internal/shared"
+)
+
+func TestDecodeWriteRequest(t *testing.T) {
+	req := WriteRequest{
+		Timeseries: []TimeSeries{{
+			Labels:  map[string]string{"__name__": "up", "job": "node"},
+			Samples: []Sample{{Value: 1, Timestamp: 1000}, {Value: 0.5, Timestamp: -1}},
+		}},
+		Metadata: []MetricMetadata{{Type: MetadataGauge, FamilyName: "up"}},
+	}
+
+	decoded, err := DecodeWriteRequest(EncodeWriteRequest(req))
+	require.NoError(t, err)
+	require.Equal(t, req, decoded)
+
+	_, err = DecodeWriteRequest([]byte("not snappy"))
+	require.Error(t, err)
+}
+
+func TestMetricType(t *testing.T) {
+	converter, err := NewConverter([]string{"custom_*"}, []string{"*_gauge_total"})
+	require.NoError(t, err)
+	metadata := map[string]int{
+		"requests":      MetadataCounter,
+		"temperature":   MetadataGauge,
+		"queue":         MetadataGaugeHistogram,
+		"rpc_durations": MetadataSummary,
+	}
+
+	testCases := map[string]string{
+		"custom_value":        shared.Counter,
+		"size_gauge_total":    shared.Gauge,
+		"requests":            shared.Counter,
+		"temperature":         shared.Gauge,
+		"temperature_total":   shared.Gauge,
+		"queue_bucket":        shared.Gauge,
+		"queue_sum":           shared.Counter,
+		"rpc_durations":       shared.Gauge,
+		"rpc_durations_count": shared.Counter,
+		"http_requests_total": shared.Counter,
+		"latency_bucket":      shared.Counter,
+		"memory_bytes":        shared.Gauge,
+	}
+	for name, expected := range testCases {
+		require.Equal(t, expected, converter.metricType(name, metadata), name)
+	}
+
+	_, err = NewConverter([]string{"["}, nil)
+	require.Error(t, err)
+}
+
+func TestConverterWrite(t *testing.T) {
+	converter, err := NewConverter(nil, nil)
+	require.NoError(t, err)
+	validate := func(metric shared.Metric) error {
+		if *metric.Timestamp < 0 {
+			return errors.New("too old")
+		}
+		return nil
+	}
+	var written []shared.Metric
+	update := func(metrics []shared.Metric) error {
+		written = append(written, metrics...)
+		return nil
+	}
+
+	req := WriteRequest{Timeseries: []TimeSeries{
+		{
+			Labels:  map[string]string{"__name__": "requests_total", "code": "200"},
+			Samples: []Sample{{Value: 10, Timestamp: 1}, {Value: 15, Timestamp: 2}, {Value: 3, Timestamp: 3}},
+		},
+		{
+			Labels: map[string]string{"__name__": "temperature"},
+			Samples: []Sample{
+				{Value: 21.5, Timestamp: 1},
+				{Value: math.Float64frombits(staleNaN), Timestamp: 2},
+				{Value: 20, Timestamp: -1},
+			},
+		},
+		{Labels: map[string]string{"job": "node"}, Samples: []Sample{{Value: 1, Timestamp: 1}}},
+	}}
+	rejected, err := converter.Write(req, validate, update)
+	require.NoError(t, err)
+	require.Len(t, rejected, 2)
+
+	var deltas []int64
+	var gauges []float64
+	for _, metric := range written {
+		switch metric.MType {
+		case shared.Counter:
+			require.Equal(t, `requests_total{code="200"}`, metric.ID)
+			deltas = append(deltas, *metric.Delta)
+		case shared.Gauge:
+			require.Equal(t, "temperature", metric.ID)
+			gauges = append(gauges, *metric.Value)
+		}
+	}
+	// the first sample is the baseline counted before the series was seen
+	require.Equal(t, []int64{5, 3}, deltas)
+	require.Equal(t, []float64{21.5}, gauges)
+
+	// a failed update doesn't advance the counter state, so the retry writes the same increment
+	req = WriteRequest{Timeseries: []TimeSeries{{
+		Labels:  map[string]string{"__name__": "requests_total", "code": "200"},
+		Samples: []Sample{{Value: 7, Timestamp: 4}},
+	}}}
+	_, err = converter.Write(req, validate, func([]shared.Metric) error { return errors.New("storage is down") })
+	require.Error(t, err)
+
+	written = nil
+	_, err = converter.Write(req, validate, update)
+	require.NoError(t, err)
+	require.Len(t, written, 1)
+	require.Equal(t, int64(4), *written[0].Delta)
+
+	// the forgotten series starts from a new baseline
+	converter.now = func() time.Time { return time.Now().Add(2 * counterTTL) }
+	_, err = converter.Write(WriteRequest{}, validate, update)
+	require.NoError(t, err)
+	require.Empty(t, converter.counters)
+	written = nil
+	_, err = converter.Write(req, validate, update)
+	require.NoError(t, err)
+	require.Empty(t, written)
+}
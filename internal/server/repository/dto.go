@@ -1,9 +1,17 @@
 package repository
 
+import (
+	"time"
+
+	"github.com/gonozov0/go-musthave-devops/internal/shared"
+)
+
 // GaugeMetric is a struct that represents a gauge metric.
+// Timestamp is the sample time, zero means the time of the update.
 type GaugeMetric struct {
-	Name  string  `db:"name"`
-	Value float64 `db:"value"`
+	Name      string    `db:"name"`
+	Value     float64   `db:"value"`
+	Timestamp time.Time `db:"updated_at"`
 }
 
 // CounterMetric is a struct that represents a counter metric.
@@ -11,3 +19,17 @@ type CounterMetric struct {
 	Name  string `db:"name"`
 	Value int64  `db:"value"`
 }
+
+// HistogramMetric is a struct that represents a histogram metric.
+type HistogramMetric struct {
+	Name  string
+	Value shared.HistogramValue
+}
+
+// SetMetric is a struct that represents a set metric: the members added by an update
+// or the estimated number of distinct members of the stored set.
+type SetMetric struct {
+	Name        string
+	Members     []string
+	Cardinality uint64
+}
@@ -3,3 +3,5 @@ package repository
 import "errors"
 
 var ErrMetricNotFound = errors.New("metric not found")
+
+var ErrSilenceNotFound = errors.New("silence not found")
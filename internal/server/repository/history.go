@@ -0,0 +1,236 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/gonozov0/go-musthave-devops/internal/shared"
+)
+
+// RollupResolutions are the resolutions of the downsampled history tiers from the finest to the coarsest.
+var RollupResolutions = []time.Duration{time.Minute, time.Hour, 24 * time.Hour}
+
+// RollupDelay is the time the samples in flight have to arrive before their bucket is rolled up.
+// The rollup job holds the rollups back further by the max sample age, see rollup.WithDelay.
+const RollupDelay = time.Minute
+
+// RollupRetention is the retention of every tier by its resolution. Zero or missing keeps the tier forever.
+type RollupRetention map[time.Duration]time.Duration
+
+// HistoryPoint is a recorded metric update.
+// Value is the sampled value of a gauge or the increment of a counter.
+type HistoryPoint struct {
+	Timestamp time.Time `json:"timestamp" db:"ts"`
+	Value     float64   `json:"value" db:"value"`
+}
+
+// HistoryAggregate summarizes the points of a time bucket starting at Timestamp.
+// Value is the average for gauges and the sum of increments for counters.
+// Min, Max and Last are only kept for gauges.
+type HistoryAggregate struct {
+	Timestamp time.Time `json:"timestamp" db:"ts"`
+	Value     float64   `json:"value" db:"-"`
+	Min       float64   `json:"min" db:"min"`
+	Max       float64   `json:"max" db:"max"`
+	Last      float64   `json:"last" db:"last"`
+	Sum       float64   `json:"sum" db:"sum"`
+	Count     int64     `json:"count" db:"count"`
+}
+
+// HistoryRepository is implemented by repositories recording every accepted metric update.
+type HistoryRepository interface {
+	// GetHistory returns the points of the metric within the inclusive time range ordered by time.
+	GetHistory(metricType, name string, from, to time.Time) ([]HistoryPoint, error)
+	// GetHistoryAggregates returns the history of the metric aggregated into buckets of the step.
+	// The coarsest rolled up tier fitting the step is used where it's available.
+	GetHistoryAggregates(metricType, name string, from, to time.Time, step time.Duration) ([]HistoryAggregate, error)
+	// RollupHistory aggregates the complete buckets of every tier up to the delay ago and deletes the data
+	// expired at now. The raw history that isn't rolled up yet is kept.
+	RollupHistory(now time.Time, delay time.Duration, retention RollupRetention) error
+}
+
+// TierReader reads the raw and the rolled up history.
+type TierReader interface {
+	GetHistory(metricType, name string, from, to time.Time) ([]HistoryPoint, error)
+	// GetRollups returns the aggregates of the tier starting within [from, to) ordered by time.
+	GetRollups(metricType, name string, resolution time.Duration, from, to time.Time) ([]HistoryAggregate, error)
+	// RollupCheckpoint returns the time the tier is rolled up to, zero if it's empty.
+	RollupCheckpoint(resolution time.Duration) (time.Time, error)
+}
+
+// SeriesHistoryReader reads the raw and the rolled up history of many metrics of a type at once.
+type SeriesHistoryReader interface {
+	// GetSeriesHistory returns the points of the metrics within the inclusive time range ordered by time.
+	GetSeriesHistory(metricType string, names []string, from, to time.Time) (map[string][]HistoryPoint, error)
+	// GetSeriesRollups returns the aggregates of the tier of the metrics starting within [from, to) ordered by time.
+	GetSeriesRollups(
+		metricType string,
+		names []string,
+		resolution time.Duration,
+		from, to time.Time,
+	) (map[string][]HistoryAggregate, error)
+}
+
+// QuerySeriesHistory returns the history of the metrics within the inclusive time range with a read per tier.
+// The raw history is kept for a shorter time than the tiers, so the part of the range before the first raw
+// point of a metric is read from the finest tier and the part before its first aggregate from the coarser ones.
+// The rolled up buckets are points at their start with the last value of gauges and the sum of increments
+// of counters.
+func QuerySeriesHistory(
+	reader SeriesHistoryReader,
+	metricType string,
+	names []string,
+	from, to time.Time,
+) (map[string][]HistoryPoint, error) {
+	history, err := reader.GetSeriesHistory(metricType, names, from, to)
+	if err != nil {
+		return nil, err
+	}
+	// a bucket is only read when it ends before the first point read from a finer source,
+	// so the points are never counted twice
+	limit := func(name string, resolution time.Duration) time.Time {
+		if points := history[name]; len(points) > 0 {
+			return BucketStart(points[0].Timestamp, resolution)
+		}
+		return to.Add(time.Nanosecond)
+	}
+
+	for _, resolution := range RollupResolutions {
+		var missing []string
+		var end time.Time
+		for _, name := range names {
+			if l := limit(name, resolution); l.After(from) {
+				missing = append(missing, name)
+				if l.After(end) {
+					end = l
+				}
+			}
+		}
+		if len(missing) == 0 {
+			break
+		}
+
+		rollups, err := reader.GetSeriesRollups(metricType, missing, resolution, from, end)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range missing {
+			l := limit(name, resolution)
+			var points []HistoryPoint
+			for _, aggregate := range rollups[name] {
+				if !aggregate.Timestamp.Before(l) {
+					break
+				}
+				point := HistoryPoint{Timestamp: aggregate.Timestamp, Value: aggregate.Sum}
+				if metricType != shared.Counter {
+					point.Value = aggregate.Last
+				}
+				points = append(points, point)
+			}
+			if len(points) > 0 {
+				history[name] = append(points, history[name]...)
+			}
+		}
+	}
+	return history, nil
+}
+
+// QueryHistoryAggregates implements GetHistoryAggregates on top of the tiers. The range is read from
+// the coarsest tier fitting the step up to its checkpoint, the rest is read from the finer tiers
+// and finally from the raw history.
+func QueryHistoryAggregates(
+	reader TierReader,
+	metricType, name string,
+	from, to time.Time,
+	step time.Duration,
+) ([]HistoryAggregate, error) {
+	var parts []HistoryAggregate
+	start := from
+	for i := len(RollupResolutions) - 1; i >= 0; i-- {
+		resolution := RollupResolutions[i]
+		if resolution > step || step%resolution != 0 {
+			continue
+		}
+		checkpoint, err := reader.RollupCheckpoint(resolution)
+		if err != nil {
+			return nil, err
+		}
+		end := checkpoint
+		if end.After(to) {
+			end = to
+		}
+		if !end.After(start) {
+			continue
+		}
+		aggregates, err := reader.GetRollups(metricType, name, resolution, start, end)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, aggregates...)
+		start = end
+	}
+
+	points, err := reader.GetHistory(metricType, name, start, to)
+	if err != nil {
+		return nil, err
+	}
+	parts = append(parts, pointsToAggregates(metricType, points)...)
+	return MergeAggregates(metricType, parts, step), nil
+}
+
+// AggregateHistory groups the time-ordered points into buckets of the step aligned to the Unix epoch.
+// Empty buckets are omitted.
+func AggregateHistory(metricType string, points []HistoryPoint, step time.Duration) []HistoryAggregate {
+	return MergeAggregates(metricType, pointsToAggregates(metricType, points), step)
+}
+
+// MergeAggregates groups the time-ordered aggregates into buckets of the step aligned to the Unix epoch.
+// The step must be a multiple of the resolution of the aggregates.
+func MergeAggregates(metricType string, aggregates []HistoryAggregate, step time.Duration) []HistoryAggregate {
+	merged := make([]HistoryAggregate, 0)
+	for _, aggregate := range aggregates {
+		bucket := BucketStart(aggregate.Timestamp, step)
+		if n := len(merged); n == 0 || !merged[n-1].Timestamp.Equal(bucket) {
+			aggregate.Timestamp = bucket
+			merged = append(merged, aggregate)
+			continue
+		}
+		last := &merged[len(merged)-1]
+		last.Sum += aggregate.Sum
+		last.Count += aggregate.Count
+		if metricType != shared.Counter {
+			last.Min = min(last.Min, aggregate.Min)
+			last.Max = max(last.Max, aggregate.Max)
+			last.Last = aggregate.Last
+		}
+	}
+
+	for i := range merged {
+		merged[i].Value = merged[i].Sum
+		if metricType != shared.Counter && merged[i].Count > 0 {
+			merged[i].Value /= float64(merged[i].Count)
+		}
+	}
+	return merged
+}
+
+// BucketStart returns the start of the bucket of the step containing the time.
+func BucketStart(t time.Time, step time.Duration) time.Time {
+	return time.Unix(0, t.UnixNano()/int64(step)*int64(step))
+}
+
+// RollupEnd returns the end of the last complete bucket of the resolution which can be rolled up.
+func RollupEnd(now time.Time, resolution time.Duration) time.Time {
+	return BucketStart(now.Add(-RollupDelay), resolution)
+}
+
+func pointsToAggregates(metricType string, points []HistoryPoint) []HistoryAggregate {
+	aggregates := make([]HistoryAggregate, 0, len(points))
+	for _, point := range points {
+		aggregate := HistoryAggregate{Timestamp: point.Timestamp, Sum: point.Value, Count: 1}
+		if metricType != shared.Counter {
+			aggregate.Min, aggregate.Max, aggregate.Last = point.Value, point.Value, point.Value
+		}
+		aggregates = append(aggregates, aggregate)
+	}
+	return aggregates
+}
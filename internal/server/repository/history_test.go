@@ -0,0 +1,136 @@
+package repository
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gonozov0/go-musthave-devops/internal/shared"
+)
+
+type tierRead struct {
+	resolution time.Duration // zero for the raw history
+	from, to   time.Time
+}
+
+type fakeTierReader struct {
+	checkpoints map[time.Duration]time.Time
+	reads       []tierRead
+}
+
+func (r *fakeTierReader) GetHistory(_, _ string, from, to time.Time) ([]HistoryPoint, error) {
+	r.reads = append(r.reads, tierRead{from: from, to: to})
+	return []HistoryPoint{{Timestamp: from, Value: 2}}, nil
+}
+
+func (r *fakeTierReader) GetRollups(_, _ string, resolution time.Duration, from, to time.Time) ([]HistoryAggregate, error) {
+	r.reads = append(r.reads, tierRead{resolution: resolution, from: from, to: to})
+	return []HistoryAggregate{{Timestamp: from, Min: 1, Max: 3, Last: 3, Sum: 4, Count: 2}}, nil
+}
+
+func (r *fakeTierReader) RollupCheckpoint(resolution time.Duration) (time.Time, error) {
+	return r.checkpoints[resolution], nil
+}
+
+func TestQueryHistoryAggregates(t *testing.T) {
+	day := time.Unix(0, 0).Add(1000 * 24 * time.Hour)
+	reader := &fakeTierReader{checkpoints: map[time.Duration]time.Time{
+		time.Minute:    day.Add(3*time.Hour + 30*time.Minute),
+		time.Hour:      day.Add(3 * time.Hour),
+		24 * time.Hour: day,
+	}}
+
+	aggregates, err := QueryHistoryAggregates(reader, shared.Gauge, "Alloc", day.Add(time.Hour), day.Add(4*time.Hour), 2*time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, []tierRead{
+		{resolution: time.Hour, from: day.Add(time.Hour), to: day.Add(3 * time.Hour)},
+		{resolution: time.Minute, from: day.Add(3 * time.Hour), to: day.Add(3*time.Hour + 30*time.Minute)},
+		{from: day.Add(3*time.Hour + 30*time.Minute), to: day.Add(4 * time.Hour)},
+	}, reader.reads)
+	require.Equal(t, []HistoryAggregate{
+		{Timestamp: day, Value: 2, Min: 1, Max: 3, Last: 3, Sum: 4, Count: 2},
+		{Timestamp: day.Add(2 * time.Hour), Value: 2, Min: 1, Max: 3, Last: 2, Sum: 6, Count: 3},
+	}, aggregates)
+
+	reader.reads = nil
+	_, err = QueryHistoryAggregates(reader, shared.Gauge, "Alloc", day, day.Add(time.Hour), 90*time.Second)
+	require.NoError(t, err)
+	require.Equal(t, []tierRead{{from: day, to: day.Add(time.Hour)}}, reader.reads, "no tier fits the step")
+}
+
+type seriesRead struct {
+	resolution time.Duration // zero for the raw history
+	names      []string
+}
+
+type fakeSeriesReader struct {
+	raw     map[string][]HistoryPoint
+	rollups map[time.Duration]map[string][]HistoryAggregate
+	reads   []seriesRead
+}
+
+func (r *fakeSeriesReader) GetSeriesHistory(_ string, names []string, _, _ time.Time) (map[string][]HistoryPoint, error) {
+	r.reads = append(r.reads, seriesRead{names: names})
+	history := make(map[string][]HistoryPoint)
+	for _, name := range names {
+		if points, ok := r.raw[name]; ok {
+			history[name] = points
+		}
+	}
+	return history, nil
+}
+
+func (r *fakeSeriesReader) GetSeriesRollups(
+	_ string,
+	names []string,
+	resolution time.Duration,
+	from, to time.Time,
+) (map[string][]HistoryAggregate, error) {
+	r.reads = append(r.reads, seriesRead{resolution: resolution, names: names})
+	rollups := make(map[string][]HistoryAggregate)
+	for _, name := range names {
+		for _, aggregate := range r.rollups[resolution][name] {
+			if !aggregate.Timestamp.Before(from) && aggregate.Timestamp.Before(to) {
+				rollups[name] = append(rollups[name], aggregate)
+			}
+		}
+	}
+	return rollups, nil
+}
+
+func TestQuerySeriesHistory(t *testing.T) {
+	day := time.Unix(0, 0).Add(1000 * 24 * time.Hour)
+	at := func(d time.Duration) time.Time { return day.Add(d) }
+	reader := &fakeSeriesReader{
+		raw: map[string][]HistoryPoint{
+			"a": {{Timestamp: at(2 * time.Hour), Value: 1}, {Timestamp: at(150 * time.Minute), Value: 2}},
+			"c": {{Timestamp: day, Value: 5}},
+		},
+		rollups: map[time.Duration]map[string][]HistoryAggregate{
+			time.Minute: {
+				"a": {{Timestamp: at(119 * time.Minute), Last: 3}, {Timestamp: at(2 * time.Hour), Last: 4}},
+				"b": {{Timestamp: at(3 * time.Hour), Last: 6}},
+			},
+			time.Hour: {
+				"a": {{Timestamp: day, Last: 7}, {Timestamp: at(time.Hour), Last: 8}},
+			},
+		},
+	}
+
+	history, err := QuerySeriesHistory(reader, shared.Gauge, []string{"a", "b", "c"}, day, at(4*time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, []seriesRead{
+		{names: []string{"a", "b", "c"}},
+		{resolution: time.Minute, names: []string{"a", "b"}},
+		{resolution: time.Hour, names: []string{"a", "b"}},
+	}, reader.reads, "every tier must be read once for all metrics")
+	require.Equal(t, []HistoryPoint{
+		{Timestamp: day, Value: 7},
+		{Timestamp: at(119 * time.Minute), Value: 3},
+		{Timestamp: at(2 * time.Hour), Value: 1},
+		{Timestamp: at(150 * time.Minute), Value: 2},
+	}, history["a"], "the buckets overlapping a finer source must not be read")
+	require.Equal(t, []HistoryPoint{{Timestamp: at(3 * time.Hour), Value: 6}}, history["b"])
+	require.Equal(t, []HistoryPoint{{Timestamp: day, Value: 5}}, history["c"])
+}
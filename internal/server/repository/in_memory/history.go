@@ -0,0 +1,217 @@
+package repository
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gonozov0/go-musthave-devops/internal/server/repository"
+)
+
+// GetHistory returns the recorded updates of the metric within the history window.
+func (repo *inMemoryRepository) GetHistory(metricType, name string, from, to time.Time) ([]repository.HistoryPoint, error) {
+	repo.historyMu.Lock()
+	defer repo.historyMu.Unlock()
+
+	series := repo.history[historyKey(metricType, name)]
+	start := searchPoints(series, from)
+	end := sort.Search(len(series), func(i int) bool { return series[i].Timestamp.After(to) })
+	if start >= end {
+		return []repository.HistoryPoint{}, nil
+	}
+	return append([]repository.HistoryPoint(nil), series[start:end]...), nil
+}
+
+// GetSeriesHistory returns the recorded updates of the metrics within the history window.
+func (repo *inMemoryRepository) GetSeriesHistory(
+	metricType string,
+	names []string,
+	from, to time.Time,
+) (map[string][]repository.HistoryPoint, error) {
+	history := make(map[string][]repository.HistoryPoint, len(names))
+	for _, name := range names {
+		points, err := repo.GetHistory(metricType, name, from, to)
+		if err != nil {
+			return nil, err
+		}
+		if len(points) > 0 {
+			history[name] = points
+		}
+	}
+	return history, nil
+}
+
+// recordHistory inserts the point keeping the series ordered and drops the points out of the window
+// that are already rolled up. It must be called with historyMu held.
+func (repo *inMemoryRepository) recordHistory(metricType, name string, point repository.HistoryPoint, now time.Time) {
+	if repo.historyWindow <= 0 {
+		return
+	}
+	minTime := now.Add(-repo.historyWindow)
+	if point.Timestamp.Before(minTime) {
+		return
+	}
+
+	key := historyKey(metricType, name)
+	series := repo.history[key]
+	i := sort.Search(len(series), func(i int) bool { return series[i].Timestamp.After(point.Timestamp) })
+	series = append(series, repository.HistoryPoint{})
+	copy(series[i+1:], series[i:])
+	series[i] = point
+
+	// the rollups lag behind to include the late samples, so the points they didn't reach are kept
+	if checkpoint := repo.checkpoints[repository.RollupResolutions[0]]; !checkpoint.IsZero() && checkpoint.Before(minTime) {
+		minTime = checkpoint
+	}
+	// the memory of the expired points is released when append grows the slice
+	expired := searchPoints(series, minTime)
+	repo.history[key] = series[max(expired, len(series)-maxHistoryPoints):]
+}
+
+func historyKey(metricType, name string) string {
+	return metricType + "/" + name
+}
+
+// GetHistoryAggregates returns the history of the metric aggregated into buckets of the step.
+func (repo *inMemoryRepository) GetHistoryAggregates(
+	metricType, name string,
+	from, to time.Time,
+	step time.Duration,
+) ([]repository.HistoryAggregate, error) {
+	return repository.QueryHistoryAggregates(repo, metricType, name, from, to, step)
+}
+
+// GetRollups returns the aggregates of the tier starting within [from, to).
+func (repo *inMemoryRepository) GetRollups(
+	metricType, name string,
+	resolution time.Duration,
+	from, to time.Time,
+) ([]repository.HistoryAggregate, error) {
+	repo.historyMu.Lock()
+	defer repo.historyMu.Unlock()
+
+	aggregates := repo.rollups[resolution][historyKey(metricType, name)]
+	start, end := searchAggregates(aggregates, from), searchAggregates(aggregates, to)
+	return append([]repository.HistoryAggregate{}, aggregates[start:end]...), nil
+}
+
+// GetSeriesRollups returns the aggregates of the tier of the metrics starting within [from, to).
+func (repo *inMemoryRepository) GetSeriesRollups(
+	metricType string,
+	names []string,
+	resolution time.Duration,
+	from, to time.Time,
+) (map[string][]repository.HistoryAggregate, error) {
+	rollups := make(map[string][]repository.HistoryAggregate, len(names))
+	for _, name := range names {
+		aggregates, err := repo.GetRollups(metricType, name, resolution, from, to)
+		if err != nil {
+			return nil, err
+		}
+		if len(aggregates) > 0 {
+			rollups[name] = aggregates
+		}
+	}
+	return rollups, nil
+}
+
+// RollupCheckpoint returns the time the tier is rolled up to.
+func (repo *inMemoryRepository) RollupCheckpoint(resolution time.Duration) (time.Time, error) {
+	repo.historyMu.Lock()
+	defer repo.historyMu.Unlock()
+	return repo.checkpoints[resolution], nil
+}
+
+// RollupHistory aggregates the raw history into the finest tier and every tier into the next one
+// up to the last complete bucket before the delay, then deletes the expired aggregates and raw points.
+// Neither the raw history nor the tiers are saved to the file storage, so they're lost on a restart.
+func (repo *inMemoryRepository) RollupHistory(
+	now time.Time,
+	delay time.Duration,
+	retention repository.RollupRetention,
+) error {
+	repo.historyMu.Lock()
+	defer repo.historyMu.Unlock()
+
+	for i, resolution := range repository.RollupResolutions {
+		tier := repo.rollups[resolution]
+		if tier == nil {
+			tier = make(map[string][]repository.HistoryAggregate)
+			repo.rollups[resolution] = tier
+		}
+
+		from, to := repo.checkpoints[resolution], repository.RollupEnd(now.Add(-delay), resolution)
+		if i == 0 {
+			if to.After(from) {
+				for key, series := range repo.history {
+					metricType, _, _ := strings.Cut(key, "/")
+					points := series[searchPoints(series, from):searchPoints(series, to)]
+					if len(points) > 0 {
+						tier[key] = append(tier[key], repository.AggregateHistory(metricType, points, resolution)...)
+					}
+				}
+				repo.checkpoints[resolution] = to
+			}
+		} else if sourceCheckpoint := repo.checkpoints[repository.RollupResolutions[i-1]]; !sourceCheckpoint.IsZero() {
+			// the coarser tier is built from the complete buckets of the finer one
+			if limit := repository.BucketStart(sourceCheckpoint, resolution); limit.Before(to) {
+				to = limit
+			}
+			if to.After(from) {
+				for key, source := range repo.rollups[repository.RollupResolutions[i-1]] {
+					metricType, _, _ := strings.Cut(key, "/")
+					aggregates := source[searchAggregates(source, from):searchAggregates(source, to)]
+					if len(aggregates) > 0 {
+						tier[key] = append(tier[key], repository.MergeAggregates(metricType, aggregates, resolution)...)
+					}
+				}
+				repo.checkpoints[resolution] = to
+			}
+		}
+
+		if retention[resolution] > 0 {
+			minTime := now.Add(-retention[resolution])
+			for key, aggregates := range tier {
+				if aggregates = aggregates[searchAggregates(aggregates, minTime):]; len(aggregates) > 0 {
+					tier[key] = aggregates
+				} else {
+					delete(tier, key)
+				}
+			}
+		}
+	}
+
+	// drop the raw history of the metrics that aren't updated anymore, but not before it's rolled up
+	if repo.historyWindow > 0 {
+		minTime := now.Add(-repo.historyWindow)
+		if checkpoint := repo.checkpoints[repository.RollupResolutions[0]]; checkpoint.Before(minTime) {
+			minTime = checkpoint
+		}
+		for key, series := range repo.history {
+			if series = series[searchPoints(series, minTime):]; len(series) > 0 {
+				repo.history[key] = series
+			} else {
+				delete(repo.history, key)
+			}
+		}
+	}
+	return nil
+}
+
+// deleteHistory deletes the raw and the rolled up history of the metric. It must be called with historyMu held.
+func (repo *inMemoryRepository) deleteHistory(key string) {
+	delete(repo.history, key)
+	for _, tier := range repo.rollups {
+		delete(tier, key)
+	}
+}
+
+// searchPoints returns the index of the first point not before the time.
+func searchPoints(points []repository.HistoryPoint, t time.Time) int {
+	return sort.Search(len(points), func(i int) bool { return !points[i].Timestamp.Before(t) })
+}
+
+// searchAggregates returns the index of the first aggregate not before the time.
+func searchAggregates(aggregates []repository.HistoryAggregate, t time.Time) int {
+	return sort.Search(len(aggregates), func(i int) bool { return !aggregates[i].Timestamp.Before(t) })
+}
@@ -8,26 +8,95 @@ import (
 
 	log "github.com/sirupsen/logrus"
 
+	"github.com/gonozov0/go-musthave-devops/internal/server/hll"
 	"github.com/gonozov0/go-musthave-devops/internal/server/repository"
 	filestorage "github.com/gonozov0/go-musthave-devops/internal/server/repository/in_memory/internal/file_storage"
+	"github.com/gonozov0/go-musthave-devops/internal/shared"
+)
+
+const (
+	// DefaultHistoryWindow is the history window of repositories created without WithHistoryWindow.
+	DefaultHistoryWindow = time.Hour
+	// maxHistoryPoints bounds the memory of a single metric updated too often for the window.
+	maxHistoryPoints = 100000
 )
 
 // inMemoryRepository is an in-memory implementation of the Repository interface.
 type inMemoryRepository struct {
 	gaugeMu     sync.RWMutex
 	counterMu   sync.RWMutex
+	histogramMu sync.RWMutex
 	gauges      map[string]float64
+	gaugeTimes  map[string]time.Time
 	counters    map[string]int64
+	histograms  map[string]shared.HistogramValue
 	fileStorage *filestorage.FileStorage
-	saveTicker  *time.Ticker
+
+	setMu            sync.RWMutex
+	sets             map[string]*set
+	setResetInterval time.Duration
+	saveTicker       *time.Ticker
+	publisher        repository.Publisher
+
+	silenceMu sync.RWMutex
+	silences  map[string]repository.Silence
+
+	historyMu     sync.Mutex
+	history       map[string][]repository.HistoryPoint // by historyKey, ordered by time
+	historyWindow time.Duration
+	rollups       map[time.Duration]map[string][]repository.HistoryAggregate // by resolution and historyKey
+	checkpoints   map[time.Duration]time.Time                                // rolled up time by resolution
+}
+
+// set is the sketch of the set members added since the start of the period.
+type set struct {
+	sketch *hll.Sketch
+	period time.Time
+}
+
+// Option configures the in-memory repository.
+type Option func(*inMemoryRepository)
+
+// WithHistoryWindow sets how long the metric updates are kept for GetHistory. Zero disables the history.
+func WithHistoryWindow(window time.Duration) Option {
+	return func(repo *inMemoryRepository) {
+		repo.historyWindow = window
+	}
+}
+
+// WithSetResetInterval makes the set metrics count the members of the current interval only,
+// see repository.SetPeriod. Zero never resets them.
+func WithSetResetInterval(interval time.Duration) Option {
+	return func(repo *inMemoryRepository) {
+		repo.setResetInterval = interval
+	}
+}
+
+// WithPublisher publishes the metrics stored by every successful update.
+func WithPublisher(publisher repository.Publisher) Option {
+	return func(repo *inMemoryRepository) {
+		repo.publisher = publisher
+	}
 }
 
 // NewInMemoryRepository creates a new inMemoryRepository and returns it as a Repository interface.
-func NewInMemoryRepository() repository.Repository {
-	return &inMemoryRepository{
-		gauges:   make(map[string]float64),
-		counters: make(map[string]int64),
+func NewInMemoryRepository(opts ...Option) repository.Repository {
+	repo := &inMemoryRepository{
+		gauges:        make(map[string]float64),
+		gaugeTimes:    make(map[string]time.Time),
+		counters:      make(map[string]int64),
+		histograms:    make(map[string]shared.HistogramValue),
+		sets:          make(map[string]*set),
+		silences:      make(map[string]repository.Silence),
+		history:       make(map[string][]repository.HistoryPoint),
+		historyWindow: DefaultHistoryWindow,
+		rollups:       make(map[time.Duration]map[string][]repository.HistoryAggregate),
+		checkpoints:   make(map[time.Duration]time.Time),
 	}
+	for _, opt := range opts {
+		opt(repo)
+	}
+	return repo
 }
 
 // NewInMemoryRepositoryWithFileStorage creates a new in-memory repository with file storage capability.
@@ -41,6 +110,7 @@ func NewInMemoryRepository() repository.Repository {
 //	If it is set to 0, the default minimal interval will be used (practically immediate).
 //
 // restore: If true, the repository will attempt to restore metrics from the specified file storage at initialization.
+// opts: Options of the repository, the history isn't saved to the file storage.
 //
 // Returns:
 // - An instance of repository.Repository populated with metrics from the file storage (if restore is true and no errors occurred).
@@ -54,6 +124,7 @@ func NewInMemoryRepositoryWithFileStorage(
 	filePath string,
 	intervalInSeconds uint64,
 	restore bool,
+	opts ...Option,
 ) (repository.Repository, error) {
 	var (
 		metrics filestorage.Metrics
@@ -75,11 +146,28 @@ func NewInMemoryRepositoryWithFileStorage(
 	}
 	saveTicker := time.NewTicker(duration)
 
+	gauges, gaugeTimes := gaugesToMaps(metrics.Gauges)
+	sets, err := setsToMap(metrics.Sets)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load sets from file storage: %w", err)
+	}
 	repo := inMemoryRepository{
-		gauges:      gaugesToMap(metrics.Gauges),
+		gauges:      gauges,
+		gaugeTimes:  gaugeTimes,
 		counters:    countersToMap(metrics.Counters),
+		histograms:  histogramsToMap(metrics.Histograms),
+		sets:        sets,
+		silences:    silencesToMap(metrics.Silences),
 		fileStorage: fileStorage,
 		saveTicker:  saveTicker,
+
+		history:       make(map[string][]repository.HistoryPoint),
+		historyWindow: DefaultHistoryWindow,
+		rollups:       make(map[time.Duration]map[string][]repository.HistoryAggregate),
+		checkpoints:   make(map[time.Duration]time.Time),
+	}
+	for _, opt := range opts {
+		opt(&repo)
 	}
 	repo.startSaveMetricsInBackground(ctx, wg)
 
@@ -93,10 +181,17 @@ func (repo *inMemoryRepository) Ping() error {
 
 // UpdateGauge updates or sets a new gauge metric with the given name and value.
 func (repo *inMemoryRepository) UpdateGauge(metricName string, value float64) (float64, error) {
+	now := time.Now()
 	repo.gaugeMu.Lock()
-	repo.gauges[metricName] = value
+	metric := repo.updateGauge(repository.GaugeMetric{Name: metricName, Value: value}, now)
 	repo.gaugeMu.Unlock()
-	return value, nil
+
+	repo.historyMu.Lock()
+	repo.recordHistory(shared.Gauge, metricName, repository.HistoryPoint{Timestamp: now, Value: value}, now)
+	repo.historyMu.Unlock()
+
+	repository.PublishGauges(repo.publisher, []repository.GaugeMetric{metric})
+	return metric.Value, nil
 }
 
 // UpdateCounter updates or sets a new counter metric with the given name and value.
@@ -105,17 +200,52 @@ func (repo *inMemoryRepository) UpdateCounter(metricName string, value int64) (i
 	newValue := repo.counters[metricName] + value
 	repo.counters[metricName] = newValue
 	repo.counterMu.Unlock()
+
+	now := time.Now()
+	repo.historyMu.Lock()
+	repo.recordHistory(shared.Counter, metricName, repository.HistoryPoint{Timestamp: now, Value: float64(value)}, now)
+	repo.historyMu.Unlock()
+
+	repository.PublishCounters(repo.publisher, []repository.CounterMetric{{Name: metricName, Value: newValue}})
 	return newValue, nil
 }
 
 // UpdateGauges updates or sets a new gauge metrics with the given name and value.
 func (repo *inMemoryRepository) UpdateGauges(metrics []repository.GaugeMetric) ([]repository.GaugeMetric, error) {
+	now := time.Now()
+	newMetrics := make([]repository.GaugeMetric, 0, len(metrics))
 	repo.gaugeMu.Lock()
 	for _, metric := range metrics {
-		repo.gauges[metric.Name] = metric.Value
+		newMetrics = append(newMetrics, repo.updateGauge(metric, now))
 	}
 	repo.gaugeMu.Unlock()
-	return metrics, nil
+
+	repo.historyMu.Lock()
+	for _, metric := range metrics {
+		point := repository.HistoryPoint{Timestamp: metric.Timestamp, Value: metric.Value}
+		if point.Timestamp.IsZero() {
+			point.Timestamp = now
+		}
+		repo.recordHistory(shared.Gauge, metric.Name, point, now)
+	}
+	repo.historyMu.Unlock()
+
+	repository.PublishGauges(repo.publisher, newMetrics)
+	return newMetrics, nil
+}
+
+// updateGauge stores the sample unless a newer one is already stored and returns the current metric.
+// It must be called with gaugeMu held.
+func (repo *inMemoryRepository) updateGauge(metric repository.GaugeMetric, now time.Time) repository.GaugeMetric {
+	if metric.Timestamp.IsZero() {
+		metric.Timestamp = now
+	}
+	if storedTime, ok := repo.gaugeTimes[metric.Name]; ok && storedTime.After(metric.Timestamp) {
+		return repository.GaugeMetric{Name: metric.Name, Value: repo.gauges[metric.Name], Timestamp: storedTime}
+	}
+	repo.gauges[metric.Name] = metric.Value
+	repo.gaugeTimes[metric.Name] = metric.Timestamp
+	return metric
 }
 
 // UpdateCounters updates or sets a new counter metrics with the given name and value.
@@ -124,13 +254,88 @@ func (repo *inMemoryRepository) UpdateCounters(metrics []repository.CounterMetri
 	repo.counterMu.Lock()
 	for _, metric := range metrics {
 		newValue := repo.counters[metric.Name] + metric.Value
-		repo.counters[metric.Name] = metric.Value
+		repo.counters[metric.Name] = newValue
 		newMetrics = append(newMetrics, repository.CounterMetric{Name: metric.Name, Value: newValue})
 	}
 	repo.counterMu.Unlock()
+
+	now := time.Now()
+	repo.historyMu.Lock()
+	for _, metric := range metrics {
+		point := repository.HistoryPoint{Timestamp: now, Value: float64(metric.Value)}
+		repo.recordHistory(shared.Counter, metric.Name, point, now)
+	}
+	repo.historyMu.Unlock()
+
+	repository.PublishCounters(repo.publisher, newMetrics)
+	return newMetrics, nil
+}
+
+// UpdateHistograms merges the histogram updates into the stored histograms.
+// The histograms aren't recorded in the history.
+func (repo *inMemoryRepository) UpdateHistograms(
+	metrics []repository.HistogramMetric,
+) ([]repository.HistogramMetric, error) {
+	newMetrics := make([]repository.HistogramMetric, 0, len(metrics))
+	repo.histogramMu.Lock()
+	for _, metric := range metrics {
+		value := repo.histograms[metric.Name].Merge(metric.Value)
+		repo.histograms[metric.Name] = value
+		newMetrics = append(newMetrics, repository.HistogramMetric{Name: metric.Name, Value: value})
+	}
+	repo.histogramMu.Unlock()
+
+	repository.PublishHistograms(repo.publisher, newMetrics)
 	return newMetrics, nil
 }
 
+// ObserveHistogram adds the observation to the stored histogram, the buckets are chosen under the lock
+// so concurrent observations aren't lost.
+func (repo *inMemoryRepository) ObserveHistogram(
+	metricName string,
+	value float64,
+	bounds []float64,
+) (shared.HistogramValue, error) {
+	repo.histogramMu.Lock()
+	stored := repo.histograms[metricName]
+	newValue := stored.Merge(shared.NewHistogram(stored.ObservationBounds(bounds), value))
+	repo.histograms[metricName] = newValue
+	repo.histogramMu.Unlock()
+
+	repository.PublishHistograms(repo.publisher, []repository.HistogramMetric{{Name: metricName, Value: newValue}})
+	return newValue, nil
+}
+
+// UpdateSets adds the members to the set sketches.
+func (repo *inMemoryRepository) UpdateSets(metrics []repository.SetMetric) ([]repository.SetMetric, error) {
+	period := repository.SetPeriod(time.Now(), repo.setResetInterval)
+	newMetrics := make([]repository.SetMetric, 0, len(metrics))
+	repo.setMu.Lock()
+	for _, metric := range metrics {
+		s, ok := repo.sets[metric.Name]
+		if !ok || !s.period.Equal(period) {
+			s = &set{sketch: hll.New(), period: period}
+			repo.sets[metric.Name] = s
+		}
+		for _, member := range metric.Members {
+			s.sketch.Insert(member)
+		}
+		newMetrics = append(newMetrics, repository.SetMetric{Name: metric.Name, Cardinality: s.sketch.Estimate()})
+	}
+	repo.setMu.Unlock()
+
+	repository.PublishSets(repo.publisher, newMetrics)
+	return newMetrics, nil
+}
+
+// cardinality returns the estimate of the set, zero when it was updated in an earlier period.
+func (s *set) cardinality(period time.Time) uint64 {
+	if !s.period.Equal(period) {
+		return 0
+	}
+	return s.sketch.Estimate()
+}
+
 // GetGauge return gauge metric by name.
 func (repo *inMemoryRepository) GetGauge(name string) (float64, error) {
 	repo.gaugeMu.RLock()
@@ -153,6 +358,28 @@ func (repo *inMemoryRepository) GetCounter(name string) (int64, error) {
 	return counter, nil
 }
 
+// GetHistogram return histogram metric by name.
+func (repo *inMemoryRepository) GetHistogram(name string) (shared.HistogramValue, error) {
+	repo.histogramMu.RLock()
+	defer repo.histogramMu.RUnlock()
+	histogram, ok := repo.histograms[name]
+	if !ok {
+		return shared.HistogramValue{}, repository.ErrMetricNotFound
+	}
+	return histogram, nil
+}
+
+// GetSet return the estimated cardinality of the set metric by name.
+func (repo *inMemoryRepository) GetSet(name string) (uint64, error) {
+	repo.setMu.RLock()
+	defer repo.setMu.RUnlock()
+	s, ok := repo.sets[name]
+	if !ok {
+		return 0, repository.ErrMetricNotFound
+	}
+	return s.cardinality(repository.SetPeriod(time.Now(), repo.setResetInterval)), nil
+}
+
 // GetAllGauges returns all gauge metrics.
 func (repo *inMemoryRepository) GetAllGauges() ([]repository.GaugeMetric, error) {
 	repo.gaugeMu.RLock()
@@ -160,7 +387,7 @@ func (repo *inMemoryRepository) GetAllGauges() ([]repository.GaugeMetric, error)
 
 	gauges := make([]repository.GaugeMetric, 0, len(repo.gauges))
 	for name, value := range repo.gauges {
-		gauges = append(gauges, repository.GaugeMetric{Name: name, Value: value})
+		gauges = append(gauges, repository.GaugeMetric{Name: name, Value: value, Timestamp: repo.gaugeTimes[name]})
 	}
 
 	return gauges, nil
@@ -179,11 +406,43 @@ func (repo *inMemoryRepository) GetAllCounters() ([]repository.CounterMetric, er
 	return counters, nil
 }
 
+// GetAllHistograms returns all histogram metrics.
+func (repo *inMemoryRepository) GetAllHistograms() ([]repository.HistogramMetric, error) {
+	repo.histogramMu.RLock()
+	defer repo.histogramMu.RUnlock()
+
+	histograms := make([]repository.HistogramMetric, 0, len(repo.histograms))
+	for name, value := range repo.histograms {
+		histograms = append(histograms, repository.HistogramMetric{Name: name, Value: value})
+	}
+
+	return histograms, nil
+}
+
+// GetAllSets returns the estimated cardinalities of all set metrics.
+func (repo *inMemoryRepository) GetAllSets() ([]repository.SetMetric, error) {
+	period := repository.SetPeriod(time.Now(), repo.setResetInterval)
+	repo.setMu.RLock()
+	defer repo.setMu.RUnlock()
+
+	sets := make([]repository.SetMetric, 0, len(repo.sets))
+	for name, s := range repo.sets {
+		sets = append(sets, repository.SetMetric{Name: name, Cardinality: s.cardinality(period)})
+	}
+
+	return sets, nil
+}
+
 // DeleteGauge deletes gauge metric by name.
 func (repo *inMemoryRepository) DeleteGauge(name string) error {
 	repo.gaugeMu.Lock()
 	delete(repo.gauges, name)
+	delete(repo.gaugeTimes, name)
 	repo.gaugeMu.Unlock()
+
+	repo.historyMu.Lock()
+	repo.deleteHistory(historyKey(shared.Gauge, name))
+	repo.historyMu.Unlock()
 	return nil
 }
 
@@ -192,6 +451,26 @@ func (repo *inMemoryRepository) DeleteCounter(name string) error {
 	repo.counterMu.Lock()
 	delete(repo.counters, name)
 	repo.counterMu.Unlock()
+
+	repo.historyMu.Lock()
+	repo.deleteHistory(historyKey(shared.Counter, name))
+	repo.historyMu.Unlock()
+	return nil
+}
+
+// DeleteHistogram deletes histogram metric by name.
+func (repo *inMemoryRepository) DeleteHistogram(name string) error {
+	repo.histogramMu.Lock()
+	delete(repo.histograms, name)
+	repo.histogramMu.Unlock()
+	return nil
+}
+
+// DeleteSet deletes set metric by name.
+func (repo *inMemoryRepository) DeleteSet(name string) error {
+	repo.setMu.Lock()
+	delete(repo.sets, name)
+	repo.setMu.Unlock()
 	return nil
 }
 
@@ -201,6 +480,12 @@ func (repo *inMemoryRepository) startSaveMetricsInBackground(ctx context.Context
 		for {
 			select {
 			case <-ctx.Done():
+				repo.saveTicker.Stop()
+				// save the metrics updated since the last tick before shutdown
+				err := repo.fileStorage.SaveMetrics(repo.dumpMetrics())
+				if err != nil {
+					log.Errorf("failed to save metrics through FileStorage: %v", err)
+				}
 				return
 			case <-repo.saveTicker.C:
 				err := repo.fileStorage.SaveMetrics(repo.dumpMetrics())
@@ -216,7 +501,11 @@ func (repo *inMemoryRepository) dumpMetrics() filestorage.Metrics {
 	repo.gaugeMu.RLock()
 	gauges := make([]filestorage.GaugeMetric, 0, len(repo.gauges))
 	for name, value := range repo.gauges {
-		gauges = append(gauges, filestorage.GaugeMetric{Name: name, Value: value})
+		gauge := filestorage.GaugeMetric{Name: name, Value: value}
+		if timestamp, ok := repo.gaugeTimes[name]; ok {
+			gauge.Timestamp = timestamp.UnixMilli()
+		}
+		gauges = append(gauges, gauge)
 	}
 	repo.gaugeMu.RUnlock()
 
@@ -227,18 +516,72 @@ func (repo *inMemoryRepository) dumpMetrics() filestorage.Metrics {
 	}
 	repo.counterMu.RUnlock()
 
+	repo.histogramMu.RLock()
+	histograms := make([]filestorage.HistogramMetric, 0, len(repo.histograms))
+	for name, value := range repo.histograms {
+		histograms = append(histograms, filestorage.HistogramMetric{
+			Name:   name,
+			Bounds: value.Bounds,
+			Counts: value.Counts,
+			Sum:    value.Sum,
+			Count:  value.Count,
+		})
+	}
+	repo.histogramMu.RUnlock()
+
+	repo.setMu.RLock()
+	sets := make([]filestorage.SetMetric, 0, len(repo.sets))
+	for name, s := range repo.sets {
+		sketch, err := s.sketch.MarshalBinary()
+		if err != nil {
+			log.Errorf("failed to encode set %s: %v", name, err)
+			continue
+		}
+		setMetric := filestorage.SetMetric{Name: name, Sketch: sketch}
+		if !s.period.IsZero() {
+			setMetric.Period = s.period.UnixMilli()
+		}
+		sets = append(sets, setMetric)
+	}
+	repo.setMu.RUnlock()
+
+	repo.silenceMu.RLock()
+	silences := make([]filestorage.Silence, 0, len(repo.silences))
+	for _, silence := range repo.silences {
+		matchers := make([]filestorage.SilenceMatcher, 0, len(silence.Matchers))
+		for _, m := range silence.Matchers {
+			matchers = append(matchers, filestorage.SilenceMatcher{Name: m.Name, Value: m.Value, IsRegex: m.IsRegex, IsEqual: m.IsEqual})
+		}
+		silences = append(silences, filestorage.Silence{
+			ID:        silence.ID,
+			Matchers:  matchers,
+			StartsAt:  silence.StartsAt.UnixMilli(),
+			EndsAt:    silence.EndsAt.UnixMilli(),
+			CreatedBy: silence.CreatedBy,
+			Comment:   silence.Comment,
+		})
+	}
+	repo.silenceMu.RUnlock()
+
 	return filestorage.Metrics{
-		Gauges:   gauges,
-		Counters: counters,
+		Gauges:     gauges,
+		Counters:   counters,
+		Histograms: histograms,
+		Sets:       sets,
+		Silences:   silences,
 	}
 }
 
-func gaugesToMap(gauges []filestorage.GaugeMetric) map[string]float64 {
-	result := make(map[string]float64, len(gauges))
+func gaugesToMaps(gauges []filestorage.GaugeMetric) (map[string]float64, map[string]time.Time) {
+	values := make(map[string]float64, len(gauges))
+	times := make(map[string]time.Time, len(gauges))
 	for _, g := range gauges {
-		result[g.Name] = g.Value
+		values[g.Name] = g.Value
+		if g.Timestamp != 0 {
+			times[g.Name] = time.UnixMilli(g.Timestamp)
+		}
 	}
-	return result
+	return values, times
 }
 
 func countersToMap(counters []filestorage.CounterMetric) map[string]int64 {
@@ -248,3 +591,46 @@ func countersToMap(counters []filestorage.CounterMetric) map[string]int64 {
 	}
 	return result
 }
+
+func histogramsToMap(histograms []filestorage.HistogramMetric) map[string]shared.HistogramValue {
+	result := make(map[string]shared.HistogramValue, len(histograms))
+	for _, h := range histograms {
+		result[h.Name] = shared.HistogramValue{Bounds: h.Bounds, Counts: h.Counts, Sum: h.Sum, Count: h.Count}
+	}
+	return result
+}
+
+func setsToMap(sets []filestorage.SetMetric) (map[string]*set, error) {
+	result := make(map[string]*set, len(sets))
+	for _, s := range sets {
+		sketch := hll.New()
+		if err := sketch.UnmarshalBinary(s.Sketch); err != nil {
+			return nil, fmt.Errorf("set %s: %w", s.Name, err)
+		}
+		var period time.Time
+		if s.Period != 0 {
+			period = time.UnixMilli(s.Period)
+		}
+		result[s.Name] = &set{sketch: sketch, period: period}
+	}
+	return result, nil
+}
+
+func silencesToMap(silences []filestorage.Silence) map[string]repository.Silence {
+	result := make(map[string]repository.Silence, len(silences))
+	for _, s := range silences {
+		matchers := make([]repository.SilenceMatcher, 0, len(s.Matchers))
+		for _, m := range s.Matchers {
+			matchers = append(matchers, repository.SilenceMatcher{Name: m.Name, Value: m.Value, IsRegex: m.IsRegex, IsEqual: m.IsEqual})
+		}
+		result[s.ID] = repository.Silence{
+			ID:        s.ID,
+			Matchers:  matchers,
+			StartsAt:  time.UnixMilli(s.StartsAt),
+			EndsAt:    time.UnixMilli(s.EndsAt),
+			CreatedBy: s.CreatedBy,
+			Comment:   s.Comment,
+		}
+	}
+	return result
+}
@@ -5,13 +5,16 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/require"
 
+	"github.com/gonozov0/go-musthave-devops/internal/server/repository"
 	filestorage "github.com/gonozov0/go-musthave-devops/internal/server/repository/in_memory/internal/file_storage"
+	"github.com/gonozov0/go-musthave-devops/internal/shared"
 )
 
 func TestLoadMetrics(t *testing.T) {
@@ -90,3 +93,340 @@ func TestSaveMetrics(t *testing.T) {
 		"File content is not equal to expected",
 	)
 }
+
+func TestSaveMetricsOnShutdown(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "metrics.json")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	repo, err := NewInMemoryRepositoryWithFileStorage(ctx, wg, fileName, 3600, false)
+	require.NoError(t, err)
+	_, err = repo.UpdateCounter("TestMetric", 42)
+	require.NoError(t, err)
+
+	// the updates since the last tick are saved when the server stops
+	cancel()
+	wg.Wait()
+
+	metrics, err := filestorage.NewFileStorage(fileName).LoadMetrics()
+	require.NoError(t, err)
+	require.Equal(t, []filestorage.CounterMetric{{Name: "TestMetric", Value: 42}}, metrics.Counters)
+}
+
+func TestUpdateCounters(t *testing.T) {
+	repo := NewInMemoryRepository()
+
+	metrics, err := repo.UpdateCounters([]repository.CounterMetric{
+		{Name: "PollCount", Value: 2},
+		{Name: "PollCount", Value: 3},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []repository.CounterMetric{{Name: "PollCount", Value: 2}, {Name: "PollCount", Value: 5}}, metrics)
+
+	// the batch deltas are added to the stored total, not stored in place of it
+	metrics, err = repo.UpdateCounters([]repository.CounterMetric{{Name: "PollCount", Value: 4}})
+	require.NoError(t, err)
+	require.Equal(t, []repository.CounterMetric{{Name: "PollCount", Value: 9}}, metrics)
+	value, err := repo.GetCounter("PollCount")
+	require.NoError(t, err)
+	require.Equal(t, int64(9), value)
+}
+
+func TestStaleGaugeSample(t *testing.T) {
+	repo := NewInMemoryRepository()
+	now := time.Now().Truncate(time.Millisecond)
+
+	metrics, err := repo.UpdateGauges([]repository.GaugeMetric{{Name: "TestMetric", Value: 2, Timestamp: now}})
+	require.NoError(t, err)
+	require.Equal(t, []repository.GaugeMetric{{Name: "TestMetric", Value: 2, Timestamp: now}}, metrics)
+
+	metrics, err = repo.UpdateGauges([]repository.GaugeMetric{
+		{Name: "TestMetric", Value: 1, Timestamp: now.Add(-time.Second)},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []repository.GaugeMetric{{Name: "TestMetric", Value: 2, Timestamp: now}}, metrics)
+
+	value, err := repo.GetGauge("TestMetric")
+	require.NoError(t, err)
+	require.Equal(t, 2.0, value)
+}
+
+func TestHistory(t *testing.T) {
+	repo := NewInMemoryRepository(WithHistoryWindow(time.Hour))
+	history := repo.(repository.HistoryRepository)
+	now := time.Now().Truncate(time.Millisecond)
+
+	_, err := repo.UpdateGauges([]repository.GaugeMetric{
+		{Name: "TestMetric", Value: 2, Timestamp: now},
+		{Name: "TestMetric", Value: 1, Timestamp: now.Add(-time.Minute)},
+		{Name: "TestMetric", Value: 0, Timestamp: now.Add(-2 * time.Hour)}, // out of the window
+	})
+	require.NoError(t, err)
+	_, err = repo.UpdateCounter("TestMetric", 5)
+	require.NoError(t, err)
+
+	points, err := history.GetHistory(shared.Gauge, "TestMetric", now.Add(-time.Hour), now)
+	require.NoError(t, err)
+	require.Equal(t, []repository.HistoryPoint{
+		{Timestamp: now.Add(-time.Minute), Value: 1},
+		{Timestamp: now, Value: 2},
+	}, points)
+
+	points, err = history.GetHistory(shared.Gauge, "TestMetric", now.Add(-time.Hour), now.Add(-time.Second))
+	require.NoError(t, err)
+	require.Len(t, points, 1)
+
+	points, err = history.GetHistory(shared.Counter, "TestMetric", now, time.Now())
+	require.NoError(t, err)
+	require.Len(t, points, 1)
+	require.Equal(t, 5.0, points[0].Value)
+
+	require.NoError(t, repo.DeleteGauge("TestMetric"))
+	points, err = history.GetHistory(shared.Gauge, "TestMetric", now.Add(-time.Hour), now)
+	require.NoError(t, err)
+	require.Empty(t, points)
+}
+
+func TestRollupHistory(t *testing.T) {
+	repo := NewInMemoryRepository(WithHistoryWindow(72 * time.Hour))
+	history := repo.(repository.HistoryRepository)
+	day := repository.BucketStart(time.Now().Add(-48*time.Hour), 24*time.Hour)
+
+	_, err := repo.UpdateGauges([]repository.GaugeMetric{
+		{Name: "TestMetric", Value: 1, Timestamp: day.Add(10 * time.Second)},
+		{Name: "TestMetric", Value: 3, Timestamp: day.Add(20 * time.Second)},
+		{Name: "TestMetric", Value: 5, Timestamp: day.Add(time.Minute)},
+		{Name: "TestMetric", Value: 7, Timestamp: day.Add(time.Hour)},
+	})
+	require.NoError(t, err)
+	_, err = repo.UpdateCounters([]repository.CounterMetric{{Name: "TestMetric", Value: 2}, {Name: "TestMetric", Value: 3}})
+	require.NoError(t, err)
+
+	now := time.Now().Add(3 * time.Minute)
+	for i := 0; i < 2; i++ { // the second rollup must not repeat the work
+		require.NoError(t, history.RollupHistory(now, 0, nil))
+	}
+
+	minutes, err := history.(repository.TierReader).GetRollups(shared.Gauge, "TestMetric", time.Minute, day, day.Add(2*time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, []repository.HistoryAggregate{
+		{Timestamp: day, Value: 2, Min: 1, Max: 3, Last: 3, Sum: 4, Count: 2},
+		{Timestamp: day.Add(time.Minute), Value: 5, Min: 5, Max: 5, Last: 5, Sum: 5, Count: 1},
+	}, minutes)
+
+	days, err := history.GetHistoryAggregates(shared.Gauge, "TestMetric", day, day.Add(23*time.Hour), 24*time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, []repository.HistoryAggregate{
+		{Timestamp: day, Value: 4, Min: 1, Max: 7, Last: 7, Sum: 16, Count: 4},
+	}, days)
+
+	counters, err := history.GetHistoryAggregates(shared.Counter, "TestMetric", now.Add(-time.Hour), now, time.Hour)
+	require.NoError(t, err)
+	var sum float64
+	for _, aggregate := range counters {
+		sum += aggregate.Value
+	}
+	require.Equal(t, 5.0, sum)
+
+	require.NoError(t, history.RollupHistory(now, 0, repository.RollupRetention{time.Minute: time.Hour}))
+	minutes, err = history.(repository.TierReader).GetRollups(shared.Gauge, "TestMetric", time.Minute, day, day.Add(2*time.Minute))
+	require.NoError(t, err)
+	require.Empty(t, minutes)
+	days, err = history.GetHistoryAggregates(shared.Gauge, "TestMetric", day, day.Add(23*time.Hour), 24*time.Hour)
+	require.NoError(t, err)
+	require.Len(t, days, 1)
+}
+
+func TestRollupLateSample(t *testing.T) {
+	repo := NewInMemoryRepository(WithHistoryWindow(time.Hour))
+	history := repo.(repository.HistoryRepository)
+	now := time.Now()
+
+	// the job rolls up with the delay of the max sample age
+	require.NoError(t, history.RollupHistory(now, time.Hour, nil))
+	late := repository.BucketStart(now.Add(-50*time.Minute), time.Minute).Add(10 * time.Second)
+	_, err := repo.UpdateGauges([]repository.GaugeMetric{{Name: "TestMetric", Value: 4, Timestamp: late}})
+	require.NoError(t, err)
+	_, err = repo.UpdateGauge("TestMetric", 1)
+	require.NoError(t, err)
+
+	require.NoError(t, history.RollupHistory(now.Add(20*time.Minute), time.Hour, nil))
+	aggregates, err := history.(repository.TierReader).GetRollups(shared.Gauge, "TestMetric", time.Minute, late.Add(-time.Minute), late)
+	require.NoError(t, err)
+	require.Len(t, aggregates, 1)
+	require.Equal(t, 4.0, aggregates[0].Last)
+	require.Equal(t, int64(1), aggregates[0].Count)
+
+	// the retention isn't held back by the delay
+	require.NoError(t, history.RollupHistory(now, time.Hour, repository.RollupRetention{time.Minute: 30 * time.Minute}))
+	aggregates, err = history.(repository.TierReader).GetRollups(shared.Gauge, "TestMetric", time.Minute, late.Add(-time.Minute), late)
+	require.NoError(t, err)
+	require.Empty(t, aggregates)
+}
+
+func TestHistograms(t *testing.T) {
+	fileName := "test_histograms.json"
+	defer os.Remove(fileName)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	repo, err := NewInMemoryRepositoryWithFileStorage(ctx, wg, fileName, 0, false)
+	require.NoError(t, err)
+
+	bounds := []float64{1, 5}
+	metrics, err := repo.UpdateHistograms([]repository.HistogramMetric{
+		{Name: "TestMetric", Value: shared.NewHistogram(bounds, 0.5)},
+		{Name: "TestMetric", Value: shared.NewHistogram(bounds, 3)},
+	})
+	require.NoError(t, err)
+	require.Len(t, metrics, 2)
+	expected := shared.HistogramValue{Bounds: bounds, Counts: []uint64{1, 1, 0}, Sum: 3.5, Count: 2}
+	require.Equal(t, expected, metrics[1].Value)
+
+	cancel()
+	wg.Wait()
+
+	ctx, cancel = context.WithCancel(context.Background())
+	wg.Add(1)
+	restored, err := NewInMemoryRepositoryWithFileStorage(ctx, wg, fileName, 0, true)
+	require.NoError(t, err)
+	value, err := restored.GetHistogram("TestMetric")
+	require.NoError(t, err)
+	require.Equal(t, expected, value)
+
+	require.NoError(t, restored.DeleteHistogram("TestMetric"))
+	_, err = restored.GetHistogram("TestMetric")
+	require.ErrorIs(t, err, repository.ErrMetricNotFound)
+	cancel()
+	wg.Wait()
+}
+
+func TestSets(t *testing.T) {
+	fileName := "test_sets.json"
+	defer os.Remove(fileName)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	repo, err := NewInMemoryRepositoryWithFileStorage(ctx, wg, fileName, 0, false)
+	require.NoError(t, err)
+
+	metrics, err := repo.UpdateSets([]repository.SetMetric{
+		{Name: "TestMetric", Members: []string{"alice", "bob"}},
+		{Name: "TestMetric", Members: []string{"bob", "carol"}},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []repository.SetMetric{
+		{Name: "TestMetric", Cardinality: 2},
+		{Name: "TestMetric", Cardinality: 3},
+	}, metrics)
+
+	cancel()
+	wg.Wait()
+
+	ctx, cancel = context.WithCancel(context.Background())
+	wg.Add(1)
+	restored, err := NewInMemoryRepositoryWithFileStorage(ctx, wg, fileName, 0, true)
+	require.NoError(t, err)
+	metrics, err = restored.UpdateSets([]repository.SetMetric{{Name: "TestMetric", Members: []string{"alice", "dave"}}})
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), metrics[0].Cardinality)
+
+	require.NoError(t, restored.DeleteSet("TestMetric"))
+	_, err = restored.GetSet("TestMetric")
+	require.ErrorIs(t, err, repository.ErrMetricNotFound)
+	cancel()
+	wg.Wait()
+}
+
+func TestSetResetInterval(t *testing.T) {
+	repo := NewInMemoryRepository(WithSetResetInterval(time.Millisecond))
+
+	_, err := repo.UpdateSets([]repository.SetMetric{{Name: "TestMetric", Members: []string{"alice", "bob"}}})
+	require.NoError(t, err)
+	time.Sleep(2 * time.Millisecond)
+
+	cardinality, err := repo.GetSet("TestMetric")
+	require.NoError(t, err)
+	require.Zero(t, cardinality, "the set must be empty in the next interval")
+
+	metrics, err := repo.UpdateSets([]repository.SetMetric{{Name: "TestMetric", Members: []string{"carol"}}})
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), metrics[0].Cardinality)
+}
+
+func TestSilences(t *testing.T) {
+	fileName := "test_silences.json"
+	defer os.Remove(fileName)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	repo, err := NewInMemoryRepositoryWithFileStorage(ctx, wg, fileName, 0, false)
+	require.NoError(t, err)
+	silences := repo.(repository.SilenceRepository)
+
+	startsAt := time.Now().Truncate(time.Millisecond)
+	silence := repository.Silence{
+		ID:        "b",
+		Matchers:  []repository.SilenceMatcher{{Name: "alertname", Value: "High.*", IsRegex: true}},
+		StartsAt:  startsAt,
+		EndsAt:    startsAt.Add(time.Hour),
+		CreatedBy: "alice",
+		Comment:   "maintenance",
+	}
+	require.NoError(t, silences.SaveSilence(silence))
+	require.NoError(t, silences.SaveSilence(repository.Silence{ID: "a", StartsAt: startsAt, EndsAt: startsAt.Add(time.Minute)}))
+
+	cancel()
+	wg.Wait()
+
+	ctx, cancel = context.WithCancel(context.Background())
+	wg.Add(1)
+	repo, err = NewInMemoryRepositoryWithFileStorage(ctx, wg, fileName, 0, true)
+	require.NoError(t, err)
+	restored := repo.(repository.SilenceRepository)
+	stored, err := restored.GetSilences()
+	require.NoError(t, err)
+	require.Len(t, stored, 2)
+	require.Equal(t, "a", stored[0].ID)
+	require.Equal(t, silence.Matchers, stored[1].Matchers)
+	require.True(t, silence.EndsAt.Equal(stored[1].EndsAt))
+	require.Equal(t, "maintenance", stored[1].Comment)
+
+	require.NoError(t, restored.DeleteSilence("a"))
+	require.ErrorIs(t, restored.DeleteSilence("a"), repository.ErrSilenceNotFound)
+	cancel()
+	wg.Wait()
+}
+
+type recordingPublisher struct {
+	metrics []shared.Metric
+}
+
+func (p *recordingPublisher) Publish(metrics []shared.Metric) {
+	p.metrics = append(p.metrics, metrics...)
+}
+
+func TestPublishUpdates(t *testing.T) {
+	publisher := &recordingPublisher{}
+	repo := NewInMemoryRepository(WithPublisher(publisher))
+
+	_, err := repo.UpdateGauge("HeapAlloc", 1)
+	require.NoError(t, err)
+	_, err = repo.UpdateCounter("PollCount", 2)
+	require.NoError(t, err)
+	_, err = repo.UpdateCounters([]repository.CounterMetric{{Name: "PollCount", Value: 3}})
+	require.NoError(t, err)
+	_, err = repo.UpdateSets([]repository.SetMetric{{Name: "Users", Members: []string{"alice"}}})
+	require.NoError(t, err)
+
+	require.Len(t, publisher.metrics, 4)
+	require.Equal(t, 1.0, *publisher.metrics[0].Value)
+	require.NotNil(t, publisher.metrics[0].Timestamp)
+	require.Equal(t, int64(2), *publisher.metrics[1].Delta)
+	require.Equal(t, int64(5), *publisher.metrics[2].Delta)
+	require.Equal(t, uint64(1), *publisher.metrics[3].Cardinality)
+}
@@ -2,8 +2,9 @@ package filestorage
 
 // GaugeMetric is a struct that represents a gauge metric.
 type GaugeMetric struct {
-	Name  string  `json:"name"`
-	Value float64 `json:"value"`
+	Name      string  `json:"name"`
+	Value     float64 `json:"value"`
+	Timestamp int64   `json:"timestamp,omitempty"` // in Unix milliseconds
 }
 
 // CounterMetric is a struct that represents a counter metric.
@@ -12,8 +13,45 @@ type CounterMetric struct {
 	Value int64  `json:"value"`
 }
 
+// HistogramMetric is a struct that represents a histogram metric.
+type HistogramMetric struct {
+	Name   string    `json:"name"`
+	Bounds []float64 `json:"bounds"`
+	Counts []uint64  `json:"counts"`
+	Sum    float64   `json:"sum"`
+	Count  uint64    `json:"count"`
+}
+
+// SetMetric is a struct that represents a set metric.
+type SetMetric struct {
+	Name   string `json:"name"`
+	Sketch []byte `json:"sketch"`           // serialized HyperLogLog sketch
+	Period int64  `json:"period,omitempty"` // start of the reset interval in Unix milliseconds
+}
+
+// Silence is a struct that represents an alert silence.
+type Silence struct {
+	ID        string           `json:"id"`
+	Matchers  []SilenceMatcher `json:"matchers"`
+	StartsAt  int64            `json:"startsAt"` // in Unix milliseconds
+	EndsAt    int64            `json:"endsAt"`   // in Unix milliseconds
+	CreatedBy string           `json:"createdBy,omitempty"`
+	Comment   string           `json:"comment,omitempty"`
+}
+
+// SilenceMatcher is a struct that represents a label matcher of a silence.
+type SilenceMatcher struct {
+	Name    string `json:"name"`
+	Value   string `json:"value"`
+	IsRegex bool   `json:"isRegex,omitempty"`
+	IsEqual *bool  `json:"isEqual,omitempty"`
+}
+
 // Metrics is a struct that represents a data to save metrics.
 type Metrics struct {
-	Gauges   []GaugeMetric
-	Counters []CounterMetric
+	Gauges     []GaugeMetric
+	Counters   []CounterMetric
+	Histograms []HistogramMetric `json:",omitempty"`
+	Sets       []SetMetric       `json:",omitempty"`
+	Silences   []Silence         `json:",omitempty"` // stored with the metrics, although they aren't ones
 }
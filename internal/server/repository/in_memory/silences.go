@@ -0,0 +1,46 @@
+package repository
+
+import (
+	"sort"
+
+	"github.com/gonozov0/go-musthave-devops/internal/server/repository"
+)
+
+// SaveSilence creates or replaces the silence with the ID.
+func (repo *inMemoryRepository) SaveSilence(silence repository.Silence) error {
+	repo.silenceMu.Lock()
+	defer repo.silenceMu.Unlock()
+
+	repo.silences[silence.ID] = copySilence(silence)
+	return nil
+}
+
+// GetSilences returns all stored silences ordered by ID.
+func (repo *inMemoryRepository) GetSilences() ([]repository.Silence, error) {
+	repo.silenceMu.RLock()
+	defer repo.silenceMu.RUnlock()
+
+	silences := make([]repository.Silence, 0, len(repo.silences))
+	for _, silence := range repo.silences {
+		silences = append(silences, copySilence(silence))
+	}
+	sort.Slice(silences, func(i, j int) bool { return silences[i].ID < silences[j].ID })
+	return silences, nil
+}
+
+// DeleteSilence deletes the silence.
+func (repo *inMemoryRepository) DeleteSilence(id string) error {
+	repo.silenceMu.Lock()
+	defer repo.silenceMu.Unlock()
+
+	if _, ok := repo.silences[id]; !ok {
+		return repository.ErrSilenceNotFound
+	}
+	delete(repo.silences, id)
+	return nil
+}
+
+func copySilence(silence repository.Silence) repository.Silence {
+	silence.Matchers = append([]repository.SilenceMatcher(nil), silence.Matchers...)
+	return silence
+}
@@ -1,27 +1,52 @@
 package repository
 
+import "github.com/gonozov0/go-musthave-devops/internal/shared"
+
 // Repository describes the behavior for storing metrics.
 type Repository interface {
-	// UpdateGauge updates or adds a new gauge metric with the given name and value.
+	// UpdateGauge updates or adds a new gauge metric with the given name and value sampled now.
+	// It returns the current value, which differs from the given one when a newer sample is stored.
 	UpdateGauge(metricName string, value float64) (float64, error)
 	// UpdateCounter updates or adds a new counter metric with the given name and value.
 	UpdateCounter(metricName string, value int64) (int64, error)
 	// UpdateGauges updates or adds a new gauge metrics with the given name and value.
+	// Samples older than the stored ones are ignored. It returns the current metrics with their timestamps.
 	UpdateGauges(metrics []GaugeMetric) ([]GaugeMetric, error)
 	// UpdateCounters updates or adds a new counter metrics with the given name and value.
 	UpdateCounters(metrics []CounterMetric) ([]CounterMetric, error)
+	// UpdateHistograms merges the histogram updates into the stored histograms as described
+	// by shared.HistogramValue. It returns the current histograms.
+	UpdateHistograms(metrics []HistogramMetric) ([]HistogramMetric, error)
+	// ObserveHistogram adds a single observation to the stored histogram. Without the bounds the observation
+	// goes to the stored buckets or to shared.DefaultHistogramBounds for a new histogram.
+	// It returns the current histogram.
+	ObserveHistogram(metricName string, value float64, bounds []float64) (shared.HistogramValue, error)
+	// UpdateSets adds the members to the stored sets. It returns the estimated cardinalities of the sets.
+	UpdateSets(metrics []SetMetric) ([]SetMetric, error)
 	// GetGauge return gauge metric by name.
 	GetGauge(name string) (float64, error)
 	// GetCounter return counter metric by name.
 	GetCounter(name string) (int64, error)
+	// GetHistogram return histogram metric by name.
+	GetHistogram(name string) (shared.HistogramValue, error)
+	// GetSet return the estimated cardinality of the set metric by name.
+	GetSet(name string) (uint64, error)
 	// GetAllGauges returns all gauge metrics.
 	GetAllGauges() ([]GaugeMetric, error)
 	// GetAllCounters returns all counter metrics.
 	GetAllCounters() ([]CounterMetric, error)
+	// GetAllHistograms returns all histogram metrics.
+	GetAllHistograms() ([]HistogramMetric, error)
+	// GetAllSets returns the estimated cardinalities of all set metrics.
+	GetAllSets() ([]SetMetric, error)
 	// DeleteGauge deletes gauge metric by name.
 	DeleteGauge(name string) error
 	// DeleteCounter deletes counter metric by name.
 	DeleteCounter(name string) error
+	// DeleteHistogram deletes histogram metric by name.
+	DeleteHistogram(name string) error
+	// DeleteSet deletes set metric by name.
+	DeleteSet(name string) error
 	// Ping checks the connection to the repository.
 	Ping() error
 }
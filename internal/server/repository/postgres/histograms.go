@@ -0,0 +1,188 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+
+	"github.com/gonozov0/go-musthave-devops/internal/server/repository"
+	"github.com/gonozov0/go-musthave-devops/internal/shared"
+)
+
+type histogramRow struct {
+	Name   string          `db:"name"`
+	Bounds pq.Float64Array `db:"bounds"`
+	Counts pq.Int64Array   `db:"counts"`
+	Sum    float64         `db:"sum"`
+	Count  int64           `db:"count"`
+}
+
+func (row histogramRow) metric() repository.HistogramMetric {
+	counts := make([]uint64, len(row.Counts))
+	for i, c := range row.Counts {
+		counts[i] = uint64(c)
+	}
+	return repository.HistogramMetric{
+		Name: row.Name,
+		Value: shared.HistogramValue{
+			Bounds: []float64(row.Bounds),
+			Counts: counts,
+			Sum:    row.Sum,
+			Count:  uint64(row.Count),
+		},
+	}
+}
+
+func (r *pgRepository) UpdateHistograms(metrics []repository.HistogramMetric) ([]repository.HistogramMetric, error) {
+	if len(metrics) == 0 {
+		return make([]repository.HistogramMetric, 0), nil
+	}
+
+	// Name can be duplicated in slice, so we need to merge them first
+	uniqueMetrics := make(map[string]shared.HistogramValue, len(metrics))
+	for _, metric := range metrics {
+		uniqueMetrics[metric.Name] = uniqueMetrics[metric.Name].Merge(metric.Value)
+	}
+
+	updatedMetrics, err := mergeHistograms(r.db, uniqueMetrics)
+	if err != nil {
+		return nil, err
+	}
+
+	repository.PublishHistograms(r.publisher, updatedMetrics)
+	return updatedMetrics, nil
+}
+
+// ObserveHistogram locks the stored histogram to choose the buckets of the observation,
+// so concurrent observations aren't lost.
+func (r *pgRepository) ObserveHistogram(
+	metricName string,
+	value float64,
+	bounds []float64,
+) (shared.HistogramValue, error) {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return shared.HistogramValue{}, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	var stored shared.HistogramValue
+	var row histogramRow
+	err = tx.Get(&row, `SELECT name, bounds, counts, sum, count FROM histograms WHERE name = $1 FOR UPDATE`, metricName)
+	switch {
+	case err == nil:
+		stored = row.metric().Value
+	case !errors.Is(err, sql.ErrNoRows):
+		return shared.HistogramValue{}, fmt.Errorf("failed to select histogram: %w", err)
+	}
+
+	// a histogram inserted concurrently is merged by the upsert
+	updatedMetrics, err := mergeHistograms(tx, map[string]shared.HistogramValue{
+		metricName: shared.NewHistogram(stored.ObservationBounds(bounds), value),
+	})
+	if err != nil {
+		return shared.HistogramValue{}, err
+	}
+	if err = tx.Commit(); err != nil {
+		return shared.HistogramValue{}, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	repository.PublishHistograms(r.publisher, updatedMetrics)
+	return updatedMetrics[0].Value, nil
+}
+
+// mergeHistograms upserts the histogram updates and returns the stored histograms.
+func mergeHistograms(
+	db sqlx.Ext,
+	uniqueMetrics map[string]shared.HistogramValue,
+) ([]repository.HistogramMetric, error) {
+	valueStrings := make([]string, 0, len(uniqueMetrics))
+	queryArgs := make(map[string]interface{})
+	for name, value := range uniqueMetrics {
+		i := len(valueStrings)
+		counts := make([]int64, len(value.Counts))
+		for j, c := range value.Counts {
+			counts[j] = int64(c)
+		}
+		valueStrings = append(valueStrings, fmt.Sprintf("(:name%d, :bounds%d, :counts%d, :sum%d, :count%d)", i, i, i, i, i))
+		queryArgs[fmt.Sprintf("name%d", i)] = name
+		queryArgs[fmt.Sprintf("bounds%d", i)] = pq.Array(value.Bounds)
+		queryArgs[fmt.Sprintf("counts%d", i)] = pq.Array(counts)
+		queryArgs[fmt.Sprintf("sum%d", i)] = value.Sum
+		queryArgs[fmt.Sprintf("count%d", i)] = int64(value.Count)
+	}
+
+	// The counts are added element-wise when the bounds are the same, otherwise the update replaces the histogram.
+	// All the SET expressions see the stored row, so the bounds are compared before they're replaced.
+	queryStr := fmt.Sprintf(
+		`INSERT INTO histograms(name, bounds, counts, sum, count) VALUES %s
+		ON CONFLICT(name) DO UPDATE SET
+			counts = CASE WHEN histograms.bounds = EXCLUDED.bounds
+				THEN ARRAY(
+					SELECT s.stored + s.update
+					FROM unnest(histograms.counts, EXCLUDED.counts) WITH ORDINALITY AS s(stored, update, i)
+					ORDER BY s.i
+				)
+				ELSE EXCLUDED.counts END,
+			sum = CASE WHEN histograms.bounds = EXCLUDED.bounds
+				THEN histograms.sum + EXCLUDED.sum ELSE EXCLUDED.sum END,
+			count = CASE WHEN histograms.bounds = EXCLUDED.bounds
+				THEN histograms.count + EXCLUDED.count ELSE EXCLUDED.count END,
+			bounds = EXCLUDED.bounds
+		RETURNING name, bounds, counts, sum, count`,
+		strings.Join(valueStrings, ","),
+	)
+
+	rows, err := sqlx.NamedQuery(db, queryStr, queryArgs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute named query: %w", err)
+	}
+	defer rows.Close()
+
+	updatedMetrics := make([]repository.HistogramMetric, 0, len(uniqueMetrics))
+	for rows.Next() {
+		var row histogramRow
+		if err := rows.StructScan(&row); err != nil {
+			return nil, fmt.Errorf("failed to scan struct: %w", err)
+		}
+		updatedMetrics = append(updatedMetrics, row.metric())
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during iteration over rows: %w", err)
+	}
+	return updatedMetrics, nil
+}
+
+func (r *pgRepository) GetHistogram(name string) (shared.HistogramValue, error) {
+	var row histogramRow
+	err := r.db.Get(&row, `SELECT name, bounds, counts, sum, count FROM histograms WHERE name = $1`, name)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return shared.HistogramValue{}, repository.ErrMetricNotFound
+		}
+		return shared.HistogramValue{}, err
+	}
+	return row.metric().Value, nil
+}
+
+func (r *pgRepository) GetAllHistograms() ([]repository.HistogramMetric, error) {
+	var rows []histogramRow
+	err := r.db.Select(&rows, `SELECT name, bounds, counts, sum, count FROM histograms`)
+	if err != nil {
+		return nil, err
+	}
+	metrics := make([]repository.HistogramMetric, 0, len(rows))
+	for _, row := range rows {
+		metrics = append(metrics, row.metric())
+	}
+	return metrics, nil
+}
+
+func (r *pgRepository) DeleteHistogram(name string) error {
+	_, err := r.db.Exec(`DELETE FROM histograms WHERE name = $1`, name)
+	return err
+}
@@ -0,0 +1,294 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/gonozov0/go-musthave-devops/internal/server/repository"
+	"github.com/gonozov0/go-musthave-devops/internal/shared"
+)
+
+// bucketExpression truncates ts to the tier resolution passed as $1 in seconds.
+const bucketExpression = `to_timestamp(floor(extract(epoch FROM ts) / $1::integer) * $1::integer)`
+
+// The rollup queries insert the aggregates of the buckets starting within [$2, $3) into the tier $1,
+// the tier queries read the aggregates of the finer tier $4.
+const (
+	gaugeRawRollupQuery = `
+		INSERT INTO gauge_rollups(resolution, name, ts, min, max, sum, last, count)
+		SELECT $1::integer, name, ` + bucketExpression + ` AS bucket,
+			min(value), max(value), sum(value), (array_agg(value ORDER BY ts DESC))[1], count(*)
+		FROM gauge_history
+		WHERE ts >= $2 AND ts < $3
+		GROUP BY name, bucket` + gaugeRollupConflict
+	gaugeTierRollupQuery = `
+		INSERT INTO gauge_rollups(resolution, name, ts, min, max, sum, last, count)
+		SELECT $1::integer, name, ` + bucketExpression + ` AS bucket,
+			min(min), max(max), sum(sum), (array_agg(last ORDER BY ts DESC))[1], sum(count)::bigint
+		FROM gauge_rollups
+		WHERE resolution = $4 AND ts >= $2 AND ts < $3
+		GROUP BY name, bucket` + gaugeRollupConflict
+	gaugeRollupConflict = `
+		ON CONFLICT (resolution, name, ts) DO UPDATE SET
+			min = EXCLUDED.min, max = EXCLUDED.max, sum = EXCLUDED.sum, last = EXCLUDED.last, count = EXCLUDED.count`
+
+	counterRawRollupQuery = `
+		INSERT INTO counter_rollups(resolution, name, ts, sum, count)
+		SELECT $1::integer, name, ` + bucketExpression + ` AS bucket, sum(delta)::bigint, count(*)
+		FROM counter_history
+		WHERE ts >= $2 AND ts < $3
+		GROUP BY name, bucket` + counterRollupConflict
+	counterTierRollupQuery = `
+		INSERT INTO counter_rollups(resolution, name, ts, sum, count)
+		SELECT $1::integer, name, ` + bucketExpression + ` AS bucket, sum(sum)::bigint, sum(count)::bigint
+		FROM counter_rollups
+		WHERE resolution = $4 AND ts >= $2 AND ts < $3
+		GROUP BY name, bucket` + counterRollupConflict
+	counterRollupConflict = `
+		ON CONFLICT (resolution, name, ts) DO UPDATE SET sum = EXCLUDED.sum, count = EXCLUDED.count`
+)
+
+func (r *pgRepository) GetHistory(metricType, name string, from, to time.Time) ([]repository.HistoryPoint, error) {
+	var query string
+	switch metricType {
+	case shared.Gauge:
+		query = `SELECT ts, value FROM gauge_history WHERE name = $1 AND ts BETWEEN $2 AND $3 ORDER BY ts`
+	case shared.Counter:
+		query = `SELECT ts, delta::float8 AS value FROM counter_history WHERE name = $1 AND ts BETWEEN $2 AND $3 ORDER BY ts`
+	default:
+		return nil, fmt.Errorf("unknown metric type %q", metricType)
+	}
+
+	points := make([]repository.HistoryPoint, 0)
+	if err := r.db.Select(&points, query, name, from, to); err != nil {
+		return nil, fmt.Errorf("failed to select history: %w", err)
+	}
+	return points, nil
+}
+
+// GetSeriesHistory reads the history of all metrics in one query.
+func (r *pgRepository) GetSeriesHistory(
+	metricType string,
+	names []string,
+	from, to time.Time,
+) (map[string][]repository.HistoryPoint, error) {
+	var query string
+	switch metricType {
+	case shared.Gauge:
+		query = `SELECT name, ts, value FROM gauge_history
+			WHERE name = ANY($1) AND ts BETWEEN $2 AND $3 ORDER BY name, ts`
+	case shared.Counter:
+		query = `SELECT name, ts, delta::float8 AS value FROM counter_history
+			WHERE name = ANY($1) AND ts BETWEEN $2 AND $3 ORDER BY name, ts`
+	default:
+		return nil, fmt.Errorf("unknown metric type %q", metricType)
+	}
+
+	var rows []struct {
+		Name string `db:"name"`
+		repository.HistoryPoint
+	}
+	if err := r.db.Select(&rows, query, pq.Array(names), from, to); err != nil {
+		return nil, fmt.Errorf("failed to select history: %w", err)
+	}
+	history := make(map[string][]repository.HistoryPoint, len(names))
+	for _, row := range rows {
+		history[row.Name] = append(history[row.Name], row.HistoryPoint)
+	}
+	return history, nil
+}
+
+func (r *pgRepository) GetHistoryAggregates(
+	metricType, name string,
+	from, to time.Time,
+	step time.Duration,
+) ([]repository.HistoryAggregate, error) {
+	return repository.QueryHistoryAggregates(r, metricType, name, from, to, step)
+}
+
+func (r *pgRepository) GetRollups(
+	metricType, name string,
+	resolution time.Duration,
+	from, to time.Time,
+) ([]repository.HistoryAggregate, error) {
+	var query string
+	switch metricType {
+	case shared.Gauge:
+		query = `SELECT ts, min, max, sum, last, count FROM gauge_rollups
+			WHERE resolution = $1 AND name = $2 AND ts >= $3 AND ts < $4 ORDER BY ts`
+	case shared.Counter:
+		query = `SELECT ts, sum::float8 AS sum, count FROM counter_rollups
+			WHERE resolution = $1 AND name = $2 AND ts >= $3 AND ts < $4 ORDER BY ts`
+	default:
+		return nil, fmt.Errorf("unknown metric type %q", metricType)
+	}
+
+	aggregates := make([]repository.HistoryAggregate, 0)
+	if err := r.db.Select(&aggregates, query, resolutionSeconds(resolution), name, from, to); err != nil {
+		return nil, fmt.Errorf("failed to select rollups: %w", err)
+	}
+	return aggregates, nil
+}
+
+// GetSeriesRollups reads the aggregates of the tier of all metrics in one query.
+func (r *pgRepository) GetSeriesRollups(
+	metricType string,
+	names []string,
+	resolution time.Duration,
+	from, to time.Time,
+) (map[string][]repository.HistoryAggregate, error) {
+	var query string
+	switch metricType {
+	case shared.Gauge:
+		query = `SELECT name, ts, min, max, sum, last, count FROM gauge_rollups
+			WHERE resolution = $1 AND name = ANY($2) AND ts >= $3 AND ts < $4 ORDER BY name, ts`
+	case shared.Counter:
+		query = `SELECT name, ts, sum::float8 AS sum, count FROM counter_rollups
+			WHERE resolution = $1 AND name = ANY($2) AND ts >= $3 AND ts < $4 ORDER BY name, ts`
+	default:
+		return nil, fmt.Errorf("unknown metric type %q", metricType)
+	}
+
+	var rows []struct {
+		Name string `db:"name"`
+		repository.HistoryAggregate
+	}
+	if err := r.db.Select(&rows, query, resolutionSeconds(resolution), pq.Array(names), from, to); err != nil {
+		return nil, fmt.Errorf("failed to select rollups: %w", err)
+	}
+	rollups := make(map[string][]repository.HistoryAggregate, len(names))
+	for _, row := range rows {
+		rollups[row.Name] = append(rollups[row.Name], row.HistoryAggregate)
+	}
+	return rollups, nil
+}
+
+func (r *pgRepository) RollupCheckpoint(resolution time.Duration) (time.Time, error) {
+	var checkpoint time.Time
+	err := r.db.Get(
+		&checkpoint,
+		`SELECT rolled_up_to FROM rollup_checkpoints WHERE resolution = $1`,
+		resolutionSeconds(resolution),
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to select rollup checkpoint: %w", err)
+	}
+	return checkpoint, nil
+}
+
+// RollupHistory rolls up every tier in its own transaction together with its checkpoint,
+// so a restart continues from the last rolled up bucket. Then it deletes the expired raw history
+// that is already rolled up.
+func (r *pgRepository) RollupHistory(now time.Time, delay time.Duration, retention repository.RollupRetention) error {
+	var sourceCheckpoint, rawCheckpoint time.Time
+	for i, resolution := range repository.RollupResolutions {
+		checkpoint, err := r.rollupTier(i, now, delay, sourceCheckpoint, retention[resolution])
+		if err != nil {
+			return fmt.Errorf("failed to roll up %s tier: %w", resolution, err)
+		}
+		if i == 0 {
+			rawCheckpoint = checkpoint
+		}
+		sourceCheckpoint = checkpoint
+	}
+
+	if r.historyRetention > 0 {
+		minTime := now.Add(-r.historyRetention)
+		if rawCheckpoint.Before(minTime) {
+			minTime = rawCheckpoint
+		}
+		for _, table := range []string{"gauge_history", "counter_history"} {
+			if _, err := r.db.Exec(`DELETE FROM `+table+` WHERE ts < $1`, minTime); err != nil {
+				return fmt.Errorf("failed to delete expired %s: %w", table, err)
+			}
+		}
+	}
+	return nil
+}
+
+// rollupTier aggregates the buckets of the tier completed since its checkpoint, from the raw history
+// for the finest tier and from the previous tier up to its checkpoint for the others.
+// It returns the new checkpoint of the tier.
+func (r *pgRepository) rollupTier(
+	tierIndex int,
+	now time.Time,
+	delay time.Duration,
+	sourceCheckpoint time.Time,
+	retention time.Duration,
+) (time.Time, error) {
+	resolution := repository.RollupResolutions[tierIndex]
+	seconds := resolutionSeconds(resolution)
+
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	// the row lock serializes the rollups of the servers sharing the database
+	_, err = tx.Exec(
+		`INSERT INTO rollup_checkpoints(resolution, rolled_up_to) VALUES ($1, $2) ON CONFLICT DO NOTHING`,
+		seconds, time.Unix(0, 0),
+	)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to init checkpoint: %w", err)
+	}
+	var from time.Time
+	err = tx.Get(&from, `SELECT rolled_up_to FROM rollup_checkpoints WHERE resolution = $1 FOR UPDATE`, seconds)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to select checkpoint: %w", err)
+	}
+
+	to := repository.RollupEnd(now.Add(-delay), resolution)
+	gaugeQuery, counterQuery := gaugeRawRollupQuery, counterRawRollupQuery
+	args := []interface{}{seconds, from}
+	if tierIndex > 0 {
+		if limit := repository.BucketStart(sourceCheckpoint, resolution); limit.Before(to) {
+			to = limit
+		}
+		gaugeQuery, counterQuery = gaugeTierRollupQuery, counterTierRollupQuery
+		args = append(args, to, resolutionSeconds(repository.RollupResolutions[tierIndex-1]))
+	} else {
+		args = append(args, to)
+	}
+
+	if to.After(from) {
+		if _, err := tx.Exec(gaugeQuery, args...); err != nil {
+			return time.Time{}, fmt.Errorf("failed to roll up gauges: %w", err)
+		}
+		if _, err := tx.Exec(counterQuery, args...); err != nil {
+			return time.Time{}, fmt.Errorf("failed to roll up counters: %w", err)
+		}
+		_, err = tx.Exec(`UPDATE rollup_checkpoints SET rolled_up_to = $2 WHERE resolution = $1`, seconds, to)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("failed to update checkpoint: %w", err)
+		}
+		from = to
+	}
+
+	if retention > 0 {
+		minTime := now.Add(-retention)
+		for _, table := range []string{"gauge_rollups", "counter_rollups"} {
+			_, err := tx.Exec(`DELETE FROM `+table+` WHERE resolution = $1 AND ts < $2`, seconds, minTime)
+			if err != nil {
+				return time.Time{}, fmt.Errorf("failed to delete expired %s: %w", table, err)
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return time.Time{}, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return from, nil
+}
+
+func resolutionSeconds(resolution time.Duration) int64 {
+	return int64(resolution / time.Second)
+}
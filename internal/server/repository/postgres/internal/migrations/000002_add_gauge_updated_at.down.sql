@@ -0,0 +1,2 @@
+ALTER TABLE gauges
+    DROP COLUMN updated_at;
@@ -0,0 +1,9 @@
+CREATE TABLE profiles
+(
+    id         BIGSERIAL PRIMARY KEY,
+    source     TEXT        NOT NULL,
+    type       TEXT        NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    data       BYTEA       NOT NULL
+);
+CREATE INDEX profiles_source_type_created_at_idx ON profiles (source, type, created_at);
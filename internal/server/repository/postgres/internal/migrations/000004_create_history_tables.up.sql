@@ -0,0 +1,17 @@
+CREATE TABLE gauge_history
+(
+    name  TEXT        NOT NULL,
+    ts    TIMESTAMPTZ NOT NULL,
+    value FLOAT8      NOT NULL
+);
+CREATE INDEX gauge_history_name_ts_idx ON gauge_history (name, ts);
+CREATE INDEX gauge_history_ts_idx ON gauge_history (ts);
+
+CREATE TABLE counter_history
+(
+    name  TEXT        NOT NULL,
+    ts    TIMESTAMPTZ NOT NULL,
+    delta BIGINT      NOT NULL
+);
+CREATE INDEX counter_history_name_ts_idx ON counter_history (name, ts);
+CREATE INDEX counter_history_ts_idx ON counter_history (ts);
@@ -0,0 +1,3 @@
+DROP TABLE rollup_checkpoints;
+DROP TABLE counter_rollups;
+DROP TABLE gauge_rollups;
@@ -0,0 +1,30 @@
+CREATE TABLE gauge_rollups
+(
+    resolution INTEGER     NOT NULL, -- in seconds
+    name       TEXT        NOT NULL,
+    ts         TIMESTAMPTZ NOT NULL,
+    min        FLOAT8      NOT NULL,
+    max        FLOAT8      NOT NULL,
+    sum        FLOAT8      NOT NULL,
+    last       FLOAT8      NOT NULL,
+    count      BIGINT      NOT NULL,
+    PRIMARY KEY (resolution, name, ts)
+);
+CREATE INDEX gauge_rollups_resolution_ts_idx ON gauge_rollups (resolution, ts);
+
+CREATE TABLE counter_rollups
+(
+    resolution INTEGER     NOT NULL, -- in seconds
+    name       TEXT        NOT NULL,
+    ts         TIMESTAMPTZ NOT NULL,
+    sum        BIGINT      NOT NULL,
+    count      BIGINT      NOT NULL,
+    PRIMARY KEY (resolution, name, ts)
+);
+CREATE INDEX counter_rollups_resolution_ts_idx ON counter_rollups (resolution, ts);
+
+CREATE TABLE rollup_checkpoints
+(
+    resolution   INTEGER PRIMARY KEY, -- in seconds
+    rolled_up_to TIMESTAMPTZ NOT NULL
+);
@@ -0,0 +1,8 @@
+CREATE TABLE histograms
+(
+    name   TEXT PRIMARY KEY,
+    bounds FLOAT8[] NOT NULL,
+    counts BIGINT[] NOT NULL, -- one more than bounds, the last one is the +Inf bucket
+    sum    FLOAT8   NOT NULL,
+    count  BIGINT   NOT NULL
+);
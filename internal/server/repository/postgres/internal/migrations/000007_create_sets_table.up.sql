@@ -0,0 +1,6 @@
+CREATE TABLE sets
+(
+    name   TEXT PRIMARY KEY,
+    sketch BYTEA       NOT NULL, -- serialized HyperLogLog sketch
+    period TIMESTAMPTZ NOT NULL  -- start of the reset interval, the epoch when sets are never reset
+);
@@ -0,0 +1,9 @@
+CREATE TABLE silences
+(
+    id         TEXT PRIMARY KEY,
+    matchers   JSONB       NOT NULL,
+    starts_at  TIMESTAMPTZ NOT NULL,
+    ends_at    TIMESTAMPTZ NOT NULL,
+    created_by TEXT        NOT NULL DEFAULT '',
+    comment    TEXT        NOT NULL DEFAULT ''
+);
@@ -7,21 +7,64 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"time"
 
 	"github.com/golang-migrate/migrate/v4"
 	_ "github.com/golang-migrate/migrate/v4/database/postgres"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
 	"github.com/jmoiron/sqlx"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 
 	"github.com/gonozov0/go-musthave-devops/internal/server/repository"
 )
 
 type pgRepository struct {
-	db *sqlx.DB
+	db               *sqlx.DB
+	historyRetention time.Duration
+	setResetInterval time.Duration
+	publisher        repository.Publisher
 }
 
-func NewPGRepository(connectionString string) (repository.Repository, error) {
+// Option configures the postgres repository.
+type Option func(*pgRepository)
+
+// WithHistoryRetention sets how long the raw metric updates are kept in the history tables
+// by RollupHistory. Zero keeps them forever.
+func WithHistoryRetention(retention time.Duration) Option {
+	return func(r *pgRepository) {
+		r.historyRetention = retention
+	}
+}
+
+// WithSetResetInterval makes the set metrics count the members of the current interval only,
+// see repository.SetPeriod. Zero never resets them.
+func WithSetResetInterval(interval time.Duration) Option {
+	return func(r *pgRepository) {
+		r.setResetInterval = interval
+	}
+}
+
+// WithPublisher publishes the metrics stored by every successful update.
+func WithPublisher(publisher repository.Publisher) Option {
+	return func(r *pgRepository) {
+		r.publisher = publisher
+	}
+}
+
+func NewPGRepository(connectionString string, opts ...Option) (repository.Repository, error) {
+	db, err := connect(connectionString)
+	if err != nil {
+		return nil, err
+	}
+	r := &pgRepository{db: db}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r, nil
+}
+
+// connect opens the database and applies the migrations.
+func connect(connectionString string) (*sqlx.DB, error) {
 	db, err := sqlx.Connect("postgres", connectionString)
 	if err != nil {
 		return nil, err
@@ -37,7 +80,7 @@ func NewPGRepository(connectionString string) (repository.Repository, error) {
 		return nil, err
 	}
 
-	return &pgRepository{db: db}, nil
+	return db, nil
 }
 
 func (r *pgRepository) Ping() error {
@@ -45,15 +88,30 @@ func (r *pgRepository) Ping() error {
 }
 
 func (r *pgRepository) UpdateGauge(metricName string, value float64) (float64, error) {
-	_, err := r.db.Exec(
-		`INSERT INTO gauges(name, value) VALUES ($1, $2) ON CONFLICT(name) DO UPDATE SET value = $2`,
-		metricName,
-		value,
-	)
+	// The CTE doesn't see its own changes, so the second part returns the stored value when it's newer.
+	// The sample is stamped by the server like in UpdateGauges, not by the database clock.
+	query := `
+		WITH history AS (
+			INSERT INTO gauge_history(name, ts, value) VALUES ($1, $3, $2)
+		), upsert AS (
+			INSERT INTO gauges(name, value, updated_at)
+			VALUES ($1, $2, $3)
+			ON CONFLICT(name)
+			DO UPDATE SET value = EXCLUDED.value, updated_at = EXCLUDED.updated_at
+			WHERE gauges.updated_at <= EXCLUDED.updated_at
+			RETURNING value
+		)
+		SELECT value FROM upsert
+		UNION ALL
+		SELECT value FROM gauges WHERE name = $1 AND NOT EXISTS (SELECT 1 FROM upsert)
+	`
+	var newValue float64
+	err := r.db.QueryRow(query, metricName, value, time.Now()).Scan(&newValue)
 	if err != nil {
 		return 0, err
 	}
-	return value, nil
+	repository.PublishGauges(r.publisher, []repository.GaugeMetric{{Name: metricName, Value: newValue}})
+	return newValue, nil
 }
 
 func (r *pgRepository) UpdateGauges(metrics []repository.GaugeMetric) ([]repository.GaugeMetric, error) {
@@ -61,10 +119,17 @@ func (r *pgRepository) UpdateGauges(metrics []repository.GaugeMetric) ([]reposit
 		return make([]repository.GaugeMetric, 0), nil
 	}
 
-	// Name can be duplicated in slice, so we need to fix it
-	uniqueMetrics := make(map[string]float64, len(metrics))
+	// Name can be duplicated in slice, so we need to fix it keeping the newest sample
+	now := time.Now()
+	uniqueMetrics := make(map[string]repository.GaugeMetric, len(metrics))
 	for _, metric := range metrics {
-		uniqueMetrics[metric.Name] = metric.Value
+		if metric.Timestamp.IsZero() {
+			metric.Timestamp = now
+		}
+		if stored, ok := uniqueMetrics[metric.Name]; ok && stored.Timestamp.After(metric.Timestamp) {
+			continue
+		}
+		uniqueMetrics[metric.Name] = metric
 	}
 
 	tx, err := r.db.Beginx()
@@ -73,38 +138,58 @@ func (r *pgRepository) UpdateGauges(metrics []repository.GaugeMetric) ([]reposit
 	}
 	defer tx.Rollback() //nolint:errcheck
 
-	var valueStrings []string
-	for metricName := range uniqueMetrics {
-		valueStrings = append(valueStrings, fmt.Sprintf("(:name%s, :value%s)", metricName, metricName))
+	valueStrings := make([]string, 0, len(uniqueMetrics))
+	names := make([]string, 0, len(uniqueMetrics))
+	queryArgs := make(map[string]interface{})
+	for _, metric := range uniqueMetrics {
+		i := len(names)
+		valueStrings = append(valueStrings, fmt.Sprintf("(:name%d, :value%d, :updated_at%d)", i, i, i))
+		queryArgs[fmt.Sprintf("name%d", i)] = metric.Name
+		queryArgs[fmt.Sprintf("value%d", i)] = metric.Value
+		queryArgs[fmt.Sprintf("updated_at%d", i)] = metric.Timestamp
+		names = append(names, metric.Name)
 	}
 
 	queryStr := fmt.Sprintf(
-		"INSERT INTO gauges(name, value) VALUES %s ON CONFLICT(name) DO UPDATE SET value = EXCLUDED.value RETURNING name, value",
+		`INSERT INTO gauges(name, value, updated_at) VALUES %s
+		ON CONFLICT(name) DO UPDATE SET value = EXCLUDED.value, updated_at = EXCLUDED.updated_at
+		WHERE gauges.updated_at <= EXCLUDED.updated_at`,
 		strings.Join(valueStrings, ","),
 	)
-
-	queryArgs := make(map[string]interface{})
-	for name, value := range uniqueMetrics {
-		queryArgs[fmt.Sprintf("name%s", name)] = name
-		queryArgs[fmt.Sprintf("value%s", name)] = value
+	if _, err = tx.NamedExec(queryStr, queryArgs); err != nil {
+		return nil, fmt.Errorf("failed to execute named query: %w", err)
 	}
 
-	rows, err := tx.NamedQuery(queryStr, queryArgs)
+	// every sample goes to the history, including the duplicated and the stale ones
+	historyNames := make([]string, 0, len(metrics))
+	historyTimes := make([]string, 0, len(metrics))
+	historyValues := make([]float64, 0, len(metrics))
+	for _, metric := range metrics {
+		timestamp := metric.Timestamp
+		if timestamp.IsZero() {
+			timestamp = now
+		}
+		historyNames = append(historyNames, metric.Name)
+		historyTimes = append(historyTimes, timestamp.Format(time.RFC3339Nano))
+		historyValues = append(historyValues, metric.Value)
+	}
+	_, err = tx.Exec(
+		`INSERT INTO gauge_history(name, ts, value)
+		SELECT * FROM unnest($1::text[], $2::timestamptz[], $3::float8[])`,
+		pq.Array(historyNames), pq.Array(historyTimes), pq.Array(historyValues),
+	)
 	if err != nil {
-		return nil, fmt.Errorf("failed to execute named query: %w", err)
+		return nil, fmt.Errorf("failed to insert gauge history: %w", err)
 	}
-	defer rows.Close()
 
 	var updatedMetrics []repository.GaugeMetric
-	for rows.Next() {
-		var metric repository.GaugeMetric
-		if err := rows.StructScan(&metric); err != nil {
-			return nil, fmt.Errorf("failed to scan struct: %w", err)
-		}
-		updatedMetrics = append(updatedMetrics, metric)
-	}
-	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("error during iteration over rows: %w", err)
+	err = tx.Select(
+		&updatedMetrics,
+		`SELECT name, value, updated_at FROM gauges WHERE name = ANY($1)`,
+		pq.Array(names),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select updated gauges: %w", err)
 	}
 
 	err = tx.Commit()
@@ -112,6 +197,7 @@ func (r *pgRepository) UpdateGauges(metrics []repository.GaugeMetric) ([]reposit
 		return nil, fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
+	repository.PublishGauges(r.publisher, updatedMetrics)
 	return updatedMetrics, nil
 }
 
@@ -129,7 +215,7 @@ func (r *pgRepository) GetGauge(name string) (float64, error) {
 
 func (r *pgRepository) GetAllGauges() ([]repository.GaugeMetric, error) {
 	var metrics []repository.GaugeMetric
-	err := r.db.Select(&metrics, `SELECT name, value FROM gauges g`)
+	err := r.db.Select(&metrics, `SELECT name, value, updated_at FROM gauges`)
 	if err != nil {
 		return nil, err
 	}
@@ -138,6 +224,9 @@ func (r *pgRepository) GetAllGauges() ([]repository.GaugeMetric, error) {
 
 func (r *pgRepository) UpdateCounter(metricName string, value int64) (int64, error) {
 	query := `
+		WITH history AS (
+			INSERT INTO counter_history(name, ts, delta) VALUES ($1, now(), $2)
+		)
 		INSERT INTO counters(name, value) 
 		VALUES ($1, $2) 
 		ON CONFLICT(name) 
@@ -149,6 +238,7 @@ func (r *pgRepository) UpdateCounter(metricName string, value int64) (int64, err
 	if err != nil {
 		return 0, err
 	}
+	repository.PublishCounters(r.publisher, []repository.CounterMetric{{Name: metricName, Value: newValue}})
 	return newValue, nil
 }
 
@@ -169,9 +259,14 @@ func (r *pgRepository) UpdateCounters(metrics []repository.CounterMetric) ([]rep
 	}
 	defer tx.Rollback() //nolint:errcheck
 
-	var valueStrings []string
-	for metricName := range uniqueMetrics {
-		valueStrings = append(valueStrings, fmt.Sprintf("(:name%s, :value%s)", metricName, metricName))
+	// Metric names may contain characters that aren't allowed in bind parameter names, so indexes are used
+	valueStrings := make([]string, 0, len(uniqueMetrics))
+	queryArgs := make(map[string]interface{})
+	for name, value := range uniqueMetrics {
+		i := len(valueStrings)
+		valueStrings = append(valueStrings, fmt.Sprintf("(:name%d, :value%d)", i, i))
+		queryArgs[fmt.Sprintf("name%d", i)] = name
+		queryArgs[fmt.Sprintf("value%d", i)] = value
 	}
 
 	queryStr := fmt.Sprintf(
@@ -179,12 +274,6 @@ func (r *pgRepository) UpdateCounters(metrics []repository.CounterMetric) ([]rep
 		strings.Join(valueStrings, ","),
 	)
 
-	queryArgs := make(map[string]interface{})
-	for name, value := range uniqueMetrics {
-		queryArgs[fmt.Sprintf("name%s", name)] = name
-		queryArgs[fmt.Sprintf("value%s", name)] = value
-	}
-
 	rows, err := tx.NamedQuery(queryStr, queryArgs)
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute named query: %w", err)
@@ -202,12 +291,29 @@ func (r *pgRepository) UpdateCounters(metrics []repository.CounterMetric) ([]rep
 	if err = rows.Err(); err != nil {
 		return nil, fmt.Errorf("error during iteration over rows: %w", err)
 	}
+	rows.Close()
+
+	historyNames := make([]string, 0, len(metrics))
+	historyDeltas := make([]int64, 0, len(metrics))
+	for _, metric := range metrics {
+		historyNames = append(historyNames, metric.Name)
+		historyDeltas = append(historyDeltas, metric.Value)
+	}
+	_, err = tx.Exec(
+		`INSERT INTO counter_history(name, ts, delta)
+		SELECT name, now(), delta FROM unnest($1::text[], $2::bigint[]) AS t(name, delta)`,
+		pq.Array(historyNames), pq.Array(historyDeltas),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert counter history: %w", err)
+	}
 
 	err = tx.Commit()
 	if err != nil {
 		return nil, fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
+	repository.PublishCounters(r.publisher, updatedMetrics)
 	return updatedMetrics, nil
 }
 
@@ -233,12 +339,22 @@ func (r *pgRepository) GetAllCounters() ([]repository.CounterMetric, error) {
 }
 
 func (r *pgRepository) DeleteGauge(name string) error {
-	_, err := r.db.Exec(`DELETE FROM gauges WHERE name = $1`, name)
+	_, err := r.db.Exec(
+		`WITH history AS (DELETE FROM gauge_history WHERE name = $1),
+		rollups AS (DELETE FROM gauge_rollups WHERE name = $1)
+		DELETE FROM gauges WHERE name = $1`,
+		name,
+	)
 	return err
 }
 
 func (r *pgRepository) DeleteCounter(name string) error {
-	_, err := r.db.Exec(`DELETE FROM counters WHERE name = $1`, name)
+	_, err := r.db.Exec(
+		`WITH history AS (DELETE FROM counter_history WHERE name = $1),
+		rollups AS (DELETE FROM counter_rollups WHERE name = $1)
+		DELETE FROM counters WHERE name = $1`,
+		name,
+	)
 	return err
 }
 
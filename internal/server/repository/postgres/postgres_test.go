@@ -2,7 +2,9 @@ package repository
 
 import (
 	"errors"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/gonozov0/go-musthave-devops/internal/server"
 
@@ -10,6 +12,7 @@ import (
 	"github.com/stretchr/testify/suite"
 
 	"github.com/gonozov0/go-musthave-devops/internal/server/repository"
+	"github.com/gonozov0/go-musthave-devops/internal/shared"
 )
 
 type PGRepositorySuite struct {
@@ -105,16 +108,158 @@ func (s *PGRepositorySuite) TestUpdateAndGetAllGauges() {
 
 	actualGauges, err := s.repo.UpdateGauges(expectedGauges)
 	require.NoError(s.T(), err)
-	require.Equal(s.T(), expectedGauges, actualGauges)
+	require.ElementsMatch(s.T(), expectedGauges, withoutTimestamps(actualGauges))
 
 	actualGauges, err = s.repo.GetAllGauges()
 	require.NoError(s.T(), err)
 	require.Equal(s.T(), len(expectedGauges), len(actualGauges))
-	for _, actualGauge := range actualGauges {
+	for _, actualGauge := range withoutTimestamps(actualGauges) {
 		require.Contains(s.T(), expectedGauges, actualGauge)
 	}
 }
 
+func (s *PGRepositorySuite) TestStaleGaugeSample() {
+	metricName := "test_stale_gauge"
+	defer func() {
+		s.repo.DeleteGauge(metricName)
+	}()
+	now := time.Now()
+
+	_, err := s.repo.UpdateGauges([]repository.GaugeMetric{{Name: metricName, Value: 2, Timestamp: now}})
+	require.NoError(s.T(), err)
+
+	actualGauges, err := s.repo.UpdateGauges([]repository.GaugeMetric{
+		{Name: metricName, Value: 1, Timestamp: now.Add(-time.Minute)},
+	})
+	require.NoError(s.T(), err)
+	require.Len(s.T(), actualGauges, 1)
+	require.Equal(s.T(), 2., actualGauges[0].Value)
+	require.WithinDuration(s.T(), now, actualGauges[0].Timestamp, time.Millisecond)
+
+	fetchedValue, err := s.repo.GetGauge(metricName)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), 2., fetchedValue)
+}
+
+func (s *PGRepositorySuite) TestUpdateGaugeTimestamp() {
+	metricName := "test_gauge_timestamp"
+	defer func() {
+		s.repo.DeleteGauge(metricName)
+	}()
+
+	before := time.Now()
+	_, err := s.repo.UpdateGauge(metricName, 2)
+	require.NoError(s.T(), err)
+	after := time.Now()
+
+	// the stale sample returns the stored one, which is stamped by the server clock
+	actualGauges, err := s.repo.UpdateGauges([]repository.GaugeMetric{
+		{Name: metricName, Value: 1, Timestamp: before.Add(-time.Minute)},
+	})
+	require.NoError(s.T(), err)
+	require.Len(s.T(), actualGauges, 1)
+	require.Equal(s.T(), 2., actualGauges[0].Value)
+	require.False(s.T(), actualGauges[0].Timestamp.Before(before.Truncate(time.Microsecond)))
+	require.False(s.T(), actualGauges[0].Timestamp.After(after))
+}
+
+func (s *PGRepositorySuite) TestHistory() {
+	metricName := "test_history"
+	defer func() {
+		s.repo.DeleteGauge(metricName)
+		s.repo.DeleteCounter(metricName)
+	}()
+	history := s.repo.(repository.HistoryRepository)
+	now := time.Now().Truncate(time.Microsecond)
+
+	_, err := s.repo.UpdateGauges([]repository.GaugeMetric{
+		{Name: metricName, Value: 2, Timestamp: now},
+		{Name: metricName, Value: 1, Timestamp: now.Add(-time.Minute)},
+	})
+	require.NoError(s.T(), err)
+	_, err = s.repo.UpdateCounters([]repository.CounterMetric{{Name: metricName, Value: 3}, {Name: metricName, Value: 4}})
+	require.NoError(s.T(), err)
+
+	points, err := history.GetHistory(shared.Gauge, metricName, now.Add(-time.Hour), now)
+	require.NoError(s.T(), err)
+	require.Len(s.T(), points, 2)
+	require.Equal(s.T(), 1., points[0].Value)
+	require.Equal(s.T(), 2., points[1].Value)
+
+	points, err = history.GetHistory(shared.Counter, metricName, now.Add(-time.Hour), time.Now())
+	require.NoError(s.T(), err)
+	require.Len(s.T(), points, 2)
+	require.Equal(s.T(), 7., points[0].Value+points[1].Value)
+}
+
+func (s *PGRepositorySuite) TestRollupHistory() {
+	metricName := "test_rollup_history"
+	defer func() {
+		s.repo.DeleteGauge(metricName)
+	}()
+	history := s.repo.(repository.HistoryRepository)
+	now := time.Now()
+	minute := repository.BucketStart(now, time.Minute)
+
+	_, err := s.repo.UpdateGauges([]repository.GaugeMetric{
+		{Name: metricName, Value: 1, Timestamp: minute},
+		{Name: metricName, Value: 3, Timestamp: minute.Add(time.Second)},
+	})
+	require.NoError(s.T(), err)
+	require.NoError(s.T(), history.RollupHistory(now.Add(3*time.Minute), 0, nil))
+
+	checkpoint, err := s.repo.(repository.TierReader).RollupCheckpoint(time.Minute)
+	require.NoError(s.T(), err)
+	require.True(s.T(), checkpoint.After(minute))
+
+	aggregates, err := history.GetHistoryAggregates(shared.Gauge, metricName, minute, minute.Add(59*time.Second), time.Minute)
+	require.NoError(s.T(), err)
+	require.Len(s.T(), aggregates, 1)
+	require.Equal(s.T(), 2., aggregates[0].Value)
+	require.Equal(s.T(), 3., aggregates[0].Last)
+	require.Equal(s.T(), int64(2), aggregates[0].Count)
+}
+
+func (s *PGRepositorySuite) TestSeriesHistory() {
+	names := []string{"test_series_history_1", "test_series_history_2"}
+	defer func() {
+		for _, name := range names {
+			s.repo.DeleteGauge(name)
+		}
+	}()
+	reader := s.repo.(repository.SeriesHistoryReader)
+	now := time.Now()
+	minute := repository.BucketStart(now, time.Minute)
+
+	_, err := s.repo.UpdateGauges([]repository.GaugeMetric{
+		{Name: names[0], Value: 1, Timestamp: minute},
+		{Name: names[1], Value: 2, Timestamp: minute.Add(time.Second)},
+		{Name: names[1], Value: 3, Timestamp: minute.Add(2 * time.Second)},
+	})
+	require.NoError(s.T(), err)
+
+	history, err := reader.GetSeriesHistory(shared.Gauge, names, minute, now)
+	require.NoError(s.T(), err)
+	require.Len(s.T(), history[names[0]], 1)
+	require.Len(s.T(), history[names[1]], 2)
+	require.Equal(s.T(), 3., history[names[1]][1].Value)
+
+	require.NoError(s.T(), s.repo.(repository.HistoryRepository).RollupHistory(now.Add(3*time.Minute), 0, nil))
+	rollups, err := reader.GetSeriesRollups(shared.Gauge, names, time.Minute, minute, minute.Add(time.Minute))
+	require.NoError(s.T(), err)
+	require.Len(s.T(), rollups[names[1]], 1)
+	require.Equal(s.T(), 3., rollups[names[1]][0].Last)
+	require.Equal(s.T(), int64(2), rollups[names[1]][0].Count)
+}
+
+func withoutTimestamps(gauges []repository.GaugeMetric) []repository.GaugeMetric {
+	result := make([]repository.GaugeMetric, 0, len(gauges))
+	for _, gauge := range gauges {
+		result = append(result, repository.GaugeMetric{Name: gauge.Name, Value: gauge.Value})
+	}
+	return result
+}
+
 func (s *PGRepositorySuite) TestGetCounterMetricNotFound() {
 	_, err := s.repo.GetCounter("non_existing_counter")
 	require.True(s.T(), errors.Is(err, repository.ErrMetricNotFound))
@@ -142,3 +287,120 @@ func (s *PGRepositorySuite) TestUpdateAndGetAllCounters() {
 		require.Contains(s.T(), expectedCounters, actualCounter)
 	}
 }
+
+func (s *PGRepositorySuite) TestObserveHistogram() {
+	metricName := "test_observed_histogram"
+	defer func() {
+		s.repo.DeleteHistogram(metricName)
+	}()
+
+	actual, err := s.repo.ObserveHistogram(metricName, 0.3, nil)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), shared.NewHistogram(shared.DefaultHistogramBounds, 0.3), actual)
+
+	_, err = s.repo.ObserveHistogram(metricName, 2, []float64{1, 5})
+	require.NoError(s.T(), err)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := s.repo.ObserveHistogram(metricName, 7, nil)
+			require.NoError(s.T(), err)
+		}()
+	}
+	wg.Wait()
+
+	fetched, err := s.repo.GetHistogram(metricName)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), shared.HistogramValue{Bounds: []float64{1, 5}, Counts: []uint64{0, 1, 10}, Sum: 72, Count: 11}, fetched)
+}
+
+func (s *PGRepositorySuite) TestUpdateAndGetHistogram() {
+	metricName := "test_histogram"
+	defer func() {
+		s.repo.DeleteHistogram(metricName)
+	}()
+	bounds := []float64{1, 5}
+
+	_, err := s.repo.UpdateHistograms([]repository.HistogramMetric{
+		{Name: metricName, Value: shared.NewHistogram(bounds, 0.5)},
+	})
+	require.NoError(s.T(), err)
+	actual, err := s.repo.UpdateHistograms([]repository.HistogramMetric{
+		{Name: metricName, Value: shared.NewHistogram(bounds, 3)},
+		{Name: metricName, Value: shared.NewHistogram(bounds, 7)},
+	})
+	require.NoError(s.T(), err)
+	expected := shared.HistogramValue{Bounds: bounds, Counts: []uint64{1, 1, 1}, Sum: 10.5, Count: 3}
+	require.Equal(s.T(), []repository.HistogramMetric{{Name: metricName, Value: expected}}, actual)
+
+	fetched, err := s.repo.GetHistogram(metricName)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), expected, fetched)
+
+	// other bounds reset the histogram
+	reset := shared.NewHistogram([]float64{10}, 2)
+	actual, err = s.repo.UpdateHistograms([]repository.HistogramMetric{{Name: metricName, Value: reset}})
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), []repository.HistogramMetric{{Name: metricName, Value: reset}}, actual)
+
+	require.NoError(s.T(), s.repo.DeleteHistogram(metricName))
+	_, err = s.repo.GetHistogram(metricName)
+	require.ErrorIs(s.T(), err, repository.ErrMetricNotFound)
+}
+
+func (s *PGRepositorySuite) TestUpdateAndGetSet() {
+	metricName := "test_set"
+	defer func() {
+		s.repo.DeleteSet(metricName)
+	}()
+
+	actual, err := s.repo.UpdateSets([]repository.SetMetric{
+		{Name: metricName, Members: []string{"alice", "bob"}},
+		{Name: metricName, Members: []string{"bob", "carol"}},
+	})
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), []repository.SetMetric{{Name: metricName, Cardinality: 3}}, actual)
+
+	actual, err = s.repo.UpdateSets([]repository.SetMetric{{Name: metricName, Members: []string{"alice", "dave"}}})
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), []repository.SetMetric{{Name: metricName, Cardinality: 4}}, actual)
+
+	cardinality, err := s.repo.GetSet(metricName)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), uint64(4), cardinality)
+
+	require.NoError(s.T(), s.repo.DeleteSet(metricName))
+	_, err = s.repo.GetSet(metricName)
+	require.ErrorIs(s.T(), err, repository.ErrMetricNotFound)
+}
+
+func (s *PGRepositorySuite) TestSilences() {
+	silenceRepo := s.repo.(repository.SilenceRepository)
+	isEqual := false
+	silence := repository.Silence{
+		ID:        "test_silence",
+		Matchers:  []repository.SilenceMatcher{{Name: "alertname", Value: "High.*", IsRegex: true, IsEqual: &isEqual}},
+		StartsAt:  time.Unix(1700000000, 0).UTC(),
+		EndsAt:    time.Unix(1700003600, 0).UTC(),
+		CreatedBy: "ops",
+	}
+	defer func() {
+		silenceRepo.DeleteSilence(silence.ID)
+	}()
+
+	require.NoError(s.T(), silenceRepo.SaveSilence(silence))
+	silence.Comment = "maintenance"
+	require.NoError(s.T(), silenceRepo.SaveSilence(silence))
+
+	silences, err := silenceRepo.GetSilences()
+	require.NoError(s.T(), err)
+	require.Len(s.T(), silences, 1)
+	require.True(s.T(), silence.EndsAt.Equal(silences[0].EndsAt))
+	silences[0].StartsAt, silences[0].EndsAt = silence.StartsAt, silence.EndsAt
+	require.Equal(s.T(), silence, silences[0])
+
+	require.NoError(s.T(), silenceRepo.DeleteSilence(silence.ID))
+	require.ErrorIs(s.T(), silenceRepo.DeleteSilence(silence.ID), repository.ErrSilenceNotFound)
+}
@@ -0,0 +1,160 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/gonozov0/go-musthave-devops/internal/server/profiles"
+)
+
+type pgProfileStore struct {
+	db        *sqlx.DB
+	retention profiles.Retention
+}
+
+type profileRow struct {
+	ID        int64     `db:"id"`
+	Source    string    `db:"source"`
+	Type      string    `db:"type"`
+	CreatedAt time.Time `db:"created_at"`
+	Size      int64     `db:"size"`
+}
+
+func (r profileRow) toProfile() profiles.Profile {
+	return profiles.Profile{
+		ID:        strconv.FormatInt(r.ID, 10),
+		Source:    r.Source,
+		Type:      r.Type,
+		Timestamp: r.CreatedAt,
+		Size:      r.Size,
+	}
+}
+
+// NewPGProfileStore creates a profile store keeping profiles in the profiles table.
+func NewPGProfileStore(connectionString string, retention profiles.Retention) (profiles.Store, error) {
+	db, err := connect(connectionString)
+	if err != nil {
+		return nil, err
+	}
+	return &pgProfileStore{db: db, retention: retention}, nil
+}
+
+func (s *pgProfileStore) Save(profile profiles.Profile, data []byte) (profiles.Profile, error) {
+	if err := profiles.Validate(profile); err != nil {
+		return profiles.Profile{}, err
+	}
+
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return profiles.Profile{}, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	var id int64
+	err = tx.QueryRow(
+		`INSERT INTO profiles(source, type, created_at, data) VALUES ($1, $2, $3, $4) RETURNING id`,
+		profile.Source, profile.Type, profile.Timestamp, data,
+	).Scan(&id)
+	if err != nil {
+		return profiles.Profile{}, fmt.Errorf("failed to insert profile: %w", err)
+	}
+
+	if s.retention.MaxAge > 0 {
+		_, err = tx.Exec(`DELETE FROM profiles WHERE created_at < $1`, time.Now().Add(-s.retention.MaxAge))
+		if err != nil {
+			return profiles.Profile{}, fmt.Errorf("failed to delete expired profiles: %w", err)
+		}
+	}
+	if s.retention.MaxBytes > 0 {
+		_, err = tx.Exec(`
+			DELETE FROM profiles WHERE id IN (
+				SELECT id FROM (
+					SELECT id, sum(octet_length(data)) OVER (ORDER BY created_at DESC, id DESC) AS total
+					FROM profiles
+				) sizes
+				WHERE total > $1
+			)`,
+			s.retention.MaxBytes,
+		)
+		if err != nil {
+			return profiles.Profile{}, fmt.Errorf("failed to delete oldest profiles: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return profiles.Profile{}, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	profile.ID = strconv.FormatInt(id, 10)
+	profile.Size = int64(len(data))
+	return profile, nil
+}
+
+func (s *pgProfileStore) List(filter profiles.Filter) ([]profiles.Profile, error) {
+	var (
+		conditions []string
+		args       []interface{}
+	)
+	addCondition := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+	if filter.Source != "" {
+		addCondition("source = $%d", filter.Source)
+	}
+	if filter.Type != "" {
+		addCondition("type = $%d", filter.Type)
+	}
+	if !filter.From.IsZero() {
+		addCondition("created_at >= $%d", filter.From)
+	}
+	if !filter.To.IsZero() {
+		addCondition("created_at <= $%d", filter.To)
+	}
+
+	query := `SELECT id, source, type, created_at, octet_length(data) AS size FROM profiles`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY created_at DESC, id DESC"
+
+	var rows []profileRow
+	if err := s.db.Select(&rows, query, args...); err != nil {
+		return nil, fmt.Errorf("failed to select profiles: %w", err)
+	}
+	result := make([]profiles.Profile, 0, len(rows))
+	for _, row := range rows {
+		result = append(result, row.toProfile())
+	}
+	return result, nil
+}
+
+func (s *pgProfileStore) Get(id string) (profiles.Profile, []byte, error) {
+	parsedID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return profiles.Profile{}, nil, profiles.ErrProfileNotFound
+	}
+
+	var row struct {
+		profileRow
+		Data []byte `db:"data"`
+	}
+	err = s.db.Get(
+		&row,
+		`SELECT id, source, type, created_at, octet_length(data) AS size, data FROM profiles WHERE id = $1`,
+		parsedID,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return profiles.Profile{}, nil, profiles.ErrProfileNotFound
+	}
+	if err != nil {
+		return profiles.Profile{}, nil, fmt.Errorf("failed to select profile: %w", err)
+	}
+	return row.toProfile(), row.Data, nil
+}
@@ -0,0 +1,35 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gonozov0/go-musthave-devops/internal/server/profiles"
+)
+
+func (s *PGRepositorySuite) TestProfileStore() {
+	t := s.T()
+	store, err := NewPGProfileStore(s.connectionString, profiles.Retention{MaxBytes: 10})
+	require.NoError(t, err)
+
+	source := "test-" + time.Now().Format(time.RFC3339Nano)
+	now := time.Now().Truncate(time.Microsecond)
+	first, err := store.Save(profiles.Profile{Source: source, Type: "cpu", Timestamp: now.Add(-time.Minute)}, []byte("12345"))
+	require.NoError(t, err)
+	second, err := store.Save(profiles.Profile{Source: source, Type: "heap", Timestamp: now}, []byte("123456"))
+	require.NoError(t, err)
+
+	list, err := store.List(profiles.Filter{Source: source})
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	require.Equal(t, second.ID, list[0].ID)
+	require.Equal(t, int64(6), list[0].Size)
+
+	_, _, err = store.Get(first.ID)
+	require.ErrorIs(t, err, profiles.ErrProfileNotFound)
+	profile, data, err := store.Get(second.ID)
+	require.NoError(t, err)
+	require.Equal(t, []byte("123456"), data)
+	require.True(t, now.Equal(profile.Timestamp))
+}
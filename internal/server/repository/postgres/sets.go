@@ -0,0 +1,158 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/gonozov0/go-musthave-devops/internal/server/hll"
+	"github.com/gonozov0/go-musthave-devops/internal/server/repository"
+)
+
+type setRow struct {
+	Name   string    `db:"name"`
+	Sketch []byte    `db:"sketch"`
+	Period time.Time `db:"period"`
+}
+
+// cardinality returns the estimate of the stored sketch, zero when it was updated in an earlier period.
+func (row setRow) cardinality(period time.Time) (uint64, error) {
+	if !row.Period.Equal(period) {
+		return 0, nil
+	}
+	sketch := hll.New()
+	if err := sketch.UnmarshalBinary(row.Sketch); err != nil {
+		return 0, fmt.Errorf("set %s: %w", row.Name, err)
+	}
+	return sketch.Estimate(), nil
+}
+
+// period returns the start of the current reset interval. The epoch stands for the sets that are never reset.
+func (r *pgRepository) period() time.Time {
+	period := repository.SetPeriod(time.Now(), r.setResetInterval)
+	if period.IsZero() {
+		return time.Unix(0, 0)
+	}
+	return period
+}
+
+// UpdateSets merges the members into the stored sketches. The sketches can't be merged in SQL,
+// so the rows are created if needed and locked until the merged sketches are written back.
+func (r *pgRepository) UpdateSets(metrics []repository.SetMetric) ([]repository.SetMetric, error) {
+	if len(metrics) == 0 {
+		return make([]repository.SetMetric, 0), nil
+	}
+
+	// Name can be duplicated in slice, so we need to fix it
+	members := make(map[string][]string, len(metrics))
+	for _, metric := range metrics {
+		members[metric.Name] = append(members[metric.Name], metric.Members...)
+	}
+	names := make([]string, 0, len(members))
+	for name := range members {
+		names = append(names, name)
+	}
+	// the rows are locked in the same order by concurrent updates to avoid deadlocks
+	sort.Strings(names)
+
+	period := r.period()
+	empty, err := hll.New().MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	_, err = tx.Exec(
+		`INSERT INTO sets(name, sketch, period)
+		SELECT name, $2, $3 FROM unnest($1::text[]) AS t(name) ORDER BY name
+		ON CONFLICT(name) DO NOTHING`,
+		pq.Array(names), empty, period,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert sets: %w", err)
+	}
+
+	var rows []setRow
+	err = tx.Select(
+		&rows,
+		`SELECT name, sketch, period FROM sets WHERE name = ANY($1) ORDER BY name FOR UPDATE`,
+		pq.Array(names),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select sets: %w", err)
+	}
+
+	updatedMetrics := make([]repository.SetMetric, 0, len(rows))
+	for _, row := range rows {
+		sketch := hll.New()
+		if row.Period.Equal(period) {
+			if err := sketch.UnmarshalBinary(row.Sketch); err != nil {
+				return nil, fmt.Errorf("set %s: %w", row.Name, err)
+			}
+		}
+		for _, member := range members[row.Name] {
+			sketch.Insert(member)
+		}
+		data, err := sketch.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		_, err = tx.Exec(`UPDATE sets SET sketch = $2, period = $3 WHERE name = $1`, row.Name, data, period)
+		if err != nil {
+			return nil, fmt.Errorf("failed to update set: %w", err)
+		}
+		updatedMetrics = append(updatedMetrics, repository.SetMetric{Name: row.Name, Cardinality: sketch.Estimate()})
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	repository.PublishSets(r.publisher, updatedMetrics)
+	return updatedMetrics, nil
+}
+
+func (r *pgRepository) GetSet(name string) (uint64, error) {
+	var row setRow
+	err := r.db.Get(&row, `SELECT name, sketch, period FROM sets WHERE name = $1`, name)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, repository.ErrMetricNotFound
+		}
+		return 0, err
+	}
+	return row.cardinality(r.period())
+}
+
+func (r *pgRepository) GetAllSets() ([]repository.SetMetric, error) {
+	var rows []setRow
+	err := r.db.Select(&rows, `SELECT name, sketch, period FROM sets`)
+	if err != nil {
+		return nil, err
+	}
+	period := r.period()
+	metrics := make([]repository.SetMetric, 0, len(rows))
+	for _, row := range rows {
+		cardinality, err := row.cardinality(period)
+		if err != nil {
+			return nil, err
+		}
+		metrics = append(metrics, repository.SetMetric{Name: row.Name, Cardinality: cardinality})
+	}
+	return metrics, nil
+}
+
+func (r *pgRepository) DeleteSet(name string) error {
+	_, err := r.db.Exec(`DELETE FROM sets WHERE name = $1`, name)
+	return err
+}
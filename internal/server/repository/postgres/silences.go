@@ -0,0 +1,71 @@
+package repository
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gonozov0/go-musthave-devops/internal/server/repository"
+)
+
+type silenceRow struct {
+	ID        string    `db:"id"`
+	Matchers  []byte    `db:"matchers"`
+	StartsAt  time.Time `db:"starts_at"`
+	EndsAt    time.Time `db:"ends_at"`
+	CreatedBy string    `db:"created_by"`
+	Comment   string    `db:"comment"`
+}
+
+func (r *pgRepository) SaveSilence(silence repository.Silence) error {
+	matchers, err := json.Marshal(silence.Matchers)
+	if err != nil {
+		return fmt.Errorf("failed to encode silence matchers: %w", err)
+	}
+	_, err = r.db.Exec(
+		`INSERT INTO silences(id, matchers, starts_at, ends_at, created_by, comment)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT(id) DO UPDATE SET matchers = EXCLUDED.matchers, starts_at = EXCLUDED.starts_at,
+			ends_at = EXCLUDED.ends_at, created_by = EXCLUDED.created_by, comment = EXCLUDED.comment`,
+		silence.ID, matchers, silence.StartsAt, silence.EndsAt, silence.CreatedBy, silence.Comment,
+	)
+	return err
+}
+
+func (r *pgRepository) GetSilences() ([]repository.Silence, error) {
+	var rows []silenceRow
+	err := r.db.Select(&rows, `SELECT id, matchers, starts_at, ends_at, created_by, comment FROM silences ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	silences := make([]repository.Silence, 0, len(rows))
+	for _, row := range rows {
+		silence := repository.Silence{
+			ID:        row.ID,
+			StartsAt:  row.StartsAt,
+			EndsAt:    row.EndsAt,
+			CreatedBy: row.CreatedBy,
+			Comment:   row.Comment,
+		}
+		if err := json.Unmarshal(row.Matchers, &silence.Matchers); err != nil {
+			return nil, fmt.Errorf("silence %s: failed to decode matchers: %w", row.ID, err)
+		}
+		silences = append(silences, silence)
+	}
+	return silences, nil
+}
+
+func (r *pgRepository) DeleteSilence(id string) error {
+	result, err := r.db.Exec(`DELETE FROM silences WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return repository.ErrSilenceNotFound
+	}
+	return nil
+}
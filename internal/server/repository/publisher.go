@@ -0,0 +1,66 @@
+package repository
+
+import "github.com/gonozov0/go-musthave-devops/internal/shared"
+
+// Publisher receives the metrics stored by the successful updates: the gauge values, the counter totals,
+// the merged histograms and the set cardinalities. It's called after every update, so it must not block.
+type Publisher interface {
+	Publish(metrics []shared.Metric)
+}
+
+// PublishGauges publishes the stored gauges, the publisher may be nil.
+func PublishGauges(publisher Publisher, metrics []GaugeMetric) {
+	if publisher == nil || len(metrics) == 0 {
+		return
+	}
+	published := make([]shared.Metric, 0, len(metrics))
+	for _, metric := range metrics {
+		value := metric.Value
+		m := shared.Metric{ID: metric.Name, MType: shared.Gauge, Value: &value}
+		if !metric.Timestamp.IsZero() {
+			timestamp := metric.Timestamp.UnixMilli()
+			m.Timestamp = &timestamp
+		}
+		published = append(published, m)
+	}
+	publisher.Publish(published)
+}
+
+// PublishCounters publishes the stored counter totals, the publisher may be nil.
+func PublishCounters(publisher Publisher, metrics []CounterMetric) {
+	if publisher == nil || len(metrics) == 0 {
+		return
+	}
+	published := make([]shared.Metric, 0, len(metrics))
+	for _, metric := range metrics {
+		delta := metric.Value
+		published = append(published, shared.Metric{ID: metric.Name, MType: shared.Counter, Delta: &delta})
+	}
+	publisher.Publish(published)
+}
+
+// PublishHistograms publishes the merged histograms, the publisher may be nil.
+func PublishHistograms(publisher Publisher, metrics []HistogramMetric) {
+	if publisher == nil || len(metrics) == 0 {
+		return
+	}
+	published := make([]shared.Metric, 0, len(metrics))
+	for _, metric := range metrics {
+		value := metric.Value
+		published = append(published, shared.Metric{ID: metric.Name, MType: shared.Histogram, Histogram: &value})
+	}
+	publisher.Publish(published)
+}
+
+// PublishSets publishes the estimated set cardinalities, the publisher may be nil.
+func PublishSets(publisher Publisher, metrics []SetMetric) {
+	if publisher == nil || len(metrics) == 0 {
+		return
+	}
+	published := make([]shared.Metric, 0, len(metrics))
+	for _, metric := range metrics {
+		cardinality := metric.Cardinality
+		published = append(published, shared.Metric{ID: metric.Name, MType: shared.Set, Cardinality: &cardinality})
+	}
+	publisher.Publish(published)
+}
@@ -0,0 +1,13 @@
+package repository
+
+import "time"
+
+// SetPeriod returns the start of the period containing the time for sets reset every interval.
+// It's the zero time when the interval is zero and the sets are never reset.
+// A set updated in an earlier period is empty in the current one.
+func SetPeriod(now time.Time, interval time.Duration) time.Time {
+	if interval <= 0 {
+		return time.Time{}
+	}
+	return BucketStart(now, interval)
+}
@@ -0,0 +1,31 @@
+package repository
+
+import "time"
+
+// Silence mutes the alerts matching all matchers from StartsAt until EndsAt.
+type Silence struct {
+	ID        string           `json:"id"`
+	Matchers  []SilenceMatcher `json:"matchers"`
+	StartsAt  time.Time        `json:"startsAt"`
+	EndsAt    time.Time        `json:"endsAt"`
+	CreatedBy string           `json:"createdBy,omitempty"`
+	Comment   string           `json:"comment,omitempty"`
+}
+
+// SilenceMatcher matches the value of an alert label like the matchers of the Alertmanager API.
+type SilenceMatcher struct {
+	Name    string `json:"name"`
+	Value   string `json:"value"`
+	IsRegex bool   `json:"isRegex"`
+	IsEqual *bool  `json:"isEqual,omitempty"` // true when missing
+}
+
+// SilenceRepository is implemented by repositories storing the alert silences next to the metrics.
+type SilenceRepository interface {
+	// SaveSilence creates or replaces the silence with the ID.
+	SaveSilence(silence Silence) error
+	// GetSilences returns all stored silences ordered by ID.
+	GetSilences() ([]Silence, error)
+	// DeleteSilence deletes the silence, it returns ErrSilenceNotFound when it doesn't exist.
+	DeleteSilence(id string) error
+}
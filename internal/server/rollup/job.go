@@ -0,0 +1,66 @@
+package rollup
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/gonozov0/go-musthave-devops/internal/server/repository"
+)
+
+// Job periodically downsamples the metric history into the rollup tiers and applies their retention.
+type Job struct {
+	repo      repository.HistoryRepository
+	interval  time.Duration
+	retention repository.RollupRetention
+	delay     time.Duration
+}
+
+// Option configures the Job.
+type Option func(*Job)
+
+// WithDelay rolls up the history only up to the delay ago, so the samples with past timestamps
+// accepted up to the delay late still get into their buckets. It should be the max sample age.
+func WithDelay(delay time.Duration) Option {
+	return func(j *Job) {
+		j.delay = delay
+	}
+}
+
+// NewJob creates a new Job.
+func NewJob(
+	repo repository.HistoryRepository,
+	interval time.Duration,
+	retention repository.RollupRetention,
+	opts ...Option,
+) *Job {
+	j := &Job{
+		repo:      repo,
+		interval:  interval,
+		retention: retention,
+	}
+	for _, opt := range opts {
+		opt(j)
+	}
+	return j
+}
+
+// Run rolls up the history immediately and then every interval until the context is canceled.
+func (j *Job) Run(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+	for {
+		if err := j.repo.RollupHistory(time.Now(), j.delay, j.retention); err != nil {
+			log.Errorf("Could not roll up metric history: %s", err.Error())
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
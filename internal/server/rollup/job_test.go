@@ -0,0 +1,62 @@
+package rollup
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gonozov0/go-musthave-devops/internal/server/repository"
+)
+
+type rollupCall struct {
+	now       time.Time
+	delay     time.Duration
+	retention repository.RollupRetention
+}
+
+type fakeHistory struct {
+	repository.HistoryRepository
+
+	mu    sync.Mutex
+	calls []rollupCall
+}
+
+func (h *fakeHistory) RollupHistory(now time.Time, delay time.Duration, retention repository.RollupRetention) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.calls = append(h.calls, rollupCall{now: now, delay: delay, retention: retention})
+	return nil
+}
+
+func (h *fakeHistory) rollups() []rollupCall {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]rollupCall(nil), h.calls...)
+}
+
+func TestJob(t *testing.T) {
+	history := &fakeHistory{}
+	retention := repository.RollupRetention{time.Minute: time.Hour}
+	job := NewJob(history, 10*time.Millisecond, retention, WithDelay(time.Hour))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	start := time.Now()
+	go job.Run(ctx, wg)
+
+	require.Eventually(t, func() bool { return len(history.rollups()) >= 2 }, time.Second, 5*time.Millisecond)
+	cancel()
+	wg.Wait()
+	stopped := len(history.rollups())
+	time.Sleep(30 * time.Millisecond)
+	require.Len(t, history.rollups(), stopped, "the job must stop with the context")
+
+	call := history.rollups()[0]
+	require.False(t, call.now.Before(start), "the retention must be applied at the current time")
+	require.Equal(t, time.Hour, call.delay)
+	require.Equal(t, retention, call.retention)
+}
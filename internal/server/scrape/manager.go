@@ -0,0 +1,256 @@
+package scrape
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/gonozov0/go-musthave-devops/internal/server/repository"
+	"github.com/gonozov0/go-musthave-devops/internal/shared"
+)
+
+// Target health values.
+const (
+	HealthUnknown = "unknown"
+	HealthUp      = "up"
+	HealthDown    = "down"
+)
+
+// Target is a struct that represents a scraped endpoint.
+type Target struct {
+	URL    string            `json:"url"`
+	Labels map[string]string `json:"labels,omitempty"` // attached to every scraped metric
+	Source string            `json:"source"`           // where the target comes from: "static" or a file path
+}
+
+// TargetStatus is a struct that represents the result of the last scrape of a target.
+type TargetStatus struct {
+	Target
+	Health         string        `json:"health"`
+	LastScrape     time.Time     `json:"last_scrape"`
+	LastDuration   time.Duration `json:"last_duration"`
+	LastError      string        `json:"last_error,omitempty"`
+	ScrapedMetrics int           `json:"scraped_metrics"`
+}
+
+// Manager periodically pulls metrics from the targets into the repository.
+type Manager struct {
+	repo     repository.Repository
+	client   *http.Client
+	interval time.Duration
+
+	mu      sync.Mutex
+	ctx     context.Context
+	wg      sync.WaitGroup
+	targets map[string]*target
+}
+
+type target struct {
+	cancel context.CancelFunc
+
+	mu       sync.RWMutex
+	status   TargetStatus
+	counters map[string]int64 // last seen counter totals
+}
+
+// NewManager creates a new Manager. Scrapes don't start until Run is called.
+func NewManager(repo repository.Repository, interval, timeout time.Duration) *Manager {
+	return &Manager{
+		repo:     repo,
+		client:   &http.Client{Timeout: timeout},
+		interval: interval,
+		targets:  make(map[string]*target),
+	}
+}
+
+// Run starts scraping the current targets and blocks until the context is canceled.
+func (m *Manager) Run(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	m.mu.Lock()
+	m.ctx = ctx
+	for url, t := range m.targets {
+		m.startTarget(url, t)
+	}
+	m.mu.Unlock()
+
+	<-ctx.Done()
+	m.wg.Wait()
+}
+
+// SetTargets replaces the list of scraped targets. Targets are identified by URL.
+// Scrapes of new targets start immediately, scrapes of removed targets stop
+// and targets that are still present keep their state with updated labels.
+func (m *Manager) SetTargets(targets []Target) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	wanted := make(map[string]struct{}, len(targets))
+	for _, newTarget := range targets {
+		url := newTarget.URL
+		wanted[url] = struct{}{}
+		if t, ok := m.targets[url]; ok {
+			t.mu.Lock()
+			t.status.Target = newTarget
+			t.mu.Unlock()
+			continue
+		}
+		t := &target{
+			status:   TargetStatus{Target: newTarget, Health: HealthUnknown},
+			counters: make(map[string]int64),
+		}
+		m.targets[url] = t
+		if m.ctx != nil {
+			m.startTarget(url, t)
+		}
+	}
+
+	for url, t := range m.targets {
+		if _, ok := wanted[url]; ok {
+			continue
+		}
+		if t.cancel != nil {
+			t.cancel()
+		}
+		delete(m.targets, url)
+	}
+}
+
+// Targets returns the statuses of all targets sorted by URL.
+func (m *Manager) Targets() []TargetStatus {
+	m.mu.Lock()
+	statuses := make([]TargetStatus, 0, len(m.targets))
+	for _, t := range m.targets {
+		t.mu.RLock()
+		statuses = append(statuses, t.status)
+		t.mu.RUnlock()
+	}
+	m.mu.Unlock()
+
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].URL < statuses[j].URL })
+	return statuses
+}
+
+// startTarget starts the scrape loop of the target. It must be called with m.mu held.
+func (m *Manager) startTarget(url string, t *target) {
+	ctx, cancel := context.WithCancel(m.ctx)
+	t.cancel = cancel
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		ticker := time.NewTicker(m.interval)
+		defer ticker.Stop()
+		for {
+			m.scrape(ctx, url, t)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (m *Manager) scrape(ctx context.Context, url string, t *target) {
+	start := time.Now()
+	count, err := m.scrapeTarget(ctx, url, t)
+	duration := time.Since(start)
+	if ctx.Err() != nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	prevHealth := t.status.Health
+	t.status.LastScrape = start
+	t.status.LastDuration = duration
+	t.status.ScrapedMetrics = count
+	if err != nil {
+		t.status.Health = HealthDown
+		t.status.LastError = err.Error()
+	} else {
+		t.status.Health = HealthUp
+		t.status.LastError = ""
+	}
+	if prevHealth != t.status.Health {
+		log.Infof("Scrape target %s is %s", url, t.status.Health)
+	}
+}
+
+// scrapeTarget pulls the metrics of the target and saves them to the repository.
+// Targets expose counter totals, so the difference with the previous scrape is saved as delta.
+// The first scrape of a counter only records its total, a total lower than the previous one
+// means the target restarted and is saved as is.
+func (m *Manager) scrapeTarget(ctx context.Context, url string, t *target) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	r, err := m.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(r.Body)
+		return 0, fmt.Errorf("received non-OK response: %d, error: %s", r.StatusCode, string(body))
+	}
+
+	var metrics []shared.Metric
+	if err := json.NewDecoder(r.Body).Decode(&metrics); err != nil {
+		return 0, fmt.Errorf("failed to decode metrics: %w", err)
+	}
+
+	gauges := make([]repository.GaugeMetric, 0, len(metrics))
+	counters := make([]repository.CounterMetric, 0, len(metrics))
+	totals := make(map[string]int64)
+	t.mu.RLock()
+	labels := t.status.Labels
+	for _, metric := range metrics {
+		name := shared.WithLabels(metric.ID, labels)
+		switch {
+		case metric.MType == shared.Gauge && metric.Value != nil:
+			gauges = append(gauges, repository.GaugeMetric{Name: name, Value: *metric.Value})
+		case metric.MType == shared.Counter && metric.Delta != nil:
+			total := *metric.Delta
+			totals[metric.ID] = total
+			last, ok := t.counters[metric.ID]
+			if !ok {
+				continue // the baseline, the total was counted before the server started or saw the target
+			}
+			delta := total
+			if total >= last {
+				delta = total - last
+			}
+			if delta != 0 {
+				counters = append(counters, repository.CounterMetric{Name: name, Value: delta})
+			}
+		}
+	}
+	t.mu.RUnlock()
+
+	if _, err := m.repo.UpdateGauges(gauges); err != nil {
+		return 0, fmt.Errorf("failed to update gauges: %w", err)
+	}
+	if _, err := m.repo.UpdateCounters(counters); err != nil {
+		return 0, fmt.Errorf("failed to update counters: %w", err)
+	}
+
+	t.mu.Lock()
+	for name, total := range totals {
+		t.counters[name] = total
+	}
+	t.mu.Unlock()
+
+	return len(metrics), nil
+}
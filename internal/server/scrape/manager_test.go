@@ -0,0 +1,123 @@
+package scrape
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	repository "github.com/gonozov0/go-musthave-devops/internal/server/repository/in_memory"
+	"github.com/gonozov0/go-musthave-devops/internal/shared"
+)
+
+func TestManager(t *testing.T) {
+	var scrapes atomic.Int64
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "application/json", r.Header.Get("Accept"))
+		n := scrapes.Add(1)
+		gauge := float64(n)
+		total := 10 * n
+		_ = json.NewEncoder(w).Encode([]shared.Metric{
+			{ID: "Alloc", MType: shared.Gauge, Value: &gauge},
+			{ID: "PollCount", MType: shared.Counter, Delta: &total},
+		})
+	}))
+	defer agent.Close()
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "broken", http.StatusInternalServerError)
+	}))
+	defer broken.Close()
+
+	repo := repository.NewInMemoryRepository()
+	manager := NewManager(repo, 10*time.Millisecond, time.Second)
+	manager.SetTargets([]Target{{URL: agent.URL, Source: "static"}, {URL: broken.URL, Source: "static"}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go manager.Run(ctx, wg)
+
+	require.Eventually(t, func() bool { return scrapes.Load() >= 3 }, time.Second, 5*time.Millisecond)
+	manager.SetTargets([]Target{{URL: broken.URL, Source: "static"}})
+	stopped := scrapes.Load()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	wg.Wait()
+
+	require.Equal(t, stopped, scrapes.Load(), "removed target must not be scraped")
+	counter, err := repo.GetCounter("PollCount")
+	require.NoError(t, err)
+	require.Equal(t, 10*(stopped-1), counter, "counter totals must be converted to deltas")
+	gauge, err := repo.GetGauge("Alloc")
+	require.NoError(t, err)
+	require.Equal(t, float64(stopped), gauge)
+
+	statuses := manager.Targets()
+	require.Len(t, statuses, 1)
+	require.Equal(t, broken.URL, statuses[0].URL)
+	require.Equal(t, HealthDown, statuses[0].Health)
+	require.Contains(t, statuses[0].LastError, "500")
+}
+
+func TestManagerRestart(t *testing.T) {
+	var scrapes atomic.Int64
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		total := 1000 + 5*scrapes.Add(1) // the agent counted before the server started
+		_ = json.NewEncoder(w).Encode([]shared.Metric{{ID: "PollCount", MType: shared.Counter, Delta: &total}})
+	}))
+	defer agent.Close()
+
+	repo := repository.NewInMemoryRepository()
+	scrape := func(manager *Manager, tg *target, times int) {
+		for i := 0; i < times; i++ {
+			_, err := manager.scrapeTarget(context.Background(), agent.URL, tg)
+			require.NoError(t, err)
+		}
+	}
+
+	scrape(NewManager(repo, time.Hour, time.Second), &target{counters: make(map[string]int64)}, 3)
+	counter, err := repo.GetCounter("PollCount")
+	require.NoError(t, err)
+	require.Equal(t, int64(10), counter, "the first scraped total must be a baseline")
+
+	// the restarted server doesn't know the totals it has already counted
+	scrape(NewManager(repo, time.Hour, time.Second), &target{counters: make(map[string]int64)}, 2)
+	counter, err = repo.GetCounter("PollCount")
+	require.NoError(t, err)
+	require.Equal(t, int64(15), counter, "the first scraped total after a restart must be a baseline")
+}
+
+func TestManagerLabels(t *testing.T) {
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gauge := 1.5
+		_ = json.NewEncoder(w).Encode([]shared.Metric{{ID: "Alloc", MType: shared.Gauge, Value: &gauge}})
+	}))
+	defer agent.Close()
+
+	repo := repository.NewInMemoryRepository()
+	manager := NewManager(repo, time.Hour, time.Second)
+	manager.SetTargets([]Target{{URL: agent.URL, Labels: map[string]string{"env": "prod"}}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go manager.Run(ctx, wg)
+
+	require.Eventually(t, func() bool {
+		_, err := repo.GetGauge(`Alloc{env="prod"}`)
+		return err == nil
+	}, time.Second, 5*time.Millisecond)
+	cancel()
+	wg.Wait()
+
+	statuses := manager.Targets()
+	require.Len(t, statuses, 1)
+	require.Equal(t, HealthUp, statuses[0].Health)
+	require.Equal(t, 1, statuses[0].ScrapedMetrics)
+}
@@ -0,0 +1,78 @@
+// Package statsd receives StatsD packets and writes the metrics aggregated over the flush interval.
+package statsd
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Sample types of the StatsD protocol.
+const (
+	TypeCounter = "c"
+	TypeGauge   = "g"
+	TypeTimer   = "ms"
+	// TypeHistogram and TypeDistribution are aggregated like timers.
+	TypeHistogram    = "h"
+	TypeDistribution = "d"
+)
+
+// Sample is a parsed StatsD line. Tags of the DogStatsD format (|#tag:value,...) become labels.
+type Sample struct {
+	Name       string
+	Type       string
+	Value      float64
+	Relative   bool // the gauge value is added to the current one, it has the + or - sign
+	SampleRate float64
+	Tags       map[string]string
+}
+
+// ParseLine parses the "name:value|type[|@rate][|#tags]" line.
+func ParseLine(line string) (Sample, error) {
+	name, rest, ok := strings.Cut(line, ":")
+	if !ok || name == "" {
+		return Sample{}, errors.New("missing name")
+	}
+	parts := strings.Split(rest, "|")
+	if len(parts) < 2 {
+		return Sample{}, errors.New("missing type")
+	}
+
+	sample := Sample{Name: name, Type: parts[1], SampleRate: 1}
+	switch sample.Type {
+	case TypeCounter, TypeGauge, TypeTimer, TypeHistogram, TypeDistribution:
+	default:
+		return Sample{}, fmt.Errorf("unsupported type %q", sample.Type)
+	}
+	value, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return Sample{}, fmt.Errorf("invalid value %q", parts[0])
+	}
+	sample.Value = value
+	sample.Relative = sample.Type == TypeGauge && (strings.HasPrefix(parts[0], "+") || strings.HasPrefix(parts[0], "-"))
+
+	for _, part := range parts[2:] {
+		switch {
+		case strings.HasPrefix(part, "@"):
+			rate, err := strconv.ParseFloat(part[1:], 64)
+			if err != nil || rate <= 0 || rate > 1 {
+				return Sample{}, fmt.Errorf("invalid sample rate %q", part)
+			}
+			sample.SampleRate = rate
+		case strings.HasPrefix(part, "#"):
+			sample.Tags = make(map[string]string)
+			for _, tag := range strings.Split(part[1:], ",") {
+				key, tagValue, _ := strings.Cut(tag, ":")
+				if key == "" {
+					return Sample{}, fmt.Errorf("invalid tag %q", tag)
+				}
+				sample.Tags[key] = tagValue
+			}
+		default:
+			return Sample{}, fmt.Errorf("unexpected field %q", part)
+		}
+	}
+	return sample, nil
+}
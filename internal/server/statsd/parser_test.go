@@ -0,0 +1,34 @@
+package statsd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLine(t *testing.T) {
+	testCases := []struct {
+		line     string
+		expected Sample
+	}{
+		{"hits:3|c", Sample{Name: "hits", Type: TypeCounter, Value: 3, SampleRate: 1}},
+		{"hits:1|c|@0.1|#env:prod,dc:eu", Sample{
+			Name: "hits", Type: TypeCounter, Value: 1, SampleRate: 0.1,
+			Tags: map[string]string{"env": "prod", "dc": "eu"},
+		}},
+		{"queue:42|g", Sample{Name: "queue", Type: TypeGauge, Value: 42, SampleRate: 1}},
+		{"queue:-5|g", Sample{Name: "queue", Type: TypeGauge, Value: -5, Relative: true, SampleRate: 1}},
+		{"queue:+5|g", Sample{Name: "queue", Type: TypeGauge, Value: 5, Relative: true, SampleRate: 1}},
+		{"latency:320|ms", Sample{Name: "latency", Type: TypeTimer, Value: 320, SampleRate: 1}},
+	}
+	for _, tc := range testCases {
+		sample, err := ParseLine(tc.line)
+		require.NoError(t, err, tc.line)
+		require.Equal(t, tc.expected, sample, tc.line)
+	}
+
+	for _, line := range []string{"hits", ":1|c", "hits:1", "hits:x|c", "users:1|s", "hits:1|c|@2", "hits:1|c|x"} {
+		_, err := ParseLine(line)
+		require.Error(t, err, line)
+	}
+}
@@ -0,0 +1,361 @@
+package statsd
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/gonozov0/go-musthave-devops/internal/server/repository"
+	"github.com/gonozov0/go-musthave-devops/internal/shared"
+)
+
+const (
+	// DefaultFlushInterval is the aggregation interval of StatsD itself.
+	DefaultFlushInterval = 10 * time.Second
+
+	// maxTimerValues limits the values of a timer kept for the quantiles of an interval,
+	// the count, sum, min and max still include all of them.
+	maxTimerValues = 10000
+	// maxPacketSize is the largest UDP payload.
+	maxPacketSize = 65535
+	// expireFlushes is the number of flushes without samples after which the last value of a gauge
+	// and the remainder of a counter are forgotten.
+	expireFlushes = 30
+)
+
+// Self-metrics of the listener, written on every flush.
+const (
+	MetricPackets          = "statsd_packets_received"
+	MetricSamples          = "statsd_samples_received"
+	MetricMalformed        = "statsd_lines_malformed"
+	MetricSamplesPerSecond = "statsd_samples_per_second"
+)
+
+// quantiles of the timer summaries.
+var quantiles = []float64{0.5, 0.9, 0.99}
+
+// timer is the aggregate of a timer over the flush interval.
+type timer struct {
+	count    float64 // scaled by the sample rates
+	sum      float64
+	min, max float64
+	values   []float64
+}
+
+// lastValue is a value kept between the flush intervals with the number of the flush that updated it.
+type lastValue struct {
+	value float64
+	flush int64
+}
+
+// Server aggregates the StatsD samples received on the UDP address over the flush interval.
+// On flush counters are written with the sums of the increments scaled by the sample rates and rounded
+// to integers, the rounding remainders are added to the next interval. Gauges are written with their last
+// values, and timers as summaries: the name{quantile="..."} gauges and the name_count, name_sum, name_min
+// and name_max gauges of the interval.
+type Server struct {
+	repo          repository.Repository
+	addr          string
+	flushInterval time.Duration
+
+	conn net.PacketConn
+
+	mu         sync.Mutex
+	counters   map[string]float64
+	gauges     map[string]float64
+	lastGauges map[string]lastValue // the values relative gauges are added to
+	timers     map[string]*timer
+	packets    int64
+	samples    int64
+	malformed  int64
+	flushes    int64
+
+	remainders map[string]lastValue // the rounding remainders of the counters, only used by flush
+}
+
+// Option configures the Server.
+type Option func(*Server)
+
+// WithFlushInterval sets the aggregation interval.
+func WithFlushInterval(interval time.Duration) Option {
+	return func(s *Server) {
+		s.flushInterval = interval
+	}
+}
+
+// NewServer creates a new Server listening on the UDP address. Nothing is received until Listen and Run are called.
+func NewServer(repo repository.Repository, addr string, opts ...Option) *Server {
+	s := &Server{
+		repo:          repo,
+		addr:          addr,
+		flushInterval: DefaultFlushInterval,
+		counters:      make(map[string]float64),
+		gauges:        make(map[string]float64),
+		lastGauges:    make(map[string]lastValue),
+		timers:        make(map[string]*timer),
+		remainders:    make(map[string]lastValue),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Listen opens the UDP listener, so the address errors are reported before Run.
+func (s *Server) Listen() error {
+	conn, err := net.ListenPacket("udp", s.addr)
+	if err != nil {
+		return err
+	}
+	s.conn = conn
+	return nil
+}
+
+// Addr returns the address of the opened listener.
+func (s *Server) Addr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+// Run receives packets until the context is canceled. Then it closes the listener and flushes the last interval.
+func (s *Server) Run(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.serve()
+	}()
+
+	ticker := time.NewTicker(s.flushInterval)
+	defer ticker.Stop()
+	start := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			s.conn.Close()
+			<-done
+			s.flush(time.Since(start))
+			return
+		case now := <-ticker.C:
+			s.flush(now.Sub(start))
+			start = now
+		}
+	}
+}
+
+func (s *Server) serve() {
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, _, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Errorf("statsd listener stopped: %v", err)
+			}
+			return
+		}
+		s.handlePacket(string(buf[:n]))
+	}
+}
+
+// handlePacket aggregates the lines of the packet.
+func (s *Server) handlePacket(packet string) {
+	var samples []Sample
+	var malformed int64
+	for _, line := range strings.Split(packet, "\n") {
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		sample, err := ParseLine(line)
+		if err != nil {
+			malformed++
+			log.Debugf("malformed statsd line %q: %v", line, err)
+			continue
+		}
+		samples = append(samples, sample)
+	}
+	stored := s.storedGauges(samples)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.packets++
+	s.samples += int64(len(samples))
+	s.malformed += malformed
+	for _, sample := range samples {
+		s.add(sample, stored)
+	}
+}
+
+// storedGauges reads the stored values of the relative gauges that have no value in the server yet.
+// The repository is read without mu held, so the flush isn't blocked by it.
+func (s *Server) storedGauges(samples []Sample) map[string]float64 {
+	var ids []string
+	s.mu.Lock()
+	for _, sample := range samples {
+		if sample.Type != TypeGauge || !sample.Relative {
+			continue
+		}
+		id := shared.WithLabels(sample.Name, sample.Tags)
+		if _, ok := s.gauges[id]; ok {
+			continue
+		}
+		if _, ok := s.lastGauges[id]; ok {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	s.mu.Unlock()
+
+	stored := make(map[string]float64, len(ids))
+	for _, id := range ids {
+		if _, ok := stored[id]; ok {
+			continue
+		}
+		value, err := s.repo.GetGauge(id)
+		if err != nil && !errors.Is(err, repository.ErrMetricNotFound) {
+			log.Errorf("failed to get gauge %s: %v", id, err)
+		}
+		stored[id] = value
+	}
+	return stored
+}
+
+// add aggregates the sample, relative gauges without a value in the server are added to the stored ones.
+// It must be called with mu held.
+func (s *Server) add(sample Sample, stored map[string]float64) {
+	id := shared.WithLabels(sample.Name, sample.Tags)
+	switch sample.Type {
+	case TypeCounter:
+		s.counters[id] += sample.Value / sample.SampleRate
+	case TypeGauge:
+		if !sample.Relative {
+			s.gauges[id] = sample.Value
+			return
+		}
+		current, ok := s.gauges[id]
+		if !ok {
+			var last lastValue
+			last, ok = s.lastGauges[id]
+			current = last.value
+		}
+		if !ok {
+			current = stored[id]
+		}
+		s.gauges[id] = current + sample.Value
+	default:
+		t, ok := s.timers[id]
+		if !ok {
+			t = &timer{min: sample.Value, max: sample.Value}
+			s.timers[id] = t
+		}
+		t.count += 1 / sample.SampleRate
+		t.sum += sample.Value
+		t.min = min(t.min, sample.Value)
+		t.max = max(t.max, sample.Value)
+		if len(t.values) < maxTimerValues {
+			t.values = append(t.values, sample.Value)
+		}
+	}
+}
+
+// flush writes the aggregates of the interval with the self-metrics. The write errors are logged
+// and the interval is dropped, StatsD has no acknowledgements to make the clients resend it.
+func (s *Server) flush(interval time.Duration) {
+	s.mu.Lock()
+	s.flushes++
+	flushes := s.flushes
+	counters, gauges, timers := s.counters, s.gauges, s.timers
+	s.counters, s.gauges, s.timers = make(map[string]float64), make(map[string]float64), make(map[string]*timer)
+	for id, value := range gauges {
+		s.lastGauges[id] = lastValue{value: value, flush: flushes}
+	}
+	expire(s.lastGauges, flushes)
+	packets, samples, malformed := s.packets, s.samples, s.malformed
+	s.packets, s.samples, s.malformed = 0, 0, 0
+	s.mu.Unlock()
+
+	updateCounters := make([]repository.CounterMetric, 0, len(counters)+3)
+	for id, value := range counters {
+		value += s.remainders[id].value
+		rounded := math.Round(value)
+		s.remainders[id] = lastValue{value: value - rounded, flush: flushes}
+		updateCounters = append(updateCounters, repository.CounterMetric{Name: id, Value: int64(rounded)})
+	}
+	expire(s.remainders, flushes)
+	updateCounters = append(updateCounters,
+		repository.CounterMetric{Name: MetricPackets, Value: packets},
+		repository.CounterMetric{Name: MetricSamples, Value: samples},
+		repository.CounterMetric{Name: MetricMalformed, Value: malformed},
+	)
+
+	now := time.Now()
+	updateGauges := make([]repository.GaugeMetric, 0, len(gauges)+len(timers)*(4+len(quantiles))+1)
+	for id, value := range gauges {
+		updateGauges = append(updateGauges, repository.GaugeMetric{Name: id, Value: value, Timestamp: now})
+	}
+	for id, t := range timers {
+		updateGauges = append(updateGauges, timerGauges(id, t, now)...)
+	}
+	var perSecond float64
+	if interval > 0 {
+		perSecond = float64(samples) / interval.Seconds()
+	}
+	updateGauges = append(updateGauges, repository.GaugeMetric{Name: MetricSamplesPerSecond, Value: perSecond, Timestamp: now})
+
+	if _, err := s.repo.UpdateCounters(updateCounters); err != nil {
+		log.Errorf("failed to write %d statsd counters: %v", len(updateCounters), err)
+	}
+	if _, err := s.repo.UpdateGauges(updateGauges); err != nil {
+		log.Errorf("failed to write %d statsd gauges: %v", len(updateGauges), err)
+	}
+}
+
+// expire deletes the values not updated for expireFlushes flushes.
+func expire(values map[string]lastValue, flush int64) {
+	for id, value := range values {
+		if flush-value.flush >= expireFlushes {
+			delete(values, id)
+		}
+	}
+}
+
+// timerGauges returns the summary gauges of the timer.
+func timerGauges(id string, t *timer, now time.Time) []repository.GaugeMetric {
+	name, labels, err := shared.SplitLabels(id)
+	if err != nil {
+		name, labels = id, nil
+	}
+	withSuffix := func(suffix string) string {
+		return shared.WithLabels(name+suffix, labels)
+	}
+
+	gauges := []repository.GaugeMetric{
+		{Name: withSuffix("_count"), Value: t.count, Timestamp: now},
+		{Name: withSuffix("_sum"), Value: t.sum, Timestamp: now},
+		{Name: withSuffix("_min"), Value: t.min, Timestamp: now},
+		{Name: withSuffix("_max"), Value: t.max, Timestamp: now},
+	}
+	sort.Float64s(t.values)
+	for _, q := range quantiles {
+		quantileLabels := make(map[string]string, len(labels)+1)
+		for key, value := range labels {
+			quantileLabels[key] = value
+		}
+		quantileLabels["quantile"] = strconv.FormatFloat(q, 'g', -1, 64)
+		// nearest-rank quantile
+		rank := int(math.Ceil(q*float64(len(t.values)))) - 1
+		gauges = append(gauges, repository.GaugeMetric{
+			Name:      shared.WithLabels(name, quantileLabels),
+			Value:     t.values[max(rank, 0)],
+			Timestamp: now,
+		})
+	}
+	return gauges
+}
@@ -0,0 +1,117 @@
+package statsd
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	repository "github.com/gonozov0/go-musthave-devops/internal/server/repository/in_memory"
+)
+
+func TestServer(t *testing.T) {
+	repo := repository.NewInMemoryRepository()
+	_, err := repo.UpdateGauge("queue", 10)
+	require.NoError(t, err)
+
+	server := NewServer(repo, "127.0.0.1:0", WithFlushInterval(time.Hour))
+	require.NoError(t, server.Listen())
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go server.Run(ctx, wg)
+
+	conn, err := net.Dial("udp", server.Addr().String())
+	require.NoError(t, err)
+	packets := []string{
+		"hits:3|c\nhits:1|c|@0.5\nqueue:+5|g",
+		"queue:-2|g\ntemp:21.5|g|#room:kitchen",
+		"latency:10|ms\nlatency:30|ms\nlatency:20|ms\nbroken line",
+	}
+	for _, packet := range packets {
+		_, err = conn.Write([]byte(packet))
+		require.NoError(t, err)
+	}
+	require.NoError(t, conn.Close())
+
+	require.Eventually(t, func() bool {
+		server.mu.Lock()
+		defer server.mu.Unlock()
+		return server.packets == 3
+	}, time.Second, 10*time.Millisecond)
+	cancel()
+	wg.Wait()
+
+	counter, err := repo.GetCounter("hits")
+	require.NoError(t, err)
+	require.Equal(t, int64(5), counter)
+
+	expectedGauges := map[string]float64{
+		"queue":                    13,
+		`temp{room="kitchen"}`:     21.5,
+		"latency_count":            3,
+		"latency_sum":              60,
+		"latency_min":              10,
+		"latency_max":              30,
+		`latency{quantile="0.5"}`:  20,
+		`latency{quantile="0.99"}`: 30,
+	}
+	for id, expected := range expectedGauges {
+		gauge, err := repo.GetGauge(id)
+		require.NoError(t, err, id)
+		require.Equal(t, expected, gauge, id)
+	}
+
+	for id, expected := range map[string]int64{MetricPackets: 3, MetricSamples: 8, MetricMalformed: 1} {
+		counter, err := repo.GetCounter(id)
+		require.NoError(t, err, id)
+		require.Equal(t, expected, counter, id)
+	}
+	perSecond, err := repo.GetGauge(MetricSamplesPerSecond)
+	require.NoError(t, err)
+	require.Greater(t, perSecond, 0.0)
+}
+
+func TestServerCounterRemainder(t *testing.T) {
+	repo := repository.NewInMemoryRepository()
+	server := NewServer(repo, "127.0.0.1:0")
+
+	for i := 0; i < 3; i++ {
+		server.handlePacket("hits:1|c|@0.3")
+		server.flush(time.Second)
+	}
+
+	counter, err := repo.GetCounter("hits")
+	require.NoError(t, err)
+	require.Equal(t, int64(10), counter)
+}
+
+func TestServerLastGaugesExpire(t *testing.T) {
+	repo := repository.NewInMemoryRepository()
+	_, err := repo.UpdateGauge("queue", 10)
+	require.NoError(t, err)
+	server := NewServer(repo, "127.0.0.1:0")
+
+	server.handlePacket("queue:+5|g")
+	server.flush(time.Second)
+	gauge, err := repo.GetGauge("queue")
+	require.NoError(t, err)
+	require.Equal(t, 15.0, gauge)
+	require.Contains(t, server.lastGauges, "queue")
+
+	for i := 0; i < expireFlushes; i++ {
+		server.flush(time.Second)
+	}
+	require.Empty(t, server.lastGauges)
+
+	_, err = repo.UpdateGauge("queue", 100)
+	require.NoError(t, err)
+	server.handlePacket("queue:+1|g")
+	server.flush(time.Second)
+	gauge, err = repo.GetGauge("queue")
+	require.NoError(t, err)
+	require.Equal(t, 101.0, gauge)
+}
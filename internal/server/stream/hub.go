@@ -0,0 +1,197 @@
+package stream
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+
+	"github.com/gonozov0/go-musthave-devops/internal/shared"
+)
+
+// DefaultBufferSize is the number of updates a subscriber may lag behind before it's disconnected.
+const DefaultBufferSize = 1024
+
+var (
+	// ErrSlowConsumer is the reason of closing a subscription whose buffer is full.
+	ErrSlowConsumer = errors.New("subscriber is too slow, its buffer is full")
+	// ErrUnsubscribed is the reason of closing a subscription by its subscriber.
+	ErrUnsubscribed = errors.New("unsubscribed")
+	// ErrHubClosed is the reason of closing the subscriptions of a closed hub.
+	ErrHubClosed = errors.New("server is shutting down")
+)
+
+// Filter selects the streamed metrics. Empty fields match every metric.
+type Filter struct {
+	Types []string       // metric types
+	Name  *regexp.Regexp // matches the whole metric ID
+}
+
+// NewFilter creates a Filter of the types and the name regular expression, which is anchored
+// like the PromQL regular expressions.
+func NewFilter(types []string, name string) (Filter, error) {
+	filter := Filter{Types: types}
+	for _, metricType := range types {
+		switch metricType {
+		case shared.Gauge, shared.Counter, shared.Histogram, shared.Set:
+		default:
+			return Filter{}, fmt.Errorf("unknown metric type %q", metricType)
+		}
+	}
+	if name != "" {
+		re, err := regexp.Compile("^(?:" + name + ")$")
+		if err != nil {
+			return Filter{}, fmt.Errorf("invalid name pattern %q: %w", name, err)
+		}
+		filter.Name = re
+	}
+	return filter, nil
+}
+
+// Match reports whether the metric passes the filter.
+func (f Filter) Match(metric shared.Metric) bool {
+	if len(f.Types) > 0 {
+		matched := false
+		for _, metricType := range f.Types {
+			if metricType == metric.MType {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return f.Name == nil || f.Name.MatchString(metric.ID)
+}
+
+// Subscription receives the published metrics passing its filter until it's closed.
+type Subscription struct {
+	hub     *Hub
+	filter  Filter
+	updates chan shared.Metric
+	done    chan struct{}
+	err     error // set before done is closed
+}
+
+// Updates returns the channel of the metrics, it isn't closed.
+func (s *Subscription) Updates() <-chan shared.Metric {
+	return s.updates
+}
+
+// Done returns the channel closed when the subscription is closed.
+func (s *Subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns the reason of closing the subscription, nil while it's open.
+func (s *Subscription) Err() error {
+	select {
+	case <-s.done:
+		return s.err
+	default:
+		return nil
+	}
+}
+
+// Close closes the subscription.
+func (s *Subscription) Close() {
+	s.hub.unsubscribe(s, ErrUnsubscribed)
+}
+
+// Hub fans the published metrics out to the subscribers. Publishing never blocks: a subscriber
+// whose buffer is full is disconnected with ErrSlowConsumer, so a stuck client can't block the writes.
+type Hub struct {
+	bufferSize int
+
+	mu          sync.RWMutex
+	subscribers map[*Subscription]struct{}
+	closed      bool
+}
+
+// NewHub creates a new Hub buffering up to bufferSize updates per subscriber.
+func NewHub(bufferSize int) *Hub {
+	if bufferSize <= 0 {
+		bufferSize = DefaultBufferSize
+	}
+	return &Hub{
+		bufferSize:  bufferSize,
+		subscribers: make(map[*Subscription]struct{}),
+	}
+}
+
+// Subscribe creates a new subscription of the metrics passing the filter.
+func (h *Hub) Subscribe(filter Filter) *Subscription {
+	s := &Subscription{
+		hub:     h,
+		filter:  filter,
+		updates: make(chan shared.Metric, h.bufferSize),
+		done:    make(chan struct{}),
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		s.err = ErrHubClosed
+		close(s.done)
+		return s
+	}
+	h.subscribers[s] = struct{}{}
+	return s
+}
+
+// Close closes all subscriptions with ErrHubClosed, the later ones are closed right away.
+// It ends the streams, which http.Server.Shutdown doesn't interrupt.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for s := range h.subscribers {
+		delete(h.subscribers, s)
+		s.err = ErrHubClosed
+		close(s.done)
+	}
+}
+
+// Subscribers returns the number of the open subscriptions.
+func (h *Hub) Subscribers() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.subscribers)
+}
+
+// Publish sends the metrics to the subscribers, it implements repository.Publisher.
+func (h *Hub) Publish(metrics []shared.Metric) {
+	var slow []*Subscription
+	h.mu.RLock()
+	for s := range h.subscribers {
+		for _, metric := range metrics {
+			if !s.filter.Match(metric) {
+				continue
+			}
+			select {
+			case s.updates <- metric:
+				continue
+			default:
+			}
+			slow = append(slow, s)
+			break
+		}
+	}
+	h.mu.RUnlock()
+
+	for _, s := range slow {
+		h.unsubscribe(s, ErrSlowConsumer)
+	}
+}
+
+// unsubscribe removes the subscription and closes it with the reason unless it's already closed.
+func (h *Hub) unsubscribe(s *Subscription, reason error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subscribers[s]; !ok {
+		return
+	}
+	delete(h.subscribers, s)
+	s.err = reason
+	close(s.done)
+}
@@ -0,0 +1,67 @@
+package stream
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gonozov0/go-musthave-devops/internal/shared"
+)
+
+func gauge(id string, value float64) shared.Metric {
+	return shared.Metric{ID: id, MType: shared.Gauge, Value: &value}
+}
+
+func TestFilter(t *testing.T) {
+	filter, err := NewFilter([]string{shared.Gauge}, "Heap.*")
+	require.NoError(t, err)
+	require.True(t, filter.Match(gauge("HeapAlloc", 1)))
+	require.False(t, filter.Match(gauge("TotalHeapAlloc", 1)))
+	delta := int64(1)
+	require.False(t, filter.Match(shared.Metric{ID: "HeapAlloc", MType: shared.Counter, Delta: &delta}))
+
+	_, err = NewFilter([]string{"summary"}, "")
+	require.Error(t, err)
+	_, err = NewFilter(nil, "(")
+	require.Error(t, err)
+}
+
+func TestHub(t *testing.T) {
+	hub := NewHub(2)
+	counters := hub.Subscribe(Filter{Types: []string{shared.Counter}})
+	filter, err := NewFilter(nil, "Heap.*")
+	require.NoError(t, err)
+	heap := hub.Subscribe(filter)
+	require.Equal(t, 2, hub.Subscribers())
+
+	delta := int64(2)
+	hub.Publish([]shared.Metric{gauge("HeapAlloc", 1), {ID: "PollCount", MType: shared.Counter, Delta: &delta}})
+	require.Equal(t, "PollCount", (<-counters.Updates()).ID)
+	require.Equal(t, "HeapAlloc", (<-heap.Updates()).ID)
+
+	// the subscriber not reading its updates is disconnected without blocking the publisher
+	hub.Publish([]shared.Metric{gauge("HeapAlloc", 3), gauge("HeapInuse", 4), gauge("HeapSys", 5)})
+	<-heap.Done()
+	require.ErrorIs(t, heap.Err(), ErrSlowConsumer)
+	require.Nil(t, counters.Err())
+	require.Equal(t, 1, hub.Subscribers())
+
+	counters.Close()
+	require.ErrorIs(t, counters.Err(), ErrUnsubscribed)
+	counters.Close()
+	require.Equal(t, 0, hub.Subscribers())
+}
+
+func TestHubClose(t *testing.T) {
+	hub := NewHub(1)
+	subscription := hub.Subscribe(Filter{})
+	hub.Close()
+	<-subscription.Done()
+	require.ErrorIs(t, subscription.Err(), ErrHubClosed)
+	require.Equal(t, 0, hub.Subscribers())
+
+	late := hub.Subscribe(Filter{})
+	<-late.Done()
+	require.ErrorIs(t, late.Err(), ErrHubClosed)
+	hub.Publish([]shared.Metric{gauge("HeapAlloc", 1)})
+}
@@ -0,0 +1,254 @@
+package shared
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Content encodings supported by the server and the agent.
+const (
+	EncodingZstd     = "zstd"
+	EncodingBrotli   = "br"
+	EncodingGzip     = "gzip"
+	EncodingDeflate  = "deflate"
+	EncodingIdentity = "identity"
+)
+
+// SupportedEncodings lists the supported compressions from the most to the least preferred.
+var SupportedEncodings = []string{EncodingZstd, EncodingBrotli, EncodingGzip, EncodingDeflate}
+
+// IsSupportedEncoding reports whether the content encoding can be encoded and decoded.
+func IsSupportedEncoding(encoding string) bool {
+	return encoding == EncodingIdentity || indexOf(SupportedEncodings, encoding) >= 0
+}
+
+// NegotiateEncoding picks the supported encoding with the highest q-value in the Accept-Encoding header.
+// Ties are broken by the order of SupportedEncodings. It returns EncodingIdentity when nothing
+// better is acceptable.
+func NegotiateEncoding(acceptEncoding string) string {
+	accepted := ParseAcceptEncoding(acceptEncoding)
+	wildcard, hasWildcard := accepted["*"]
+
+	best, bestQ := EncodingIdentity, 0.0
+	for _, encoding := range SupportedEncodings {
+		q, ok := accepted[encoding]
+		if !ok && hasWildcard {
+			q, ok = wildcard, true
+		}
+		if ok && q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+	return best
+}
+
+// ParseAcceptEncoding parses the Accept-Encoding header into lowercase codings and their q-values.
+// Codings without a q-value get 1, malformed q-values are treated as 0.
+func ParseAcceptEncoding(header string) map[string]float64 {
+	accepted := make(map[string]float64)
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+		accepted[coding] = parseQValue(params)
+	}
+	return accepted
+}
+
+// parseQValue returns the q-value of the semicolon-separated header parameters.
+// It's 1 when missing and 0 when malformed.
+func parseQValue(params string) float64 {
+	q := 1.0
+	for _, param := range strings.Split(params, ";") {
+		key, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+		if !ok || strings.ToLower(strings.TrimSpace(key)) != "q" {
+			continue
+		}
+		parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || parsed < 0 || parsed > 1 {
+			parsed = 0
+		}
+		q = parsed
+	}
+	return q
+}
+
+// AcceptedEncodings returns the supported encodings with a positive q-value in the header,
+// ordered by q-value and then by preference.
+func AcceptedEncodings(header string) []string {
+	accepted := ParseAcceptEncoding(header)
+	encodings := make([]string, 0, len(SupportedEncodings))
+	for _, encoding := range SupportedEncodings {
+		if accepted[encoding] > 0 {
+			encodings = append(encodings, encoding)
+		}
+	}
+	sort.SliceStable(encodings, func(i, j int) bool {
+		return accepted[encodings[i]] > accepted[encodings[j]]
+	})
+	return encodings
+}
+
+var (
+	gzipWriters    sync.Pool
+	deflateWriters sync.Pool
+	brotliWriters  sync.Pool
+	zstdWriters    sync.Pool
+	gzipReaders    sync.Pool
+	deflateReaders sync.Pool
+	brotliReaders  sync.Pool
+	zstdReaders    sync.Pool
+)
+
+// NewCompressWriter returns a writer compressing into w with the encoding.
+// Closing the writer flushes it and returns the encoder to the pool, w is not closed.
+func NewCompressWriter(encoding string, w io.Writer) (io.WriteCloser, error) {
+	switch encoding {
+	case EncodingIdentity, "":
+		return nopWriteCloser{w}, nil
+	case EncodingGzip:
+		if writer, ok := gzipWriters.Get().(*gzip.Writer); ok {
+			writer.Reset(w)
+			return &pooledWriter{writer, &gzipWriters}, nil
+		}
+		return &pooledWriter{gzip.NewWriter(w), &gzipWriters}, nil
+	case EncodingDeflate:
+		if writer, ok := deflateWriters.Get().(*flate.Writer); ok {
+			writer.Reset(w)
+			return &pooledWriter{writer, &deflateWriters}, nil
+		}
+		writer, err := flate.NewWriter(w, flate.DefaultCompression)
+		if err != nil {
+			return nil, err
+		}
+		return &pooledWriter{writer, &deflateWriters}, nil
+	case EncodingBrotli:
+		if writer, ok := brotliWriters.Get().(*brotli.Writer); ok {
+			writer.Reset(w)
+			return &pooledWriter{writer, &brotliWriters}, nil
+		}
+		return &pooledWriter{brotli.NewWriter(w), &brotliWriters}, nil
+	case EncodingZstd:
+		if writer, ok := zstdWriters.Get().(*zstd.Encoder); ok {
+			writer.Reset(w)
+			return &pooledWriter{writer, &zstdWriters}, nil
+		}
+		writer, err := zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return &pooledWriter{writer, &zstdWriters}, nil
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", encoding)
+	}
+}
+
+// NewDecompressReader returns a reader decompressing r with the encoding.
+// Closing the reader returns the decoder to the pool, r is not closed.
+func NewDecompressReader(encoding string, r io.Reader) (io.ReadCloser, error) {
+	switch encoding {
+	case EncodingIdentity, "":
+		return io.NopCloser(r), nil
+	case EncodingGzip:
+		if reader, ok := gzipReaders.Get().(*gzip.Reader); ok {
+			if err := reader.Reset(r); err != nil {
+				return nil, err
+			}
+			return &pooledReader{reader, &gzipReaders}, nil
+		}
+		reader, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return &pooledReader{reader, &gzipReaders}, nil
+	case EncodingDeflate:
+		if reader, ok := deflateReaders.Get().(io.ReadCloser); ok {
+			if err := reader.(flate.Resetter).Reset(r, nil); err != nil {
+				return nil, err
+			}
+			return &pooledReader{reader, &deflateReaders}, nil
+		}
+		return &pooledReader{flate.NewReader(r), &deflateReaders}, nil
+	case EncodingBrotli:
+		if reader, ok := brotliReaders.Get().(*brotli.Reader); ok {
+			if err := reader.Reset(r); err != nil {
+				return nil, err
+			}
+			return &pooledReader{reader, &brotliReaders}, nil
+		}
+		return &pooledReader{brotli.NewReader(r), &brotliReaders}, nil
+	case EncodingZstd:
+		if reader, ok := zstdReaders.Get().(*zstd.Decoder); ok {
+			if err := reader.Reset(r); err != nil {
+				return nil, err
+			}
+			return &pooledReader{reader, &zstdReaders}, nil
+		}
+		reader, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return &pooledReader{reader, &zstdReaders}, nil
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", encoding)
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// pooledWriter closes the encoder and puts it back to the pool.
+type pooledWriter struct {
+	io.WriteCloser
+	pool *sync.Pool
+}
+
+func (w *pooledWriter) Close() error {
+	if w.WriteCloser == nil {
+		return nil
+	}
+	err := w.WriteCloser.Close()
+	w.pool.Put(w.WriteCloser)
+	w.WriteCloser = nil
+	return err
+}
+
+// pooledReader puts the decoder back to the pool on Close.
+type pooledReader struct {
+	io.Reader
+	pool *sync.Pool
+}
+
+func (r *pooledReader) Close() error {
+	if r.Reader == nil {
+		return nil
+	}
+	r.pool.Put(r.Reader)
+	r.Reader = nil
+	return nil
+}
+
+func indexOf(values []string, value string) int {
+	for i, v := range values {
+		if v == value {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,65 @@
+package shared
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	testCases := []struct {
+		header   string
+		expected string
+	}{
+		{header: "", expected: EncodingIdentity},
+		{header: "gzip", expected: EncodingGzip},
+		{header: "gzip, deflate, br, zstd", expected: EncodingZstd},
+		{header: "gzip;q=1.0, br;q=0.5", expected: EncodingGzip},
+		{header: "zstd;q=0, br;q=0.8, gzip;q=0.8", expected: EncodingBrotli},
+		{header: "*;q=0.5, gzip;q=0.9", expected: EncodingGzip},
+		{header: "*", expected: EncodingZstd},
+		{header: "GZIP ; Q=0.3", expected: EncodingGzip},
+		{header: "gzip;q=abc, compress", expected: EncodingIdentity},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.header, func(t *testing.T) {
+			require.Equal(t, tc.expected, NegotiateEncoding(tc.header))
+		})
+	}
+}
+
+func TestAcceptedEncodings(t *testing.T) {
+	require.Equal(t, []string{EncodingGzip, EncodingZstd}, AcceptedEncodings("zstd;q=0.5, gzip, lz4"))
+	require.Empty(t, AcceptedEncodings(""))
+}
+
+func TestCompressionRoundTrip(t *testing.T) {
+	data := bytes.Repeat([]byte(`{"id":"Alloc","type":"gauge","value":1.5}`), 100)
+
+	for _, encoding := range append(SupportedEncodings, EncodingIdentity) {
+		t.Run(encoding, func(t *testing.T) {
+			// the second iteration reuses pooled encoders and decoders
+			for i := 0; i < 2; i++ {
+				var buffer bytes.Buffer
+				writer, err := NewCompressWriter(encoding, &buffer)
+				require.NoError(t, err)
+				_, err = writer.Write(data)
+				require.NoError(t, err)
+				require.NoError(t, writer.Close())
+
+				reader, err := NewDecompressReader(encoding, &buffer)
+				require.NoError(t, err)
+				decoded, err := io.ReadAll(reader)
+				require.NoError(t, err)
+				require.NoError(t, reader.Close())
+				require.Equal(t, data, decoded)
+			}
+		})
+	}
+
+	_, err := NewCompressWriter("lz4", io.Discard)
+	require.Error(t, err)
+}
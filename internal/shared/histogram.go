@@ -0,0 +1,136 @@
+package shared
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+)
+
+// DefaultHistogramBounds are the bucket bounds of histograms created by a single observation,
+// the default buckets of the Prometheus client in seconds.
+var DefaultHistogramBounds = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// HistogramValue is a distribution of observations in buckets with explicit upper bounds.
+// Counts[i] is the number of observations in (Bounds[i-1], Bounds[i]] and the last count
+// is the number of observations above the last bound.
+//
+// Histogram updates are merged like counter deltas: the counts, the sum and the count of the update
+// are added to the stored histogram. An update with other bounds replaces the stored histogram,
+// so changing the buckets of a histogram resets it.
+type HistogramValue struct {
+	Bounds []float64 `json:"bounds"`
+	Counts []uint64  `json:"counts"`
+	Sum    float64   `json:"sum"`
+	Count  uint64    `json:"count"`
+}
+
+// NewHistogram returns the histogram of a single observation.
+func NewHistogram(bounds []float64, value float64) HistogramValue {
+	h := HistogramValue{
+		Bounds: bounds,
+		Counts: make([]uint64, len(bounds)+1),
+		Sum:    value,
+		Count:  1,
+	}
+	i, _ := slices.BinarySearch(bounds, value)
+	h.Counts[i]++
+	return h
+}
+
+// ObservationBounds returns the bucket bounds of an observation added to the histogram:
+// the given ones, the bounds of the histogram or DefaultHistogramBounds for a zero histogram.
+func (h HistogramValue) ObservationBounds(bounds []float64) []float64 {
+	switch {
+	case bounds != nil:
+		return bounds
+	case h.Counts != nil:
+		return h.Bounds
+	default:
+		return DefaultHistogramBounds
+	}
+}
+
+// Validate checks that the bounds are finite and increasing and the counts match them.
+func (h HistogramValue) Validate() error {
+	for i, bound := range h.Bounds {
+		if math.IsNaN(bound) || math.IsInf(bound, 0) {
+			return errors.New("histogram bounds must be finite")
+		}
+		if i > 0 && bound <= h.Bounds[i-1] {
+			return errors.New("histogram bounds must be increasing")
+		}
+	}
+	if len(h.Counts) != len(h.Bounds)+1 {
+		return fmt.Errorf("histogram with %d bounds must have %d counts", len(h.Bounds), len(h.Bounds)+1)
+	}
+	var count uint64
+	for _, c := range h.Counts {
+		count += c
+	}
+	if count != h.Count {
+		return errors.New("histogram count must be the sum of the bucket counts")
+	}
+	if math.IsNaN(h.Sum) || math.IsInf(h.Sum, 0) {
+		return errors.New("histogram sum must be finite")
+	}
+	return nil
+}
+
+// Merge returns the histogram with the update added, or the update when the bounds differ.
+func (h HistogramValue) Merge(update HistogramValue) HistogramValue {
+	if !slices.Equal(h.Bounds, update.Bounds) || len(h.Counts) != len(update.Counts) {
+		return update.clone()
+	}
+	merged := h.clone()
+	for i, c := range update.Counts {
+		merged.Counts[i] += c
+	}
+	merged.Sum += update.Sum
+	merged.Count += update.Count
+	return merged
+}
+
+func (h HistogramValue) clone() HistogramValue {
+	h.Bounds = slices.Clone(h.Bounds)
+	h.Counts = slices.Clone(h.Counts)
+	return h
+}
+
+// Quantile estimates the q-quantile by linear interpolation within the bucket like histogram_quantile
+// of Prometheus: the lowest bucket starts at zero unless its bound is negative, and the quantiles
+// in the bucket above the last bound are the last bound. It's NaN for an empty histogram.
+func (h HistogramValue) Quantile(q float64) float64 {
+	if h.Count == 0 || len(h.Counts) != len(h.Bounds)+1 || math.IsNaN(q) {
+		return math.NaN()
+	}
+	if q < 0 {
+		return math.Inf(-1)
+	}
+	if q > 1 {
+		return math.Inf(1)
+	}
+
+	rank := q * float64(h.Count)
+	var cumulative uint64
+	for i, c := range h.Counts {
+		if float64(cumulative+c) < rank || c == 0 {
+			cumulative += c
+			continue
+		}
+		if i == len(h.Bounds) {
+			if i == 0 {
+				return math.NaN()
+			}
+			return h.Bounds[i-1]
+		}
+		lower := 0.0
+		if i > 0 {
+			lower = h.Bounds[i-1]
+		} else if h.Bounds[0] <= 0 {
+			return h.Bounds[0]
+		}
+		return lower + (h.Bounds[i]-lower)*(rank-float64(cumulative))/float64(c)
+	}
+	return h.Bounds[len(h.Bounds)-1]
+}
@@ -0,0 +1,69 @@
+package shared
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistogramMerge(t *testing.T) {
+	bounds := []float64{1, 5}
+	stored := NewHistogram(bounds, 0.5)
+	require.Equal(t, HistogramValue{Bounds: bounds, Counts: []uint64{1, 0, 0}, Sum: 0.5, Count: 1}, stored)
+	require.NoError(t, stored.Validate())
+
+	merged := stored.Merge(NewHistogram(bounds, 7))
+	require.Equal(t, []uint64{1, 0, 1}, merged.Counts)
+	require.Equal(t, uint64(2), merged.Count)
+	require.Equal(t, 7.5, merged.Sum)
+	require.Equal(t, []uint64{1, 0, 0}, stored.Counts, "the stored histogram must not change")
+
+	// an observation on the bound belongs to its bucket
+	merged = merged.Merge(NewHistogram(bounds, 5))
+	require.Equal(t, []uint64{1, 1, 1}, merged.Counts)
+
+	reset := merged.Merge(NewHistogram([]float64{10}, 3))
+	require.Equal(t, HistogramValue{Bounds: []float64{10}, Counts: []uint64{1, 0}, Sum: 3, Count: 1}, reset)
+}
+
+func TestHistogramValidate(t *testing.T) {
+	testCases := []struct {
+		name      string
+		histogram HistogramValue
+		wantErr   string
+	}{
+		{"Valid", HistogramValue{Bounds: []float64{1}, Counts: []uint64{1, 2}, Sum: 4, Count: 3}, ""},
+		{"No Bounds", HistogramValue{Counts: []uint64{2}, Sum: 4, Count: 2}, ""},
+		{"Unsorted Bounds", HistogramValue{Bounds: []float64{2, 1}, Counts: []uint64{0, 0, 0}}, "histogram bounds must be increasing"},
+		{"Infinite Bound", HistogramValue{Bounds: []float64{math.Inf(1)}, Counts: []uint64{0, 0}}, "histogram bounds must be finite"},
+		{"Counts Mismatch", HistogramValue{Bounds: []float64{1}, Counts: []uint64{1}, Count: 1}, "histogram with 1 bounds must have 2 counts"},
+		{"Count Mismatch", HistogramValue{Bounds: []float64{1}, Counts: []uint64{1, 1}, Count: 1}, "histogram count must be the sum of the bucket counts"},
+		{"NaN Sum", HistogramValue{Counts: []uint64{0}, Sum: math.NaN()}, "histogram sum must be finite"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.histogram.Validate()
+			if tc.wantErr == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.EqualError(t, err, tc.wantErr)
+		})
+	}
+}
+
+func TestHistogramQuantile(t *testing.T) {
+	histogram := HistogramValue{Bounds: []float64{1, 2, 4}, Counts: []uint64{2, 0, 4, 2}, Count: 8}
+
+	require.Equal(t, 0.5, histogram.Quantile(0.125))
+	require.Equal(t, 1.0, histogram.Quantile(0.25))
+	require.Equal(t, 3.0, histogram.Quantile(0.5))
+	require.Equal(t, 4.0, histogram.Quantile(0.9), "the +Inf bucket is estimated by the last bound")
+	require.Equal(t, math.Inf(1), histogram.Quantile(1.5))
+	require.True(t, math.IsNaN(HistogramValue{Bounds: []float64{1}, Counts: []uint64{0, 0}}.Quantile(0.5)))
+
+	negative := HistogramValue{Bounds: []float64{-1, 1}, Counts: []uint64{1, 1, 0}, Count: 2}
+	require.Equal(t, -1.0, negative.Quantile(0.25))
+	require.Equal(t, 0.0, negative.Quantile(0.75))
+}
@@ -0,0 +1,108 @@
+package shared
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// WithLabels returns the metric ID in the Prometheus series notation: name{key="value",...}.
+// Labels already present in the name are kept unless overridden. Keys are sorted so IDs are stable.
+func WithLabels(name string, labels map[string]string) string {
+	if len(labels) == 0 {
+		return name
+	}
+
+	baseName, existing, err := SplitLabels(name)
+	if err != nil {
+		baseName, existing = name, nil
+	}
+	merged := make(map[string]string, len(existing)+len(labels))
+	for key, value := range existing {
+		merged[key] = value
+	}
+	for key, value := range labels {
+		merged[key] = value
+	}
+
+	keys := make([]string, 0, len(merged))
+	for key := range merged {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(baseName)
+	b.WriteByte('{')
+	for i, key := range keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `%s="%s"`, key, labelValueEscaper.Replace(merged[key]))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// SplitLabels parses the metric ID in the series notation into the name and labels.
+// IDs without labels are returned as is with nil labels.
+func SplitLabels(id string) (string, map[string]string, error) {
+	start := strings.IndexByte(id, '{')
+	if start < 0 {
+		return id, nil, nil
+	}
+	if !strings.HasSuffix(id, "}") {
+		return "", nil, fmt.Errorf("invalid metric id %q: missing closing brace", id)
+	}
+
+	labels := make(map[string]string)
+	rest := id[start+1 : len(id)-1]
+	for rest != "" {
+		eq := strings.IndexByte(rest, '=')
+		if eq <= 0 || eq+1 >= len(rest) || rest[eq+1] != '"' {
+			return "", nil, fmt.Errorf("invalid metric id %q: malformed label", id)
+		}
+		key := strings.TrimSpace(rest[:eq])
+
+		value, tail, err := readQuoted(rest[eq+1:])
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid metric id %q: %w", id, err)
+		}
+		labels[key] = value
+
+		tail = strings.TrimSpace(tail)
+		if tail != "" && tail[0] != ',' {
+			return "", nil, fmt.Errorf("invalid metric id %q: expected comma", id)
+		}
+		rest = strings.TrimSpace(strings.TrimPrefix(tail, ","))
+	}
+	return id[:start], labels, nil
+}
+
+// readQuoted reads a double-quoted escaped string and returns it with the remaining input.
+func readQuoted(s string) (string, string, error) {
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			return b.String(), s[i+1:], nil
+		case '\\':
+			i++
+			if i >= len(s) {
+				return "", "", errors.New("unterminated escape sequence")
+			}
+			switch s[i] {
+			case 'n':
+				b.WriteByte('\n')
+			default:
+				b.WriteByte(s[i])
+			}
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return "", "", errors.New("unterminated label value")
+}
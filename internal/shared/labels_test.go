@@ -0,0 +1,54 @@
+package shared
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithLabels(t *testing.T) {
+	require.Equal(t, "Alloc", WithLabels("Alloc", nil))
+	require.Equal(
+		t,
+		`Alloc{env="prod",host="a\"b"}`,
+		WithLabels("Alloc", map[string]string{"host": `a"b`, "env": "prod"}),
+	)
+	require.Equal(
+		t,
+		`Alloc{env="prod",zone="z1"}`,
+		WithLabels(`Alloc{env="dev",zone="z1"}`, map[string]string{"env": "prod"}),
+	)
+}
+
+func TestSplitLabels(t *testing.T) {
+	testCases := []struct {
+		id             string
+		expectedName   string
+		expectedLabels map[string]string
+		expectedErr    bool
+	}{
+		{id: "Alloc", expectedName: "Alloc"},
+		{id: `Alloc{}`, expectedName: "Alloc", expectedLabels: map[string]string{}},
+		{
+			id:             `Alloc{env="prod", host="a\"b,c"}`,
+			expectedName:   "Alloc",
+			expectedLabels: map[string]string{"env": "prod", "host": `a"b,c`},
+		},
+		{id: `Alloc{env="prod"`, expectedErr: true},
+		{id: `Alloc{env=prod}`, expectedErr: true},
+		{id: `Alloc{env="prod" host="a"}`, expectedErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.id, func(t *testing.T) {
+			name, labels, err := SplitLabels(tc.id)
+			if tc.expectedErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedName, name)
+			require.Equal(t, tc.expectedLabels, labels)
+		})
+	}
+}
@@ -6,4 +6,14 @@ type Metric struct {
 	MType string   `json:"type"`
 	Delta *int64   `json:"delta,omitempty"`
 	Value *float64 `json:"value,omitempty"`
+	// Histogram holds the observations of a histogram metric.
+	Histogram *HistogramValue `json:"histogram,omitempty"`
+	// Members are the members added to a set metric.
+	Members []string `json:"members,omitempty"`
+	// Cardinality is the estimated number of distinct members of a set metric returned by the server.
+	Cardinality *uint64 `json:"cardinality,omitempty"`
+	// Quantiles are the estimates of the histogram quantiles returned by the server, keyed by the quantile.
+	Quantiles map[string]float64 `json:"quantiles,omitempty"`
+	// Timestamp is the collection time in Unix milliseconds. The server uses the arrival time when it's empty.
+	Timestamp *int64 `json:"timestamp,omitempty"`
 }
@@ -0,0 +1,232 @@
+package shared
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Content types of the supported exposition formats.
+const (
+	ContentTypePrometheusText = "text/plain; version=0.0.4; charset=utf-8"
+	ContentTypeOpenMetrics    = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+)
+
+// SanitizePrometheusName replaces characters that are not allowed in Prometheus metric names with underscores.
+func SanitizePrometheusName(name string) string {
+	var b strings.Builder
+	b.Grow(len(name))
+	for i, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_', r == ':':
+			b.WriteRune(r)
+		case r >= '0' && r <= '9':
+			if i == 0 {
+				b.WriteRune('_')
+			}
+			b.WriteRune(r)
+		default:
+			b.WriteRune('_')
+		}
+	}
+	if b.Len() == 0 {
+		return "_"
+	}
+	return b.String()
+}
+
+// SanitizePrometheusLabelName is SanitizePrometheusName for label names, which can't contain colons.
+func SanitizePrometheusLabelName(name string) string {
+	return strings.ReplaceAll(SanitizePrometheusName(name), ":", "_")
+}
+
+// NegotiateExposition returns the content type of the exposition format preferred by the Accept header.
+// It's the Prometheus text format unless OpenMetrics has a higher q-value.
+func NegotiateExposition(accept string) string {
+	var openMetricsQ, textQ float64
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, _ := strings.Cut(part, ";")
+		q := parseQValue(params)
+		switch strings.ToLower(strings.TrimSpace(mediaType)) {
+		case "application/openmetrics-text":
+			openMetricsQ = max(openMetricsQ, q)
+		case "text/plain", "text/*", "*/*":
+			textQ = max(textQ, q)
+		}
+	}
+	if openMetricsQ > textQ {
+		return ContentTypeOpenMetrics
+	}
+	return ContentTypePrometheusText
+}
+
+// WritePrometheusText writes metrics in the Prometheus text exposition format.
+// Gauges are written with their value and counters with their delta, one sample per metric.
+func WritePrometheusText(w io.Writer, metrics []Metric) error {
+	return WriteExposition(w, ContentTypePrometheusText, metrics)
+}
+
+// expositionSample is a rendered sample line of the metric family.
+type expositionSample struct {
+	id     string
+	family string
+	mType  string
+	line   string
+}
+
+// WriteExposition writes metrics in the format of the content type, ContentTypePrometheusText
+// or ContentTypeOpenMetrics. Labels of the metric IDs in the series notation become sample labels
+// and the samples are grouped into metric families with a single # TYPE line.
+// The output is buffered in small chunks, so it's streamed to w.
+//
+// A metric whose family name is taken by a metric of another type is skipped. The skipped metrics
+// are returned as an error after the rest is written.
+func WriteExposition(w io.Writer, contentType string, metrics []Metric) error {
+	openMetrics := contentType == ContentTypeOpenMetrics
+	samples := make([]expositionSample, 0, len(metrics))
+	for _, metric := range metrics {
+		sample, err := renderSample(metric, openMetrics)
+		if err != nil {
+			return err
+		}
+		samples = append(samples, sample)
+	}
+	sort.SliceStable(samples, func(i, j int) bool {
+		if samples[i].family != samples[j].family {
+			return samples[i].family < samples[j].family
+		}
+		return samples[i].mType < samples[j].mType
+	})
+
+	bw := bufio.NewWriter(w)
+	var (
+		skipped            []error
+		family, familyType string
+		written            = make(map[string]struct{})
+	)
+	for _, sample := range samples {
+		if sample.family != family || sample.mType != familyType {
+			if _, ok := written[sample.family]; ok {
+				skipped = append(skipped, fmt.Errorf("%s %s conflicts with the %s family", sample.mType, sample.id, familyType))
+				continue
+			}
+			written[sample.family] = struct{}{}
+			family, familyType = sample.family, sample.mType
+			if _, err := fmt.Fprintf(bw, "# TYPE %s %s\n", family, familyType); err != nil {
+				return err
+			}
+		}
+		if _, err := bw.WriteString(sample.line); err != nil {
+			return err
+		}
+	}
+	if openMetrics {
+		if _, err := bw.WriteString("# EOF\n"); err != nil {
+			return err
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	return errors.Join(skipped...)
+}
+
+// renderSample renders the sample lines of the metric. In OpenMetrics counter samples have
+// the _total suffix, which isn't a part of the family name. Histograms are rendered as
+// cumulative _bucket samples with the le label followed by the _sum and _count samples
+// and sets as gauges of their cardinality.
+func renderSample(metric Metric, openMetrics bool) (expositionSample, error) {
+	var value string
+	switch metric.MType {
+	case Gauge:
+		if metric.Value == nil {
+			return expositionSample{}, fmt.Errorf("value is required for gauge metric %s", metric.ID)
+		}
+		value = strconv.FormatFloat(*metric.Value, 'g', -1, 64)
+	case Counter:
+		if metric.Delta == nil {
+			return expositionSample{}, fmt.Errorf("delta is required for counter metric %s", metric.ID)
+		}
+		value = strconv.FormatInt(*metric.Delta, 10)
+	case Set:
+		if metric.Cardinality == nil {
+			return expositionSample{}, fmt.Errorf("cardinality is required for set metric %s", metric.ID)
+		}
+		value = strconv.FormatUint(*metric.Cardinality, 10)
+	case Histogram:
+		if metric.Histogram == nil {
+			return expositionSample{}, fmt.Errorf("histogram is required for histogram metric %s", metric.ID)
+		}
+		if err := metric.Histogram.Validate(); err != nil {
+			return expositionSample{}, fmt.Errorf("invalid histogram metric %s: %w", metric.ID, err)
+		}
+	default:
+		return expositionSample{}, fmt.Errorf("unknown metric type %s", metric.MType)
+	}
+
+	baseName, labels, err := SplitLabels(metric.ID)
+	if err != nil {
+		baseName, labels = metric.ID, nil
+	}
+	family := SanitizePrometheusName(baseName)
+	name := family
+	if openMetrics && metric.MType == Counter {
+		family = strings.TrimSuffix(family, "_total")
+		name = family + "_total"
+	}
+
+	var b strings.Builder
+	if metric.MType != Histogram {
+		mType := metric.MType
+		if mType == Set {
+			mType = Gauge
+		}
+		writeSampleLine(&b, name, labels, value)
+		return expositionSample{id: metric.ID, family: family, mType: mType, line: b.String()}, nil
+	}
+
+	histogram := metric.Histogram
+	bucketLabels := make(map[string]string, len(labels)+1)
+	for key, value := range labels {
+		bucketLabels[key] = value
+	}
+	var cumulative uint64
+	for i, count := range histogram.Counts {
+		cumulative += count
+		bucketLabels["le"] = "+Inf"
+		if i < len(histogram.Bounds) {
+			bucketLabels["le"] = strconv.FormatFloat(histogram.Bounds[i], 'g', -1, 64)
+		}
+		writeSampleLine(&b, name+"_bucket", bucketLabels, strconv.FormatUint(cumulative, 10))
+	}
+	writeSampleLine(&b, name+"_sum", labels, strconv.FormatFloat(histogram.Sum, 'g', -1, 64))
+	writeSampleLine(&b, name+"_count", labels, strconv.FormatUint(histogram.Count, 10))
+	return expositionSample{id: metric.ID, family: family, mType: metric.MType, line: b.String()}, nil
+}
+
+// writeSampleLine writes a sample line with the labels sorted by name.
+func writeSampleLine(b *strings.Builder, name string, labels map[string]string, value string) {
+	b.WriteString(name)
+	if len(labels) > 0 {
+		keys := make([]string, 0, len(labels))
+		for key := range labels {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		b.WriteByte('{')
+		for i, key := range keys {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			fmt.Fprintf(b, `%s="%s"`, SanitizePrometheusLabelName(key), labelValueEscaper.Replace(labels[key]))
+		}
+		b.WriteByte('}')
+	}
+	b.WriteByte(' ')
+	b.WriteString(value)
+	b.WriteByte('\n')
+}
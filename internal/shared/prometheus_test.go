@@ -0,0 +1,61 @@
+package shared
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteExposition(t *testing.T) {
+	alloc, heap := 2.5, 1.0
+	requests, errorsTotal, conflicting := int64(5), int64(7), int64(1)
+	metrics := []Metric{
+		{ID: `requests{path="/a\"b"}`, MType: Counter, Delta: &requests},
+		{ID: "Heap.Alloc", MType: Gauge, Value: &heap},
+		{ID: `requests{path="/"}`, MType: Counter, Delta: &requests},
+		{ID: "errors_total", MType: Counter, Delta: &errorsTotal},
+		{ID: `Alloc{host="a",1zone="z"}`, MType: Gauge, Value: &alloc},
+		{ID: "Alloc", MType: Counter, Delta: &conflicting},
+		{ID: `Heap.Alloc{1zone="z"}`, MType: Gauge, Value: &alloc},
+	}
+
+	var text bytes.Buffer
+	err := WriteExposition(&text, ContentTypePrometheusText, metrics)
+	require.EqualError(t, err, `gauge Alloc{host="a",1zone="z"} conflicts with the counter family`)
+	require.Equal(t, `# TYPE Alloc counter
+Alloc 1
+# TYPE Heap_Alloc gauge
+Heap_Alloc 1
+Heap_Alloc{_1zone="z"} 2.5
+# TYPE errors_total counter
+errors_total 7
+# TYPE requests counter
+requests{path="/a\"b"} 5
+requests{path="/"} 5
+`, text.String())
+
+	var openMetrics bytes.Buffer
+	err = WriteExposition(&openMetrics, ContentTypeOpenMetrics, metrics[1:4])
+	require.NoError(t, err)
+	require.Equal(t, `# TYPE Heap_Alloc gauge
+Heap_Alloc 1
+# TYPE errors counter
+errors_total 7
+# TYPE requests counter
+requests_total{path="/"} 5
+# EOF
+`, openMetrics.String())
+}
+
+func TestNegotiateExposition(t *testing.T) {
+	require.Equal(t, ContentTypePrometheusText, NegotiateExposition(""))
+	require.Equal(t, ContentTypePrometheusText, NegotiateExposition("text/plain"))
+	require.Equal(t, ContentTypeOpenMetrics, NegotiateExposition("application/openmetrics-text"))
+	require.Equal(
+		t,
+		ContentTypeOpenMetrics,
+		NegotiateExposition("application/openmetrics-text;version=1.0.0,application/openmetrics-text;version=0.0.1;q=0.75,text/plain;version=0.0.4;q=0.5,*/*;q=0.1"),
+	)
+	require.Equal(t, ContentTypePrometheusText, NegotiateExposition("application/openmetrics-text;q=0.5,*/*"))
+}
@@ -2,6 +2,8 @@ package shared
 
 // Metric dto as string constants
 const (
-	Gauge   = "gauge"
-	Counter = "counter"
+	Gauge     = "gauge"
+	Counter   = "counter"
+	Histogram = "histogram"
+	Set       = "set"
 )